@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// adminMode registers the admin tools, which change the configuration or the
+// index, in addition to the read-only ones. Set with -admin.
+var adminMode bool
+
+// reposMutex serializes changes to the repository configuration made by
+// admin tools
+var reposMutex sync.Mutex
+
+// ingestRunning is set while an ingest started by the server runs, by the
+// reingest tool or in the background at startup
+var ingestRunning atomic.Bool
+
+// registerAdminTools adds the tools that modify the repository configuration
+// or rebuild the index
+func registerAdminTools(s *server.MCPServer) {
+	addRepoTool := mcp.NewTool("add_repository",
+		mcp.WithDescription("Adds a git repository to the configuration and enables it. Run reingest afterwards to index it."),
+		mcp.WithString("url",
+			mcp.Required(),
+			mcp.Description("The clone URL of the repository"),
+		),
+		mcp.WithString("name",
+			mcp.Required(),
+			mcp.Description("A short name for the repository, used for its clone directory and in chunk IDs"),
+		),
+		mcp.WithString("role",
+			mcp.Description("Optional special role; 'nips' marks the NIPs specification repository"),
+		),
+		mcp.WithString("collection",
+			mcp.Description("Optional collection for query routing, e.g. 'specs', 'code', 'wiki' or 'articles'"),
+		),
+	)
+	s.AddTool(addRepoTool, addRepositoryHandler)
+
+	setEnabledTool := mcp.NewTool("set_repository_enabled",
+		mcp.WithDescription("Enables or disables a configured repository. Run reingest afterwards to update the index."),
+		mcp.WithString("name",
+			mcp.Required(),
+			mcp.Description("The name of the repository"),
+		),
+		mcp.WithBoolean("enabled",
+			mcp.Required(),
+			mcp.Description("Whether the repository is indexed"),
+		),
+	)
+	s.AddTool(setEnabledTool, setRepositoryEnabledHandler)
+
+	reingestTool := mcp.NewTool("reingest",
+		mcp.WithDescription("Rebuilds the index from the enabled repositories in the background. Searches keep using the current index until the new one is complete."),
+		mcp.WithBoolean("clone",
+			mcp.Description("Clone or update the repositories before ingesting (default: true)"),
+		),
+	)
+	s.AddTool(reingestTool, reingestHandler)
+}
+
+func addRepositoryHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	url, _ := request.Params.Arguments["url"].(string)
+	name, _ := request.Params.Arguments["name"].(string)
+	role, _ := request.Params.Arguments["role"].(string)
+	collection, _ := request.Params.Arguments["collection"].(string)
+	if err := appendRepository(url, name, role, collection); err != nil {
+		return nil, err
+	}
+	return mcp.NewToolResultText(fmt.Sprintf("Added repository %s (%s). Run reingest to index it.", name, url)), nil
+}
+
+// appendRepository adds an enabled repository to the configuration and saves
+// it
+func appendRepository(url, name, role, collection string) error {
+	if url == "" || name == "" {
+		return errors.New("url and name must be non-empty strings")
+	}
+	if err := checkRepoName(name); err != nil {
+		return err
+	}
+	if err := checkRepoCollection(collection); err != nil {
+		return err
+	}
+	if err := checkRepoURL(url); err != nil {
+		return err
+	}
+
+	reposMutex.Lock()
+	defer reposMutex.Unlock()
+
+	if i := findRepoByURL(url); i >= 0 {
+		return fmt.Errorf("repository with URL %s already exists as %s", url, repos[i].Name)
+	}
+	for _, repo := range repos {
+		if repo.Name == name {
+			return fmt.Errorf("a repository named %s already exists", name)
+		}
+	}
+
+	// Readers never see a half-built slice
+	updated := make([]RepoConfig, len(repos), len(repos)+1)
+	copy(updated, repos)
+	repos = append(updated, RepoConfig{
+		URL:        url,
+		Name:       name,
+		CloneDir:   filepath.Join(dataDir, name+"-repo"),
+		Enabled:    true,
+		Role:       role,
+		Collection: collection,
+	})
+	saveReposToFile(reposConfigFile)
+	return nil
+}
+
+func setRepositoryEnabledHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	name, _ := request.Params.Arguments["name"].(string)
+	enabled, ok := request.Params.Arguments["enabled"].(bool)
+	if name == "" || !ok {
+		return nil, errors.New("name must be a non-empty string and enabled a boolean")
+	}
+	if err := updateRepositoryEnabled(name, enabled); err != nil {
+		return nil, err
+	}
+
+	state := "Disabled"
+	if enabled {
+		state = "Enabled"
+	}
+	return mcp.NewToolResultText(fmt.Sprintf("%s repository %s. Run reingest to update the index.", state, name)), nil
+}
+
+// updateRepositoryEnabled enables or disables a configured repository and
+// saves the configuration. Unlike -disable-repo it leaves the index alone
+// until the next ingest.
+func updateRepositoryEnabled(name string, enabled bool) error {
+	reposMutex.Lock()
+	defer reposMutex.Unlock()
+
+	updated := make([]RepoConfig, len(repos))
+	copy(updated, repos)
+	found := false
+	for i := range updated {
+		if updated[i].Name == name {
+			updated[i].Enabled = enabled
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("no repository named %s", name)
+	}
+	repos = updated
+	saveReposToFile(reposConfigFile)
+	return nil
+}
+
+func reingestHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	clone := true
+	if value, ok := request.Params.Arguments["clone"].(bool); ok {
+		clone = value
+	}
+
+	if err := startIngestProcess(clone); err != nil {
+		return nil, err
+	}
+
+	return mcp.NewToolResultText("Re-ingestion started. The new index will be served automatically once it is complete."), nil
+}
+
+// startIngestProcess starts an ingest in its own process, optionally cloning
+// or updating the repositories first, unless one is already running, started
+// by this server or elsewhere. The ingest takes the ingest lock itself, so
+// one started elsewhere meanwhile still makes it stop.
+func startIngestProcess(clone bool) error {
+	if !ingestRunning.CompareAndSwap(false, true) {
+		return errIngestRunning
+	}
+	if ingestLocked(dbPath) {
+		ingestRunning.Store(false)
+		return errIngestRunning
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		ingestRunning.Store(false)
+		return fmt.Errorf("error locating executable: %v", err)
+	}
+
+	// The ingest cannot open the statistics this server holds open, so it
+	// orders the files by the counts written here
+	if err := writeSourceHits(); err != nil {
+		log.Printf("Error writing source hits for the ingest: %v", err)
+	}
+
+	// Ingestion runs in its own process so its output cannot interfere with
+	// the MCP protocol; the finished index is picked up by watchStore. The
+	// server's own flags are passed on so settings such as the outbound
+	// policy apply to the ingest as well.
+	args := append([]string{}, os.Args[1:]...)
+	args = append(args, "-ingest", "-repos-config", reposConfigFile)
+	if clone {
+		args = append(args, "-clone-repos")
+	}
+	cmd := exec.Command(executable, args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		ingestRunning.Store(false)
+		return fmt.Errorf("error starting ingestion: %v", err)
+	}
+
+	go func() {
+		defer ingestRunning.Store(false)
+		if err := cmd.Wait(); err != nil {
+			log.Printf("Ingestion failed: %v", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// aliasesFile is the default path of the query alias file
+const aliasesFile = "aliases.json"
+
+// queryAliases maps community slang and abbreviations to the terms the
+// specifications use for them. Entries in aliases.json are added to these,
+// replace them, or remove them when given an empty list. Ordinary English
+// words such as "like" or "article" are only aliases together with a word
+// that places them in Nostr, or every query using them would be expanded.
+var queryAliases = map[string][]string{
+	"zap":                {"NIP-57", "zap request", "zap receipt", "lightning payment"},
+	"zaps":               {"NIP-57", "zap request", "zap receipt", "lightning payment"},
+	"nutzap":             {"NIP-61", "cashu", "ecash"},
+	"dm":                 {"NIP-17", "private direct message", "gift wrap"},
+	"dms":                {"NIP-17", "private direct message", "gift wrap"},
+	"gift wrap":          {"NIP-59", "seal", "rumor"},
+	"gift wraps":         {"NIP-59", "seal", "rumor"},
+	"blossom":            {"NIP-B7", "media server", "blob storage", "BUD"},
+	"nwc":                {"NIP-47", "Nostr Wallet Connect"},
+	"dvm":                {"NIP-90", "data vending machine", "job request"},
+	"dvms":               {"NIP-90", "data vending machine", "job request"},
+	"outbox":             {"NIP-65", "relay list metadata"},
+	"bunker":             {"NIP-46", "remote signer", "Nostr Connect"},
+	"npub":               {"NIP-19", "bech32 public key"},
+	"nsec":               {"NIP-19", "bech32 private key"},
+	"nip05":              {"NIP-05", "DNS-based internet identifier"},
+	"like event":         {"NIP-25", "reaction", "kind 7"},
+	"like events":        {"NIP-25", "reaction", "kind 7"},
+	"repost event":       {"NIP-18", "kind 6"},
+	"repost events":      {"NIP-18", "kind 6"},
+	"long-form article":  {"NIP-23", "long-form content", "kind 30023"},
+	"long-form articles": {"NIP-23", "long-form content", "kind 30023"},
+	"mute list":          {"NIP-51", "lists"},
+	"negentropy":         {"NIP-77", "set reconciliation"},
+	"highlight":          {"NIP-84", "kind 9802"},
+	"signer extension":   {"NIP-07", "window.nostr"},
+}
+
+// queryAlias is an alias with the pattern that finds it in a query
+type queryAlias struct {
+	pattern    *regexp.Regexp
+	expansions []string
+}
+
+// compiledAliases holds queryAliases ready for matching, longest alias first
+var compiledAliases = compileAliases(queryAliases)
+
+// compileAliases builds word-boundary patterns for every alias
+func compileAliases(aliases map[string][]string) []queryAlias {
+	keys := make([]string, 0, len(aliases))
+	for key := range aliases {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+
+	compiled := make([]queryAlias, 0, len(keys))
+	for _, key := range keys {
+		if len(aliases[key]) == 0 {
+			continue
+		}
+		compiled = append(compiled, queryAlias{
+			pattern:    regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(key) + `\b`),
+			expansions: aliases[key],
+		})
+	}
+	return compiled
+}
+
+// loadAliases merges the alias file into the built-in aliases. A missing
+// default file is not an error.
+func loadAliases(customFile string) {
+	cfgFile := aliasesFile
+	if customFile != "" {
+		cfgFile = customFile
+	}
+
+	file, err := os.ReadFile(cfgFile)
+	if os.IsNotExist(err) && cfgFile == aliasesFile {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error reading aliases file: %v\n", err)
+		os.Exit(1)
+	}
+
+	var custom map[string][]string
+	if err := json.Unmarshal(file, &custom); err != nil {
+		fmt.Printf("Error parsing aliases file: %v\n", err)
+		os.Exit(1)
+	}
+	for alias, expansions := range custom {
+		queryAliases[strings.ToLower(strings.TrimSpace(alias))] = expansions
+	}
+	compiledAliases = compileAliases(queryAliases)
+}
+
+// aliasExpansions returns the spec terms for every alias used in the query
+// that the query does not already contain
+func aliasExpansions(query string) []string {
+	lower := strings.ToLower(query)
+	seen := make(map[string]bool)
+	var expansions []string
+	for _, alias := range compiledAliases {
+		if !alias.pattern.MatchString(query) {
+			continue
+		}
+		for _, expansion := range alias.expansions {
+			key := strings.ToLower(expansion)
+			if seen[key] || strings.Contains(lower, key) {
+				continue
+			}
+			seen[key] = true
+			expansions = append(expansions, expansion)
+		}
+	}
+	return expansions
+}
+
+// expandAliases appends the spec terms for any aliases in the query, so that
+// slang the specifications never use still lands near the right sections
+func expandAliases(query string) string {
+	expansions := aliasExpansions(query)
+	if len(expansions) == 0 {
+		return query
+	}
+	return fmt.Sprintf("%s (%s)", query, strings.Join(expansions, ", "))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// eventArchive appends every event fetched from relays to a JSONL file, once
+// per event ID. The file uses the same format read by -events-file.
+type eventArchive struct {
+	mutex sync.Mutex
+	file  *os.File
+	seen  map[string]bool
+}
+
+// archive is the active event archive, or nil when archiving is disabled
+var archive *eventArchive
+
+// openEventArchive opens or creates the archive file and loads the IDs of the
+// events it already holds
+func openEventArchive(path string) (*eventArchive, error) {
+	seen := make(map[string]bool)
+	if existing, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(existing)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxEventLineSize)
+		for scanner.Scan() {
+			var ev struct {
+				ID string `json:"id"`
+			}
+			if json.Unmarshal(scanner.Bytes(), &ev) == nil && ev.ID != "" {
+				seen[ev.ID] = true
+			}
+		}
+		existing.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &eventArchive{file: file, seen: seen}, nil
+}
+
+// archiveEvent appends an event to the archive unless it is already stored.
+// It does nothing when archiving is disabled.
+func archiveEvent(ev *nostr.Event) {
+	if archive == nil || ev == nil {
+		return
+	}
+
+	archive.mutex.Lock()
+	defer archive.mutex.Unlock()
+
+	if archive.seen[ev.ID] {
+		return
+	}
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	if _, err := archive.file.Write(append(data, '\n')); err != nil {
+		return
+	}
+	archive.seen[ev.ID] = true
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Kinds of help a question to nostr_assistant can need
+const (
+	intentDocs    = "docs"
+	intentCode    = "code"
+	intentNetwork = "network"
+)
+
+// networkSignal finds questions about the live state of relays rather than
+// about the protocol, e.g. "is relay.damus.io up?"
+var networkSignal = regexp.MustCompile(`(?i)\brelays?\b.*\b(status|health|healthy|up|down|online|offline|reachable|working|alive|latency|uptime)\b|wss?://`)
+
+// relayURLPattern finds relay URLs mentioned in a question
+var relayURLPattern = regexp.MustCompile(`wss?://[^\s,;)]+`)
+
+// questionStopwords are left out when a question is turned into snippet search terms
+var questionStopwords = map[string]bool{
+	"about": true, "does": true, "example": true, "examples": true, "from": true,
+	"have": true, "into": true, "show": true, "some": true, "that": true,
+	"there": true, "this": true, "what": true, "when": true, "where": true,
+	"which": true, "with": true, "would": true, "write": true, "code": true,
+	"snippet": true, "snippets": true, "implement": true, "using": true,
+	"nostr": true, "could": true, "should": true, "please": true,
+	"how": true, "are": true, "the": true, "and": true, "for": true,
+	"can": true, "you": true, "get": true, "use": true, "its": true,
+}
+
+// classifyQuestion decides which sources can answer a question. Protocol
+// documentation is consulted for everything except status checks of named
+// relays, such as "is wss://relay.damus.io up?"
+func classifyQuestion(question string) []string {
+	var intents []string
+	network := networkSignal.MatchString(question)
+	code := collectionSignals[collectionCode].MatchString(question) || questionLanguage(question) != ""
+	statusCheck := network && relayURLPattern.MatchString(question)
+
+	if !statusCheck || code || collectionSignals[collectionSpecs].MatchString(question) {
+		intents = append(intents, intentDocs)
+	}
+	if code {
+		intents = append(intents, intentCode)
+	}
+	if network {
+		intents = append(intents, intentNetwork)
+	}
+	return intents
+}
+
+// questionLanguage returns the programming language a question mentions, if any
+func questionLanguage(question string) string {
+	for _, word := range embeddingTokens(question) {
+		// Two-letter aliases such as "go" or "sh" are too often plain words
+		if len(word) < 3 {
+			continue
+		}
+		if language, ok := canonicalLanguages[word]; ok {
+			return language
+		}
+	}
+	return ""
+}
+
+// questionKeywords reduces a question to the words worth matching against
+// code snippets
+func questionKeywords(question string) string {
+	var keywords []string
+	for _, word := range embeddingTokens(question) {
+		if len(word) < 3 || questionStopwords[word] {
+			continue
+		}
+		if _, ok := canonicalLanguages[word]; ok {
+			continue
+		}
+		keywords = append(keywords, word)
+	}
+	return strings.Join(keywords, " ")
+}
+
+// callTool runs another tool handler with the given arguments and returns
+// its text output
+func callTool(ctx context.Context, handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error), arguments map[string]interface{}) (string, error) {
+	var request mcp.CallToolRequest
+	request.Params.Arguments = arguments
+
+	result, err := handler(ctx, request)
+	if err != nil {
+		return "", err
+	}
+
+	var parts []string
+	for _, content := range result.Content {
+		if text, ok := content.(mcp.TextContent); ok {
+			parts = append(parts, text.Text)
+		}
+	}
+	return strings.Join(parts, "\n"), nil
+}
+
+func nostrAssistantHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	question, ok := request.Params.Arguments["question"].(string)
+	if !ok || strings.TrimSpace(question) == "" {
+		return nil, errors.New("question must be a non-empty string")
+	}
+	generate, _ := request.Params.Arguments["generate_answer"].(bool)
+
+	intents := classifyQuestion(question)
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Answered from: %s\n", strings.Join(intents, ", ")))
+
+	for _, intent := range intents {
+		var title, output string
+		var err error
+		switch intent {
+		case intentDocs:
+			title = "Documentation"
+			if generate {
+				output, err = callTool(ctx, askNostrHandler, map[string]interface{}{"query": question})
+			} else {
+				output, err = callTool(ctx, queryNostrDataHandler, map[string]interface{}{"query": question, "num_results": float64(5)})
+			}
+		case intentCode:
+			title = "Code"
+			arguments := map[string]interface{}{"limit": float64(5)}
+			if language := questionLanguage(question); language != "" {
+				arguments["language"] = language
+			}
+			if keywords := questionKeywords(question); keywords != "" {
+				arguments["query"] = keywords
+			}
+			output, err = callTool(ctx, searchCodeSnippetsHandler, arguments)
+		case intentNetwork:
+			title = "Relays"
+			arguments := map[string]interface{}{}
+			if urls := relayURLPattern.FindAllString(question, -1); len(urls) > 0 {
+				arguments["relays"] = strings.Join(urls, ",")
+			}
+			output, err = callTool(ctx, relayHealthHandler, arguments)
+		}
+
+		b.WriteString(fmt.Sprintf("\n## %s\n\n", title))
+		if err != nil {
+			// One failing source should not hide the others
+			b.WriteString(fmt.Sprintf("Unavailable: %v\n", err))
+			continue
+		}
+		b.WriteString(strings.TrimSpace(output))
+		b.WriteString("\n")
+	}
+
+	return mcp.NewToolResultText(b.String()), nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// authorPolicy controls whose events are embedded from relays, so only
+// trusted writing can influence generated answers
+var authorPolicy = struct {
+	AllowAuthors map[string]bool // When set, only events by these public keys are embedded
+	DenyAuthors  map[string]bool // Public keys whose events are never embedded
+}{}
+
+// parseAuthorList parses a comma-separated list of npubs, nprofiles, or hex
+// public keys into a set of hex public keys
+func parseAuthorList(value string) (map[string]bool, error) {
+	authors := make(map[string]bool)
+	for _, author := range strings.Split(value, ",") {
+		if strings.TrimSpace(author) == "" {
+			continue
+		}
+		pubkey, err := parseAuthor(author)
+		if err != nil {
+			return nil, err
+		}
+		authors[pubkey] = true
+	}
+	return authors, nil
+}
+
+// authorAllowed reports whether the events of a public key may be embedded.
+// The deny list wins over the allow list.
+func authorAllowed(pubkey string) bool {
+	if authorPolicy.DenyAuthors[pubkey] {
+		return false
+	}
+	return len(authorPolicy.AllowAuthors) == 0 || authorPolicy.AllowAuthors[pubkey]
+}
+
+// applyAuthorPolicy splits events into those the author policy allows and
+// those it refuses
+func applyAuthorPolicy(events []*nostr.Event) ([]*nostr.Event, []*nostr.Event) {
+	var kept, refused []*nostr.Event
+	for _, ev := range events {
+		if authorAllowed(ev.PubKey) {
+			kept = append(kept, ev)
+		} else {
+			refused = append(refused, ev)
+		}
+	}
+	return kept, refused
+}
+
+// eventPointer returns the NIP-19 pointer of an event: an naddr for
+// addressable events, which follows their newest version, and an nevent
+// for all others
+func eventPointer(ev *nostr.Event) string {
+	if kindClass(ev.Kind) == "addressable" {
+		pointer, _ := nip19.EncodeEntity(ev.PubKey, ev.Kind, eventTag(ev, "d"), nil)
+		return pointer
+	}
+	pointer, _ := nip19.EncodeEvent(ev.ID, nil, ev.PubKey)
+	return pointer
+}
+
+// ingestedSources maps the sources of ingested events to the events, for
+// labeling results with their provenance. It is reloaded whenever
+// ingestedEventsFile changes, since the events daemon adds to it while the
+// server runs.
+var ingestedSources = struct {
+	mutex    sync.Mutex
+	modTime  time.Time
+	bySource map[string]*nostr.Event
+}{}
+
+// eventProvenance returns the author's npub and the pointer of the event an
+// ingested event's chunk was made from. Both are "unknown" when the event is
+// no longer in ingestedEventsFile.
+func eventProvenance(id string) (string, string) {
+	source := strings.TrimPrefix(chunkSource(id), eventsRepo+"/")
+
+	ingestedSources.mutex.Lock()
+	defer ingestedSources.mutex.Unlock()
+	if info, err := os.Stat(ingestedEventsFile); err == nil && !info.ModTime().Equal(ingestedSources.modTime) {
+		if events, err := loadIngestedEvents(); err == nil {
+			ingestedSources.bySource = make(map[string]*nostr.Event, len(events))
+			for _, ev := range events {
+				ingestedSources.bySource[eventSource(ev)] = ev
+			}
+			ingestedSources.modTime = info.ModTime()
+		}
+	}
+
+	ev, ok := ingestedSources.bySource[source]
+	if !ok {
+		return "unknown", "unknown"
+	}
+	author, _ := nip19.EncodePublicKey(ev.PubKey)
+	return author, eventPointer(ev)
+}
+
+// provenanceAttributes returns the attributes that label a result from an
+// ingested event with its author and event, or "" for other results
+func provenanceAttributes(id string) string {
+	if chunkRepo(id) != eventsRepo {
+		return ""
+	}
+	author, pointer := eventProvenance(id)
+	return fmt.Sprintf(" author=\"%s\" event=\"%s\"", author, pointer)
+}
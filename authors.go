@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// authorFormats describes the accepted ways to name an author in error messages
+const authorFormats = "an npub (npub1...), an nprofile (nprofile1...), or a 64-character hex public key"
+
+// parseAuthor validates an author given as npub, nprofile, or hex and returns
+// the hex public key
+func parseAuthor(author string) (string, error) {
+	author = strings.TrimSpace(author)
+	author = strings.TrimPrefix(author, "nostr:")
+
+	switch {
+	case strings.HasPrefix(author, "npub1"), strings.HasPrefix(author, "nprofile1"):
+		prefix, value, err := nip19.Decode(author)
+		if err != nil {
+			return "", fmt.Errorf("invalid author %q: %v (bad checksum or truncated key?); expected %s", author, err, authorFormats)
+		}
+		switch prefix {
+		case "npub":
+			author = value.(string)
+		case "nprofile":
+			author = value.(nostr.ProfilePointer).PublicKey
+		}
+	case strings.HasPrefix(author, "nsec1"):
+		return "", fmt.Errorf("invalid author: that is a private key (nsec), never share it; expected %s", authorFormats)
+	default:
+		author = strings.ToLower(author)
+		if len(author) != 64 {
+			return "", fmt.Errorf("invalid author %q: hex public keys are 64 characters, got %d; expected %s", author, len(author), authorFormats)
+		}
+		if !nostr.IsValid32ByteHex(author) {
+			return "", fmt.Errorf("invalid author %q: not a hex string; expected %s", author, authorFormats)
+		}
+	}
+
+	if !nostr.IsValidPublicKey(author) {
+		return "", fmt.Errorf("invalid author %q: not a valid public key; expected %s", author, authorFormats)
+	}
+	return author, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Environment variables read when bootstrapping a container:
+//
+//	BHN_BOOTSTRAP=1             clone and ingest before serving if the database is empty
+//	BHN_INGEST_IN_BACKGROUND=1  serve at once and ingest meanwhile
+//	BHN_SEED_REPOS=path         repository configuration copied to repos.json if it does not exist
+//	BHN_PRESET=name             repository preset used instead when BHN_SEED_REPOS is not set
+//	BHN_OLLAMA_URL=url          Ollama server to use instead of http://localhost:11434
+//	BHN_LOW_POWER=1             apply the -low-power preset
+const seedReposEnv = "BHN_SEED_REPOS"
+
+// envEnabled reports whether a boolean environment variable is set to a true
+// value such as 1 or true
+func envEnabled(name string) bool {
+	enabled, err := strconv.ParseBool(os.Getenv(name))
+	return err == nil && enabled
+}
+
+// seedReposConfig copies the repository configuration named by
+// BHN_SEED_REPOS, or writes the preset named by BHN_PRESET, to repos.json
+// when no configuration exists yet, so a fresh volume starts with a useful
+// set of repositories
+func seedReposConfig() {
+	if _, err := os.Stat(configFile); err == nil {
+		return
+	}
+
+	seed := os.Getenv(seedReposEnv)
+	if seed == "" {
+		if preset := os.Getenv(presetEnv); preset != "" {
+			presetRepos, ok := repoPresets[preset]
+			if !ok {
+				fmt.Fprintf(os.Stderr, "Error: unknown preset %q in %s\n", preset, presetEnv)
+				os.Exit(1)
+			}
+			repos = append([]RepoConfig(nil), presetRepos...)
+			saveReposToFile(configFile)
+			repos = nil
+			fmt.Fprintf(os.Stderr, "Created %s from the %s preset\n", configFile, preset)
+		}
+		return
+	}
+
+	if err := copyFile(seed, configFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error copying %s to %s: %v\n", seed, configFile, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Created %s from %s\n", configFile, seed)
+}
+
+// bootstrapIndex clones and ingests the enabled repositories when the
+// database is missing or empty, so the server can start from an empty volume
+// without an interactive setup step. An existing index is served as is.
+func bootstrapIndex(metric string) {
+	if _, err := os.Stat(dbPath); err == nil {
+		count, err := countStoredRecords(dbPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error checking database before bootstrap: %v\n", err)
+			return
+		}
+		if count > 0 {
+			return
+		}
+	}
+
+	// Over stdio, stdout carries the MCP protocol, so progress goes to
+	// stderr until the server starts
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	defer func() { os.Stdout = stdout }()
+
+	fmt.Println("Database is empty; cloning and ingesting the configured repositories...")
+	createDatabase(true, false, metric)
+}
+
+// ingestInBackground starts an ingest in its own process so the server can
+// serve whatever the database holds meanwhile; the new index is swapped in
+// when it is done, and a first ingest swaps in partial ones along the way.
+// When bootstrapping, only an empty database or an ingest that was
+// interrupted before it finished is ingested.
+func ingestInBackground(bootstrapping bool) {
+	if bootstrapping {
+		// An unfinished ingest that still holds the lock is running, not
+		// interrupted
+		progress, ok := loadIngestProgress(dbPath)
+		interrupted := ok && progress.Finished.IsZero() && !ingestLocked(dbPath)
+		if !interrupted && !servingIndexEmpty(dbPath) {
+			return
+		}
+	}
+
+	if err := startIngestProcess(true); err != nil {
+		fmt.Fprintf(os.Stderr, "Error starting background ingest: %v\n", err)
+		return
+	}
+	fmt.Fprintln(os.Stderr, "Ingesting the configured repositories in the background; server_status reports the progress")
+}
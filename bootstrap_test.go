@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// initFixtureRepo commits files to a new git repository at dir
+func initFixtureRepo(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := make(map[string]string, len(files))
+	for name, text := range files {
+		paths[filepath.Join(dir, name)] = text
+	}
+	writeFixture(t, paths)
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := worktree.AddGlob("."); err != nil {
+		t.Fatal(err)
+	}
+	_, err = worktree.Commit("Add fixture", &git.CommitOptions{
+		Author: &object.Signature{Name: "fixture", Email: "fixture@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBootstrapIndex(t *testing.T) {
+	inTempDir(t)
+	defer func(embedder string) { requestedEmbedder = embedder }(requestedEmbedder)
+	requestedEmbedder = embedderHash
+
+	origin, err := filepath.Abs("origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	initFixtureRepo(t, origin, map[string]string{
+		"01.md":     "# NIP-01\n\nBasic protocol flow description.\n",
+		"README.md": "# NIPs\n\nThe list of NIPs.\n",
+	})
+	repos = []RepoConfig{{Name: "fixture", URL: origin, CloneDir: filepath.Join(dataDir, "fixture-repo"), Enabled: true}}
+
+	// The data directory is empty, so bootstrapping clones and ingests
+	bootstrapIndex("")
+
+	// The server opens the database in the same process right after
+	index, err := openServingIndex(dbPath)
+	if err != nil {
+		t.Fatalf("opening the bootstrapped index: %v", err)
+	}
+	defer closeStore(index.store)
+	if index.vectors == 0 {
+		t.Fatal("the bootstrapped index is empty")
+	}
+	if index.corpus == nil || len(index.corpus.Repos) != 1 {
+		t.Errorf("corpus manifest = %+v, want the fixture repository", index.corpus)
+	}
+}
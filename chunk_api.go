@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/parakeet-nest/parakeet/llm"
+)
+
+// Page sizes of the chunk listing API
+const (
+	defaultChunkPageSize = 50
+	maxChunkPageSize     = 500
+)
+
+// chunkInfo describes a stored chunk for clients that browse the index
+type chunkInfo struct {
+	ID         string `json:"id"`
+	Repo       string `json:"repo"`
+	File       string `json:"file"`           // Source file identifier shared by the file's chunk IDs, e.g. "nips/01"
+	Path       string `json:"path,omitempty"` // Path of the file in the repository
+	Chunk      int    `json:"chunk"`          // Position of the chunk in its file, from 1
+	Collection string `json:"collection"`
+	Tier       string `json:"tier"`
+	Section    string `json:"section,omitempty"`
+	Lineage    string `json:"lineage,omitempty"`
+	Commit     string `json:"commit,omitempty"`
+	URL        string `json:"url,omitempty"`
+	Kinds      []int  `json:"kinds,omitempty"`
+	Chars      int    `json:"chars"`
+	Text       string `json:"text,omitempty"`
+}
+
+// chunkPage is one page of a chunk listing. Next is the offset of the next
+// page, or 0 when this is the last one.
+type chunkPage struct {
+	Total  int         `json:"total"`
+	Offset int         `json:"offset"`
+	Next   int         `json:"next,omitempty"`
+	Chunks []chunkInfo `json:"chunks"`
+}
+
+// chunkQuery selects the chunks to list. Empty fields match every chunk.
+type chunkQuery struct {
+	Repo        string
+	Collection  string
+	File        string
+	Offset      int
+	Limit       int
+	IncludeText bool
+}
+
+// listChunks returns a page of the chunks the caller may read, ordered by
+// file and by position within the file so pages are stable between calls
+func listChunks(ctx context.Context, reader vectorReader, query chunkQuery) (chunkPage, error) {
+	if query.Offset < 0 {
+		return chunkPage{}, errors.New("offset must not be negative")
+	}
+	if query.Limit <= 0 {
+		query.Limit = defaultChunkPageSize
+	}
+	query.Limit = min(query.Limit, maxChunkPageSize)
+
+	records, err := reader.GetAll()
+	if err != nil {
+		return chunkPage{}, fmt.Errorf("error reading chunks: %v", err)
+	}
+
+	var matched []llm.VectorRecord
+	for _, record := range records {
+		switch {
+		case !tenantCanRead(ctx, record.Id):
+		case query.Repo != "" && chunkRepo(record.Id) != query.Repo:
+		case query.Collection != "" && chunkCollection(record.Id) != query.Collection:
+		case query.File != "" && chunkSource(record.Id) != query.File:
+		default:
+			matched = append(matched, record)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		a, an, _ := parseChunkID(matched[i].Id)
+		b, bn, _ := parseChunkID(matched[j].Id)
+		if a != b {
+			return a < b
+		}
+		if an != bn {
+			return an < bn
+		}
+		return matched[i].Id < matched[j].Id
+	})
+
+	page := chunkPage{Total: len(matched), Offset: query.Offset, Chunks: []chunkInfo{}}
+	end := min(query.Offset+query.Limit, len(matched))
+	for i := query.Offset; i < end; i++ {
+		page.Chunks = append(page.Chunks, describeChunk(matched[i], query.IncludeText))
+	}
+	if end < len(matched) {
+		page.Next = end
+	}
+	return page, nil
+}
+
+// describeChunk returns the metadata of a stored chunk, and its text if asked
+func describeChunk(record llm.VectorRecord, includeText bool) chunkInfo {
+	source := recordSourceMeta(record)
+	_, n, _ := parseChunkID(record.Id)
+	text := strings.TrimPrefix(record.Prompt, "search_document: ")
+	info := chunkInfo{
+		ID:         record.Id,
+		Repo:       chunkRepo(record.Id),
+		File:       chunkSource(record.Id),
+		Path:       source.Path,
+		Chunk:      n,
+		Collection: chunkCollection(record.Id),
+		Tier:       chunkTier(record.Id),
+		Section:    source.Section,
+		Lineage:    source.Lineage,
+		Commit:     source.Commit,
+		URL:        source.URL,
+		Kinds:      extractChunkMeta(record).Kinds,
+		Chars:      len(text),
+	}
+	if includeText {
+		info.Text = text
+	}
+	return info
+}
+
+// listFileChunks returns a page of the chunks of one file, with their text
+func listFileChunks(ctx context.Context, reader vectorReader, file string, offset, limit int) (chunkPage, error) {
+	if file == "" {
+		return chunkPage{}, errors.New("file must be a non-empty string")
+	}
+	page, err := listChunks(ctx, reader, chunkQuery{File: file, Offset: offset, Limit: limit, IncludeText: true})
+	if err == nil && page.Total == 0 {
+		err = fmt.Errorf("no chunks stored for file %s", file)
+	}
+	return page, err
+}
+
+func listChunksHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+	query := chunkQuery{}
+	query.Repo, _ = args["repo"].(string)
+	query.Collection, _ = args["collection"].(string)
+	if offset, ok := args["offset"].(float64); ok {
+		query.Offset = int(offset)
+	}
+	if limit, ok := args["limit"].(float64); ok {
+		query.Limit = int(limit)
+	}
+	query.IncludeText, _ = args["include_text"].(bool)
+
+	index, release := acquireIndex()
+	defer release()
+	page, err := listChunks(ctx, sessionReader(ctx, index), query)
+	if err != nil {
+		return nil, err
+	}
+	return chunkPageResult(page)
+}
+
+func getFileChunksHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+	file, _ := args["file"].(string)
+	offset, limit := 0, 0
+	if num, ok := args["offset"].(float64); ok {
+		offset = int(num)
+	}
+	if num, ok := args["limit"].(float64); ok {
+		limit = int(num)
+	}
+
+	index, release := acquireIndex()
+	defer release()
+	page, err := listFileChunks(ctx, sessionReader(ctx, index), file, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	return chunkPageResult(page)
+}
+
+// chunkPageResult returns a page of chunks as JSON
+func chunkPageResult(page chunkPage) (*mcp.CallToolResult, error) {
+	data, err := json.MarshalIndent(page, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error serializing chunks: %v", err)
+	}
+	return mcp.NewToolResultText(string(data)), nil
+}
+
+// registerChunkAPI serves the chunk listing over plain HTTP for web UIs:
+//
+//	GET /api/chunks?repo=&collection=&file=&offset=&limit=&text=true
+//	GET /api/file-chunks?file=nips/01&offset=&limit=
+func registerChunkAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/chunks", func(w http.ResponseWriter, r *http.Request) {
+		offset, limit, err := pageParams(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		params := r.URL.Query()
+		includeText, _ := strconv.ParseBool(params.Get("text"))
+		index, release := acquireIndex()
+		defer release()
+		page, err := listChunks(r.Context(), index.reader, chunkQuery{
+			Repo:        params.Get("repo"),
+			Collection:  params.Get("collection"),
+			File:        params.Get("file"),
+			Offset:      offset,
+			Limit:       limit,
+			IncludeText: includeText,
+		})
+		writeChunkPage(w, page, err, http.StatusBadRequest)
+	})
+	mux.HandleFunc("GET /api/file-chunks", func(w http.ResponseWriter, r *http.Request) {
+		offset, limit, err := pageParams(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		file := r.URL.Query().Get("file")
+		if file == "" {
+			http.Error(w, "missing file parameter", http.StatusBadRequest)
+			return
+		}
+		index, release := acquireIndex()
+		defer release()
+		page, err := listFileChunks(r.Context(), index.reader, file, offset, limit)
+		writeChunkPage(w, page, err, http.StatusNotFound)
+	})
+}
+
+// pageParams reads the offset and limit of a chunk API request
+func pageParams(r *http.Request) (int, int, error) {
+	var values [2]int
+	for i, name := range []string{"offset", "limit"} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid %s %q", name, value)
+		}
+		values[i] = n
+	}
+	return values[0], values[1], nil
+}
+
+// writeChunkPage writes a page of chunks as JSON, or the error with status
+func writeChunkPage(w http.ResponseWriter, page chunkPage, err error, status int) {
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
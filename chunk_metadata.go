@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/parakeet-nest/parakeet/content"
+	"github.com/parakeet-nest/parakeet/llm"
+)
+
+// Keys of the metadata stored with each chunk's embedding
+const (
+	metaRepo    = "repo"
+	metaPath    = "path"    // Path of the file in the repository
+	metaSection = "section" // Header of the chunk's section
+	metaLineage = "lineage" // Headers from the top of the file down to the section, joined by " > "
+	metaCommit  = "commit"  // Commit of the clone the chunk was ingested from
+	metaURL     = "url"     // Where the source can be read: the file at that commit, or the event
+)
+
+// sourceMeta is the metadata of a stored chunk, for citing its source
+type sourceMeta struct {
+	Repo    string
+	Path    string
+	Section string
+	Lineage string
+	Commit  string
+	URL     string
+}
+
+// headCommits caches the commit each repository's clone was at when this
+// ingest started embedding it
+var headCommits = make(map[string]string)
+
+// repoCommit returns the commit a repository's clone is at, or "" when it is
+// unknown
+func repoCommit(repo RepoConfig) string {
+	commit, ok := headCommits[repo.Name]
+	if !ok {
+		commit, _, _ = repoHeadCommit(repo.CloneDir)
+		headCommits[repo.Name] = commit
+	}
+	return commit
+}
+
+// fileChunkMetadata returns the metadata stored with a chunk of a
+// repository's file
+func fileChunkMetadata(repoName, filePath string, chunk content.Chunk) map[string]interface{} {
+	meta := sourceMeta{Repo: repoName, Path: filepath.Base(filePath), Section: chunk.Header, Lineage: chunk.Lineage}
+	if i := findRepoByName(repoName); i >= 0 {
+		repo := repos[i]
+		if rel, err := filepath.Rel(repo.CloneDir, filePath); err == nil && !filepath.IsAbs(rel) {
+			meta.Path = filepath.ToSlash(rel)
+		}
+		meta.Commit = repoCommit(repo)
+		meta.URL = sourceFileURL(repo.URL, meta.Commit, meta.Path)
+		if meta.URL != "" && chunk.Level > 0 && strings.HasSuffix(strings.ToLower(meta.Path), ".md") {
+			meta.URL += "#" + headerAnchor(chunk.Header)
+		}
+	}
+	return meta.toMap()
+}
+
+// toMap converts the metadata to the form stored in a vector record,
+// leaving out empty fields
+func (meta sourceMeta) toMap() map[string]interface{} {
+	stored := make(map[string]interface{})
+	for key, value := range map[string]string{
+		metaRepo:    meta.Repo,
+		metaPath:    meta.Path,
+		metaSection: meta.Section,
+		metaLineage: meta.Lineage,
+		metaCommit:  meta.Commit,
+		metaURL:     meta.URL,
+	} {
+		if value != "" {
+			stored[key] = value
+		}
+	}
+	return stored
+}
+
+// recordSourceMeta returns the metadata stored with a chunk. Chunks ingested
+// before metadata was stored get what their ID and text tell.
+func recordSourceMeta(record llm.VectorRecord) sourceMeta {
+	value := func(key string) string {
+		text, _ := record.Metadata[key].(string)
+		return text
+	}
+	if len(record.Metadata) > 0 {
+		return sourceMeta{
+			Repo:    value(metaRepo),
+			Path:    value(metaPath),
+			Section: value(metaSection),
+			Lineage: value(metaLineage),
+			Commit:  value(metaCommit),
+			URL:     value(metaURL),
+		}
+	}
+
+	meta := extractChunkMeta(record)
+	lineage := meta.Section
+	if meta.Parents != "" && meta.Parents != "None" {
+		lineage = meta.Parents + " > " + meta.Section
+	}
+	return sourceMeta{Repo: meta.Repo, Section: meta.Section, Lineage: lineage}
+}
+
+// sameMetadata reports whether two stored metadata maps hold the same values
+func sameMetadata(a, b map[string]interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if b[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// sourceFileURL returns the web page of a file at a commit for repositories
+// hosted on GitHub, GitLab, or Codeberg, or "" for other hosts
+func sourceFileURL(repoURL, commit, path string) string {
+	if commit == "" {
+		commit = "HEAD"
+	}
+	repo := normalizeRepoURL(repoURL)
+	host, _, _ := strings.Cut(repo, "/")
+	switch host {
+	case "github.com", "codeberg.org":
+		return fmt.Sprintf("https://%s/blob/%s/%s", repo, commit, path)
+	case "gitlab.com":
+		return fmt.Sprintf("https://%s/-/blob/%s/%s", repo, commit, path)
+	}
+	return ""
+}
+
+// headerAnchor returns the anchor the forges give a markdown header: lower
+// case, with spaces turned into hyphens and other punctuation dropped
+func headerAnchor(header string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(header)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_':
+			b.WriteRune(r)
+		case r == ' ':
+			b.WriteRune('-')
+		}
+	}
+	return b.String()
+}
+
+// sourceAttributes renders the path, commit, and URL of a chunk as attributes
+// of its doc tag, so clients can cite the source
+func sourceAttributes(record llm.VectorRecord) string {
+	meta := recordSourceMeta(record)
+	var b strings.Builder
+	if meta.Path != "" {
+		b.WriteString(fmt.Sprintf(" path=\"%s\"", meta.Path))
+	}
+	if meta.Commit != "" {
+		b.WriteString(fmt.Sprintf(" commit=\"%s\"", meta.Commit[:min(12, len(meta.Commit))]))
+	}
+	if meta.URL != "" {
+		b.WriteString(fmt.Sprintf(" url=\"%s\"", meta.URL))
+	}
+	return b.String()
+}
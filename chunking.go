@@ -0,0 +1,393 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/parakeet-nest/parakeet/content"
+)
+
+// Chunker splits the text of a file into the chunks that are embedded for it
+type Chunker interface {
+	Chunk(text, filePath string) []content.Chunk
+}
+
+// chunkFunc adapts a function to the Chunker interface
+type chunkFunc func(text, filePath string) []content.Chunk
+
+// Chunk calls f(text, filePath)
+func (f chunkFunc) Chunk(text, filePath string) []content.Chunk {
+	return f(text, filePath)
+}
+
+// Chunking strategies a repository can be ingested with
+const (
+	chunkSemantic  = "semantic"  // The file type's own structure: markdown sections, code declarations (default)
+	chunkFixed     = "fixed"     // Windows of ChunkSize characters, each repeating ChunkOverlap characters of the last
+	chunkSentence  = "sentence"  // Runs of whole sentences up to ChunkSize characters
+	chunkRecursive = "recursive" // Splits at paragraphs, then lines, sentences, and words until parts fit ChunkSize
+)
+
+// chunkStrategies lists the chunking strategies
+var chunkStrategies = []string{chunkSemantic, chunkFixed, chunkSentence, chunkRecursive}
+
+// minChunkSize is the smallest ChunkSize accepted, since tiny chunks carry
+// too little meaning to embed
+const minChunkSize = 100
+
+// Chunking used for repositories that do not set their own, from
+// -chunk-strategy, -chunk-size, and -chunk-overlap
+var (
+	defaultChunkStrategy = chunkSemantic
+	defaultChunkSize     = textChunkChars
+	defaultChunkOverlap  = 200
+)
+
+// checkChunking reports a strategy that does not exist or a size and overlap
+// that cannot be chunked with
+func checkChunking(strategy string, size, overlap int) error {
+	known := false
+	for _, s := range chunkStrategies {
+		known = known || s == strategy
+	}
+	if !known {
+		return fmt.Errorf("unknown chunk strategy %q; expected one of %s", strategy, strings.Join(chunkStrategies, ", "))
+	}
+	if size < minChunkSize {
+		return fmt.Errorf("chunk size %d is below the minimum of %d characters", size, minChunkSize)
+	}
+	if overlap < 0 || overlap >= size {
+		return fmt.Errorf("chunk overlap %d must be at least 0 and less than the chunk size %d", overlap, size)
+	}
+	return nil
+}
+
+// repoChunking returns the chunking strategy, size, and overlap of a
+// repository: its own settings, or the defaults
+func repoChunking(repo RepoConfig) (string, int, int) {
+	strategy, size, overlap := defaultChunkStrategy, defaultChunkSize, defaultChunkOverlap
+	if repo.ChunkStrategy != "" {
+		strategy = strings.ToLower(strings.TrimSpace(repo.ChunkStrategy))
+	}
+	if repo.ChunkSize != 0 {
+		size = repo.ChunkSize
+	}
+	if repo.ChunkOverlap != nil {
+		overlap = *repo.ChunkOverlap
+	}
+	return strategy, size, overlap
+}
+
+// checkRepoChunking reports invalid chunking settings of a repository
+func checkRepoChunking(repo RepoConfig) error {
+	if err := checkChunking(repoChunking(repo)); err != nil {
+		return err
+	}
+	if repo.ContextOverlap != "" {
+		if _, err := parseContextOverlap(repo.ContextOverlap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Units of the context overlap, the end of the previous chunk that is
+// embedded along with each chunk
+const (
+	overlapSentences = "sentences"
+	overlapChars     = "chars"
+	overlapTokens    = "tokens"
+)
+
+// contextOverlap says how much of the previous chunk is embedded with each
+// chunk. A Size of 0 disables it.
+type contextOverlap struct {
+	Size int
+	Unit string
+}
+
+// noContextOverlap embeds chunks without the end of the previous one
+var noContextOverlap = contextOverlap{}
+
+// defaultContextOverlap is used for repositories that do not set
+// ContextOverlap and use the semantic strategy, from -context-overlap. The
+// other strategies repeat ChunkOverlap characters inside the chunks instead.
+var defaultContextOverlap = contextOverlap{Size: 1, Unit: overlapSentences}
+
+// parseContextOverlap parses an overlap such as "2 sentences", "300 chars",
+// "64 tokens", or "none"
+func parseContextOverlap(value string) (contextOverlap, error) {
+	fields := strings.Fields(strings.ToLower(value))
+	if len(fields) == 1 && (fields[0] == "none" || fields[0] == "0") {
+		return noContextOverlap, nil
+	}
+	if len(fields) != 2 {
+		return contextOverlap{}, fmt.Errorf("invalid context overlap %q; expected e.g. \"2 sentences\", \"300 chars\", \"64 tokens\", or \"none\"", value)
+	}
+	var size int
+	if _, err := fmt.Sscanf(fields[0], "%d", &size); err != nil || size < 0 {
+		return contextOverlap{}, fmt.Errorf("invalid context overlap size %q", fields[0])
+	}
+	unit := strings.TrimSuffix(fields[1], "s") + "s"
+	switch unit {
+	case overlapSentences, overlapChars, overlapTokens:
+	case "characters":
+		unit = overlapChars
+	default:
+		return contextOverlap{}, fmt.Errorf("unknown context overlap unit %q; expected sentences, chars, or tokens", fields[1])
+	}
+	return contextOverlap{Size: size, Unit: unit}, nil
+}
+
+// String formats the overlap the way parseContextOverlap reads it
+func (o contextOverlap) String() string {
+	switch o.Size {
+	case 0:
+		return "none"
+	case 1:
+		return "1 " + strings.TrimSuffix(o.Unit, "s")
+	}
+	return fmt.Sprintf("%d %s", o.Size, o.Unit)
+}
+
+// repoContextOverlap returns the context overlap of a repository's chunks
+func repoContextOverlap(repoName string) contextOverlap {
+	for _, repo := range repos {
+		if repo.Name != repoName {
+			continue
+		}
+		if repo.ContextOverlap != "" {
+			overlap, _ := parseContextOverlap(repo.ContextOverlap)
+			return overlap
+		}
+		if strategy, _, _ := repoChunking(repo); strategy != chunkSemantic {
+			return noContextOverlap
+		}
+	}
+	return defaultContextOverlap
+}
+
+// shortSentenceChars is the length below which a sentence, such as "See
+// NIP-01.", is counted together with the one before it
+const shortSentenceChars = 20
+
+// extract returns the end of text that the overlap covers. Character and
+// token overlaps start at a word boundary; tokens are approximated as
+// charsPerToken characters.
+func (o contextOverlap) extract(text string) string {
+	text = strings.TrimSpace(text)
+	if o.Size == 0 || text == "" {
+		return ""
+	}
+
+	if o.Unit == overlapSentences {
+		// Find where each sentence starts, counting short ones as part of
+		// the sentence before them
+		var starts []int
+		start := 0
+		for _, match := range sentenceEnd.FindAllStringIndex(text, -1) {
+			if match[1] < len(text) {
+				if len(starts) == 0 || match[1]-start >= shortSentenceChars {
+					starts = append(starts, start)
+				}
+				start = match[1]
+			}
+		}
+		if len(starts) == 0 || len(text)-start >= shortSentenceChars {
+			starts = append(starts, start)
+		}
+		if o.Size >= len(starts) {
+			return text
+		}
+		return text[starts[len(starts)-o.Size]:]
+	}
+
+	size := o.Size
+	if o.Unit == overlapTokens {
+		size *= charsPerToken
+	}
+	if size >= len(text) {
+		return text
+	}
+	cut := runeBoundary(text, len(text)-size)
+	if space := strings.IndexAny(text[cut:], " \t\n"); space != -1 && space < len(text)-cut-1 {
+		cut += space + 1
+	}
+	return strings.TrimSpace(text[cut:])
+}
+
+// repoChunker returns the chunker for a repository's files of the type
+// handled by handler
+func repoChunker(repo RepoConfig, handler fileHandler) Chunker {
+	strategy, size, overlap := repoChunking(repo)
+	switch strategy {
+	case chunkFixed:
+		return fixedSizeChunker{Size: size, Overlap: overlap}
+	case chunkSentence:
+		return sentenceChunker{Size: size, Overlap: overlap}
+	case chunkRecursive:
+		return recursiveChunker{Size: size, Overlap: overlap}
+	}
+	return handler.Chunk
+}
+
+// fixedSizeChunker cuts text into windows of Size characters, each starting
+// Overlap characters before the end of the last
+type fixedSizeChunker struct {
+	Size    int
+	Overlap int
+}
+
+// Chunk splits text into overlapping windows
+func (c fixedSizeChunker) Chunk(text, filePath string) []content.Chunk {
+	var parts []string
+	for start := 0; start < len(text); {
+		end := runeBoundary(text, start+c.Size)
+		if part := strings.TrimSpace(text[start:end]); part != "" {
+			parts = append(parts, part)
+		}
+		if end == len(text) {
+			break
+		}
+		next := runeBoundary(text, end-c.Overlap)
+		if next <= start {
+			next = end
+		}
+		start = next
+	}
+	return textChunks(parts, filePath)
+}
+
+// sentenceChunker groups whole sentences into chunks of at most Size
+// characters, starting each chunk with the last sentences of the previous one
+// that fit in Overlap characters
+type sentenceChunker struct {
+	Size    int
+	Overlap int
+}
+
+// sentenceEnd matches the end of a sentence and the space after it, or a
+// paragraph break
+var sentenceEnd = regexp.MustCompile(`[.!?]+["')\]]*\s+|\n\s*\n`)
+
+// Chunk splits text into runs of sentences
+func (c sentenceChunker) Chunk(text, filePath string) []content.Chunk {
+	var pieces []string
+	start := 0
+	for _, match := range sentenceEnd.FindAllStringIndex(text, -1) {
+		pieces = append(pieces, text[start:match[1]])
+		start = match[1]
+	}
+	pieces = append(pieces, text[start:])
+
+	// Sentences longer than a chunk are split at line breaks and words
+	var fitted []string
+	for _, piece := range pieces {
+		fitted = append(fitted, splitRecursive(piece, []string{"\n", " "}, c.Size)...)
+	}
+	return textChunks(mergePieces(fitted, c.Size, c.Overlap), filePath)
+}
+
+// recursiveChunker splits text at the coarsest separator that makes its
+// parts fit in Size characters, then merges neighboring parts back up to
+// Size, repeating up to Overlap characters between chunks
+type recursiveChunker struct {
+	Size    int
+	Overlap int
+}
+
+// recursiveSeparators are tried in order: paragraphs, lines, sentences, and
+// words
+var recursiveSeparators = []string{"\n\n", "\n", ". ", " "}
+
+// Chunk splits text recursively
+func (c recursiveChunker) Chunk(text, filePath string) []content.Chunk {
+	return textChunks(mergePieces(splitRecursive(text, recursiveSeparators, c.Size), c.Size, c.Overlap), filePath)
+}
+
+// splitRecursive splits text after each separator in turn until every piece
+// fits in size characters. Text without any of the separators is cut at
+// size. Joined together, the pieces are the original text.
+func splitRecursive(text string, separators []string, size int) []string {
+	if len(text) <= size {
+		return []string{text}
+	}
+	if len(separators) == 0 {
+		var pieces []string
+		for len(text) > size {
+			end := runeBoundary(text, size)
+			if end == 0 {
+				end = size
+			}
+			pieces = append(pieces, text[:end])
+			text = text[end:]
+		}
+		return append(pieces, text)
+	}
+
+	var pieces []string
+	for _, piece := range strings.SplitAfter(text, separators[0]) {
+		pieces = append(pieces, splitRecursive(piece, separators[1:], size)...)
+	}
+	return pieces
+}
+
+// mergePieces joins consecutive pieces into chunks of at most size
+// characters. Each chunk starts with the last pieces of the previous one that
+// fit in overlap characters.
+func mergePieces(pieces []string, size, overlap int) []string {
+	var chunks, window []string
+	length := 0
+	flush := func() {
+		if chunk := strings.TrimSpace(strings.Join(window, "")); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+	}
+
+	for _, piece := range pieces {
+		if piece == "" {
+			continue
+		}
+		if length > 0 && length+len(piece) > size {
+			flush()
+			for len(window) > 0 && (length > overlap || length+len(piece) > size) {
+				length -= len(window[0])
+				window = window[1:]
+			}
+		}
+		window = append(window, piece)
+		length += len(piece)
+	}
+	flush()
+	return chunks
+}
+
+// runeBoundary moves i back to the start of the rune it falls in, clamped to
+// the length of text
+func runeBoundary(text string, i int) int {
+	if i >= len(text) {
+		return len(text)
+	}
+	for i > 0 && !utf8.RuneStart(text[i]) {
+		i--
+	}
+	return i
+}
+
+// textChunks turns the parts of a file into chunks headed by the file name,
+// for chunking that does not follow the file's structure
+func textChunks(parts []string, filePath string) []content.Chunk {
+	name := filepath.Base(filePath)
+	var chunks []content.Chunk
+	for i, part := range parts {
+		chunks = append(chunks, content.Chunk{
+			Header:  fmt.Sprintf("%s (part %d)", name, i+1),
+			Content: part,
+			Lineage: name,
+		})
+	}
+	return chunks
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/parakeet-nest/parakeet/llm"
+)
+
+// parseChunkID splits an embedding ID of the form "<source>-chunk-<n>" into
+// its source identifier and sequence number
+func parseChunkID(id string) (string, int, bool) {
+	idx := strings.LastIndex(id, "-chunk-")
+	if idx == -1 {
+		return "", 0, false
+	}
+
+	n, err := strconv.Atoi(id[idx+len("-chunk-"):])
+	if err != nil {
+		return "", 0, false
+	}
+	return id[:idx], n, true
+}
+
+// getChunkWithNeighbors fetches a stored chunk by ID along with up to
+// neighbors chunks on either side of it from the same source file
+func getChunkWithNeighbors(store vectorReader, id string, neighbors int) ([]llm.VectorRecord, error) {
+	record, err := store.Get(id)
+	if err != nil || record.Id == "" {
+		return nil, fmt.Errorf("chunk %s not found", id)
+	}
+
+	source, n, ok := parseChunkID(id)
+	if !ok || neighbors <= 0 {
+		return []llm.VectorRecord{record}, nil
+	}
+
+	var chunks []llm.VectorRecord
+	// Chunks of one file are numbered consecutively, so walk outwards until
+	// an ID is missing
+	for i := n - 1; i >= n-neighbors && i > 0; i-- {
+		prev, err := store.Get(fmt.Sprintf("%s-chunk-%d", source, i))
+		if err != nil || prev.Id == "" {
+			break
+		}
+		chunks = append([]llm.VectorRecord{prev}, chunks...)
+	}
+
+	chunks = append(chunks, record)
+
+	for i := n + 1; i <= n+neighbors; i++ {
+		next, err := store.Get(fmt.Sprintf("%s-chunk-%d", source, i))
+		if err != nil || next.Id == "" {
+			break
+		}
+		chunks = append(chunks, next)
+	}
+
+	return chunks, nil
+}
+
+// formatChunks renders fetched chunks, marking the one that was requested
+func formatChunks(chunks []llm.VectorRecord, requestedID string) string {
+	var b strings.Builder
+	b.WriteString("<context>\n")
+	for _, chunk := range chunks {
+		role := "neighbor"
+		if chunk.Id == requestedID {
+			role = "requested"
+		}
+		b.WriteString(fmt.Sprintf("<doc id=\"%s\" role=\"%s\"%s>\n%s\n</doc>\n", chunk.Id, role, sourceAttributes(chunk), chunk.Prompt))
+	}
+	b.WriteString("</context>")
+	return b.String()
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"golang.org/x/sync/errgroup"
+)
+
+// cloneWorkers bounds how many repositories are cloned at the same time (-clone-workers)
+var cloneWorkers = 4
+
+// cloneStatusInterval is how often the combined progress of running clones is printed
+const cloneStatusInterval = 5 * time.Second
+
+// cloneProgress aggregates the git progress output of concurrent clones so
+// that it can be printed as one status line instead of interleaved streams
+type cloneProgress struct {
+	mutex  sync.Mutex
+	status map[string]string
+	order  []string
+	done   int
+	total  int
+}
+
+// progressWriter records the latest progress message of a single clone
+type progressWriter struct {
+	progress *cloneProgress
+	name     string
+}
+
+func (w progressWriter) Write(p []byte) (int, error) {
+	// git sideband progress separates updates with carriage returns
+	lines := bytes.FieldsFunc(p, func(r rune) bool { return r == '\r' || r == '\n' })
+	if len(lines) > 0 {
+		w.progress.set(w.name, strings.TrimSpace(string(lines[len(lines)-1])))
+	}
+	return len(p), nil
+}
+
+// set updates the status of a clone
+func (p *cloneProgress) set(name, status string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, ok := p.status[name]; !ok {
+		p.order = append(p.order, name)
+	}
+	p.status[name] = status
+}
+
+// finish removes a clone from the running set and prints its outcome
+func (p *cloneProgress) finish(name, outcome string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.status, name)
+	p.done++
+	fmt.Printf("[%d/%d] %s: %s\n", p.done, p.total, name, outcome)
+}
+
+// print shows the status of every running clone on one line
+func (p *cloneProgress) print() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var parts []string
+	for _, name := range p.order {
+		if status, ok := p.status[name]; ok {
+			parts = append(parts, fmt.Sprintf("%s: %s", name, status))
+		}
+	}
+	if len(parts) > 0 {
+		fmt.Printf("  %s\n", strings.Join(parts, " | "))
+	}
+}
+
+// checkoutClone finishes a fresh clone: it checks out the tag or commit the
+// repository is pinned to, or only its sparse paths, and fetches LFS objects
+func checkoutClone(r *git.Repository, repo RepoConfig, url string, progress io.Writer) error {
+	if pinnedToRevision(repo) {
+		_, _, err := checkoutPinnedRevision(r, repo, url, progress)
+		return err
+	}
+	if isSparse(repo) {
+		head, err := r.Head()
+		if err != nil {
+			return fmt.Errorf("error reading HEAD: %v", err)
+		}
+		return sparseCheckout(repo, head.Name().Short(), head.Hash())
+	}
+	return fetchLFSObjects(repo)
+}
+
+// syncRepository clones a repository, or fast-forwards it when it is already
+// cloned, trying each of its URLs in order until one works
+func syncRepository(repo RepoConfig, progress io.Writer) (string, error) {
+	start := time.Now()
+	if err := validatePin(repo); err != nil {
+		return "", err
+	}
+	urls, err := resolveCloneURLs(repoURLs(repo))
+	if err != nil {
+		return "", err
+	}
+	if urls, err = allowedURLs(urls); err != nil {
+		return "", err
+	}
+
+	if _, err := git.PlainOpen(repo.CloneDir); err == nil {
+		outcome, _, err := pullFromMirrors(repo, urls, progress)
+		return outcome, err
+	}
+
+	options := &git.CloneOptions{
+		Progress:          progress,
+		RecurseSubmodules: submoduleDepth(repo),
+		Depth:             repo.Depth,
+		// Sparse clones are checked out afterwards, with only their paths
+		NoCheckout: isSparse(repo),
+	}
+	if repo.Branch != "" {
+		options.ReferenceName = plumbing.NewBranchReferenceName(repo.Branch)
+	}
+
+	// A clone is made in a directory of its own next to the clone directory
+	// and moved into place once it is complete, so a failed clone removes
+	// only what it created. A clone directory that exists is only replaced
+	// when it is empty.
+	if entries, err := os.ReadDir(repo.CloneDir); err == nil && len(entries) > 0 {
+		return "", fmt.Errorf("clone directory %s is not empty and not a git repository", repo.CloneDir)
+	}
+	parent := filepath.Dir(repo.CloneDir)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", fmt.Errorf("error creating %s: %v", parent, err)
+	}
+
+	var errs []string
+	for _, url := range urls {
+		options.URL = url
+		if options.Auth, err = repoAuth(repo, url); err != nil {
+			return "", err
+		}
+		staging, err := os.MkdirTemp(parent, filepath.Base(repo.CloneDir)+".cloning-")
+		if err != nil {
+			return "", fmt.Errorf("error creating a directory to clone into: %v", err)
+		}
+		staged := repo
+		staged.CloneDir = staging
+
+		r, err := git.PlainClone(staging, false, options)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", url, err))
+			os.RemoveAll(staging)
+			continue
+		}
+		if err := checkoutClone(r, staged, url, progress); err != nil {
+			os.RemoveAll(staging)
+			return "", err
+		}
+		// Rename does not replace a directory, even an empty one
+		os.Remove(repo.CloneDir)
+		if err := os.Rename(staging, repo.CloneDir); err != nil {
+			os.RemoveAll(staging)
+			return "", fmt.Errorf("error moving the clone to %s: %v", repo.CloneDir, err)
+		}
+		recordFetch(repo.CloneDir)
+
+		outcome := fmt.Sprintf("cloned in %s", time.Since(start).Round(time.Second/10))
+		if pinnedToRevision(repo) {
+			outcome += ", pinned to " + pinnedRef(repo)
+		}
+		if url != urls[0] {
+			outcome += fmt.Sprintf(" from mirror %s", url)
+		}
+		return outcome, nil
+	}
+	return "", errors.New(strings.Join(errs, "; "))
+}
+
+// pullFromMirrors fast-forwards an existing clone from the first of urls that
+// works and reports whether it received new commits
+func pullFromMirrors(repo RepoConfig, urls []string, progress io.Writer) (string, bool, error) {
+	var errs []string
+	for _, url := range urls {
+		outcome, updated, err := pullRepository(repo, url, progress)
+		if err == nil {
+			recordFetch(repo.CloneDir)
+			if url != urls[0] {
+				outcome += fmt.Sprintf(" from mirror %s", url)
+			}
+			return outcome, updated, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", url, err))
+	}
+	return "", false, errors.New(strings.Join(errs, "; "))
+}
+
+// updateRepository pulls an existing clone without cloning missing ones
+func updateRepository(repo RepoConfig, progress io.Writer) (string, bool, error) {
+	if _, err := git.PlainOpen(repo.CloneDir); err != nil {
+		return "", false, errNotCloned
+	}
+	if err := validatePin(repo); err != nil {
+		return "", false, err
+	}
+	urls, err := resolveCloneURLs(repoURLs(repo))
+	if err != nil {
+		return "", false, err
+	}
+	if urls, err = allowedURLs(urls); err != nil {
+		return "", false, err
+	}
+	return pullFromMirrors(repo, urls, progress)
+}
+
+// errNotCloned is returned when updating a repository that has no clone yet
+var errNotCloned = errors.New("not cloned yet; run with -clone-repos to clone it")
+
+// pullRepository fast-forwards an existing clone from the given URL, describes what changed,
+// e.g. "updated 1a2b3c4..5d6e7f8 (3 commits)" or "already up to date at 1a2b3c4", and
+// reports whether it received new commits. Clones pinned to a tag or commit are checked
+// out at it instead, and those pinned to a branch follow that branch.
+func pullRepository(repo RepoConfig, url string, progress io.Writer) (string, bool, error) {
+	r, err := git.PlainOpen(repo.CloneDir)
+	if err != nil {
+		return "", false, fmt.Errorf("error opening clone: %v", err)
+	}
+	if !isSparse(repo) {
+		if err := disableSparseCheckout(r, repo); err != nil {
+			return "", false, err
+		}
+	}
+	if pinnedToRevision(repo) {
+		return checkoutPinnedRevision(r, repo, url, progress)
+	}
+	if isSparse(repo) {
+		return pullSparseRepository(r, repo, url, progress)
+	}
+	if repo.Branch != "" {
+		if err := switchToPinnedBranch(r, repo, url, progress); err != nil {
+			return "", false, err
+		}
+	}
+	before, err := r.Head()
+	if err != nil {
+		return "", false, fmt.Errorf("error reading HEAD: %v", err)
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return "", false, fmt.Errorf("error opening worktree: %v", err)
+	}
+	auth, err := repoAuth(repo, url)
+	if err != nil {
+		return "", false, err
+	}
+	options := &git.PullOptions{
+		RemoteName:        "origin",
+		RemoteURL:         url,
+		Auth:              auth,
+		Progress:          progress,
+		RecurseSubmodules: submoduleDepth(repo),
+		Depth:             repo.Depth,
+	}
+	if repo.Branch != "" {
+		options.ReferenceName = plumbing.NewBranchReferenceName(repo.Branch)
+	}
+	err = w.Pull(options)
+	if err == git.NoErrAlreadyUpToDate {
+		if err := fetchLFSObjects(repo); err != nil {
+			return "", false, err
+		}
+		return fmt.Sprintf("already up to date at %s", shortHash(before.Hash())), false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("error pulling: %v", err)
+	}
+
+	after, err := r.Head()
+	if err != nil {
+		return "", false, fmt.Errorf("error reading HEAD: %v", err)
+	}
+	if err := fetchLFSObjects(repo); err != nil {
+		return "", false, err
+	}
+	return fmt.Sprintf("updated %s..%s (%s)", shortHash(before.Hash()), shortHash(after.Hash()),
+		countCommits(r, before.Hash(), after.Hash())), true, nil
+}
+
+// countCommits describes how many commits lead from one hash to another
+func countCommits(r *git.Repository, from, to plumbing.Hash) string {
+	commits, err := r.Log(&git.LogOptions{From: to})
+	if err != nil {
+		return "unknown number of commits"
+	}
+	defer commits.Close()
+
+	count := 0
+	for {
+		commit, err := commits.Next()
+		if err != nil || commit.Hash == from {
+			break
+		}
+		count++
+	}
+	if count == 1 {
+		return "1 commit"
+	}
+	return fmt.Sprintf("%d commits", count)
+}
+
+// shortHash abbreviates a commit hash for display
+func shortHash(hash plumbing.Hash) string {
+	return hash.String()[:7]
+}
+
+// cloneAllRepositories clones all enabled repositories, several at a time.
+// Repositories that are already cloned are fast-forwarded instead, and
+// mirrors are tried in order when the primary URL fails.
+func cloneAllRepositories() {
+	if offlineMode {
+		fmt.Println("Offline mode: not cloning or updating repositories; existing clones are used as they are.")
+		return
+	}
+	if len(repos) == 0 {
+		fmt.Println("No repositories configured. Create a repos.json file or use -add-repo to add repositories.")
+		return
+	}
+
+	var enabled []RepoConfig
+	for _, repo := range repos {
+		if isActiveRepo(repo) {
+			enabled = append(enabled, repo)
+		}
+	}
+
+	fmt.Printf("Cloning or updating %d enabled repositories (%d at a time)...\n", len(enabled), max(1, cloneWorkers))
+	start := time.Now()
+	failed := syncRepositories(enabled, syncRepository)
+
+	if failed > 0 {
+		fmt.Printf("Cloning completed in %s with %d failure(s).\n", time.Since(start).Round(time.Second/10), failed)
+		return
+	}
+	fmt.Printf("Cloning completed in %s.\n", time.Since(start).Round(time.Second/10))
+}
+
+// updateRepositories pulls the existing clones of the enabled repositories,
+// or only of those marked PullBeforeIngest when all is false, and reports
+// which received new commits. Missing clones are left for -clone-repos.
+func updateRepositories(all bool) {
+	if offlineMode {
+		fmt.Println("Offline mode: not updating repositories; existing clones are used as they are.")
+		return
+	}
+
+	var selected []RepoConfig
+	for _, repo := range repos {
+		if isActiveRepo(repo) && (all || repo.PullBeforeIngest) {
+			selected = append(selected, repo)
+		}
+	}
+	if len(selected) == 0 {
+		if all {
+			fmt.Println("No enabled repositories to update.")
+		}
+		return
+	}
+
+	fmt.Printf("Updating %d repositories (%d at a time)...\n", len(selected), max(1, cloneWorkers))
+	start := time.Now()
+	var updated []string
+	var updatedMutex sync.Mutex
+	failed := syncRepositories(selected, func(repo RepoConfig, progress io.Writer) (string, error) {
+		outcome, changed, err := updateRepository(repo, progress)
+		if errors.Is(err, errNotCloned) {
+			return fmt.Sprintf("skipped: %v", err), nil
+		}
+		if changed {
+			updatedMutex.Lock()
+			updated = append(updated, repo.Name)
+			updatedMutex.Unlock()
+		}
+		return outcome, err
+	})
+
+	sort.Strings(updated)
+	switch {
+	case len(updated) == 0:
+		fmt.Printf("Update completed in %s: no repository received new commits", time.Since(start).Round(time.Second/10))
+	default:
+		fmt.Printf("Update completed in %s: %d repositories received new commits (%s)", time.Since(start).Round(time.Second/10), len(updated), strings.Join(updated, ", "))
+	}
+	if failed > 0 {
+		fmt.Printf(", %d failure(s)", failed)
+	}
+	fmt.Println(".")
+}
+
+// syncRepositories runs run on each repository, several at a time, printing
+// the combined progress while they run and each outcome as it finishes. It
+// returns the number of repositories that failed.
+func syncRepositories(list []RepoConfig, run func(RepoConfig, io.Writer) (string, error)) int {
+	progress := &cloneProgress{status: make(map[string]string), total: len(list)}
+
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(cloneStatusInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				progress.print()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var g errgroup.Group
+	g.SetLimit(max(1, cloneWorkers))
+	failed := 0
+	var failedMutex sync.Mutex
+	for _, repo := range list {
+		g.Go(func() error {
+			progress.set(repo.Name, "starting")
+			if err := checkDataQuota(); err != nil {
+				progress.finish(repo.Name, fmt.Sprintf("skipped: %v", err))
+				failedMutex.Lock()
+				failed++
+				failedMutex.Unlock()
+				return nil
+			}
+			outcome, err := run(repo, progressWriter{progress, repo.Name})
+			if err != nil {
+				// Continue with other repositories even if one fails
+				progress.finish(repo.Name, fmt.Sprintf("error: %v", err))
+				failedMutex.Lock()
+				failed++
+				failedMutex.Unlock()
+				return nil
+			}
+			progress.finish(repo.Name, outcome)
+			return nil
+		})
+	}
+	g.Wait()
+	close(stop)
+	return failed
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncRepositoryKeepsWhatItDidNotCreate(t *testing.T) {
+	inTempDir(t)
+	origin, err := filepath.Abs("origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	initFixtureRepo(t, origin, map[string]string{"01.md": "# NIP-01\n"})
+	missing, err := filepath.Abs("missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed clone into a new directory leaves nothing behind
+	failed := RepoConfig{Name: "failed", URL: missing, CloneDir: filepath.Join(dataDir, "failed-repo")}
+	if _, err := syncRepository(failed, io.Discard); err == nil {
+		t.Fatal("cloning a missing repository succeeded")
+	}
+	if entries, _ := os.ReadDir(dataDir); len(entries) != 0 {
+		t.Errorf("a failed clone left %v in the data directory", entries)
+	}
+
+	// A directory with files that is not a clone is left alone
+	notes := filepath.Join(dataDir, "notes-repo")
+	writeFixture(t, map[string]string{filepath.Join(notes, "notes.md"): "my notes"})
+	for _, url := range []string{missing, origin} {
+		if _, err := syncRepository(RepoConfig{Name: "notes", URL: url, CloneDir: notes}, io.Discard); err == nil {
+			t.Fatalf("cloning %s into a directory with files succeeded", url)
+		}
+		if _, err := os.Stat(filepath.Join(notes, "notes.md")); err != nil {
+			t.Fatalf("cloning %s into a directory with files removed them: %v", url, err)
+		}
+	}
+
+	// An empty directory is replaced by the clone
+	cloneDir := filepath.Join(dataDir, "fixture-repo")
+	if err := os.MkdirAll(cloneDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := syncRepository(RepoConfig{Name: "fixture", URL: origin, CloneDir: cloneDir}, io.Discard); err != nil {
+		t.Fatalf("cloning into an empty directory: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cloneDir, "01.md")); err != nil {
+		t.Errorf("the clone is not in place: %v", err)
+	}
+	entries, _ := os.ReadDir(dataDir)
+	if len(entries) != 2 {
+		t.Errorf("data directory holds %v, want the notes and the clone only", entries)
+	}
+}
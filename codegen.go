@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/parakeet-nest/parakeet/llm"
+)
+
+// codegenLibrary is a Nostr library the generate_code tool can write for
+type codegenLibrary struct {
+	Package  string   // How the library is imported
+	Language string   // Language of the generated code
+	Markers  []string // Text that shows a snippet uses the library
+}
+
+// codegenLibraries are the libraries generate_code supports, by name
+var codegenLibraries = map[string]codegenLibrary{
+	"go-nostr": {
+		Package:  "github.com/nbd-wtf/go-nostr",
+		Language: "go",
+		Markers:  []string{"nbd-wtf/go-nostr", "go-nostr"},
+	},
+	"ndk": {
+		Package:  "@nostr-dev-kit/ndk",
+		Language: "typescript",
+		Markers:  []string{"@nostr-dev-kit/ndk", "new ndk(", "ndkevent"},
+	},
+	"nostr-tools": {
+		Package:  "nostr-tools",
+		Language: "typescript",
+		Markers:  []string{"nostr-tools", "simplepool", "finalizeevent"},
+	},
+}
+
+// Limits on the sources given to the model
+const (
+	codegenSpecResults = 4
+	codegenCodeResults = 3
+	codegenSnippets    = 3
+	codegenSnippetSize = 2000 // Characters of each snippet's code
+)
+
+// codegenSystemPrompt asks for code grounded in the specs and examples, with
+// every source cited where it was used
+const codegenSystemPrompt = `You are an expert Nostr developer. Write example %s code for the user's task using the %s library (%s). Take protocol details such as event kinds, tags, and message formats from the specification excerpts, and take the library's API from the code examples. Do not invent library functions: if the examples do not show how to do something, say which calls you are unsure about.
+
+Reply with one complete code block followed by a short explanation. In comments next to the code they informed, cite the IDs of the excerpts and examples you used, e.g. [nips/01-chunk-12] or [snippet:1a2b3c4d].`
+
+// snippetCitation is the ID a code snippet is cited by in generated code
+func snippetCitation(ev *nostr.Event) string {
+	return "snippet:" + ev.ID[:min(8, len(ev.ID))]
+}
+
+// librarySnippets returns cached code snippets that use a library, those
+// sharing the most words with the task first
+func librarySnippets(library codegenLibrary, task string, limit int) []*nostr.Event {
+	keywords := strings.Fields(questionKeywords(task))
+
+	codeSnippetCache.mutex.RLock()
+	defer codeSnippetCache.mutex.RUnlock()
+
+	type scoredSnippet struct {
+		event *nostr.Event
+		score int
+	}
+	var scored []scoredSnippet
+	for _, ev := range codeSnippetCache.events {
+		if ev.Kind == kindPatch {
+			continue
+		}
+		code := strings.ToLower(snippetCode(ev))
+		uses := false
+		for _, marker := range library.Markers {
+			if strings.Contains(code, marker) {
+				uses = true
+				break
+			}
+		}
+		if !uses {
+			continue
+		}
+		score := 0
+		for _, keyword := range keywords {
+			if strings.Contains(code, keyword) {
+				score++
+			}
+		}
+		scored = append(scored, scoredSnippet{ev, score})
+	}
+
+	sort.SliceStable(scored, func(i, j int) bool {
+		return scored[i].score > scored[j].score
+	})
+	var snippets []*nostr.Event
+	for _, s := range scored {
+		if len(snippets) == limit {
+			break
+		}
+		snippets = append(snippets, s.event)
+	}
+	return snippets
+}
+
+// formatCodegenSnippets renders snippets as context for the model
+func formatCodegenSnippets(snippets []*nostr.Event) string {
+	var b strings.Builder
+	for _, ev := range snippets {
+		code, _ := truncateSnippet(snippetCode(ev), "", codegenSnippetSize)
+		b.WriteString(fmt.Sprintf("<example id=%q source=%q>\n%s\n</example>\n", snippetCitation(ev), snippetSource(ev), code))
+	}
+	return b.String()
+}
+
+// retrieveCodegenContext finds the spec sections and indexed library code
+// relevant to a task
+func retrieveCodegenContext(ctx context.Context, task string) ([]searchResult, error) {
+	index, release := acquireIndex()
+	defer release()
+	candidates, err := retrieveCandidates(ctx, sessionReader(ctx, index), task)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []searchResult
+	for _, step := range []struct {
+		collection string
+		limit      int
+	}{
+		{collectionSpecs, codegenSpecResults},
+		{collectionCode, codegenCodeResults},
+	} {
+		allowed, err := tenantCollections(ctx, []string{step.collection})
+		if err != nil {
+			// The tenant cannot read this collection
+			continue
+		}
+		results = append(results, selectResults(candidates, searchOptions{
+			Threshold:   0.5,
+			NumResults:  step.limit,
+			MaxPerFile:  defaultMaxPerFile,
+			Collections: allowed,
+		})...)
+	}
+	return results, nil
+}
+
+func generateCodeHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	task, ok := request.Params.Arguments["task"].(string)
+	if !ok || strings.TrimSpace(task) == "" {
+		return nil, errors.New("task must be a non-empty string")
+	}
+	name, _ := request.Params.Arguments["library"].(string)
+	library, ok := codegenLibraries[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown library %q; use go-nostr, ndk, or nostr-tools", name)
+	}
+
+	results, err := retrieveCodegenContext(ctx, task)
+	if err != nil {
+		return nil, err
+	}
+	snippets := librarySnippets(library, task, codegenSnippets)
+	if len(results) == 0 && len(snippets) == 0 {
+		return mcp.NewToolResultText("No specification sections or code examples related to this task were found, so no code was generated."), nil
+	}
+	if reply, refuse := insufficientContext(results, results); refuse {
+		return mcp.NewToolResultText(reply), nil
+	}
+
+	messages := []llm.Message{
+		{Role: "system", Content: fmt.Sprintf(codegenSystemPrompt, library.Language, name, library.Package)},
+	}
+	if len(results) > 0 {
+		messages = append(messages, llm.Message{Role: "system", Content: formatResults(results)})
+	}
+	if len(snippets) > 0 {
+		messages = append(messages, llm.Message{Role: "system", Content: formatCodegenSnippets(snippets)})
+	}
+	messages = append(messages, llm.Message{Role: "user", Content: task})
+
+	code, err := chatCompletion(messages, progressStreamer(ctx, request))
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	b.WriteString(strings.TrimSpace(code))
+	b.WriteString("\n\n## Sources\n")
+	for _, result := range results {
+		if url := recordSourceMeta(result.Record).URL; url != "" {
+			b.WriteString(fmt.Sprintf("- [%s] score %.2f, %s\n", result.Record.Id, result.Score, url))
+		} else {
+			b.WriteString(fmt.Sprintf("- [%s] score %.2f\n", result.Record.Id, result.Score))
+		}
+	}
+	for _, ev := range snippets {
+		note, _ := nip19.EncodeNote(ev.ID)
+		b.WriteString(fmt.Sprintf("- [%s] %s, %s\n", snippetCitation(ev), snippetSource(ev), note))
+	}
+	if len(snippets) == 0 {
+		b.WriteString(fmt.Sprintf("No cached code snippets use %s, so the library calls were not checked against real code; verify them against its documentation.\n", name))
+	}
+	return mcp.NewToolResultText(b.String()), nil
+}
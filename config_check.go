@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// configProblem is a mistake in a configuration file and the line it is on
+// (0 when it concerns the whole file)
+type configProblem struct {
+	Line    int
+	Message string
+}
+
+// scpURLPattern matches scp-style git URLs such as git@github.com:nostr-protocol/nips
+var scpURLPattern = regexp.MustCompile(`^[\w.-]+@[\w.-]+:[^/].*$`)
+
+// printConfigProblems prints every problem found in a configuration file,
+// in line order, so they can all be fixed at once
+func printConfigProblems(what, cfgFile string, problems []configProblem) {
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Line < problems[j].Line
+	})
+	noun := "problems"
+	if len(problems) == 1 {
+		noun = "problem"
+	}
+	fmt.Printf("Error: %d %s in %s %s:\n", len(problems), noun, what, cfgFile)
+	for _, problem := range problems {
+		if problem.Line > 0 {
+			fmt.Printf("  %s:%d: %s\n", cfgFile, problem.Line, problem.Message)
+		} else {
+			fmt.Printf("  %s: %s\n", cfgFile, problem.Message)
+		}
+	}
+}
+
+// decodeConfigList decodes a configuration file holding a list of objects
+// into the slice list points to. Each object is decoded on its own, so the
+// problems of every object are reported rather than the first one only. It
+// returns the line each object starts on.
+func decodeConfigList(data []byte, list any) ([]int, []configProblem) {
+	slice := reflect.ValueOf(list).Elem()
+	elemType := slice.Type().Elem()
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	token, err := dec.Token()
+	if err == nil && token != json.Delim('[') {
+		return nil, []configProblem{{Line: lineAt(data, 1, dec.InputOffset()), Message: "expected a list of entries in [ ]"}}
+	}
+	if err != nil {
+		return nil, []configProblem{jsonProblem(data, 1, err)}
+	}
+
+	var lines []int
+	var problems []configProblem
+	decoded := reflect.MakeSlice(slice.Type(), 0, 0)
+	readable := true
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			// The rest of the file cannot be read past invalid JSON
+			problems = append(problems, jsonProblem(data, 1, err))
+			readable = false
+			break
+		}
+		// The offset is now just past the entry
+		line := lineAt(data, 1, dec.InputOffset()-int64(len(raw)))
+
+		elem := reflect.New(elemType)
+		if err := json.Unmarshal(raw, elem.Interface()); err != nil {
+			problems = append(problems, jsonProblem(raw, line, err))
+		}
+		problems = append(problems, unknownFields(raw, line, elemType, "")...)
+		decoded = reflect.Append(decoded, elem.Elem())
+		lines = append(lines, line)
+	}
+	if readable {
+		if _, err := dec.Token(); err != nil {
+			problems = append(problems, jsonProblem(data, 1, err))
+		}
+	}
+	slice.Set(decoded)
+	return lines, problems
+}
+
+// decodeConfigObject decodes a configuration file holding a single object
+// into v, keeping the values of fields missing from the file
+func decodeConfigObject(data []byte, v any) []configProblem {
+	if err := json.Unmarshal(data, v); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return []configProblem{jsonProblem(data, 1, err)}
+		}
+		return append([]configProblem{jsonProblem(data, 1, err)}, unknownFields(data, 1, reflect.TypeOf(v), "")...)
+	}
+	return unknownFields(data, 1, reflect.TypeOf(v), "")
+}
+
+// lineAt returns the line of data, which starts on line first, that the byte
+// at offset is on
+func lineAt(data []byte, first int, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return first + bytes.Count(data[:offset], []byte("\n"))
+}
+
+// jsonProblem describes a JSON decoding error of data, which starts on line
+// first, on the line it occurred on
+func jsonProblem(data []byte, first int, err error) configProblem {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return configProblem{Line: lineAt(data, first, syntaxErr.Offset), Message: fmt.Sprintf("invalid JSON: %v", err)}
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if field == "" {
+			field = "entry"
+		}
+		return configProblem{Line: lineAt(data, first, typeErr.Offset), Message: fmt.Sprintf("%s must be %s, not %s", field, jsonTypeName(typeErr.Type), typeErr.Value)}
+	}
+	return configProblem{Line: first, Message: err.Error()}
+}
+
+// jsonTypeName describes the JSON value a Go type is decoded from
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a whole number"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	}
+	return "an object"
+}
+
+// unknownFields reports the keys of the JSON object raw, which starts on line
+// first, that no field of t decodes, which encoding/json silently ignores.
+// Objects nested in fields of struct type are checked too, unless the type
+// decodes itself.
+func unknownFields(raw []byte, first int, t reflect.Type, prefix string) []configProblem {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || reflect.PointerTo(t).Implements(reflect.TypeFor[json.Unmarshaler]()) {
+		return nil
+	}
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		// encoding/json matches keys case-insensitively
+		fields[strings.ToLower(name)] = field
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if token, err := dec.Token(); err != nil || token != json.Delim('{') {
+		return nil
+	}
+	var problems []configProblem
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return problems
+		}
+		key, _ := token.(string)
+		line := lineAt(raw, first, dec.InputOffset())
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return problems
+		}
+
+		field, ok := fields[strings.ToLower(key)]
+		if !ok {
+			message := fmt.Sprintf("unknown field %q", prefix+key)
+			if suggestion := closestField(key, fields); suggestion != "" {
+				message += fmt.Sprintf(" (did you mean %q?)", prefix+suggestion)
+			}
+			problems = append(problems, configProblem{Line: line, Message: message})
+			continue
+		}
+		problems = append(problems, unknownFields(value, line, field.Type, prefix+field.Name+".")...)
+	}
+	return problems
+}
+
+// closestField returns the field name a misspelled key was probably meant
+// to be, or "" when none is close
+func closestField(key string, fields map[string]reflect.StructField) string {
+	best := ""
+	for name, field := range fields {
+		if withinEdits(strings.ToLower(key), name, maxEdits(name)) && (best == "" || field.Name < best) {
+			best = field.Name
+		}
+	}
+	return best
+}
+
+// parseReposConfig decodes and checks a repository configuration file,
+// returning the repositories with their default clone directories filled in,
+// or every problem found in the file
+func parseReposConfig(data []byte) ([]RepoConfig, []configProblem) {
+	var list []RepoConfig
+	lines, problems := decodeConfigList(data, &list)
+	for i := range list {
+		if list[i].CloneDir == "" && checkRepoName(list[i].Name) == nil {
+			list[i].CloneDir = filepath.Join(dataDir, list[i].Name+"-repo")
+		}
+	}
+	problems = append(problems, checkRepoEntries(list, lines)...)
+	return list, problems
+}
+
+// checkRepoEntries checks the settings of each repository and the
+// repositories against each other: names must be unique, and clone
+// directories must not be shared or nested, or one repository's ingest would
+// index or delete another's files
+func checkRepoEntries(list []RepoConfig, lines []int) []configProblem {
+	var problems []configProblem
+	nameLines := make(map[string]int)
+	type cloneDir struct {
+		path string
+		name string
+		line int
+	}
+	var dirs []cloneDir
+
+	for i, repo := range list {
+		line := lines[i]
+		label := "repository " + repo.Name
+		if repo.Name == "" {
+			label = fmt.Sprintf("repository #%d", i+1)
+		}
+		add := func(format string, args ...any) {
+			problems = append(problems, configProblem{Line: line, Message: label + ": " + fmt.Sprintf(format, args...)})
+		}
+
+		// The clone directory of an entry without a valid, unique name is
+		// not checked, since it derives from the name
+		validName := false
+		if err := checkRepoName(repo.Name); err != nil {
+			add("%v", err)
+		} else if first, ok := nameLines[repo.Name]; ok {
+			add("duplicate name, already used on line %d", first)
+		} else {
+			nameLines[repo.Name] = line
+			validName = true
+		}
+
+		if repo.URL == "" {
+			add("missing URL")
+		} else if err := checkRepoURL(repo.URL); err != nil {
+			add("%v", err)
+		}
+		for _, mirror := range repo.Mirrors {
+			if err := checkRepoURL(mirror); err != nil {
+				add("mirror: %v", err)
+			}
+		}
+
+		if err := checkRepoCollection(repo.Collection); err != nil {
+			add("%v", err)
+		}
+		if repo.Trust != "" {
+			if _, err := parseTier(repo.Trust); err != nil {
+				add("%v", err)
+			}
+		}
+		if err := checkRepoChunking(repo); err != nil {
+			add("%v", err)
+		}
+		var unsupported []string
+		for ext := range repoExtensions(repo) {
+			if _, ok := fileHandlers[ext]; !ok {
+				unsupported = append(unsupported, ext)
+			}
+		}
+		sort.Strings(unsupported)
+		for _, ext := range unsupported {
+			add("no handler for %q files", ext)
+		}
+		switch strings.ToLower(repo.LFS) {
+		case "", lfsSkip, lfsFetch:
+		default:
+			add("unknown LFS mode %q (expected %q or %q)", repo.LFS, lfsSkip, lfsFetch)
+		}
+		if repo.Tag != "" && repo.Commit != "" {
+			add("set Tag or Commit, not both")
+		}
+		if repo.Depth < 0 {
+			add("Depth must not be negative")
+		}
+
+		if !validName {
+			continue
+		}
+		if err := checkCloneDir(repo); err != nil {
+			add("%v", err)
+			continue
+		}
+		path, err := filepath.Abs(repo.CloneDir)
+		if err != nil {
+			continue
+		}
+		for _, other := range dirs {
+			if insideDir(other.path, path) || insideDir(path, other.path) {
+				add("clone directory %s overlaps the clone directory of %s on line %d", repo.CloneDir, other.name, other.line)
+				break
+			}
+		}
+		dirs = append(dirs, cloneDir{path: path, name: repo.Name, line: line})
+	}
+	return problems
+}
+
+// checkRepoURL checks that a repository URL is a git URL with a scheme go-git
+// clones from, an scp-style address, a local path, or a NIP-34 announcement
+func checkRepoURL(rawURL string) error {
+	switch {
+	case strings.TrimSpace(rawURL) != rawURL:
+		return fmt.Errorf("URL %q has leading or trailing spaces", rawURL)
+	case isNostrRepoURL(rawURL):
+		if _, err := decodeRepoAnnouncement(rawURL); err != nil {
+			return fmt.Errorf("URL %s: %v", rawURL, err)
+		}
+		return nil
+	case scpURLPattern.MatchString(rawURL), filepath.IsAbs(rawURL):
+		return nil
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q", rawURL)
+	}
+	switch parsed.Scheme {
+	case "https", "http", "ssh", "git":
+		if parsed.Host == "" {
+			return fmt.Errorf("URL %s has no host", rawURL)
+		}
+	case "file":
+	case "":
+		return fmt.Errorf("URL %q needs a scheme such as https://", rawURL)
+	default:
+		return fmt.Errorf("URL %s has unsupported scheme %s:", rawURL, parsed.Scheme)
+	}
+	return nil
+}
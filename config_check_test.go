@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseReposConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []configProblem // Messages are matched as substrings
+	}{
+		{
+			name: "valid",
+			config: `[
+  {"Name": "nips", "URL": "https://github.com/nostr-protocol/nips", "Enabled": true},
+  {"Name": "go-nostr", "URL": "https://github.com/nbd-wtf/go-nostr", "Enabled": true}
+]`,
+		},
+		{
+			name: "unknown fields",
+			config: `[
+  {"Name": "nips", "URL": "https://github.com/nostr-protocol/nips",
+   "Enabeld": true},
+  {"Name": "go-nostr", "URL": "https://github.com/nbd-wtf/go-nostr",
+   "Auth": {"Usrname": "git"}, "Colour": "blue"}
+]`,
+			want: []configProblem{
+				{Line: 3, Message: `unknown field "Enabeld" (did you mean "Enabled"?)`},
+				{Line: 5, Message: `unknown field "Auth.Usrname" (did you mean "Auth.Username"?)`},
+				{Line: 5, Message: `unknown field "Colour"`},
+			},
+		},
+		{
+			name: "duplicate names",
+			config: `[
+  {"Name": "nips", "URL": "https://github.com/nostr-protocol/nips"},
+  {"Name": "wiki", "URL": "https://github.com/nostr-protocol/wiki"},
+  {"Name": "nips", "URL": "https://github.com/fiatjaf/nips"}
+]`,
+			want: []configProblem{
+				{Line: 4, Message: "repository nips: duplicate name, already used on line 2"},
+			},
+		},
+		{
+			name: "shared clone directory",
+			config: `[
+  {"Name": "nips", "URL": "https://github.com/nostr-protocol/nips", "CloneDir": "data/specs"},
+  {"Name": "nips-fork", "URL": "https://github.com/fiatjaf/nips", "CloneDir": "data/specs"}
+]`,
+			want: []configProblem{
+				{Line: 3, Message: "repository nips-fork: clone directory data/specs overlaps the clone directory of nips on line 2"},
+			},
+		},
+		{
+			name: "nested clone directory",
+			config: `[
+  {"Name": "nips", "URL": "https://github.com/nostr-protocol/nips"},
+  {"Name": "inner", "URL": "https://github.com/nbd-wtf/go-nostr", "CloneDir": "data/nips-repo/vendor"}
+]`,
+			want: []configProblem{
+				{Line: 3, Message: "overlaps the clone directory of nips on line 2"},
+			},
+		},
+		{
+			name: "invalid JSON",
+			config: `[
+  {"Name": "nips", "URL": "https://github.com/nostr-protocol/nips"},
+  {"Name": "wiki" "URL": "https://github.com/nostr-protocol/wiki"}
+]`,
+			want: []configProblem{
+				{Line: 3, Message: "invalid JSON"},
+			},
+		},
+		{
+			name: "wrong type",
+			config: `[
+  {"Name": "nips", "URL": "https://github.com/nostr-protocol/nips",
+   "Depth": "1"}
+]`,
+			want: []configProblem{
+				{Line: 3, Message: "Depth must be a whole number, not string"},
+			},
+		},
+		{
+			name:   "not a list",
+			config: `{"Name": "nips"}`,
+			want: []configProblem{
+				{Line: 1, Message: "expected a list of entries in [ ]"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, problems := parseReposConfig([]byte(test.config))
+			if len(problems) != len(test.want) {
+				t.Fatalf("got %d problems, want %d: %v", len(problems), len(test.want), problems)
+			}
+			for _, want := range test.want {
+				if !hasProblem(problems, want) {
+					t.Errorf("no problem on line %d containing %q in %v", want.Line, want.Message, problems)
+				}
+			}
+		})
+	}
+}
+
+// hasProblem reports whether problems has one on want's line whose message
+// contains want's
+func hasProblem(problems []configProblem, want configProblem) bool {
+	for _, problem := range problems {
+		if problem.Line == want.Line && strings.Contains(problem.Message, want.Message) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestParseReposConfigDefaults(t *testing.T) {
+	list, problems := parseReposConfig([]byte(`[{"Name": "nips", "URL": "https://github.com/nostr-protocol/nips"}]`))
+	if len(problems) != 0 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+	if want := filepath.Join(dataDir, "nips-repo"); len(list) != 1 || list[0].CloneDir != want {
+		t.Errorf("got %+v, want the clone directory %s", list, want)
+	}
+}
+
+func TestUnknownFieldsLines(t *testing.T) {
+	type settings struct {
+		Name    string
+		Filters []string
+	}
+	raw := []byte(`{
+  "name": "nips",
+  "Filtres": [],
+  "Extra": {"Nested": true}
+}`)
+	problems := unknownFields(raw, 10, reflect.TypeFor[settings](), "")
+	want := []configProblem{
+		{Line: 12, Message: `unknown field "Filtres" (did you mean "Filters"?)`},
+		{Line: 13, Message: `unknown field "Extra"`},
+	}
+	if fmt.Sprint(problems) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", problems, want)
+	}
+}
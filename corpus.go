@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// corpusKey is the meta key holding the manifest of the last ingest
+const corpusKey = "corpus"
+
+// corpusRepo describes what was ingested from one repository
+type corpusRepo struct {
+	Name       string
+	URL        string
+	Collection string
+	Ref        string    `json:",omitempty"` // Branch, tag, or commit the repository is pinned to
+	Commit     string    `json:",omitempty"` // HEAD of the clone when it was ingested
+	CommitDate time.Time `json:",omitzero"`
+	FetchedAt  time.Time `json:",omitzero"` // When the clone last fetched from upstream
+	Files      int
+	Chunks     int
+	Notes      []string      `json:",omitempty"` // Anything that makes the repository's coverage incomplete
+	Skipped    []skippedFile `json:",omitempty"` // Files and directories left out by the size, binary, and vendoring checks
+}
+
+// fetchedFile records in a clone's .git directory when the clone last
+// fetched from upstream successfully
+const fetchedFile = "last-fetch"
+
+// recordFetch notes that the clone in dir has just fetched from upstream.
+// It is best effort: without the record, staleness is judged by the date of
+// the checked-out commit.
+func recordFetch(dir string) {
+	os.WriteFile(filepath.Join(dir, ".git", fetchedFile), []byte(time.Now().UTC().Format(time.RFC3339)), 0644)
+}
+
+// repoFetchTime returns when the clone in dir last fetched from upstream, or
+// the zero time when no fetch was recorded
+func repoFetchTime(dir string) time.Time {
+	data, err := os.ReadFile(filepath.Join(dir, ".git", fetchedFile))
+	if err != nil {
+		return time.Time{}
+	}
+	fetched, _ := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	return fetched
+}
+
+// corpusManifest describes everything the index was built from
+type corpusManifest struct {
+	IngestedAt time.Time
+	Repos      []corpusRepo
+}
+
+// ingestManifest collects the manifest while createDatabase runs
+var ingestManifest corpusManifest
+
+// repoHeadCommit returns the commit checked out in a clone and its commit date
+func repoHeadCommit(dir string) (string, time.Time, error) {
+	r, err := git.PlainOpen(dir)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	commit, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return head.Hash().String(), time.Time{}, nil
+	}
+	return head.Hash().String(), commit.Committer.When, nil
+}
+
+// newCorpusRepo starts the manifest entry for a repository about to be ingested
+func newCorpusRepo(repo RepoConfig) corpusRepo {
+	entry := corpusRepo{Name: repo.Name, URL: repo.URL, Collection: repoCollection(repo.Name), Ref: pinnedRef(repo)}
+	if _, err := os.Stat(repo.CloneDir); err != nil {
+		entry.Notes = append(entry.Notes, "not cloned; nothing was ingested")
+		return entry
+	}
+	entry.FetchedAt = repoFetchTime(repo.CloneDir)
+	commit, date, err := repoHeadCommit(repo.CloneDir)
+	if err != nil {
+		entry.Notes = append(entry.Notes, fmt.Sprintf("commit unknown: %v", err))
+		return entry
+	}
+	entry.Commit, entry.CommitDate = commit, date
+	if note := pinMismatch(repo, commit); note != "" {
+		entry.Notes = append(entry.Notes, note)
+	}
+	return entry
+}
+
+// loadCorpusManifest reads the manifest of the database at path. It returns
+// nil for databases ingested before manifests were recorded.
+func loadCorpusManifest(path string) (*corpusManifest, error) {
+	var manifest corpusManifest
+	found, err := loadMetaJSON(path, corpusKey, &manifest)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// describeAge turns a duration into a rough human age, e.g. "3 days" or "4 months"
+func describeAge(d time.Duration) string {
+	switch days := int(d.Hours() / 24); {
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	case days < 60:
+		return fmt.Sprintf("%d days", days)
+	default:
+		return fmt.Sprintf("%d months", days/30)
+	}
+}
+
+// formatCorpusManifest renders the manifest as markdown
+func formatCorpusManifest(manifest *corpusManifest) string {
+	var b strings.Builder
+	b.WriteString("# Corpus Manifest\n\n")
+	if manifest == nil {
+		b.WriteString("No manifest was recorded for this index. Re-ingest with -ingest to record one.\n")
+		return b.String()
+	}
+
+	now := time.Now()
+	b.WriteString(fmt.Sprintf("Ingested %s (%s ago).\n", manifest.IngestedAt.UTC().Format(time.RFC3339), describeAge(now.Sub(manifest.IngestedAt))))
+
+	for _, repo := range manifest.Repos {
+		b.WriteString(fmt.Sprintf("\n## %s\n", repo.Name))
+		b.WriteString(fmt.Sprintf("- URL: %s\n", repo.URL))
+		b.WriteString(fmt.Sprintf("- Collection: %s\n", repo.Collection))
+		if repo.Ref != "" {
+			b.WriteString(fmt.Sprintf("- Pinned to: %s\n", repo.Ref))
+		}
+		if repo.Commit != "" {
+			b.WriteString(fmt.Sprintf("- Commit: %s", repo.Commit))
+			if !repo.CommitDate.IsZero() {
+				b.WriteString(fmt.Sprintf(" from %s (%s old)", repo.CommitDate.UTC().Format("2006-01-02"), describeAge(now.Sub(repo.CommitDate))))
+			}
+			b.WriteString("\n")
+		}
+		if !repo.FetchedAt.IsZero() {
+			b.WriteString(fmt.Sprintf("- Last fetched: %s (%s ago)\n", repo.FetchedAt.UTC().Format("2006-01-02"), describeAge(now.Sub(repo.FetchedAt))))
+		}
+		b.WriteString(fmt.Sprintf("- Files: %d\n", repo.Files))
+		b.WriteString(fmt.Sprintf("- Chunks: %d\n", repo.Chunks))
+		for _, note := range repo.Notes {
+			b.WriteString(fmt.Sprintf("- Note: %s\n", note))
+		}
+		if len(repo.Skipped) > 0 {
+			b.WriteString(fmt.Sprintf("- Skipped: %d files\n", len(repo.Skipped)))
+			for i, file := range repo.Skipped {
+				if i == maxSkippedShown {
+					b.WriteString(fmt.Sprintf("  - ... and %d more\n", len(repo.Skipped)-maxSkippedShown))
+					break
+				}
+				b.WriteString(fmt.Sprintf("  - %s: %s\n", file.Path, file.Reason))
+			}
+		}
+	}
+	return b.String()
+}
+
+func corpusResourceHandler(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	return []mcp.ResourceContents{
+		mcp.TextResourceContents{
+			URI:      request.Params.URI,
+			MIMEType: "text/markdown",
+			Text:     formatCorpusManifest(currentIndex().corpus),
+		},
+	}, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// countTimeout bounds the NIP-45 COUNT pre-check on each relay
+var countTimeout = 3 * time.Second
+
+// relaysWithMatches asks each relay for a NIP-45 COUNT of events matching the
+// filters and drops the relays that report none. Relays that do not support
+// COUNT, or do not answer in time, are kept so no results are lost.
+func relaysWithMatches(ctx context.Context, urls []string, filters []nostr.Filter) []string {
+	keep := make([]bool, len(urls))
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			count, ok := countEvents(ctx, url, filters)
+			keep[i] = !ok || count > 0
+		}(i, url)
+	}
+	wg.Wait()
+
+	var matching []string
+	for i, url := range urls {
+		if keep[i] {
+			matching = append(matching, url)
+		}
+	}
+	return matching
+}
+
+// countEvents returns the number of events a relay holds for the filters and
+// whether the relay answered the COUNT request
+func countEvents(ctx context.Context, url string, filters []nostr.Filter) (int64, bool) {
+	countCtx, cancel := context.WithTimeout(ctx, countTimeout)
+	defer cancel()
+
+	relay, err := connectRelay(countCtx, url)
+	if err != nil {
+		return 0, false
+	}
+	defer relay.Close()
+
+	// Relays that do not support COUNT may never answer, so only an answer
+	// counts for the circuit breaker
+	count, _, err := relay.Count(countCtx, filters)
+	if err != nil {
+		return 0, false
+	}
+	relaySucceeded(url)
+	return count, true
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// coverageRecentDays is how long ago a NIP may last have appeared in query
+// results to count as recently hit
+const coverageRecentDays = 30
+
+// nipCoverage is how well a NIP file is covered by the index
+type nipCoverage struct {
+	NIP     nipEntry
+	Chunks  int
+	Commit  string    // Commit the file's chunks were ingested from
+	Hits    uint64    // Queries whose results included the file
+	LastHit time.Time // Last such query, zero when unknown
+}
+
+// recent reports whether the NIP appeared in query results in the last
+// coverageRecentDays days
+func (c nipCoverage) recent() bool {
+	return !c.LastHit.IsZero() && time.Since(c.LastHit) < coverageRecentDays*24*time.Hour
+}
+
+// printCoverageReport prints every NIP file of the NIPs repository with its
+// chunk count, the commit it was ingested from, and whether it appears in
+// query results, as a markdown or csv table. NIP files without chunks were
+// skipped by the walker or the chunker.
+func printCoverageReport(format string) {
+	if format != "markdown" && format != "csv" {
+		fmt.Printf("Error: unknown report format %q; use markdown or csv\n", format)
+		os.Exit(1)
+	}
+
+	nipsRepo, err := findNipsRepo()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	nips, err := scanNips(nipsRepo.CloneDir)
+	if err != nil {
+		log.Fatalf("Error reading NIPs from %s: %v", nipsRepo.CloneDir, err)
+	}
+
+	coverage, err := readNipCoverage(nipsRepo, nips)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if format == "csv" {
+		writeCoverageCSV(coverage)
+		return
+	}
+	fmt.Print(formatCoverageMarkdown(coverage))
+}
+
+// readNipCoverage counts the chunks of each NIP file in the index and looks
+// up its query hits in the local usage statistics
+func readNipCoverage(nipsRepo RepoConfig, nips []nipEntry) ([]nipCoverage, error) {
+	store := vectorStore{}
+	if err := initializeStore(&store, dbPath); err != nil {
+		return nil, fmt.Errorf("error initializing vector store: %v", err)
+	}
+	records, err := store.GetAll()
+	closeStore(&store)
+	if err != nil {
+		return nil, fmt.Errorf("error reading chunks: %v", err)
+	}
+
+	chunks := make(map[string]int)
+	commits := make(map[string]string)
+	for _, record := range records {
+		source := chunkSource(record.Id)
+		chunks[source]++
+		if commit := recordSourceMeta(record).Commit; commit != "" {
+			commits[source] = commit
+		}
+	}
+
+	hits := make(map[string]uint64)
+	lastHits := make(map[string]time.Time)
+	if _, err := os.Stat(statsPath); err == nil {
+		db, err := openStatsForReading()
+		if err != nil {
+			return nil, fmt.Errorf("error opening statistics database %s: %v", statsPath, err)
+		}
+		db.View(func(tx *bbolt.Tx) error {
+			hits = readCounters(tx, statsSourcesBucket)
+			lastHits = readLastHits(tx)
+			return nil
+		})
+		db.Close()
+	}
+
+	coverage := make([]nipCoverage, len(nips))
+	for i, nip := range nips {
+		source := fileSource(nipsRepo.Name, filepath.Join(nipsRepo.CloneDir, nip.File))
+		coverage[i] = nipCoverage{
+			NIP:     nip,
+			Chunks:  chunks[source],
+			Commit:  commits[source],
+			Hits:    hits[source],
+			LastHit: lastHits[source],
+		}
+	}
+	return coverage, nil
+}
+
+// formatCoverageMarkdown renders the coverage as a markdown table, followed
+// by the NIPs missing from the index
+func formatCoverageMarkdown(coverage []nipCoverage) string {
+	var b strings.Builder
+	b.WriteString("| NIP | Title | Chunks | Commit | Hits | Last hit | Recent |\n")
+	b.WriteString("|-----|-------|-------:|--------|-----:|----------|--------|\n")
+	var missing []string
+	for _, c := range coverage {
+		chunks := strconv.Itoa(c.Chunks)
+		if c.Chunks == 0 {
+			chunks = "**0**"
+			missing = append(missing, c.NIP.Number)
+		}
+		b.WriteString(fmt.Sprintf("| %s | %s | %s | %s | %d | %s | %s |\n",
+			c.NIP.Number, strings.ReplaceAll(c.NIP.Title, "|", "\\|"), chunks, shortCommit(c.Commit), c.Hits, lastHitDate(c.LastHit), yesNo(c.recent())))
+	}
+
+	b.WriteString(fmt.Sprintf("\n%d NIP files, %d without chunks", len(coverage), len(missing)))
+	if len(missing) > 0 {
+		b.WriteString(": " + strings.Join(missing, ", "))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// writeCoverageCSV writes the coverage as CSV with a header row
+func writeCoverageCSV(coverage []nipCoverage) {
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"nip", "file", "title", "chunks", "commit", "hits", "last_hit", "recent"})
+	for _, c := range coverage {
+		w.Write([]string{
+			c.NIP.Number,
+			c.NIP.File,
+			c.NIP.Title,
+			strconv.Itoa(c.Chunks),
+			c.Commit,
+			strconv.FormatUint(c.Hits, 10),
+			lastHitDate(c.LastHit),
+			strconv.FormatBool(c.recent()),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Fatalf("Error writing report: %v", err)
+	}
+}
+
+// shortCommit abbreviates a commit hash for display
+func shortCommit(commit string) string {
+	return commit[:min(12, len(commit))]
+}
+
+// lastHitDate formats when a NIP last appeared in query results, or "" when
+// that is unknown
+func lastHitDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.DateOnly)
+}
+
+// yesNo renders a boolean for the markdown table
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
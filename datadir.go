@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// dataQuota is the maximum combined size of the clones and the database in
+// bytes (0 for no limit), set with -data-quota
+var dataQuota int64
+
+// tempFileSuffixes mark files left behind by interrupted operations
+var tempFileSuffixes = []string{".compact", ingestSuffix, eventBatchSuffix, checkpointSuffix, ".tmp", ".partial"}
+
+// diskUsage is the size of one item in the data directory
+type diskUsage struct {
+	Name  string
+	Path  string
+	Bytes int64
+}
+
+// parseSize parses a size such as "500MB", "2G" or "1048576"
+func parseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	value = strings.TrimSuffix(value, "B")
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		Suffix string
+		Bytes  int64
+	}{{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40}} {
+		if number, ok := strings.CutSuffix(value, unit.Suffix); ok {
+			value, multiplier = number, unit.Bytes
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %q (expected e.g. 500MB or 2GB)", value)
+	}
+	return int64(number * float64(multiplier)), nil
+}
+
+// formatBytes renders a byte count for humans
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGT"[exp])
+}
+
+// pathSize returns the total size of the files under path
+func pathSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// dataUsage measures the database and every directory in the data directory
+func dataUsage() []diskUsage {
+	usage := []diskUsage{{Name: "embeddings database", Path: dbPath, Bytes: pathSize(dbPath)}}
+
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return usage
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dataDir, entry.Name())
+		usage = append(usage, diskUsage{Name: entry.Name(), Path: path, Bytes: pathSize(path)})
+	}
+
+	sort.SliceStable(usage[1:], func(i, j int) bool {
+		return usage[i+1].Bytes > usage[j+1].Bytes
+	})
+	return usage
+}
+
+// totalUsage adds up the sizes of all items
+func totalUsage(usage []diskUsage) int64 {
+	var total int64
+	for _, item := range usage {
+		total += item.Bytes
+	}
+	return total
+}
+
+// checkDataQuota returns an error when the data directory and database
+// already use more than the configured quota
+func checkDataQuota() error {
+	if dataQuota <= 0 {
+		return nil
+	}
+	if total := totalUsage(dataUsage()); total > dataQuota {
+		return fmt.Errorf("data quota exceeded: %s used of %s; run with -clean or raise -data-quota", formatBytes(total), formatBytes(dataQuota))
+	}
+	return nil
+}
+
+// printDiskUsage lists what takes up space in the data directory
+func printDiskUsage() {
+	usage := dataUsage()
+	for _, item := range usage {
+		fmt.Printf("  %10s  %s\n", formatBytes(item.Bytes), item.Path)
+	}
+
+	total := totalUsage(usage)
+	if dataQuota > 0 {
+		fmt.Printf("Total: %s of %s quota (%.0f%%)\n", formatBytes(total), formatBytes(dataQuota), 100*float64(total)/float64(dataQuota))
+		return
+	}
+	fmt.Printf("Total: %s\n", formatBytes(total))
+}
+
+// cleanDataDirectory removes clones that no enabled repository uses and
+// temporary files left behind by interrupted operations
+func cleanDataDirectory() {
+	inUse := make(map[string]bool)
+	for _, repo := range repos {
+		if isActiveRepo(repo) {
+			if abs, err := filepath.Abs(repo.CloneDir); err == nil {
+				inUse[abs] = true
+			}
+		}
+	}
+
+	var freed int64
+	remove := func(path, reason string) {
+		size := pathSize(path)
+		if err := os.RemoveAll(path); err != nil {
+			fmt.Printf("Error removing %s: %v\n", path, err)
+			return
+		}
+		freed += size
+		fmt.Printf("Removed %s (%s, %s)\n", path, reason, formatBytes(size))
+	}
+
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		log.Fatalf("Error reading data directory: %v", err)
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dataDir, entry.Name())
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			continue
+		}
+
+		if entry.IsDir() {
+			// Only git clones are removed; anything else may be user data
+			if _, err := os.Stat(filepath.Join(path, ".git")); err == nil && !inUse[abs] {
+				remove(path, "clone of a disabled or removed repository")
+			}
+			continue
+		}
+		for _, suffix := range tempFileSuffixes {
+			if strings.HasSuffix(entry.Name(), suffix) {
+				remove(path, "leftover temporary file")
+				break
+			}
+		}
+	}
+
+	for _, suffix := range tempFileSuffixes {
+		if _, err := os.Stat(dbPath + suffix); err == nil {
+			remove(dbPath+suffix, "leftover temporary file")
+		}
+	}
+
+	fmt.Printf("Freed %s.\n\n", formatBytes(freed))
+	printDiskUsage()
+}
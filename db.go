@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/parakeet-nest/parakeet/llm"
+	"go.etcd.io/bbolt"
+)
+
+// dbOpenTimeout bounds how long maintenance commands wait for the database
+// file lock, so they fail fast while an MCP server is holding it open
+var dbOpenTimeout = 2 * time.Second
+
+// chunkIDSuffix matches the "-chunk-N" suffix appended to every embedding ID
+var chunkIDSuffix = regexp.MustCompile(`-chunk-\d+$`)
+
+// dbIssue describes a single problem found in the embeddings database
+type dbIssue struct {
+	Bucket  string
+	Key     string
+	Problem string
+	Source  string // Repository and path of the chunk's file, when its record stored them
+}
+
+// openRawDatabase opens the bbolt file backing the vector store directly
+func openRawDatabase(path string, readOnly bool) (*bbolt.DB, error) {
+	return bbolt.Open(path, 0600, &bbolt.Options{
+		Timeout:  dbOpenTimeout,
+		ReadOnly: readOnly,
+	})
+}
+
+// verifyDatabase scans every stored record and reports corrupt entries,
+// optionally deleting them when repair is true
+func verifyDatabase(path string, repair bool) {
+	db, err := openRawDatabase(path, !repair)
+	if err != nil {
+		log.Fatalf("Error opening database %s: %v", path, err)
+	}
+	defer db.Close()
+
+	var issues []dbIssue
+	var total int
+	err = db.View(func(tx *bbolt.Tx) error {
+		var scanErr error
+		issues, total, scanErr = scanDatabase(tx)
+		return scanErr
+	})
+	if err != nil {
+		log.Fatalf("Error scanning database: %v", err)
+	}
+
+	var version int
+	err = db.View(func(tx *bbolt.Tx) error {
+		var versionErr error
+		version, versionErr = readSchemaVersion(tx)
+		return versionErr
+	})
+	if err != nil {
+		log.Fatalf("Error reading schema version: %v", err)
+	}
+
+	fmt.Printf("Checked %d records in %s (schema version %d, current %d)\n", total, path, version, currentSchemaVersion())
+	if len(issues) == 0 {
+		fmt.Println("No problems found.")
+		return
+	}
+
+	fmt.Printf("Found %d problem(s):\n", len(issues))
+	for _, issue := range issues {
+		fmt.Printf("  [%s] %s: %s\n", issue.Bucket, issue.Key, issue.Problem)
+	}
+
+	sources := affectedSources(issues)
+	if len(sources) > 0 {
+		fmt.Println("\nAffected source files (re-ingest these):")
+		for _, source := range sources {
+			fmt.Printf("  %s\n", source)
+		}
+	}
+
+	if !repair {
+		fmt.Println("\nRun again with -db-repair to drop the corrupt entries.")
+		return
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, issue := range issues {
+			bucket := tx.Bucket([]byte(issue.Bucket))
+			if bucket == nil {
+				continue
+			}
+			if err := bucket.Delete([]byte(issue.Key)); err != nil {
+				return fmt.Errorf("error deleting %s: %v", issue.Key, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Error repairing database: %v", err)
+	}
+	fmt.Printf("\nRemoved %d corrupt entries.\n", len(issues))
+}
+
+// scanDatabase walks every bucket of the store and collects problems with
+// individual records. It returns the issues and the number of records checked.
+func scanDatabase(tx *bbolt.Tx) ([]dbIssue, int, error) {
+	type decoded struct {
+		bucket string
+		key    string
+		record llm.VectorRecord
+	}
+
+	var issues []dbIssue
+	var records []decoded
+	var total int
+	dimensions := make(map[int]int)
+
+	err := tx.ForEach(func(name []byte, bucket *bbolt.Bucket) error {
+		if string(name) == metaBucket {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			key := string(k)
+			if v == nil {
+				// Nested buckets are not part of the vector store layout
+				return nil
+			}
+			total++
+
+			var record llm.VectorRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				issues = append(issues, dbIssue{Bucket: string(name), Key: key, Problem: fmt.Sprintf("unreadable record: %v", err)})
+				return nil
+			}
+
+			records = append(records, decoded{string(name), key, record})
+			if len(record.Embedding) > 0 {
+				dimensions[len(record.Embedding)]++
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// The most common vector length is taken as the expected dimension
+	expected, expectedCount := 0, 0
+	for dim, count := range dimensions {
+		if count > expectedCount || (count == expectedCount && dim > expected) {
+			expected, expectedCount = dim, count
+		}
+	}
+
+	for _, d := range records {
+		if problem := checkRecord(d.key, d.record, expected); problem != "" {
+			issue := dbIssue{Bucket: d.bucket, Key: d.key, Problem: problem}
+			if meta := recordSourceMeta(d.record); meta.Repo != "" && meta.Path != "" {
+				issue.Source = meta.Repo + "/" + meta.Path
+			}
+			issues = append(issues, issue)
+		}
+	}
+
+	sort.Slice(issues, func(i, j int) bool {
+		if issues[i].Bucket != issues[j].Bucket {
+			return issues[i].Bucket < issues[j].Bucket
+		}
+		return issues[i].Key < issues[j].Key
+	})
+
+	return issues, total, nil
+}
+
+// checkRecord returns a description of what is wrong with a record, or an
+// empty string if the record looks healthy
+func checkRecord(key string, record llm.VectorRecord, expectedDim int) string {
+	if len(record.Embedding) == 0 {
+		return "truncated vector: embedding is empty"
+	}
+	if expectedDim > 0 && len(record.Embedding) != expectedDim {
+		return fmt.Sprintf("dimension mismatch: got %d, expected %d", len(record.Embedding), expectedDim)
+	}
+	for _, value := range record.Embedding {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return "truncated vector: embedding contains NaN or Inf values"
+		}
+	}
+	if record.Id != "" && record.Id != key {
+		return fmt.Sprintf("orphaned metadata: stored under %s but record ID is %s", key, record.Id)
+	}
+	if record.Prompt == "" {
+		return "orphaned metadata: vector has no document text"
+	}
+	return ""
+}
+
+// affectedSources maps corrupt embedding IDs back to the files they came from
+func affectedSources(issues []dbIssue) []string {
+	seen := make(map[string]bool)
+	var sources []string
+	for _, issue := range issues {
+		if !chunkIDSuffix.MatchString(issue.Key) {
+			continue
+		}
+		source := issue.Source
+		if source == "" {
+			source = sourceFile(chunkSource(issue.Key))
+		}
+		if !seen[source] {
+			seen[source] = true
+			sources = append(sources, source)
+		}
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// sourceFile finds the file in its repository's clone that a chunk source
+// ("<repo>/<path>", with ".md" dropped from NIP files) was ingested from.
+// Sources whose file is not found are returned unchanged.
+func sourceFile(source string) string {
+	repoName, rest, found := strings.Cut(source, "/")
+	i := findRepoByName(repoName)
+	if !found || i < 0 {
+		return source
+	}
+	for _, name := range []string{rest, rest + ".md"} {
+		if info, err := os.Stat(filepath.Join(repos[i].CloneDir, filepath.FromSlash(name))); err == nil && !info.IsDir() {
+			return repoName + "/" + name
+		}
+	}
+	return source
+}
+
+// compactDatabase rewrites the database into a fresh file so that free pages
+// left behind by deletions and re-ingestion are returned to the filesystem
+func compactDatabase(path string) {
+	before, err := os.Stat(path)
+	if err != nil {
+		log.Fatalf("Error reading database %s: %v", path, err)
+	}
+
+	src, err := openRawDatabase(path, true)
+	if err != nil {
+		log.Fatalf("Error opening database %s: %v", path, err)
+	}
+
+	tmpPath := path + ".compact"
+	os.Remove(tmpPath)
+	dst, err := bbolt.Open(tmpPath, before.Mode(), nil)
+	if err != nil {
+		src.Close()
+		log.Fatalf("Error creating compacted database: %v", err)
+	}
+
+	err = bbolt.Compact(dst, src, 0)
+	src.Close()
+	dst.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		log.Fatalf("Error compacting database: %v", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		log.Fatalf("Error replacing database with compacted copy: %v", err)
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		log.Fatalf("Error reading compacted database: %v", err)
+	}
+
+	fmt.Printf("Compacted %s: %d bytes -> %d bytes (reclaimed %d bytes)\n",
+		path, before.Size(), after.Size(), before.Size()-after.Size())
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAffectedSources(t *testing.T) {
+	inTempDir(t)
+	repos = []RepoConfig{
+		{Name: "nips", CloneDir: "nips-repo"},
+		{Name: "go-nostr", CloneDir: "go-nostr-repo"},
+	}
+	writeFixture(t, map[string]string{
+		"nips-repo/01.md":         "# NIP-01",
+		"go-nostr-repo/relay.go":  "package nostr",
+		"go-nostr-repo/README.md": "# go-nostr",
+	})
+
+	issues := []dbIssue{
+		{Key: "nips/01-chunk-1"},
+		{Key: "nips/01-chunk-2"},
+		{Key: "go-nostr/relay.go-chunk-2"},
+		{Key: "go-nostr/README-chunk-1"},
+		{Key: "nostr-tools/pool-chunk-3", Source: "nostr-tools/src/pool.ts"},
+		{Key: "removed/notes-chunk-1"},
+		{Key: "__schema_version__"},
+	}
+	want := []string{"go-nostr/README.md", "go-nostr/relay.go", "nips/01.md", "nostr-tools/src/pool.ts", "removed/notes"}
+	if got := affectedSources(issues); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
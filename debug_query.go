@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// String renders a filter term the way it is written in a query
+func (t filterTerm) String() string {
+	op := ""
+	if t.Op != "=" {
+		op = t.Op
+	}
+	value := t.Value
+	if strings.ContainsAny(value, " \t") {
+		value = fmt.Sprintf("%q", value)
+	}
+	neg := ""
+	if t.Negate {
+		neg = "-"
+	}
+	return fmt.Sprintf("%s%s:%s%s", neg, t.Field, op, value)
+}
+
+// String renders a filter the way it is written in a query
+func (f *queryFilter) String() string {
+	if f == nil {
+		return "none"
+	}
+	groups := make([]string, len(f.Groups))
+	for i, group := range f.Groups {
+		terms := make([]string, len(group))
+		for j, term := range group {
+			terms[j] = term.String()
+		}
+		groups[i] = strings.Join(terms, " ")
+	}
+	return strings.Join(groups, " OR ")
+}
+
+// debugRetrieval runs a query through every retrieval step and describes
+// each one: how the query was parsed and embedded, where it was routed, how
+// every top candidate scored, and why each was kept or dropped
+func debugRetrieval(ctx context.Context, store vectorReader, query, mode string, expand, rerank, routed bool, opts searchOptions, maxChars int) (string, error) {
+	candidates, trace, err := traceCandidates(ctx, store, query, mode, expand)
+	if err != nil {
+		return "", err
+	}
+	selected, reranked := rerankResults(query, candidates, opts, rerank)
+	results := applyContextBudget(selected, maxChars)
+
+	var b strings.Builder
+	b.WriteString("## Query\n")
+	b.WriteString(fmt.Sprintf("- Input: %s\n", query))
+	b.WriteString(fmt.Sprintf("- Search text: %s\n", trace.QueryText))
+	b.WriteString(fmt.Sprintf("- Filters: %s\n", trace.Filter))
+	if len(opts.Filter) > 0 {
+		b.WriteString(fmt.Sprintf("- Filter parameters: %s\n", formatTerms(opts.Filter)))
+	}
+	if len(trace.Expansions) > 0 {
+		b.WriteString(fmt.Sprintf("- Alias expansions: %s\n", strings.Join(trace.Expansions, ", ")))
+	} else {
+		b.WriteString("- Alias expansions: none\n")
+	}
+	prompt := trace.Prompt
+	if activeEmbedder != embedderOllama {
+		prompt = stripTaskPrefix(prompt)
+	}
+	b.WriteString(fmt.Sprintf("- Embedded prompt: %q\n", prompt))
+	switch {
+	case trace.VariantErr != nil:
+		b.WriteString(fmt.Sprintf("- Query variants: none, expansion failed: %v\n", trace.VariantErr))
+	case len(trace.Variants) > 0:
+		b.WriteString(fmt.Sprintf("- Query variants from %s, also embedded, each chunk keeping its best score:\n", expansionModel()))
+		for _, variant := range trace.Variants {
+			b.WriteString(fmt.Sprintf("  - %s\n", variant))
+		}
+	}
+	b.WriteString(fmt.Sprintf("- Embedder: %s, metric: %s\n", activeEmbedder, store.Metric()))
+	if trace.Mode == modeHybrid {
+		b.WriteString(fmt.Sprintf("- Retrieval: hybrid, score = %.2f × similarity + %.2f × keyword score\n", vectorWeight, lexicalWeight))
+	} else {
+		b.WriteString("- Retrieval: vector\n")
+	}
+
+	b.WriteString("\n## Routing\n")
+	switch {
+	case len(opts.Collections) == 0:
+		b.WriteString("- Searched every collection\n")
+	case routed:
+		b.WriteString(fmt.Sprintf("- Routed to %s by the query's wording\n", strings.Join(opts.Collections, ", ")))
+	default:
+		b.WriteString(fmt.Sprintf("- Searched %s as requested\n", strings.Join(opts.Collections, ", ")))
+	}
+
+	b.WriteString("\n## Candidates\n")
+	scored := "similarity"
+	if trace.Mode == modeHybrid {
+		scored = "hybrid score"
+	}
+	if len(trace.Feedback) == 0 {
+		b.WriteString(fmt.Sprintf("%d chunks passed the filters and were scored, ranked by %s alone.\n\n", len(candidates), scored))
+	} else {
+		b.WriteString(fmt.Sprintf("%d chunks passed the filters and were scored. Result ratings adjusted the scores below before ranking:\n", len(candidates)))
+		for _, candidate := range candidates {
+			if adjustment, ok := trace.Feedback[candidate.Record.Id]; ok {
+				b.WriteString(fmt.Sprintf("- %s: %s %.4f, feedback %+.4f\n", candidate.Record.Id, scored, candidate.Score-adjustment, adjustment))
+			}
+		}
+		b.WriteString("\n")
+	}
+	if trace.Mode == modeHybrid {
+		b.WriteString(formatKeywordScores(candidates, trace.Lexical))
+	}
+	b.WriteString(explainSearch(store.Metric(), candidates, opts))
+
+	if reranked != nil {
+		b.WriteString("\n## Reranking\n")
+		b.WriteString(formatRerank(reranked))
+	}
+
+	b.WriteString("\n## Selection\n")
+	if len(results) == 0 {
+		b.WriteString("No chunk was selected.\n")
+		if len(candidates) > 0 {
+			b.WriteString(fmt.Sprintf("The best candidate, %s, scored %.4f against a min score of %.4f.\n", candidates[0].Record.Id, candidates[0].Score, opts.Threshold))
+		}
+		return b.String(), nil
+	}
+	for i, result := range results {
+		note := ""
+		if i < len(selected) && len(result.Record.Prompt) < len(selected[i].Record.Prompt) {
+			note = " (shortened to fit the character budget)"
+		}
+		b.WriteString(fmt.Sprintf("%d. %s score=%.4f%s\n", i+1, result.Record.Id, result.Score, note))
+	}
+	if dropped := len(selected) - len(results); dropped > 0 {
+		b.WriteString(fmt.Sprintf("%d lower-ranked chunks were dropped to stay within %d characters.\n", dropped, maxChars))
+	}
+	return b.String(), nil
+}
+
+// keywordScoresShown caps the keyword matches listed by debug_query
+const keywordScoresShown = 10
+
+// formatKeywordScores lists the candidates with the best keyword scores in
+// hybrid mode, with the rank their merged score gave them
+func formatKeywordScores(candidates []searchResult, lexical map[string]float64) string {
+	if len(lexical) == 0 {
+		return "No chunk contains the words of the query, so keyword scores changed nothing.\n\n"
+	}
+	type match struct {
+		rank    int
+		id      string
+		keyword float64
+	}
+	var matches []match
+	for i, candidate := range candidates {
+		if keyword, ok := lexical[candidate.Record.Id]; ok {
+			matches = append(matches, match{rank: i + 1, id: candidate.Record.Id, keyword: keyword})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].keyword > matches[j].keyword
+	})
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("%d chunks contain words of the query. The best keyword matches:\n", len(matches)))
+	for i, m := range matches {
+		if i == keywordScoresShown {
+			break
+		}
+		b.WriteString(fmt.Sprintf("- %s: keyword %.4f, ranked #%d\n", m.id, m.keyword, m.rank))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// printRetrievalDebug prints the retrieval steps for a query against the database
+func printRetrievalDebug(query string, routed bool, opts searchOptions, maxChars int) {
+	store := vectorStore{}
+	if err := initializeStore(&store, dbPath); err != nil {
+		log.Fatalf("Error initializing vector store: %v", err)
+	}
+
+	report, err := debugRetrieval(context.Background(), &store, query, retrievalMode, expansionEnabled, rerankEnabled, routed, opts, maxChars)
+	if err != nil {
+		log.Fatalf("Error debugging query: %v", err)
+	}
+	fmt.Print(report)
+}
+
+func debugQueryHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	query, ok := request.Params.Arguments["query"].(string)
+	if !ok || query == "" {
+		return nil, errors.New("query must be a non-empty string")
+	}
+
+	opts := searchOptions{
+		Threshold:  0.6,
+		NumResults: 3,
+		MaxPerFile: defaultMaxPerFile,
+	}
+	if minScore, ok := request.Params.Arguments["min_score"].(float64); ok {
+		opts.Threshold = minScore
+	}
+	if num, ok := request.Params.Arguments["num_results"].(float64); ok {
+		opts.NumResults = int(num)
+	}
+	if num, ok := request.Params.Arguments["max_per_file"].(float64); ok {
+		opts.MaxPerFile = int(num)
+	}
+	maxChars := 0
+	if num, ok := request.Params.Arguments["max_chars"].(float64); ok {
+		maxChars = int(num)
+	}
+
+	collections, _ := request.Params.Arguments["collections"].(string)
+	routed := collections == "" || strings.TrimSpace(collections) == "auto"
+	allowed, err := tenantCollections(ctx, parseCollections(collections, query))
+	if err != nil {
+		return nil, err
+	}
+	opts.Collections = allowed
+	if opts.MinTier, err = minTierArgument(request); err != nil {
+		return nil, err
+	}
+	if opts.Filter, err = filterArguments(request); err != nil {
+		return nil, err
+	}
+	if opts.MMRLambda, err = mmrLambdaArgument(request); err != nil {
+		return nil, err
+	}
+	mode, err := modeArgument(request)
+	if err != nil {
+		return nil, err
+	}
+
+	index, release := acquireIndex()
+	defer release()
+	report, err := debugRetrieval(ctx, sessionReader(ctx, index), query, mode, expandArgument(request), rerankArgument(request), routed, opts, maxChars)
+	if err != nil {
+		return nil, err
+	}
+	return mcp.NewToolResultText(report), nil
+}
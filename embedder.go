@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/parakeet-nest/parakeet/llm"
+	"go.etcd.io/bbolt"
+)
+
+// Embedders that can produce the vectors of a collection
+const (
+	embedderOllama = "ollama" // nomic-embed-text through Ollama (default, best quality)
+	embedderHash   = "hash"   // built-in feature hashing of words and word pairs, no model needed
+	embedderStatic = "static" // averaged word vectors from a user-supplied text file
+)
+
+// Keys stored under metaBucket describing how the vectors were embedded
+const (
+	embedderKey      = "embedder"
+	embedderModelKey = "embedder_model"
+)
+
+// hashDimensions is the vector length of the hash embedder
+const hashDimensions = 512
+
+// activeEmbedder embeds documents and queries for the open collection. It is
+// loaded from the database, since queries must use the embedder the stored
+// vectors were made with.
+var activeEmbedder = embedderOllama
+
+// embeddingTimeout bounds a single embedding request to Ollama, including
+// loading the model for the first one
+var embeddingTimeout = 2 * time.Minute
+
+// ollamaClient sends the embedding requests. It does not go through the
+// outbound policy, since Ollama is usually on this machine.
+var ollamaClient = &http.Client{Timeout: embeddingTimeout}
+
+// staticModelPath is the word vector file used by the static embedder
+var staticModelPath string
+
+// requestedEmbedder and requestedModel are the embedder chosen with
+// -embedder and -embedder-model, or "" to use the stored one
+var requestedEmbedder, requestedModel string
+
+// staticModel holds the loaded word vectors of the static embedder
+var staticModel struct {
+	once    sync.Once
+	vectors map[string][]float64
+	dims    int
+	err     error
+}
+
+// parseEmbedder validates an embedder name
+func parseEmbedder(name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case embedderOllama:
+		return embedderOllama, nil
+	case embedderHash:
+		return embedderHash, nil
+	case embedderStatic:
+		return embedderStatic, nil
+	}
+	return "", fmt.Errorf("unknown embedder %q: expected %s, %s, or %s", name, embedderOllama, embedderHash, embedderStatic)
+}
+
+// setStoreEmbedder records the embedder used to build the collection at path:
+// the requested one, or otherwise the one it was built with before. Switching
+// embedders drops the stored chunks, since their vectors cannot be reused.
+func setStoreEmbedder(path string) error {
+	db, err := openRawDatabase(path, false)
+	if err != nil {
+		return fmt.Errorf("error opening database %s: %v", path, err)
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bbolt.Tx) error {
+		embedder, model := requestedEmbedder, requestedModel
+		if embedder == "" {
+			embedder, model = readMeta(tx, embedderKey), readMeta(tx, embedderModelKey)
+			if requestedModel != "" {
+				model = requestedModel
+			}
+		}
+		if embedder == "" {
+			embedder = embedderOllama
+		}
+		if embedder == embedderStatic && model == "" {
+			return fmt.Errorf("the %s embedder needs a word vector file; pass it with -embedder-model", embedderStatic)
+		}
+		if embedder != embedderStatic {
+			model = ""
+		}
+
+		stored := readMeta(tx, embedderKey)
+		if stored == "" {
+			stored = embedderOllama
+		}
+		if stored != embedder || readMeta(tx, embedderModelKey) != model {
+			if err := clearChunks(tx); err != nil {
+				return err
+			}
+		}
+
+		if err := writeMeta(tx, embedderKey, embedder); err != nil {
+			return err
+		}
+		return writeMeta(tx, embedderModelKey, model)
+	})
+}
+
+// useStoredEmbedder switches to the embedder a collection was built with.
+// Asking for a different one is an error, since its vectors would not be
+// comparable with the stored ones.
+func useStoredEmbedder(embedder, model string) error {
+	if embedder == "" {
+		// Collections from before embedders were recorded used Ollama
+		embedder = embedderOllama
+	}
+	if requestedEmbedder != "" && requestedEmbedder != embedder {
+		return fmt.Errorf("database was ingested with the %s embedder; re-ingest with -embedder %s to switch", embedder, requestedEmbedder)
+	}
+
+	activeEmbedder = embedder
+	staticModelPath = model
+	if requestedModel != "" {
+		staticModelPath = requestedModel
+	}
+	return nil
+}
+
+// createEmbedding embeds text with the active embedder
+func createEmbedding(text, id string) (llm.VectorRecord, error) {
+	switch activeEmbedder {
+	case embedderHash:
+		return llm.VectorRecord{Id: id, Prompt: text, Embedding: hashEmbedding(stripTaskPrefix(text))}, nil
+	case embedderStatic:
+		vector, err := staticEmbedding(stripTaskPrefix(text))
+		if err != nil {
+			return llm.VectorRecord{}, err
+		}
+		return llm.VectorRecord{Id: id, Prompt: text, Embedding: vector}, nil
+	}
+
+	if err := checkOllamaReachable(); err != nil {
+		return llm.VectorRecord{}, err
+	}
+	return ollamaEmbedding(text, id)
+}
+
+// ollamaEmbedding embeds text with Ollama's embeddings API. It makes the same
+// request as parakeet's embeddings.CreateEmbedding, whose HTTP client cannot
+// be given a timeout.
+func ollamaEmbedding(text, id string) (llm.VectorRecord, error) {
+	body, err := json.Marshal(llm.Query4Embedding{Model: embeddingModel, Prompt: text})
+	if err != nil {
+		return llm.VectorRecord{}, err
+	}
+	resp, err := ollamaClient.Post(ollamaURL+"/api/embeddings", "application/json; charset=utf-8", bytes.NewReader(body))
+	if err != nil {
+		return llm.VectorRecord{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return llm.VectorRecord{}, fmt.Errorf("embedding request failed: %s", resp.Status)
+	}
+
+	var answer struct {
+		Embedding []float64 `json:"embedding"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return llm.VectorRecord{}, fmt.Errorf("error decoding embedding: %v", err)
+	}
+	if len(answer.Embedding) == 0 {
+		return llm.VectorRecord{}, fmt.Errorf("Ollama returned an empty embedding; is %s an embedding model?", embeddingModel)
+	}
+	return llm.VectorRecord{Id: id, Prompt: text, Embedding: answer.Embedding}, nil
+}
+
+// stripTaskPrefix removes the nomic-embed-text task prefixes, which only
+// carry meaning for that model
+func stripTaskPrefix(text string) string {
+	for _, prefix := range []string{"search_query: ", "search_document: "} {
+		if rest, ok := strings.CutPrefix(text, prefix); ok {
+			return rest
+		}
+	}
+	return text
+}
+
+// embeddingTokens lowercases text and splits it into words
+func embeddingTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// hashEmbedding maps words and adjacent word pairs into a fixed number of
+// dimensions with a signed hash, then normalizes the result
+func hashEmbedding(text string) []float64 {
+	vector := make([]float64, hashDimensions)
+	add := func(feature string, weight float64) {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		sign := 1.0
+		if sum>>63 == 1 {
+			sign = -1
+		}
+		vector[sum%hashDimensions] += sign * weight
+	}
+
+	tokens := embeddingTokens(text)
+	for i, token := range tokens {
+		add(token, 1)
+		if i > 0 {
+			add(tokens[i-1]+" "+token, 0.5)
+		}
+	}
+
+	normalizeVector(vector)
+	return vector
+}
+
+// staticEmbedding averages the word vectors of every known word in text
+func staticEmbedding(text string) ([]float64, error) {
+	staticModel.once.Do(func() {
+		staticModel.vectors, staticModel.dims, staticModel.err = loadWordVectors(staticModelPath)
+	})
+	if staticModel.err != nil {
+		return nil, staticModel.err
+	}
+
+	vector := make([]float64, staticModel.dims)
+	for _, token := range embeddingTokens(text) {
+		if wv, ok := staticModel.vectors[token]; ok {
+			for i, value := range wv {
+				vector[i] += value
+			}
+		}
+	}
+
+	normalizeVector(vector)
+	return vector, nil
+}
+
+// loadWordVectors reads a text file of word vectors, one "word v1 v2 ..."
+// line per word, as exported by GloVe, word2vec (text format) or model2vec.
+// A word2vec "count dimensions" header line is skipped.
+func loadWordVectors(path string) (map[string][]float64, int, error) {
+	if path == "" {
+		return nil, 0, fmt.Errorf("the %s embedder needs a word vector file; pass it with -embedder-model", embedderStatic)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error opening word vectors: %v", err)
+	}
+	defer file.Close()
+
+	vectors := make(map[string][]float64)
+	dims := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			// Blank lines and the word2vec header
+			continue
+		}
+
+		vector := make([]float64, len(fields)-1)
+		for i, field := range fields[1:] {
+			if vector[i], err = strconv.ParseFloat(field, 64); err != nil {
+				return nil, 0, fmt.Errorf("%s:%d: invalid number %q", path, lineNumber, field)
+			}
+		}
+		if dims == 0 {
+			dims = len(vector)
+		} else if len(vector) != dims {
+			return nil, 0, fmt.Errorf("%s:%d: expected %d values, got %d", path, lineNumber, dims, len(vector))
+		}
+		vectors[strings.ToLower(fields[0])] = vector
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error reading word vectors: %v", err)
+	}
+	if len(vectors) == 0 {
+		return nil, 0, fmt.Errorf("no word vectors found in %s", path)
+	}
+	return vectors, dims, nil
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// eventFeedsFile is the default path of the feeds followed by -events-daemon
+const eventFeedsFile = "event-feeds.json"
+
+// EventFeed is a filter whose events -events-daemon embeds as they are
+// published
+type EventFeed struct {
+	Name      string
+	Filter    nostr.Filter // Events to embed, e.g. {"kinds":[30023]}
+	FollowsOf string       `json:",omitempty"` // npub or hex key whose contact list sets the filter's authors
+}
+
+// eventBatchSuffix names the copy of the database that a batch of events is
+// embedded into before it is swapped in over the serving one
+const eventBatchSuffix = ".events"
+
+const (
+	// eventBatchInterval is how often the daemon embeds the events that
+	// arrived since the last batch
+	eventBatchInterval = time.Minute
+
+	// feedRefreshInterval is how often the daemon resubscribes, picking up
+	// changed contact lists and re-ranking the relays
+	feedRefreshInterval = time.Hour
+
+	// Reconnects to a dropped relay back off from the first delay to the last
+	relayRetryDelay    = 5 * time.Second
+	maxRelayRetryDelay = 5 * time.Minute
+
+	// A batch that fails is tried again after a delay growing up to
+	// maxBatchRetryDelay, and dropped after maxBatchAttempts attempts
+	maxBatchRetryDelay = 30 * time.Minute
+	maxBatchAttempts   = 5
+
+	// maxPendingEvents bounds the events waiting for the next batch; more
+	// are dropped until a batch goes through
+	maxPendingEvents = 10000
+)
+
+// errDatabaseReplaced is returned when a full ingest swapped in a new
+// database while a batch of events was being embedded
+var errDatabaseReplaced = errors.New("the database was replaced while embedding")
+
+// loadEventFeeds reads the daemon's feeds from a file, checking that each
+// names a filter that does not match every event on a relay
+func loadEventFeeds(customConfigFile string) ([]EventFeed, error) {
+	cfgFile := eventFeedsFile
+	if customConfigFile != "" {
+		cfgFile = customConfigFile
+	}
+
+	file, err := os.ReadFile(cfgFile)
+	if err != nil {
+		return nil, err
+	}
+	var feeds []EventFeed
+	if err := json.Unmarshal(file, &feeds); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", cfgFile, err)
+	}
+	if len(feeds) == 0 {
+		return nil, fmt.Errorf("%s lists no feeds", cfgFile)
+	}
+
+	for i, feed := range feeds {
+		if feed.Name == "" {
+			return nil, fmt.Errorf("feed %d in %s has no name", i+1, cfgFile)
+		}
+		if feed.FollowsOf != "" {
+			pubkey, err := parseAuthor(feed.FollowsOf)
+			if err != nil {
+				return nil, fmt.Errorf("FollowsOf of feed %s: %v", feed.Name, err)
+			}
+			feeds[i].FollowsOf = pubkey
+			continue
+		}
+		if err := checkEventFilter(feed.Filter); err != nil {
+			return nil, fmt.Errorf("feed %s: %v", feed.Name, err)
+		}
+	}
+	return feeds, nil
+}
+
+// feedFilters turns the feeds into the filters to subscribe with. Authors are
+// taken from the contact lists of FollowsOf feeds, split across several
+// filters when there are many, and each filter starts from the newest event
+// already ingested for it, so a restart does not fetch everything again.
+func feedFilters(ctx context.Context, feeds []EventFeed, ingested []*nostr.Event) []nostr.Filter {
+	var filters []nostr.Filter
+	for _, feed := range feeds {
+		filter := feed.Filter
+		if feed.FollowsOf != "" {
+			follows, err := fetchFollows(ctx, feed.FollowsOf)
+			if err != nil {
+				log.Printf("Skipping feed %s: %v", feed.Name, err)
+				continue
+			}
+			filter.Authors = follows
+		}
+
+		var newest nostr.Timestamp
+		for _, ev := range ingested {
+			if ev.CreatedAt > newest && filter.Matches(ev) {
+				newest = ev.CreatedAt
+			}
+		}
+		if newest > 0 {
+			filter.Since = &newest
+		}
+		if filter.Limit == 0 {
+			filter.Limit = defaultEventIngestLimit
+		}
+		filters = append(filters, splitAuthors(filter)...)
+	}
+	return filters
+}
+
+// runEventsDaemon keeps subscriptions to the feeds open on the search relays
+// and embeds new events into the database in batches until interrupted. Each
+// batch is embedded into a copy of the database that is then swapped in, so
+// the daemon runs alongside a server, which picks up every batch.
+func runEventsDaemon(customConfigFile string) {
+	feeds, err := loadEventFeeds(customConfigFile)
+	if err != nil {
+		fmt.Printf("Error loading event feeds: %v\n", err)
+		os.Exit(1)
+	}
+	if relaysUnavailable() {
+		fmt.Println(offlineNotice("The events daemon"))
+		os.Exit(1)
+	}
+	if eventsFile != "" {
+		fmt.Println("The events daemon subscribes to live relays and cannot run with -events-file; use -ingest-events instead.")
+		os.Exit(1)
+	}
+	if _, err := os.Stat(dbPath); err != nil {
+		fmt.Printf("No database at %s; run with -ingest first.\n", dbPath)
+		os.Exit(1)
+	}
+
+	ingested, err := loadIngestedEvents()
+	if err != nil {
+		log.Fatalf("Error reading %s: %v", ingestedEventsFile, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	incoming := make(chan *nostr.Event, 256)
+	pending := make(map[string]*nostr.Event)
+	dropped := 0
+	// A database that cannot be written is not retried every batch interval,
+	// and its events are not held forever
+	attempts, retryDelay := 0, eventBatchInterval
+	var retryAt time.Time
+	flush := func() {
+		if dropped > 0 {
+			log.Printf("Dropped %d events that arrived while %d were waiting to be embedded", dropped, maxPendingEvents)
+			dropped = 0
+		}
+		if len(pending) == 0 || time.Now().Before(retryAt) {
+			return
+		}
+		batch := make([]*nostr.Event, 0, len(pending))
+		for _, ev := range pending {
+			batch = append(batch, ev)
+		}
+		merged, err := embedEventBatch(batch, ingested)
+		if errors.Is(err, errDatabaseReplaced) || errors.Is(err, errIngestRunning) {
+			// Not a failure of the batch, which is embedded into the new
+			// database with the next one
+			log.Printf("Embedding %d events again with the next batch: %v", len(batch), err)
+			return
+		}
+		if err != nil {
+			attempts++
+			if attempts >= maxBatchAttempts {
+				log.Printf("Error embedding %d events: %v; dropping them after %d attempts", len(batch), err, attempts)
+				clear(pending)
+				attempts, retryDelay, retryAt = 0, eventBatchInterval, time.Time{}
+				return
+			}
+			log.Printf("Error embedding %d events: %v; trying again in %s", len(batch), err, retryDelay)
+			retryAt = time.Now().Add(retryDelay)
+			if retryDelay *= 2; retryDelay > maxBatchRetryDelay {
+				retryDelay = maxBatchRetryDelay
+			}
+			return
+		}
+		attempts, retryDelay, retryAt = 0, eventBatchInterval, time.Time{}
+		ingested = merged
+		clear(pending)
+		if err := saveRelayScores(); err != nil {
+			log.Printf("Failed to save relay scores: %v", err)
+		}
+	}
+
+	log.Printf("Events daemon following %d feeds; new events are embedded every %s", len(feeds), eventBatchInterval)
+	batches := time.NewTicker(eventBatchInterval)
+	defer batches.Stop()
+	for ctx.Err() == nil {
+		filters := feedFilters(ctx, feeds, ingested)
+		if len(filters) == 0 {
+			log.Printf("No feed could be resolved; retrying in %s", feedRefreshInterval)
+		}
+
+		subCtx, cancel := context.WithTimeout(ctx, feedRefreshInterval)
+		if len(filters) > 0 {
+			for _, url := range rankRelays(searchRelays) {
+				go followRelay(subCtx, url, filters, incoming)
+			}
+		}
+
+	collect:
+		for {
+			select {
+			case ev := <-incoming:
+				// Several relays send the same event
+				if _, ok := pending[ev.ID]; !ok && len(pending) >= maxPendingEvents {
+					dropped++
+					continue
+				}
+				pending[ev.ID] = ev
+			case <-batches.C:
+				flush()
+			case <-subCtx.Done():
+				break collect
+			}
+		}
+		cancel()
+	}
+
+	// Events received before the interrupt are not lost, even while a
+	// failed batch waits to be tried again
+	retryAt = time.Time{}
+	flush()
+	log.Printf("Events daemon stopped")
+}
+
+// followRelay subscribes to a relay with the feeds' filters and passes every
+// event that can be embedded to out, reconnecting with a growing delay
+// whenever the connection drops, until ctx is done
+func followRelay(ctx context.Context, url string, filters []nostr.Filter, out chan<- *nostr.Event) {
+	delay := relayRetryDelay
+	for ctx.Err() == nil {
+		start := time.Now()
+		relay, err := connectRelay(ctx, url)
+		recordRelayResult(url, time.Since(start), err)
+		if err == nil {
+			delay = relayRetryDelay
+			err = streamRelayEvents(ctx, relay, filters, out)
+			relay.Close()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Lost relay %s: %v; reconnecting in %s", url, err, delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxRelayRetryDelay {
+			delay = maxRelayRetryDelay
+		}
+	}
+}
+
+// streamRelayEvents passes the stored and then the newly published events of
+// a subscription to out until the relay closes it or ctx is done. With
+// -relay-auth, a relay that requires authentication is answered and the
+// subscription is sent once more.
+func streamRelayEvents(ctx context.Context, relay *nostr.Relay, filters []nostr.Filter, out chan<- *nostr.Event) error {
+	for authenticated := false; ; authenticated = true {
+		closed, err := readRelayStream(ctx, relay, filters, out)
+		if err != nil || authenticated || !relayAuthEnabled || !authRequired(closed) {
+			if err == nil && closed != "" {
+				err = fmt.Errorf("subscription closed: %s", closed)
+			}
+			return err
+		}
+		if err := authenticateRelay(ctx, relay); err != nil {
+			return err
+		}
+	}
+}
+
+// readRelayStream runs one subscription of streamRelayEvents and returns the
+// reason the relay gave if it closed the subscription
+func readRelayStream(ctx context.Context, relay *nostr.Relay, filters []nostr.Filter, out chan<- *nostr.Event) (string, error) {
+	sub, err := relay.Subscribe(ctx, filters)
+	if err != nil {
+		return "", fmt.Errorf("subscribe: %v", err)
+	}
+	defer sub.Unsub()
+
+	for {
+		select {
+		case reason := <-sub.ClosedReason:
+			return reason, nil
+		case ev, ok := <-sub.Events:
+			if !ok {
+				// A CLOSED message's reason is sent before Events is closed
+				select {
+				case reason := <-sub.ClosedReason:
+					return reason, nil
+				default:
+					return "", errors.New("subscription closed")
+				}
+			}
+			archiveEvent(ev)
+			// Relays may send events outside the filters
+			if !nostr.Filters(filters).Match(ev) || !ingestableEvent(ev) {
+				continue
+			}
+			select {
+			case out <- ev:
+			case <-ctx.Done():
+				return "", nil
+			}
+		case <-sub.EndOfStoredEvents:
+			relaySucceeded(relay.URL)
+		case <-relay.Context().Done():
+			return "", errors.New("connection closed")
+		case <-ctx.Done():
+			return "", nil
+		}
+	}
+}
+
+// embedEventBatch embeds the events that are newer than the ingested version
+// of their source and returns the updated list of ingested events. The
+// events are embedded into a copy of the database that replaces it when done.
+// While an ingest runs it returns errIngestRunning.
+func embedEventBatch(batch, ingested []*nostr.Event) ([]*nostr.Event, error) {
+	known := make(map[string]nostr.Timestamp, len(ingested))
+	for _, ev := range ingested {
+		known[eventSource(ev)] = ev.CreatedAt
+	}
+	var events []*nostr.Event
+	for _, ev := range batch {
+		if createdAt, ok := known[eventSource(ev)]; !ok || ev.CreatedAt > createdAt {
+			events = append(events, ev)
+		}
+	}
+	if len(events) == 0 {
+		return ingested, nil
+	}
+	merged, refused := applyAuthorPolicy(mergeEvents(ingested, events))
+
+	// A full ingest copies the database when it starts and replaces it when
+	// it is done, so a batch swapped in between would be lost; the batch
+	// waits for the ingest instead
+	release, err := lockIngest(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	start := time.Now()
+	embedded, removed, chunks, err := embedEventsIntoCopy(append(events, refused...), merged)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeEventsFile(ingestedEventsFile, merged); err != nil {
+		log.Printf("Error saving %s: %v", ingestedEventsFile, err)
+	}
+
+	log.Printf("Embedded %d chunks from %d new events (replacing %d chunks) in %s; %d events are now indexed from relays",
+		chunks, embedded, removed, time.Since(start).Round(time.Second/10), len(merged))
+	return merged, nil
+}
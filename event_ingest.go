@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/parakeet-nest/parakeet/content"
+	"go.etcd.io/bbolt"
+)
+
+// eventsRepo is the repository name in the chunk IDs of events ingested from
+// relays, e.g. "nostr-events/30023-1a2b3c4d-my-article-chunk-2"
+const eventsRepo = "nostr-events"
+
+// ingestedEventsFile holds every event ingested with -ingest-events, so that
+// a full -ingest embeds them again
+var ingestedEventsFile = filepath.Join(dataDir, "ingested-events.jsonl")
+
+// defaultEventIngestLimit caps the events fetched per relay when the filter
+// sets no limit, so a broad filter does not pull in the whole firehose
+const defaultEventIngestLimit = 500
+
+// hasIngestedEvents reports whether any events were ingested from relays
+func hasIngestedEvents() bool {
+	_, err := os.Stat(ingestedEventsFile)
+	return err == nil
+}
+
+// parseEventFilter reads a filter given as JSON, e.g. {"kinds":[30023]}.
+// Filters must be narrowed by at least one of ids, authors, kinds, or tags.
+func parseEventFilter(text string) (nostr.Filter, error) {
+	var filter nostr.Filter
+	if err := json.Unmarshal([]byte(text), &filter); err != nil {
+		return filter, fmt.Errorf("invalid filter: %v", err)
+	}
+	if err := checkEventFilter(filter); err != nil {
+		return filter, err
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultEventIngestLimit
+	}
+	return filter, nil
+}
+
+// checkEventFilter refuses filters that would match every event on a relay
+func checkEventFilter(filter nostr.Filter) error {
+	if len(filter.IDs) == 0 && len(filter.Authors) == 0 && len(filter.Kinds) == 0 && len(filter.Tags) == 0 {
+		return fmt.Errorf("the filter must set ids, authors, kinds, or tags")
+	}
+	return nil
+}
+
+// eventSource is the file part of an event's chunk IDs. Replaceable and
+// addressable events are named by their author and d tag rather than their
+// ID, so a newer version replaces the chunks of the old one.
+func eventSource(ev *nostr.Event) string {
+	switch kindClass(ev.Kind) {
+	case "replaceable":
+		return fmt.Sprintf("%d-%s", ev.Kind, ev.PubKey[:min(8, len(ev.PubKey))])
+	case "addressable":
+		source := fmt.Sprintf("%d-%s", ev.Kind, ev.PubKey[:min(8, len(ev.PubKey))])
+		if d := scratchName(eventTag(ev, "d")); d != "" {
+			source += "-" + d
+		}
+		return source
+	}
+	return fmt.Sprintf("%d-%s", ev.Kind, ev.ID[:min(8, len(ev.ID))])
+}
+
+// eventTag returns the first value of a tag, or ""
+func eventTag(ev *nostr.Event, name string) string {
+	for _, tag := range ev.Tags {
+		if len(tag) > 1 && tag[0] == name {
+			return tag[1]
+		}
+	}
+	return ""
+}
+
+// eventMarkdown converts an event to a markdown document: its title, a line
+// saying who published it and where to find it, its summary, and its content
+func eventMarkdown(ev *nostr.Event) string {
+	title := eventTag(ev, "title")
+	if title == "" {
+		title = fmt.Sprintf("Kind %d event", ev.Kind)
+	}
+
+	author, _ := nip19.EncodePublicKey(ev.PubKey)
+	pointer := eventPointer(ev)
+	published := ev.CreatedAt.Time()
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("# %s\n\n", title))
+	b.WriteString(fmt.Sprintf("Kind %d event by %s, published %s (%s)\n\n", ev.Kind, author, published.UTC().Format("2006-01-02"), pointer))
+	if summary := eventTag(ev, "summary"); summary != "" {
+		b.WriteString(summary + "\n\n")
+	}
+	b.WriteString(ev.Content)
+	return b.String()
+}
+
+// ingestEvent chunks and embeds an event into the store and returns how many
+// chunks were saved
+func ingestEvent(ev *nostr.Event, store *vectorStore) int {
+	source := eventSource(ev)
+	chunks := keepCodeFences(content.ParseMarkdownWithLineage(eventMarkdown(ev)))
+	saved := 0
+	for i := range chunks {
+		id := fmt.Sprintf("%s/%s-chunk-%d", eventsRepo, source, i+1)
+		meta := sourceMeta{Repo: eventsRepo, Path: source, Section: chunks[i].Header, Lineage: chunks[i].Lineage, URL: "nostr:" + eventPointer(ev)}
+		if err := upsertChunk(store, id, chunkDocument(chunks, i, defaultContextOverlap), meta.toMap()); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+			continue
+		}
+		saved++
+	}
+	return saved
+}
+
+// mergeEvents adds events to a list, keeping only the newest version of
+// each source so replaced articles do not linger
+func mergeEvents(existing, added []*nostr.Event) []*nostr.Event {
+	index := make(map[string]int)
+	var merged []*nostr.Event
+	for _, ev := range append(existing, added...) {
+		source := eventSource(ev)
+		if i, ok := index[source]; ok {
+			if ev.CreatedAt > merged[i].CreatedAt {
+				merged[i] = ev
+			}
+			continue
+		}
+		index[source] = len(merged)
+		merged = append(merged, ev)
+	}
+	return merged
+}
+
+// ingestableEvent reports whether an event has content to embed, an author
+// the author policy allows, and a valid signature
+func ingestableEvent(ev *nostr.Event) bool {
+	if strings.TrimSpace(ev.Content) == "" || !authorAllowed(ev.PubKey) {
+		return false
+	}
+	ok, err := ev.CheckSignature()
+	return ok && err == nil
+}
+
+// fetchEventsForIngest fetches the events matching a filter from the search
+// relays, or from the events file when one is set, keeping those with
+// content and a valid signature
+func fetchEventsForIngest(filter nostr.Filter) []*nostr.Event {
+	if eventsFile != "" {
+		events, err := loadEventsFile(eventsFile, []nostr.Filter{filter})
+		if err != nil {
+			log.Fatalf("Error reading events file: %v", err)
+		}
+		var accepted []*nostr.Event
+		for _, ev := range events {
+			if ingestableEvent(ev) {
+				accepted = append(accepted, ev)
+			}
+		}
+		return accepted
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*relayDeadline)
+	defer cancel()
+	return fetchFromRelays(ctx, rankRelays(searchRelays), []nostr.Filter{filter}, relayDeadline, filter.Limit, ingestableEvent)
+}
+
+// loadIngestedEvents reads the events kept by earlier event ingests
+func loadIngestedEvents() ([]*nostr.Event, error) {
+	if !hasIngestedEvents() {
+		return nil, nil
+	}
+	return loadEventsFile(ingestedEventsFile, []nostr.Filter{{}})
+}
+
+// embedEventSources replaces the chunks of the sources of events in the
+// database at path with the newest version of each source in merged; sources
+// missing from merged are only removed. The corpus manifest is updated to
+// count the events of merged. It returns how many events were embedded, and
+// how many chunks were removed and saved.
+func embedEventSources(path string, events, merged []*nostr.Event) (int, int, int, error) {
+	store := vectorStore{}
+	if err := initializeStore(&store, path); err != nil {
+		return 0, 0, 0, fmt.Errorf("error initializing vector store: %v", err)
+	}
+	defer closeStore(&store)
+
+	// Chunks of the events' sources are replaced, since a new version may
+	// have fewer chunks than the old one
+	sources := make(map[string]bool)
+	for _, ev := range events {
+		sources[eventsRepo+"/"+eventSource(ev)] = true
+	}
+	removed := 0
+	err := store.db.Update(func(tx *bbolt.Tx) error {
+		var err error
+		removed, err = deleteChunks(tx, func(id string) bool {
+			return sources[chunkSource(id)]
+		})
+		return err
+	})
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("error removing the chunks of earlier versions: %v", err)
+	}
+
+	// The newest known version of each event is embedded, which may be one
+	// ingested earlier if the relays returned an older one
+	embedded, chunks := 0, 0
+	for _, ev := range merged {
+		if sources[eventsRepo+"/"+eventSource(ev)] {
+			chunks += ingestEvent(ev, &store)
+			embedded++
+		}
+	}
+
+	// The store holds the database locked, so the manifest is updated
+	// through its handle
+	if err := recordEventsInManifest(&store, len(merged), removed, chunks); err != nil {
+		return embedded, removed, chunks, fmt.Errorf("error updating the corpus manifest: %v", err)
+	}
+	return embedded, removed, chunks, nil
+}
+
+// embedEventsIntoCopy runs embedEventSources on a copy of the serving
+// database and swaps the copy in when done, so an interrupted run
+// never leaves the serving index half updated. The caller holds the ingest
+// lock, so no full ingest copies or replaces the database meanwhile.
+func embedEventsIntoCopy(events, merged []*nostr.Event) (int, int, int, error) {
+	tmpPath := dbPath + eventBatchSuffix
+	original, err := os.Stat(dbPath)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	// The serving database may be locked by a running MCP server, so it is
+	// copied byte for byte instead of being opened
+	if err := copyFile(dbPath, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return 0, 0, 0, fmt.Errorf("error copying %s: %v", dbPath, err)
+	}
+
+	embedded, removed, chunks, err := embedEventSources(tmpPath, events, merged)
+	if err != nil {
+		os.Remove(tmpPath)
+		return 0, 0, 0, err
+	}
+
+	// Swapping the copy in over a database that was replaced in the
+	// meantime would undo that replacement
+	if latest, err := os.Stat(dbPath); err != nil || !os.SameFile(original, latest) {
+		os.Remove(tmpPath)
+		return 0, 0, 0, errDatabaseReplaced
+	}
+	if err := swapInDatabase(tmpPath, dbPath); err != nil {
+		return 0, 0, 0, err
+	}
+	return embedded, removed, chunks, nil
+}
+
+// ingestRelayEvents fetches the events matching a filter and embeds them into
+// the database, replacing the chunks of earlier versions of the same events.
+// The events are also kept in ingestedEventsFile for later full ingests.
+func ingestRelayEvents(filterText string) {
+	filter, err := parseEventFilter(filterText)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if relaysUnavailable() {
+		fmt.Println(offlineNotice("Ingesting events"))
+		os.Exit(1)
+	}
+	if _, err := os.Stat(dbPath); err != nil {
+		fmt.Printf("No database at %s; run with -ingest first.\n", dbPath)
+		os.Exit(1)
+	}
+	// The events file and the database are updated together, neither
+	// during a full ingest nor alongside the events daemon
+	release, err := lockIngest(dbPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer release()
+
+	start := time.Now()
+	events := fetchEventsForIngest(filter)
+	if err := saveRelayScores(); err != nil {
+		fmt.Printf("Failed to save relay scores: %v\n", err)
+	}
+	if len(events) == 0 {
+		fmt.Println("No events with content by allowed authors matched the filter.")
+		return
+	}
+	fmt.Printf("Fetched %d events in %s\n", len(events), time.Since(start).Round(time.Second/10))
+
+	existing, err := loadIngestedEvents()
+	if err != nil {
+		log.Fatalf("Error reading %s: %v", ingestedEventsFile, err)
+	}
+	// Events ingested before their authors were denied are dropped as well
+	merged, refused := applyAuthorPolicy(mergeEvents(existing, events))
+	if len(refused) > 0 {
+		fmt.Printf("Removing %d earlier ingested events by authors the author policy refuses\n", len(refused))
+	}
+
+	embedded, removed, chunks, err := embedEventsIntoCopy(append(events, refused...), merged)
+	if err != nil {
+		fmt.Printf("Error embedding events: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := writeEventsFile(ingestedEventsFile, merged); err != nil {
+		fmt.Printf("Error saving %s: %v\n", ingestedEventsFile, err)
+	}
+	fmt.Printf("Embedded %d chunks from %d events (replacing %d chunks) in %s; %d events are now indexed from relays.\n",
+		chunks, embedded, removed, time.Since(start).Round(time.Second/10), len(merged))
+}
+
+// recordEventsInManifest updates the corpus manifest entry of the ingested
+// events in the store's database after new events were embedded
+func recordEventsInManifest(store *vectorStore, events, removed, added int) error {
+	return store.db.Update(func(tx *bbolt.Tx) error {
+		data := readMeta(tx, corpusKey)
+		if data == "" {
+			return nil
+		}
+		var manifest corpusManifest
+		if err := json.Unmarshal([]byte(data), &manifest); err != nil {
+			return fmt.Errorf("error reading %s: %v", corpusKey, err)
+		}
+		for i := range manifest.Repos {
+			if manifest.Repos[i].Name == eventsRepo {
+				manifest.Repos[i].Files = events
+				manifest.Repos[i].Chunks += added - removed
+				return putMetaJSON(tx, corpusKey, manifest)
+			}
+		}
+		manifest.Repos = append(manifest.Repos, newEventsCorpusRepo(events, added))
+		return putMetaJSON(tx, corpusKey, manifest)
+	})
+}
+
+// newEventsCorpusRepo describes the ingested events in the corpus manifest
+func newEventsCorpusRepo(events, chunks int) corpusRepo {
+	return corpusRepo{
+		Name:       eventsRepo,
+		URL:        "Nostr relays (-ingest-events)",
+		Collection: collectionArticles,
+		Files:      events,
+		Chunks:     chunks,
+	}
+}
+
+// processIngestedEvents embeds the events kept by earlier -ingest-events runs
+// during a full ingest
+func processIngestedEvents(store *vectorStore) {
+	if !hasIngestedEvents() {
+		return
+	}
+	events, err := loadIngestedEvents()
+	if err != nil {
+		fmt.Printf("Error reading %s: %v\n", ingestedEventsFile, err)
+		return
+	}
+
+	events, refused := applyAuthorPolicy(events)
+	if len(refused) > 0 {
+		fmt.Printf("Skipping %d ingested events by authors the author policy refuses\n", len(refused))
+	}
+
+	fmt.Printf("Processing %d events ingested from relays\n", len(events))
+	chunks := 0
+	for _, ev := range events {
+		chunks += ingestEvent(ev, store)
+	}
+	ingestManifest.Repos = append(ingestManifest.Repos, newEventsCorpusRepo(len(events), chunks))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestEmbedEventSources(t *testing.T) {
+	inTempDir(t)
+	defer func(embedder string) { requestedEmbedder = embedder }(requestedEmbedder)
+	requestedEmbedder = embedderHash
+
+	// An indexed database with a manifest, as -ingest leaves it
+	if err := setStoreEmbedder(dbPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := storeMetaJSON(dbPath, corpusKey, corpusManifest{Repos: []corpusRepo{{Name: "nips", Files: 1, Chunks: 3}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	article := &nostr.Event{
+		PubKey:    "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798",
+		CreatedAt: 1700000000,
+		Kind:      30023,
+		Tags:      nostr.Tags{{"d", "outbox"}, {"title", "The outbox model"}},
+		Content:   "Clients read from the write relays of the people they follow.",
+	}
+	events := []*nostr.Event{article}
+	embedded, removed, chunks, err := embedEventSources(dbPath, events, events)
+	if err != nil {
+		t.Fatalf("embedEventSources: %v", err)
+	}
+	if embedded != 1 || removed != 0 || chunks == 0 {
+		t.Fatalf("embedEventSources = %d events, %d removed, %d chunks; want 1 event with chunks", embedded, removed, chunks)
+	}
+
+	corpus, err := loadCorpusManifest(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, repo := range corpus.Repos {
+		if repo.Name == eventsRepo {
+			found = repo.Files == 1 && repo.Chunks == chunks
+		}
+	}
+	if !found {
+		t.Errorf("manifest repositories = %+v, want %s with 1 event and %d chunks", corpus.Repos, eventsRepo, chunks)
+	}
+
+	// A new version replaces the chunks of the old one
+	updated := *article
+	updated.CreatedAt++
+	updated.Content = "Relays are chosen from the NIP-65 relay lists."
+	events = []*nostr.Event{&updated}
+	if _, removed, _, err = embedEventSources(dbPath, events, events); err != nil {
+		t.Fatalf("embedEventSources again: %v", err)
+	}
+	if removed != chunks {
+		t.Errorf("replacing the event removed %d chunks, want %d", removed, chunks)
+	}
+}
+
+func TestEmbedEventBatchWaitsForIngest(t *testing.T) {
+	inTempDir(t)
+	defer func(embedder string) { requestedEmbedder = embedder }(requestedEmbedder)
+	requestedEmbedder = embedderHash
+	if err := setStoreEmbedder(dbPath); err != nil {
+		t.Fatal(err)
+	}
+
+	note := &nostr.Event{
+		PubKey:    "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798",
+		CreatedAt: 1700000000,
+		Kind:      30023,
+		Tags:      nostr.Tags{{"d", "zaps"}},
+		Content:   "Zap receipts are kind 9735 events published by the recipient's wallet.",
+	}
+	batch := []*nostr.Event{note}
+
+	// An ingest copied the database and will replace it, so the batch must
+	// not be swapped in before that
+	release, err := lockIngest(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := embedEventBatch(batch, nil); !errors.Is(err, errIngestRunning) {
+		t.Fatalf("embedEventBatch during an ingest = %v, want errIngestRunning", err)
+	}
+	if ids := storedIDs(t); len(ids) != 0 {
+		t.Fatalf("the batch was embedded during an ingest: %v", ids)
+	}
+	release()
+
+	merged, err := embedEventBatch(batch, nil)
+	if err != nil || len(merged) != 1 {
+		t.Fatalf("embedEventBatch = %d events, %v; want the event", len(merged), err)
+	}
+	if ids := storedIDs(t); len(ids) == 0 || !strings.HasPrefix(ids[0], eventsRepo+"/") {
+		t.Errorf("stored chunks %v, want the event's", ids)
+	}
+	if _, err := os.Stat(dbPath + eventBatchSuffix); !os.IsNotExist(err) {
+		t.Errorf("the copy of the database was left behind: %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/parakeet-nest/parakeet/llm"
+)
+
+// schemaFormats describes each output format of the event_schema tool to the model
+var schemaFormats = map[string]string{
+	"json-schema": "a JSON Schema (draft 2020-12) for the whole event object",
+	"typescript":  "a TypeScript interface for the whole event object, with a comment on each field",
+	"go":          "a Go struct for the whole event object, with json struct tags and a comment on each field",
+}
+
+// defaultSchemaFormat is used when the event_schema tool is not given a format
+const defaultSchemaFormat = "json-schema"
+
+// schemaSystemPrompt asks for a schema that only contains what the spec says
+// and quotes the lines it is based on, so the quotes can be checked
+const schemaSystemPrompt = `You are an expert on the Nostr protocol. From the specification excerpts provided in the context, write %s describing events of kind %d. Include the kind, content, and every tag the excerpts define for this kind, marking which tags are required and what their values mean. Use only what the excerpts state; if something is not specified, leave it out or mark it as unspecified rather than guessing.
+
+Reply with the code block first. Then add a section titled "Spec lines" listing every line of the excerpts you relied on, each quoted verbatim on its own line starting with "> " and followed by the excerpt ID it comes from, e.g. [nips/01-chunk-12].`
+
+// quotedLinePattern finds the quoted spec lines in a reply, without their citation
+var quotedLinePattern = regexp.MustCompile(`(?m)^>\s*(.+?)\s*(\[[^\]]+\])?\s*$`)
+
+// schemaSources retrieves the specification chunks that describe a kind,
+// preferring the NIPs the registry says define it
+func schemaSources(ctx context.Context, index *servingIndex, kind int, entries []registryKind) ([]searchResult, error) {
+	var nips, descriptions []string
+	for _, entry := range entries {
+		descriptions = append(descriptions, entry.Description)
+		for _, nip := range entry.NIPs {
+			nips = append(nips, "nip:"+nip)
+		}
+	}
+
+	filter := fmt.Sprintf("kind:%d", kind)
+	if len(nips) > 0 {
+		filter = strings.Join(nips, " OR ")
+	}
+	query := fmt.Sprintf("%s kind %d %s event structure content tags", filter, kind, strings.Join(descriptions, " "))
+
+	allowed, err := tenantCollections(ctx, []string{collectionSpecs})
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := retrieveCandidates(ctx, index.reader, query)
+	if err != nil {
+		return nil, err
+	}
+	return selectResults(candidates, searchOptions{
+		Threshold:   0.3,
+		NumResults:  6,
+		MaxPerFile:  4,
+		Collections: allowed,
+	}), nil
+}
+
+// normalizeQuote reduces a quoted line to lowercase words so that quotes can
+// be found in the sources regardless of markdown and spacing
+func normalizeQuote(text string) string {
+	text = strings.NewReplacer("`", "", "*", "", "\"", "", "“", "", "”", "").Replace(text)
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+// verifyQuotes checks each line the model quoted against the retrieved
+// chunks and returns the quotes that were found and those that were not
+func verifyQuotes(reply string, results []searchResult) ([]string, []string) {
+	var sources []string
+	for _, result := range results {
+		sources = append(sources, normalizeQuote(result.Record.Prompt))
+	}
+
+	var found, missing []string
+	for _, match := range quotedLinePattern.FindAllStringSubmatch(reply, -1) {
+		quote := strings.Trim(match[1], " \"")
+		normalized := normalizeQuote(quote)
+		if normalized == "" {
+			continue
+		}
+		verified := false
+		for _, source := range sources {
+			if strings.Contains(source, normalized) {
+				verified = true
+				break
+			}
+		}
+		if verified {
+			found = append(found, quote)
+		} else {
+			missing = append(missing, quote)
+		}
+	}
+	return found, missing
+}
+
+func eventSchemaHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	number, ok := request.Params.Arguments["kind"].(float64)
+	if !ok || number < 0 || number > 65535 || number != float64(int(number)) {
+		return nil, errors.New("kind must be an integer between 0 and 65535")
+	}
+	kind := int(number)
+
+	format := defaultSchemaFormat
+	if value, ok := request.Params.Arguments["format"].(string); ok && value != "" {
+		format = value
+	}
+	description, ok := schemaFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q; use json-schema, typescript, or go", format)
+	}
+
+	index, release := acquireIndex()
+	defer release()
+	var entries []registryKind
+	if index.registry != nil {
+		entries = registeredKinds(index.registry, kind)
+	}
+
+	results, err := schemaSources(ctx, index, kind, entries)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return mcp.NewToolResultText(fmt.Sprintf("No specification text for kind %d was found in the index.", kind)), nil
+	}
+	if reply, refuse := insufficientContext(results, results); refuse {
+		return mcp.NewToolResultText(reply), nil
+	}
+
+	reply, err := chatCompletion([]llm.Message{
+		{Role: "system", Content: fmt.Sprintf(schemaSystemPrompt, description, kind)},
+		{Role: "system", Content: formatResults(results)},
+		{Role: "user", Content: fmt.Sprintf("Write the schema for kind %d.", kind)},
+	}, progressStreamer(ctx, request))
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("# Kind %d", kind))
+	if len(entries) > 0 {
+		b.WriteString(fmt.Sprintf(": %s (%s)", entries[0].Description, formatNipRefs(entries[0].NIPs, entries[0].Reference)))
+	}
+	b.WriteString("\n\n")
+	b.WriteString(strings.TrimSpace(reply))
+
+	// The model can misquote, so every quote is checked against the sources
+	found, missing := verifyQuotes(reply, results)
+	b.WriteString("\n\n## Verification\n")
+	switch {
+	case len(found)+len(missing) == 0:
+		b.WriteString("The model quoted no spec lines, so the schema could not be checked against the sources; treat it as unverified.\n")
+	case len(missing) == 0:
+		b.WriteString(fmt.Sprintf("All %d quoted spec lines were found in the sources.\n", len(found)))
+	default:
+		b.WriteString(fmt.Sprintf("%d of %d quoted spec lines were found in the sources. These were not, so the parts of the schema based on them may be invented:\n", len(found), len(found)+len(missing)))
+		for _, quote := range missing {
+			b.WriteString(fmt.Sprintf("- %s\n", quote))
+		}
+	}
+
+	ids := make([]string, len(results))
+	for i, result := range results {
+		ids[i] = result.Record.Id
+	}
+	b.WriteString(fmt.Sprintf("Sources: %s\n", strings.Join(ids, ", ")))
+	return mcp.NewToolResultText(b.String()), nil
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"go.etcd.io/bbolt"
+)
+
+// feedbackPath is the local database of result ratings. Like the usage
+// statistics it is kept apart from the embeddings database.
+var feedbackPath = filepath.Join(dataDir, "feedback.db")
+
+// feedbackWeight is the largest amount ratings can move a chunk's score
+// (-feedback-weight); 0 turns feedback off
+var feedbackWeight = 0.05
+
+// Buckets of the feedback database. The ratings of each tenant of the HTTP
+// transport are kept in buckets of their own, named after the tenant.
+const (
+	feedbackChunkBucket = "chunk-votes"
+	feedbackQueryBucket = "query-votes"
+)
+
+// Limits on ratings, which anyone who can call rate_result can give: the
+// votes per caller and hour, and the length of the rated query
+const (
+	maxFeedbackVotes       = 60
+	maxFeedbackQueryLength = 500
+)
+
+// feedbackDB holds the feedback database open once it is first used
+var feedbackDB struct {
+	mutex sync.Mutex
+	db    *bbolt.DB
+}
+
+// feedbackLimiters limit the votes of each caller, keyed by feedback scope
+var feedbackLimiters = struct {
+	mutex    sync.Mutex
+	limiters map[string]*rateLimiter
+}{limiters: make(map[string]*rateLimiter)}
+
+// feedbackVotes counts the ratings given to a chunk
+type feedbackVotes struct {
+	Up   int `json:"up"`
+	Down int `json:"down"`
+}
+
+// balance is the share of net positive votes, damped so that a single vote
+// has less effect than a consistent record: 1 up gives 0.33, 10 up give 0.83
+func (v feedbackVotes) balance() float64 {
+	return float64(v.Up-v.Down) / float64(v.Up+v.Down+2)
+}
+
+// feedbackKey identifies a chunk by its text, so that ratings survive
+// re-ingestion as long as the section itself does not change
+func feedbackKey(prompt string) string {
+	sum := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(sum[:12])
+}
+
+// normalizeFeedbackQuery makes ratings for the same question match
+// regardless of case and spacing
+func normalizeFeedbackQuery(query string) string {
+	return strings.Join(strings.Fields(strings.ToLower(query)), " ")
+}
+
+// feedbackScope returns whose ratings apply to a call: the calling tenant's,
+// or the local ones over stdio and an HTTP transport without tenants
+func feedbackScope(ctx context.Context) string {
+	if tenant := tenantFromContext(ctx); tenant != nil {
+		return tenant.Name
+	}
+	return ""
+}
+
+// feedbackBuckets returns the names of the chunk and query vote buckets of a
+// scope. The local ones keep the names ratings were always stored under.
+func feedbackBuckets(scope string) (string, string) {
+	if scope == "" {
+		return feedbackChunkBucket, feedbackQueryBucket
+	}
+	return feedbackChunkBucket + ":" + scope, feedbackQueryBucket + ":" + scope
+}
+
+// openFeedbackDatabase returns the feedback database, opening it and creating
+// the data directory on first use. The handle stays open for the life of the
+// process, so queries do not reopen the file.
+func openFeedbackDatabase() (*bbolt.DB, error) {
+	feedbackDB.mutex.Lock()
+	defer feedbackDB.mutex.Unlock()
+
+	if feedbackDB.db != nil {
+		return feedbackDB.db, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(feedbackPath), 0755); err != nil {
+		return nil, fmt.Errorf("error creating %s: %v", filepath.Dir(feedbackPath), err)
+	}
+	db, err := bbolt.Open(feedbackPath, 0600, &bbolt.Options{Timeout: dbOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("error opening feedback database: %v", err)
+	}
+	feedbackDB.db = db
+	return db, nil
+}
+
+// allowFeedbackVote takes one of the votes a scope may give per hour, or
+// reports how long until the next one
+func allowFeedbackVote(scope string) (bool, time.Duration) {
+	feedbackLimiters.mutex.Lock()
+	limiter, ok := feedbackLimiters.limiters[scope]
+	if !ok {
+		limiter = newRateLimiter(maxFeedbackVotes, time.Hour)
+		feedbackLimiters.limiters[scope] = limiter
+	}
+	feedbackLimiters.mutex.Unlock()
+	return limiter.allow()
+}
+
+// recordFeedback stores a rating of a chunk for a query in the ratings of
+// the given scope
+func recordFeedback(scope, query, prompt string, up bool) (feedbackVotes, error) {
+	db, err := openFeedbackDatabase()
+	if err != nil {
+		return feedbackVotes{}, err
+	}
+
+	chunkBucket, queryBucket := feedbackBuckets(scope)
+	key := feedbackKey(prompt)
+	var total feedbackVotes
+	err = db.Update(func(tx *bbolt.Tx) error {
+		if _, err := addVote(tx, queryBucket, normalizeFeedbackQuery(query)+"\x00"+key, up); err != nil {
+			return err
+		}
+		total, err = addVote(tx, chunkBucket, key, up)
+		return err
+	})
+	return total, err
+}
+
+// addVote adds one vote to the counts stored under key and returns the new counts
+func addVote(tx *bbolt.Tx, bucketName, key string, up bool) (feedbackVotes, error) {
+	var votes feedbackVotes
+	bucket, err := tx.CreateBucketIfNotExists([]byte(bucketName))
+	if err != nil {
+		return votes, err
+	}
+	if data := bucket.Get([]byte(key)); data != nil {
+		if err := json.Unmarshal(data, &votes); err != nil {
+			return votes, err
+		}
+	}
+	if up {
+		votes.Up++
+	} else {
+		votes.Down++
+	}
+	data, err := json.Marshal(votes)
+	if err != nil {
+		return votes, err
+	}
+	return votes, bucket.Put([]byte(key), data)
+}
+
+// applyFeedback adjusts candidate scores by the ratings their chunks have
+// received from the caller found in ctx, counting ratings given for the same
+// query twice as much as ratings for other queries, and re-sorts the
+// candidates. It returns the adjustment applied to each chunk ID. Feedback is
+// best effort: failures are logged and leave the scores unchanged.
+func applyFeedback(ctx context.Context, query string, candidates []searchResult) map[string]float64 {
+	if feedbackWeight <= 0 || len(candidates) == 0 {
+		return nil
+	}
+	if _, err := os.Stat(feedbackPath); err != nil {
+		// No ratings have been given yet
+		return nil
+	}
+	db, err := openFeedbackDatabase()
+	if err != nil {
+		log.Printf("Ignoring result feedback: %v", err)
+		return nil
+	}
+
+	chunkBucket, queryBucket := feedbackBuckets(feedbackScope(ctx))
+	adjustments := make(map[string]float64)
+	normalized := normalizeFeedbackQuery(query)
+	err = db.View(func(tx *bbolt.Tx) error {
+		chunks := tx.Bucket([]byte(chunkBucket))
+		queries := tx.Bucket([]byte(queryBucket))
+		if chunks == nil {
+			return nil
+		}
+		for i := range candidates {
+			key := feedbackKey(candidates[i].Record.Prompt)
+			data := chunks.Get([]byte(key))
+			if data == nil {
+				continue
+			}
+			var overall, forQuery feedbackVotes
+			if err := json.Unmarshal(data, &overall); err != nil {
+				return err
+			}
+			if queries != nil {
+				if data := queries.Get([]byte(normalized + "\x00" + key)); data != nil {
+					if err := json.Unmarshal(data, &forQuery); err != nil {
+						return err
+					}
+				}
+			}
+
+			adjustment := feedbackWeight * (overall.balance() + 2*forQuery.balance()) / 3
+			candidates[i].Score += adjustment
+			adjustments[candidates[i].Record.Id] = adjustment
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Ignoring result feedback: %v", err)
+		return nil
+	}
+
+	if len(adjustments) > 0 {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].Score > candidates[j].Score
+		})
+	}
+	return adjustments
+}
+
+func rateResultHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	query, ok := request.Params.Arguments["query"].(string)
+	if !ok || query == "" {
+		return nil, errors.New("query must be a non-empty string")
+	}
+	id, ok := request.Params.Arguments["chunk_id"].(string)
+	if !ok || id == "" {
+		return nil, errors.New("chunk_id must be a non-empty string")
+	}
+	rating, _ := request.Params.Arguments["rating"].(string)
+	if rating != "up" && rating != "down" {
+		return nil, errors.New("rating must be \"up\" or \"down\"")
+	}
+	if len(query) > maxFeedbackQueryLength {
+		return nil, fmt.Errorf("query must be at most %d characters", maxFeedbackQueryLength)
+	}
+	if !tenantCanRead(ctx, id) {
+		return nil, fmt.Errorf("chunk %s not found", id)
+	}
+
+	index, release := acquireIndex()
+	defer release()
+	record, err := sessionReader(ctx, index).Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s not found", id)
+	}
+
+	// The query is rated as searched, without filter terms
+	queryText, _ := parseQueryFilter(query)
+	scope := feedbackScope(ctx)
+	if ok, wait := allowFeedbackVote(scope); !ok {
+		return nil, fmt.Errorf("rate limit of %d ratings per hour exceeded; try again in %s", maxFeedbackVotes, wait.Round(time.Second))
+	}
+	votes, err := recordFeedback(scope, queryText, record.Prompt, rating == "up")
+	if err != nil {
+		return nil, err
+	}
+
+	return mcp.NewToolResultText(fmt.Sprintf("Recorded a thumbs %s for %s. It now has %d up and %d down votes.", rating, id, votes.Up, votes.Down)), nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/parakeet-nest/parakeet/llm"
+)
+
+// withFeedbackDatabase makes the test open its own feedback database in the
+// working directory and closes it afterwards
+func withFeedbackDatabase(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() {
+		feedbackDB.mutex.Lock()
+		defer feedbackDB.mutex.Unlock()
+		if feedbackDB.db != nil {
+			feedbackDB.db.Close()
+			feedbackDB.db = nil
+		}
+	})
+}
+
+func TestFeedbackScopes(t *testing.T) {
+	inTempDir(t)
+	withFeedbackDatabase(t)
+
+	candidates := func() []searchResult {
+		return []searchResult{
+			{Record: llm.VectorRecord{Id: "nips/01-chunk-1", Prompt: "first"}, Score: 0.5},
+			{Record: llm.VectorRecord{Id: "nips/01-chunk-2", Prompt: "second"}, Score: 0.5},
+		}
+	}
+	local := context.Background()
+	tenant := withTenant(context.Background(), &Tenant{Name: "acme"})
+
+	if adjustments := applyFeedback(local, "relay list", candidates()); adjustments != nil {
+		t.Fatalf("adjustments before any rating: %v", adjustments)
+	}
+
+	// recordFeedback creates the data directory it stores ratings in
+	if _, err := recordFeedback(feedbackScope(tenant), "relay list", "second", true); err != nil {
+		t.Fatalf("recordFeedback: %v", err)
+	}
+
+	ranked := candidates()
+	adjustments := applyFeedback(tenant, "Relay  list", ranked)
+	if adjustments["nips/01-chunk-2"] <= 0 || ranked[0].Record.Id != "nips/01-chunk-2" {
+		t.Errorf("the tenant's rating was not applied to its query: %v", adjustments)
+	}
+	if adjustments := applyFeedback(local, "relay list", candidates()); len(adjustments) != 0 {
+		t.Errorf("the tenant's rating was applied to a local query: %v", adjustments)
+	}
+	other := withTenant(context.Background(), &Tenant{Name: "other"})
+	if adjustments := applyFeedback(other, "relay list", candidates()); len(adjustments) != 0 {
+		t.Errorf("the tenant's rating was applied to another tenant's query: %v", adjustments)
+	}
+}
+
+func TestAllowFeedbackVote(t *testing.T) {
+	scope := t.Name()
+	for i := 0; i < maxFeedbackVotes; i++ {
+		if ok, _ := allowFeedbackVote(scope); !ok {
+			t.Fatalf("vote %d was refused", i+1)
+		}
+	}
+	if ok, wait := allowFeedbackVote(scope); ok || wait <= 0 {
+		t.Errorf("vote over the limit: allowed %v, wait %s", ok, wait)
+	}
+	if ok, _ := allowFeedbackVote(scope + "-other"); !ok {
+		t.Error("another scope's vote was refused")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/parakeet-nest/parakeet/content"
+)
+
+// fenceLine matches the lines that open and close fenced code blocks
+var fenceLine = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})")
+
+// tinyCodeChunkChars is the size below which a section holding nothing but a
+// fenced code block is merged into the section before it, since a bare
+// example embeds poorly without the prose that explains it
+const tinyCodeChunkChars = 300
+
+// unclosedFence returns the fence of the code block still open at the end of
+// text, or "" when every fenced block in it is closed
+func unclosedFence(text string) string {
+	open := ""
+	for _, line := range strings.Split(text, "\n") {
+		marker := fenceLine.FindStringSubmatch(line)
+		if marker == nil {
+			continue
+		}
+		fence := marker[1]
+		switch {
+		case open == "":
+			open = fence
+		case fence[0] == open[0] && len(fence) >= len(open) && strings.TrimSpace(line[len(marker[0]):]) == "":
+			open = ""
+		}
+	}
+	return open
+}
+
+// codeOnly reports whether text is a single fenced code block
+func codeOnly(text string) bool {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	if len(lines) < 2 || !fenceLine.MatchString(lines[0]) {
+		return false
+	}
+	// The block opened on the first line must close on the last
+	for i := 1; i < len(lines); i++ {
+		if unclosedFence(strings.Join(lines[:i+1], "\n")) == "" {
+			return i == len(lines)-1
+		}
+	}
+	return false
+}
+
+// keepCodeFences repairs the sections of a markdown file that split fenced
+// code blocks. Header-like lines inside a block, such as shell comments,
+// start a new section in the markdown parser, so the rest of the block is
+// attached back to the section holding its opening fence. Tiny sections that
+// are only a code block are merged into the section before them. Chunks that
+// do not come from headers, such as source code, are left alone.
+func keepCodeFences(chunks []content.Chunk) []content.Chunk {
+	var kept []content.Chunk
+	merged := false
+	for _, chunk := range chunks {
+		if n := len(kept); n > 0 && chunk.Level > 0 && kept[n-1].Level > 0 {
+			prev := &kept[n-1]
+			headerLine := chunk.Prefix + " " + chunk.Header
+			switch {
+			case unclosedFence(prev.Content) != "":
+				prev.Content = strings.TrimSpace(prev.Content + "\n" + headerLine + "\n" + chunk.Content)
+				merged = true
+				continue
+			case len(chunk.Content) < tinyCodeChunkChars && codeOnly(chunk.Content):
+				prev.Content = strings.TrimSpace(prev.Content + "\n\n" + headerLine + "\n\n" + chunk.Content)
+				merged = true
+				continue
+			}
+		}
+		kept = append(kept, chunk)
+	}
+	if merged {
+		relinkSections(kept)
+	}
+	return kept
+}
+
+// relinkSections rebuilds the lineage and parents of header sections after
+// some were merged away, so no section names a merged one as its parent
+func relinkSections(chunks []content.Chunk) {
+	var stack []content.Chunk
+	for i := range chunks {
+		chunk := &chunks[i]
+		if chunk.Level == 0 {
+			continue
+		}
+		for len(stack) > 0 && stack[len(stack)-1].Level >= chunk.Level {
+			stack = stack[:len(stack)-1]
+		}
+		var parent content.Chunk
+		var lineage []string
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1]
+		}
+		for _, section := range stack {
+			lineage = append(lineage, section.Header)
+		}
+		chunk.Lineage = strings.Join(append(lineage, chunk.Header), " > ")
+		chunk.ParentHeader, chunk.ParentLevel, chunk.ParentPrefix = parent.Header, parent.Level, parent.Prefix
+		stack = append(stack, *chunk)
+	}
+}
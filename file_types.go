@@ -0,0 +1,343 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/parakeet-nest/parakeet/content"
+)
+
+// fileHandler turns the contents of one type of file into the chunks that are
+// embedded for it
+type fileHandler struct {
+	Kind  string    // Name of the file type shown while ingesting, e.g. "asciidoc"
+	Chunk chunkFunc // Splits the file into chunks by its structure
+}
+
+// fileHandlers maps lowercase file extensions to the handler of their file
+// type: the documentation formats, and source code in every language that
+// snippets are recognized in. New file types are added with
+// registerFileHandler.
+var fileHandlers = func() map[string]fileHandler {
+	handlers := map[string]fileHandler{
+		".md":       {"markdown", chunkMarkdown},
+		".markdown": {"markdown", chunkMarkdown},
+		".adoc":     {"asciidoc", chunkAsciiDoc},
+		".asciidoc": {"asciidoc", chunkAsciiDoc},
+		".rst":      {"restructuredtext", chunkRST},
+		".txt":      {"text", chunkPlainText},
+	}
+	for ext, language := range extensionLanguages {
+		if _, ok := handlers[ext]; !ok {
+			handlers[ext] = fileHandler{language, chunkCode}
+		}
+	}
+	return handlers
+}()
+
+// defaultExtensions are the file types ingested from repositories that do
+// not set Extensions. Source code is only ingested when a repository asks
+// for it.
+var defaultExtensions = []string{".md", ".markdown", ".adoc", ".asciidoc", ".rst", ".txt"}
+
+// registerFileHandler makes files with the given extensions ingestible
+func registerFileHandler(handler fileHandler, extensions ...string) {
+	for _, ext := range extensions {
+		fileHandlers[strings.ToLower(ext)] = handler
+	}
+}
+
+// repoExtensions returns the file extensions ingested from a repository
+func repoExtensions(repo RepoConfig) map[string]bool {
+	extensions := repo.Extensions
+	if len(extensions) == 0 {
+		extensions = defaultExtensions
+	}
+	included := make(map[string]bool, len(extensions))
+	for _, ext := range extensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext != "" && !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		included[ext] = true
+	}
+	return included
+}
+
+// checkExtensions reports extensions in a repository's configuration that no
+// file handler supports
+func checkExtensions(repo RepoConfig) error {
+	for ext := range repoExtensions(repo) {
+		if _, ok := fileHandlers[ext]; !ok {
+			return fmt.Errorf("repository %s: no handler for %q files", repo.Name, ext)
+		}
+	}
+	return nil
+}
+
+// fileHandlerFor returns the handler for a file if its type is included
+func fileHandlerFor(path string, included map[string]bool) (fileHandler, bool) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if !included[ext] {
+		return fileHandler{}, false
+	}
+	handler, ok := fileHandlers[ext]
+	return handler, ok
+}
+
+// chunkMarkdown splits markdown into sections under their headers
+func chunkMarkdown(text, _ string) []content.Chunk {
+	return content.ParseMarkdownWithLineage(text)
+}
+
+// asciiDocTitle matches AsciiDoc section titles such as "== Section"
+var asciiDocTitle = regexp.MustCompile(`^(={1,6})\s+(\S.*)$`)
+
+// asciiDocDelimiter matches the lines that open and close AsciiDoc listing,
+// literal, and passthrough blocks
+var asciiDocDelimiter = regexp.MustCompile(`^(-{4,}|\.{4,}|\+{4,}|` + "`{3}" + `)\s*$`)
+
+// chunkAsciiDoc splits AsciiDoc into sections by converting its section
+// titles to markdown headers. Listing blocks become fenced code blocks.
+func chunkAsciiDoc(text, _ string) []content.Chunk {
+	var b strings.Builder
+	inBlock := ""
+	for _, line := range strings.Split(text, "\n") {
+		if delimiter := asciiDocDelimiter.FindString(line); delimiter != "" {
+			delimiter = strings.TrimSpace(delimiter)
+			switch inBlock {
+			case "":
+				inBlock = delimiter
+				line = "```"
+			case delimiter:
+				inBlock = ""
+				line = "```"
+			}
+		} else if inBlock == "" {
+			if match := asciiDocTitle.FindStringSubmatch(line); match != nil {
+				line = strings.Repeat("#", len(match[1])) + " " + match[2]
+			}
+		}
+		b.WriteString(line + "\n")
+	}
+	return content.ParseMarkdownWithLineage(b.String())
+}
+
+// rstAdornmentChars are the characters that underline and overline
+// reStructuredText section titles
+const rstAdornmentChars = "=-~^\"'`+#*:."
+
+// isRSTAdornment reports whether a line underlines or overlines a
+// reStructuredText section title: three or more of one adornment character
+func isRSTAdornment(line string) bool {
+	line = strings.TrimRight(line, " \t")
+	if len(line) < 3 || !strings.ContainsRune(rstAdornmentChars, rune(line[0])) {
+		return false
+	}
+	return strings.Trim(line, line[:1]) == ""
+}
+
+// chunkRST splits reStructuredText into sections by converting its section
+// titles to markdown headers. Title levels follow the order in which each
+// adornment style first appears, as in reStructuredText itself.
+func chunkRST(text, _ string) []content.Chunk {
+	lines := strings.Split(text, "\n")
+	var styles []string
+	var b strings.Builder
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		title := strings.TrimSpace(line)
+		if title == "" || line[0] == ' ' || line[0] == '\t' || isRSTAdornment(line) ||
+			i+1 >= len(lines) || !isRSTAdornment(lines[i+1]) ||
+			len(strings.TrimSpace(lines[i+1])) < len(title) {
+			b.WriteString(line + "\n")
+			continue
+		}
+
+		// An overline of the same character was already written; drop it
+		style := lines[i+1][:1]
+		if i > 0 && isRSTAdornment(lines[i-1]) && lines[i-1][:1] == style {
+			written := strings.TrimSuffix(b.String(), lines[i-1]+"\n")
+			b.Reset()
+			b.WriteString(written)
+			style += "/"
+		}
+		level := 0
+		for level < len(styles) && styles[level] != style {
+			level++
+		}
+		if level == len(styles) {
+			styles = append(styles, style)
+		}
+		b.WriteString(strings.Repeat("#", min(level+1, 6)) + " " + title + "\n")
+		i++ // Skip the underline
+	}
+	return content.ParseMarkdownWithLineage(b.String())
+}
+
+// textChunkChars is the size plain text files are split into, at paragraph
+// boundaries where possible
+const textChunkChars = 1500
+
+// chunkPlainText splits plain text into runs of paragraphs, headed by the
+// file name since plain text has no titles
+func chunkPlainText(text, filePath string) []content.Chunk {
+	return textChunks(splitParagraphs(text, textChunkChars), filePath)
+}
+
+// paragraphBreak matches the blank lines between paragraphs
+var paragraphBreak = regexp.MustCompile(`\n\s*\n`)
+
+// splitParagraphs groups the blank-line separated paragraphs of text into
+// parts of at most size characters. A single paragraph longer than size is
+// split at line breaks, or at size if it has none.
+func splitParagraphs(text string, size int) []string {
+	var parts []string
+	var current strings.Builder
+	flush := func() {
+		if part := strings.TrimSpace(current.String()); part != "" {
+			parts = append(parts, part)
+		}
+		current.Reset()
+	}
+
+	for _, paragraph := range paragraphBreak.Split(text, -1) {
+		if current.Len() > 0 && current.Len()+len(paragraph)+2 > size {
+			flush()
+		}
+		for len(paragraph) > size {
+			cut := strings.LastIndex(paragraph[:size], "\n")
+			if cut <= 0 {
+				cut = size
+				for cut > 0 && !utf8.RuneStart(paragraph[cut]) {
+					cut--
+				}
+			}
+			current.WriteString(paragraph[:cut])
+			flush()
+			paragraph = paragraph[cut:]
+		}
+		if current.Len() > 0 {
+			current.WriteString("\n\n")
+		}
+		current.WriteString(paragraph)
+	}
+	flush()
+	return parts
+}
+
+// codeDeclarations match the first line of a function, method, or type
+// declaration in each language, at the top level or one level into a class
+var codeDeclarations = map[string]*regexp.Regexp{
+	"go":         regexp.MustCompile(`^(func|type)\s`),
+	"javascript": regexp.MustCompile(`^(\s{2}|\s{4}|\t)?(export\s+)?(default\s+)?(async\s+)?(function\b|class\s|(const|let|var)\s+\w+\s*=\s*(async\s*)?(\([^)]*\)|\w+)\s*=>|(static\s+)?(async\s+)?\w+\s*\([^)]*\)\s*\{$)`),
+	"typescript": regexp.MustCompile(`^(\s{2}|\s{4}|\t)?(export\s+)?(default\s+)?(abstract\s+)?(async\s+)?(function\b|class\s|interface\s|type\s+\w+\s*=|enum\s|(const|let|var)\s+\w+(:[^=]+)?\s*=\s*(async\s*)?(\([^)]*\)|\w+)\s*=>|(public\s+|private\s+|protected\s+)?(static\s+)?(async\s+)?\w+\s*\([^)]*\)(:[^{]+)?\s*\{$)`),
+	"python":     regexp.MustCompile(`^(\s{4}|\t)?((async\s+)?def\s|class\s)`),
+	"rust":       regexp.MustCompile(`^(\s{4})?(pub(\([^)]*\))?\s+)?(async\s+)?(unsafe\s+)?(const\s+)?(fn|struct|enum|trait|impl|mod)\b`),
+	"swift":      regexp.MustCompile(`^(\s{4}|\t)?((public|private|internal|open|fileprivate|final|static|override|@\w+)\s+)*(func|class|struct|enum|protocol|extension|init)\b`),
+	"kotlin":     regexp.MustCompile(`^(\s{4})?((public|private|internal|protected|data|sealed|open|abstract|override|suspend|inline)\s+)*(fun|class|object|interface)\s`),
+	"java":       regexp.MustCompile(`^(\s{4}|\t)?((public|private|protected|static|final|abstract|synchronized|default)\s+)+[\w<>\[\], ]+\s+\w+\s*\(|^(\s{4}|\t)?((public|private|protected|static|final|abstract)\s+)*(class|interface|enum|record)\s`),
+	"dart":       regexp.MustCompile(`^(\s{2})?((abstract|static|final|Future<[^>]*>|void|[A-Z]\w*(<[^>]*>)?\??)\s+)*(class\s|mixin\s|extension\s|\w+\s*\([^)]*\)\s*(async\s*)?\{$)`),
+	"c":          regexp.MustCompile(`^[A-Za-z_][\w\s\*]*\s\**\w+\s*\([^;]*$|^(typedef\s+)?(struct|enum|union)\s+\w*\s*\{`),
+	"cpp":        regexp.MustCompile(`^[A-Za-z_][\w\s\*&:<>,]*\s[\*&]*[\w:~]+\s*\([^;]*$|^(class|struct|enum|namespace|template)\b`),
+	"ruby":       regexp.MustCompile(`^(\s{2})?(def|class|module)\s`),
+	"php":        regexp.MustCompile(`^(\s{4})?((public|private|protected|static|abstract|final)\s+)*(function|class|interface|trait)\s`),
+	"bash":       regexp.MustCompile(`^(function\s+)?[\w-]+\s*\(\)\s*\{?`),
+}
+
+// codeComment matches lines that belong to the declaration below them:
+// comments, doc comments, decorators, and attributes
+var codeComment = regexp.MustCompile(`^\s*(//|#|/\*|\*|--|@|"""|''')`)
+
+const (
+	// codeChunkChars bounds a code chunk; longer declarations are split at
+	// blank lines
+	codeChunkChars = 4000
+
+	// minCodeChunkChars is the size below which a declaration is merged
+	// with the one after it, so one-line types do not become chunks of
+	// their own
+	minCodeChunkChars = 200
+)
+
+// chunkCode splits source code into its declarations. Comments are kept with
+// the declaration they document, and the code before the first declaration,
+// such as imports, forms a chunk of its own.
+func chunkCode(text, filePath string) []content.Chunk {
+	name := filepath.Base(filePath)
+	language := extensionLanguages[strings.ToLower(filepath.Ext(filePath))]
+	declaration := codeDeclarations[language]
+	lines := strings.Split(text, "\n")
+
+	// Find where each declaration starts, including the comments above it
+	starts := []int{0}
+	headers := []string{""}
+	for i, line := range lines {
+		if declaration == nil || !declaration.MatchString(line) || codeComment.MatchString(line) {
+			continue
+		}
+		start := i
+		for start > 0 && codeComment.MatchString(lines[start-1]) && start-1 > starts[len(starts)-1] {
+			start--
+		}
+		if start <= starts[len(starts)-1] {
+			continue
+		}
+		starts = append(starts, start)
+		headers = append(headers, declarationHeader(line))
+	}
+	starts = append(starts, len(lines))
+
+	var chunks []content.Chunk
+	for i := 0; i < len(starts)-1; i++ {
+		code := strings.Join(lines[starts[i]:starts[i+1]], "\n")
+		header := headers[i]
+		// Merge small declarations into the next one
+		for len(code) < minCodeChunkChars && i+1 < len(starts)-1 {
+			i++
+			code += "\n" + strings.Join(lines[starts[i]:starts[i+1]], "\n")
+			if header != "" {
+				header += ", "
+			}
+			header += headers[i]
+		}
+		if strings.TrimSpace(code) == "" {
+			continue
+		}
+		lineage := name
+		if header == "" {
+			// The code before the first declaration is named after the file
+			header = name
+		} else {
+			lineage += " > " + header
+		}
+
+		parts := splitParagraphs(code, codeChunkChars)
+		for j, part := range parts {
+			partHeader := header
+			if len(parts) > 1 {
+				partHeader = fmt.Sprintf("%s (part %d)", header, j+1)
+			}
+			chunks = append(chunks, content.Chunk{
+				Header:  partHeader,
+				Content: fmt.Sprintf("```%s\n%s\n```", language, part),
+				Lineage: lineage,
+			})
+		}
+	}
+	return chunks
+}
+
+// declarationHeader shortens a declaration's first line to a chunk header,
+// e.g. "func processFile(filePath string) error"
+func declarationHeader(line string) string {
+	header := strings.TrimSpace(line)
+	header = strings.TrimSpace(strings.TrimSuffix(header, "{"))
+	if len(header) > 100 {
+		header = header[:100] + "..."
+	}
+	return header
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/parakeet-nest/parakeet/llm"
+)
+
+// filterFields lists the metadata fields that can be used in query filters
+var filterFields = map[string]bool{
+	"repo":    true,
+	"nip":     true,
+	"file":    true,
+	"path":    true,
+	"header":  true,
+	"section": true,
+	"kind":    true,
+}
+
+// filterParams are the metadata fields that have their own query parameters,
+// in the order their terms are shown
+var filterParams = []string{"repo", "nip", "path", "section"}
+
+// Descriptions of the filter parameters of the search tools
+const (
+	repoFilterDescription    = "Only return chunks from this repository, e.g. nips"
+	nipFilterDescription     = "Only return chunks from this NIP, e.g. 57"
+	pathFilterDescription    = "Only return chunks from files whose path in their repository starts with this, e.g. docs/"
+	sectionFilterDescription = "Only return chunks whose section header contains this text"
+)
+
+// kindMention finds event kind numbers mentioned in chunk text, e.g. "kind 1",
+// "kind:30023" or "kinds 10000-19999"
+var kindMention = regexp.MustCompile("(?i)\\bkinds?[\\s:=`'\"]*(\\d+)")
+
+// filterTerm is a single "field:value" condition in a query filter
+type filterTerm struct {
+	Field  string
+	Op     string // "=", ">", ">=", "<", "<=" for kind; "=" otherwise
+	Value  string
+	Negate bool
+}
+
+// queryFilter is a disjunction of conjunctions: a chunk matches if every term
+// of at least one group matches
+type queryFilter struct {
+	Groups [][]filterTerm
+}
+
+// chunkMeta is the metadata recovered from a stored chunk's ID and text
+type chunkMeta struct {
+	Repo    string
+	File    string
+	Path    string // Path of the file in the repository, or File for older chunks
+	Section string
+	Parents string
+	Kinds   []int
+}
+
+// parseQueryFilter separates filter terms such as `repo:nips kind:>=30000
+// header:"zap"` from the free text of a query. Terms are ANDed together;
+// the keyword OR starts a new alternative and a leading "-" or NOT negates
+// a term. Tokens that look like terms but do not parse, such as "kind:abc",
+// are kept as query text. It returns the remaining query text and the
+// filter, which is nil when the query contains no filter terms.
+func parseQueryFilter(query string) (string, *queryFilter) {
+	tokens := tokenizeQuery(query)
+
+	var text []string
+	filter := &queryFilter{Groups: [][]filterTerm{nil}}
+	hasTerms := false
+	negateNext := false
+
+	for _, token := range tokens {
+		if token == "OR" {
+			filter.Groups = append(filter.Groups, nil)
+			continue
+		}
+		if token == "NOT" {
+			negateNext = true
+			continue
+		}
+
+		term, ok, err := parseFilterTerm(token)
+		if err != nil || !ok {
+			text = append(text, strings.Trim(token, "\""))
+			negateNext = false
+			continue
+		}
+
+		term.Negate = term.Negate != negateNext
+		negateNext = false
+		last := len(filter.Groups) - 1
+		filter.Groups[last] = append(filter.Groups[last], term)
+		hasTerms = true
+	}
+
+	if !hasTerms {
+		return strings.Join(text, " "), nil
+	}
+
+	// Drop empty alternatives left by a leading, trailing or doubled OR
+	var groups [][]filterTerm
+	for _, group := range filter.Groups {
+		if len(group) > 0 {
+			groups = append(groups, group)
+		}
+	}
+	filter.Groups = groups
+
+	return strings.Join(text, " "), filter
+}
+
+// tokenizeQuery splits a query on whitespace while keeping quoted values together
+func tokenizeQuery(query string) []string {
+	var tokens []string
+	var current strings.Builder
+	inQuotes := false
+
+	for _, r := range query {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			current.WriteRune(r)
+		case (r == ' ' || r == '\t' || r == '\n') && !inQuotes:
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
+
+// parseFilterTerm parses a "field:value" token. It reports false if the token
+// is ordinary query text.
+func parseFilterTerm(token string) (filterTerm, bool, error) {
+	var term filterTerm
+	if strings.HasPrefix(token, "-") {
+		term.Negate = true
+		token = token[1:]
+	}
+
+	field, value, found := strings.Cut(token, ":")
+	field = strings.ToLower(field)
+	if !found || !filterFields[field] {
+		return filterTerm{}, false, nil
+	}
+
+	term.Field = field
+	term.Op = "="
+	if field == "kind" {
+		for _, op := range []string{">=", "<=", ">", "<", "="} {
+			if strings.HasPrefix(value, op) {
+				term.Op = op
+				value = value[len(op):]
+				break
+			}
+		}
+		if _, err := strconv.Atoi(value); err != nil {
+			return filterTerm{}, false, fmt.Errorf("invalid kind filter %q: expected a number", token)
+		}
+	}
+
+	term.Value = strings.ToLower(strings.Trim(value, "\""))
+	if term.Value == "" {
+		return filterTerm{}, false, fmt.Errorf("empty value in filter %q", token)
+	}
+	return term, true, nil
+}
+
+// Matches reports whether a stored chunk satisfies the filter
+func (f *queryFilter) Matches(record llm.VectorRecord) bool {
+	if f == nil || len(f.Groups) == 0 {
+		return true
+	}
+
+	meta := extractChunkMeta(record)
+	for _, group := range f.Groups {
+		matched := true
+		for _, term := range group {
+			if term.matches(meta) == term.Negate {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the term's condition holds, ignoring negation
+func (t filterTerm) matches(meta chunkMeta) bool {
+	switch t.Field {
+	case "repo":
+		return strings.EqualFold(meta.Repo, t.Value)
+	case "nip", "file":
+		return trimNipNumber(meta.File) == trimNipNumber(t.Value)
+	case "path":
+		return strings.HasPrefix(strings.ToLower(meta.Path), t.Value)
+	case "header":
+		return strings.Contains(strings.ToLower(meta.Section), t.Value) ||
+			strings.Contains(strings.ToLower(meta.Parents), t.Value)
+	case "section":
+		return strings.Contains(strings.ToLower(meta.Section), t.Value)
+	case "kind":
+		want, _ := strconv.Atoi(t.Value)
+		for _, kind := range meta.Kinds {
+			if compareInts(kind, t.Op, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// trimNipNumber normalizes NIP identifiers so "nip:1" matches file "01"
+func trimNipNumber(s string) string {
+	s = strings.ToLower(s)
+	trimmed := strings.TrimLeft(s, "0")
+	if trimmed == "" && s != "" {
+		return "0"
+	}
+	return trimmed
+}
+
+func compareInts(a int, op string, b int) bool {
+	switch op {
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	}
+	return a == b
+}
+
+// chunkRepo returns the repository name encoded in an embedding ID, or "" for
+// chunks ingested before IDs carried the repository
+func chunkRepo(id string) string {
+	if repo, _, found := strings.Cut(chunkSource(id), "/"); found {
+		return repo
+	}
+	return ""
+}
+
+// extractChunkMeta recovers a chunk's metadata from its ID, which has the form
+// "<repo>/<file>-chunk-<n>", and from the section headers embedded in its text
+func extractChunkMeta(record llm.VectorRecord) chunkMeta {
+	var meta chunkMeta
+
+	meta.Repo = chunkRepo(record.Id)
+	meta.File = strings.TrimPrefix(chunkSource(record.Id), meta.Repo+"/")
+	meta.Path = meta.File
+	if path, ok := record.Metadata[metaPath].(string); ok {
+		meta.Path = path
+	}
+
+	text := strings.TrimPrefix(record.Prompt, "search_document: ")
+	for _, line := range strings.SplitN(text, "\n", 3) {
+		if section, ok := strings.CutPrefix(line, "Section: "); ok {
+			meta.Section = section
+		} else if parents, ok := strings.CutPrefix(line, "Parent Sections: "); ok {
+			meta.Parents = parents
+		}
+	}
+
+	for _, match := range kindMention.FindAllStringSubmatch(record.Prompt, -1) {
+		if kind, err := strconv.Atoi(match[1]); err == nil {
+			meta.Kinds = append(meta.Kinds, kind)
+		}
+	}
+
+	return meta
+}
+
+// parseFilterParams turns the values of the repo, nip, path, and section
+// parameters into filter terms that every result must match. Empty values
+// are skipped.
+func parseFilterParams(values map[string]string) ([]filterTerm, error) {
+	var terms []filterTerm
+	for _, field := range filterParams {
+		value := strings.TrimSpace(values[field])
+		if value == "" {
+			continue
+		}
+		term, _, err := parseFilterTerm(field + ":" + value)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+	return terms, nil
+}
+
+// filterArguments reads the filter parameters of a search tool call
+func filterArguments(request mcp.CallToolRequest) ([]filterTerm, error) {
+	values := make(map[string]string)
+	for _, field := range filterParams {
+		values[field], _ = request.Params.Arguments[field].(string)
+	}
+	return parseFilterParams(values)
+}
+
+// failedTerm returns the first term a chunk does not match
+func failedTerm(terms []filterTerm, record llm.VectorRecord) (filterTerm, bool) {
+	if len(terms) == 0 {
+		return filterTerm{}, false
+	}
+	meta := extractChunkMeta(record)
+	for _, term := range terms {
+		if term.matches(meta) == term.Negate {
+			return term, true
+		}
+	}
+	return filterTerm{}, false
+}
+
+// formatTerms renders filter terms for explanations
+func formatTerms(terms []filterTerm) string {
+	rendered := make([]string, len(terms))
+	for i, term := range terms {
+		rendered[i] = term.String()
+	}
+	return strings.Join(rendered, " ")
+}
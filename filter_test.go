@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestParseQueryFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantText   string
+		wantFilter string
+	}{
+		{name: "no terms", query: "how are zaps validated", wantText: "how are zaps validated", wantFilter: "none"},
+		{name: "terms", query: "zaps repo:nips kind:>=9000", wantText: "zaps", wantFilter: "repo:nips kind:>=9000"},
+		{name: "malformed kind is text", query: "zaps kind:abc", wantText: "zaps kind:abc", wantFilter: "none"},
+		{name: "empty value is text", query: `zaps header:""`, wantText: "zaps header:", wantFilter: "none"},
+		{name: "malformed next to valid", query: "zaps kind:x9 repo:nips", wantText: "zaps kind:x9", wantFilter: "repo:nips"},
+		{name: "unknown field is text", query: "note:hello", wantText: "note:hello", wantFilter: "none"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			text, filter := parseQueryFilter(test.query)
+			if text != test.wantText {
+				t.Errorf("text: got %q, want %q", text, test.wantText)
+			}
+			if got := filter.String(); got != test.wantFilter {
+				t.Errorf("filter: got %q, want %q", got, test.wantFilter)
+			}
+		})
+	}
+}
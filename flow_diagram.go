@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/parakeet-nest/parakeet/llm"
+)
+
+// flowDiagramResults is how many spec chunks a diagram is drawn from
+const flowDiagramResults = 6
+
+// flowDiagramSystemPrompt asks for a Mermaid sequence diagram that only shows
+// the steps the specification describes
+const flowDiagramSystemPrompt = `You are an expert on the Nostr protocol. Draw the protocol flow the user asks about as a Mermaid sequence diagram, using only the specification excerpts provided in the context. Use the actors the spec names (e.g. client, relay, remote signer, LNURL server, wallet) as participants, and label each message with the event kind, message type, or request it is, e.g. "kind 9734 zap request" or "EVENT". Add a note where the spec states a requirement that matters to the flow. Leave out steps the excerpts do not describe.
+
+Reply with only a fenced mermaid code block that starts with "sequenceDiagram", followed by one line listing the excerpt IDs you used, e.g. Sources: [nips/57-chunk-3].`
+
+// mermaidBlockPattern finds a fenced mermaid block in a reply
+var mermaidBlockPattern = regexp.MustCompile("(?s)```mermaid\\s*\\n(.*?)```")
+
+// extractSequenceDiagram returns the sequence diagram in a model reply, or ""
+// if the reply contains none
+func extractSequenceDiagram(reply string) string {
+	diagram := reply
+	if match := mermaidBlockPattern.FindStringSubmatch(reply); match != nil {
+		diagram = match[1]
+	} else if idx := strings.Index(reply, "sequenceDiagram"); idx != -1 {
+		// Some models leave out the fence
+		diagram = reply[idx:]
+		if end := strings.Index(diagram, "```"); end != -1 {
+			diagram = diagram[:end]
+		}
+	}
+
+	diagram = strings.TrimSpace(diagram)
+	if !strings.HasPrefix(diagram, "sequenceDiagram") {
+		return ""
+	}
+	return diagram
+}
+
+func flowDiagramHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	flow, ok := request.Params.Arguments["flow"].(string)
+	if !ok || strings.TrimSpace(flow) == "" {
+		return nil, errors.New("flow must be a non-empty string")
+	}
+
+	allowed, err := tenantCollections(ctx, []string{collectionSpecs})
+	if err != nil {
+		return nil, err
+	}
+	index, release := acquireIndex()
+	defer release()
+	candidates, err := retrieveCandidates(ctx, sessionReader(ctx, index), flow)
+	if err != nil {
+		return nil, err
+	}
+	results := selectResults(candidates, searchOptions{
+		Threshold:   0.5,
+		NumResults:  flowDiagramResults,
+		MaxPerFile:  flowDiagramResults,
+		Collections: allowed,
+	})
+	if len(results) == 0 {
+		return mcp.NewToolResultText("No specification text describing this flow was found, so no diagram was drawn."), nil
+	}
+	if reply, refuse := insufficientContext(results, results); refuse {
+		return mcp.NewToolResultText(reply), nil
+	}
+
+	messages := []llm.Message{
+		{Role: "system", Content: flowDiagramSystemPrompt},
+		{Role: "system", Content: formatResults(results)},
+		{Role: "user", Content: fmt.Sprintf("Draw the flow: %s", flow)},
+	}
+	reply, err := chatCompletion(messages, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	diagram := extractSequenceDiagram(reply)
+	if diagram == "" {
+		// Ask once more, pointing out what was wrong with the first reply
+		messages = append(messages,
+			llm.Message{Role: "assistant", Content: reply},
+			llm.Message{Role: "user", Content: "That is not a Mermaid sequence diagram. Reply with a fenced mermaid block starting with \"sequenceDiagram\"."},
+		)
+		if reply, err = chatCompletion(messages, nil); err != nil {
+			return nil, err
+		}
+		if diagram = extractSequenceDiagram(reply); diagram == "" {
+			return nil, errors.New("the model did not produce a sequence diagram; try rephrasing the flow or another model")
+		}
+	}
+
+	ids := make([]string, len(results))
+	for i, result := range results {
+		ids[i] = fmt.Sprintf("[%s]", result.Record.Id)
+	}
+	return mcp.NewToolResultText(fmt.Sprintf("```mermaid\n%s\n```\n\nDrawn from: %s", diagram, strings.Join(ids, ", "))), nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// kindContactList is the NIP-02 follow list kind
+const kindContactList = 3
+
+// maxFilterAuthors is how many authors a single filter lists. Contact lists
+// can hold thousands of keys, and relays refuse or truncate filters that
+// large, so longer author lists are split across several filters.
+const maxFilterAuthors = 250
+
+// splitAuthors returns copies of filter that each list at most
+// maxFilterAuthors of its authors, together covering all of them
+func splitAuthors(filter nostr.Filter) []nostr.Filter {
+	if len(filter.Authors) <= maxFilterAuthors {
+		return []nostr.Filter{filter}
+	}
+	var filters []nostr.Filter
+	for start := 0; start < len(filter.Authors); start += maxFilterAuthors {
+		batch := filter
+		batch.Authors = filter.Authors[start:min(start+maxFilterAuthors, len(filter.Authors))]
+		filters = append(filters, batch)
+	}
+	return filters
+}
+
+// fetchFollows returns the public keys in an account's latest contact list
+func fetchFollows(ctx context.Context, pubkey string) ([]string, error) {
+	filters := []nostr.Filter{{Kinds: []int{kindContactList}, Authors: []string{pubkey}, Limit: 1}}
+
+	var events []*nostr.Event
+	if eventsFile != "" {
+		var err error
+		events, err = loadEventsFile(eventsFile, filters)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		events = fetchFromRelays(ctx, rankRelays(searchRelays), filters, relayDeadline, 1, func(ev *nostr.Event) bool {
+			return ev.Kind == kindContactList && ev.PubKey == pubkey
+		})
+	}
+
+	// Contact lists are replaceable, so only the newest one counts
+	var latest *nostr.Event
+	for _, ev := range events {
+		if latest == nil || ev.CreatedAt > latest.CreatedAt {
+			latest = ev
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("no contact list found for %s", pubkey)
+	}
+
+	seen := make(map[string]bool)
+	var follows []string
+	for _, tag := range latest.Tags {
+		if len(tag) >= 2 && tag[0] == "p" && nostr.IsValid32ByteHex(tag[1]) && !seen[tag[1]] {
+			seen[tag[1]] = true
+			follows = append(follows, tag[1])
+		}
+	}
+	if len(follows) == 0 {
+		return nil, fmt.Errorf("the contact list of %s follows nobody", pubkey)
+	}
+	return follows, nil
+}
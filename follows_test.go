@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestSplitAuthors(t *testing.T) {
+	authors := func(n int) []string {
+		list := make([]string, n)
+		for i := range list {
+			list[i] = fmt.Sprintf("%064x", i)
+		}
+		return list
+	}
+
+	for _, n := range []int{0, 1, maxFilterAuthors, maxFilterAuthors + 1, 3*maxFilterAuthors + 17} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			filter := nostr.Filter{Kinds: []int{30023}, Authors: authors(n), Limit: 500}
+			filters := splitAuthors(filter)
+
+			wantFilters := max(1, (n+maxFilterAuthors-1)/maxFilterAuthors)
+			if len(filters) != wantFilters {
+				t.Fatalf("got %d filters, want %d", len(filters), wantFilters)
+			}
+			var covered []string
+			for _, f := range filters {
+				if len(f.Authors) > maxFilterAuthors {
+					t.Errorf("filter lists %d authors", len(f.Authors))
+				}
+				if len(f.Kinds) != 1 || f.Kinds[0] != 30023 || f.Limit != 500 {
+					t.Errorf("filter %v lost the feed's other conditions", f)
+				}
+				covered = append(covered, f.Authors...)
+			}
+			if fmt.Sprint(covered) != fmt.Sprint(filter.Authors) {
+				t.Errorf("the filters cover %d authors, want all %d in order", len(covered), n)
+			}
+		})
+	}
+}
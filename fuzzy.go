@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// maxEdits is how many typos a query word of the given length may contain and
+// still match. Short words must match exactly, since one edit turns "dm"
+// into any other two-letter word.
+func maxEdits(word string) int {
+	switch n := len([]rune(word)); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// textWords splits lowercase text into its distinct words
+func textWords(text string) map[string]bool {
+	words := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		words[word] = true
+	}
+	return words
+}
+
+// fuzzyContains reports whether any query word, or two adjacent query words
+// written as one ("web socket" for "websocket"), is within maxEdits of a word
+// in the text. Both must be lowercase.
+func fuzzyContains(text string, queryWords []string) bool {
+	candidates := make([]string, 0, 2*len(queryWords))
+	for i, word := range queryWords {
+		candidates = append(candidates, word)
+		if i+1 < len(queryWords) {
+			candidates = append(candidates, word+queryWords[i+1])
+		}
+	}
+
+	words := textWords(text)
+	for _, candidate := range candidates {
+		limit := maxEdits(candidate)
+		if limit == 0 {
+			if words[candidate] {
+				return true
+			}
+			continue
+		}
+		for word := range words {
+			if withinEdits(candidate, word, limit) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withinEdits reports whether a can be turned into b with at most limit
+// insertions, deletions, substitutions, or swaps of adjacent characters
+func withinEdits(a, b string, limit int) bool {
+	ra, rb := []rune(a), []rune(b)
+	if diff := len(ra) - len(rb); diff > limit || -diff > limit {
+		return false
+	}
+
+	// Optimal string alignment distance, keeping the last three rows
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(min(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, curr[j])
+		}
+		if rowMin > limit {
+			return false
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(rb)] <= limit
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// gapClusterThreshold is the cosine similarity above which two zero-result
+// queries are considered the same topic
+const gapClusterThreshold = 0.75
+
+// gapExamples caps the example queries printed per topic
+const gapExamples = 5
+
+// gapCluster groups zero-result queries about the same topic
+type gapCluster struct {
+	Centroid []float64
+	Queries  []zeroResultQuery
+}
+
+// printGapReport clusters the logged zero-result queries by meaning and
+// prints the topics the corpus is missing, largest first
+func printGapReport() {
+	if _, err := os.Stat(statsPath); os.IsNotExist(err) {
+		fmt.Println("No zero-result queries recorded yet.")
+		return
+	}
+
+	db, err := openStatsForReading()
+	if err != nil {
+		log.Fatalf("Error opening statistics database %s: %v", statsPath, err)
+	}
+	var queries []zeroResultQuery
+	db.View(func(tx *bbolt.Tx) error {
+		queries = readZeroResultQueries(tx)
+		return nil
+	})
+	db.Close()
+
+	if len(queries) == 0 {
+		fmt.Println("No zero-result queries recorded yet.")
+		return
+	}
+
+	fmt.Printf("Embedding %d zero-result queries...\n", len(queries))
+	clusters := clusterGapQueries(queries)
+
+	fmt.Printf("\nCorpus gap report: %d zero-result queries in %d topics\n", len(queries), len(clusters))
+	for i, cluster := range clusters {
+		last := cluster.Queries[len(cluster.Queries)-1].Time
+		fmt.Printf("\n%d. %s (%d queries, last %s)\n", i+1, gapLabel(cluster.Queries), len(cluster.Queries), last.Format(time.DateOnly))
+
+		seen := make(map[string]bool)
+		shown := 0
+		for j := len(cluster.Queries) - 1; j >= 0 && shown < gapExamples; j-- {
+			query := cluster.Queries[j].Query
+			if seen[strings.ToLower(query)] {
+				continue
+			}
+			seen[strings.ToLower(query)] = true
+			fmt.Printf("   - %s\n", query)
+			shown++
+		}
+	}
+}
+
+// clusterGapQueries greedily assigns each query to the first cluster whose
+// centroid is similar enough, or starts a new cluster. Queries that cannot be
+// embedded are grouped by their exact text instead.
+func clusterGapQueries(queries []zeroResultQuery) []gapCluster {
+	var clusters []gapCluster
+	for _, query := range queries {
+		text, _ := parseQueryFilter(query.Query)
+		if strings.TrimSpace(text) == "" {
+			text = query.Query
+		}
+
+		var vector []float64
+		if embedding, err := embedQuery(text); err == nil {
+			vector = embedding.Embedding
+		}
+
+		best, bestScore := -1, 0.0
+		for i, cluster := range clusters {
+			var score float64
+			if vector == nil || cluster.Centroid == nil {
+				if strings.EqualFold(cluster.Queries[0].Query, query.Query) {
+					score = 1
+				}
+			} else {
+				score = similarityScore(metricCosine, vector, cluster.Centroid)
+			}
+			if score >= gapClusterThreshold && score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+
+		if best < 0 {
+			clusters = append(clusters, gapCluster{Centroid: vector, Queries: []zeroResultQuery{query}})
+			continue
+		}
+
+		cluster := &clusters[best]
+		if vector != nil && cluster.Centroid != nil {
+			// Move the centroid towards the new member
+			n := float64(len(cluster.Queries))
+			for i := range cluster.Centroid {
+				cluster.Centroid[i] = (cluster.Centroid[i]*n + vector[i]) / (n + 1)
+			}
+		}
+		cluster.Queries = append(cluster.Queries, query)
+	}
+
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return len(clusters[i].Queries) > len(clusters[j].Queries)
+	})
+	return clusters
+}
+
+// gapLabel names a topic by the words that occur most often in its queries
+func gapLabel(queries []zeroResultQuery) string {
+	ignored := make(map[string]bool)
+	for _, list := range stopwords {
+		for _, word := range list.Words {
+			ignored[word] = true
+		}
+	}
+
+	counts := make(map[string]int)
+	var order []string
+	for _, query := range queries {
+		seen := make(map[string]bool)
+		for _, word := range strings.Fields(strings.ToLower(query.Query)) {
+			word = strings.Trim(word, ".,;:!?\"'()[]")
+			if len(word) < 3 || ignored[word] || seen[word] || strings.Contains(word, ":") {
+				continue
+			}
+			seen[word] = true
+			if counts[word] == 0 {
+				order = append(order, word)
+			}
+			counts[word]++
+		}
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return counts[order[i]] > counts[order[j]]
+	})
+	if len(order) > 3 {
+		order = order[:3]
+	}
+	if len(order) == 0 {
+		return fmt.Sprintf("%q", queries[0].Query)
+	}
+	return strings.Join(order, ", ")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/parakeet-nest/parakeet/completion"
+	"github.com/parakeet-nest/parakeet/llm"
+)
+
+// defaultChatModel is the Ollama model used to generate answers unless
+// another is configured
+const defaultChatModel = "llama3.2"
+
+// answerSystemPrompt instructs the model to stay grounded in the retrieved documentation
+const answerSystemPrompt = `You are an expert on the Nostr protocol. Answer the user's question using only the documentation excerpts provided in the context. Cite the IDs of the excerpts you relied on, e.g. [nips/01-chunk-12]. If the context does not contain the answer, say so instead of guessing.`
+
+// languageInstruction tells the model which language to answer in while
+// keeping references to the English specifications intact
+const languageInstruction = `Write your answer in %s. The documentation is in English: keep NIP numbers, section titles, event kinds, tag names, and code exactly as they appear in the sources, and quote English section titles when citing them.`
+
+// generateAnswer asks the chat model to answer a question from retrieved
+// chunks in the given language, or in the language of the question when
+// language is empty. Each piece of the answer is passed to onToken as it is
+// generated; the full answer is returned once generation completes.
+func generateAnswer(question, language string, results []searchResult, onToken func(string) error) (string, error) {
+	if language == "" {
+		language = detectLanguage(question)
+	}
+
+	systemPrompt := answerSystemPrompt
+	if llmConfig.SystemPrompt != "" {
+		systemPrompt = llmConfig.SystemPrompt
+	}
+
+	messages := []llm.Message{
+		{Role: "system", Content: systemPrompt},
+		{Role: "system", Content: fmt.Sprintf(languageInstruction, language)},
+	}
+	if promptTemplate != nil {
+		prompt, err := renderPrompt(promptTemplate, question, language, results)
+		if err != nil {
+			return "", err
+		}
+		messages = append(messages, llm.Message{Role: "user", Content: prompt})
+	} else {
+		messages = append(messages,
+			llm.Message{Role: "system", Content: formatResults(results)},
+			llm.Message{Role: "user", Content: question},
+		)
+	}
+
+	return chatCompletion(messages, onToken)
+}
+
+// chatCompletion runs the configured chat model on messages with the
+// configured generation settings. Each piece of the reply is passed to
+// onToken, if set, as it is generated.
+func chatCompletion(messages []llm.Message, onToken func(string) error) (string, error) {
+	if err := checkOllamaReachable(); err != nil {
+		return "", err
+	}
+
+	query := llm.Query{
+		Model:    llmConfig.Model,
+		Messages: messages,
+		Options: llm.Options{
+			Temperature: llmConfig.Temperature,
+			NumPredict:  llmConfig.MaxTokens,
+		},
+	}
+
+	var answer strings.Builder
+	_, err := completion.ChatStream(ollamaURL, query, func(chunk llm.Answer) error {
+		answer.WriteString(chunk.Message.Content)
+		if onToken != nil {
+			return onToken(chunk.Message.Content)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("error generating answer: %v", err)
+	}
+
+	return answer.String(), nil
+}
+
+// maxRelatedSuggestions caps the documents suggested when refusing to answer
+const maxRelatedSuggestions = 3
+
+// insufficientContext reports whether the retrieved chunks are too weak to
+// answer from, according to the configured minimum confidence. When they are,
+// it returns a reply explaining that the corpus does not cover the question,
+// optionally listing the closest documents from the candidates. Every reply
+// of the chat model that is grounded in retrieved chunks goes through it.
+func insufficientContext(candidates, results []searchResult) (string, bool) {
+	if llmConfig.MinConfidence <= 0 {
+		return "", false
+	}
+
+	// Results gathered from several collections are not sorted as a whole
+	best := 0.0
+	for _, result := range results {
+		best = max(best, result.Score)
+	}
+	if best >= llmConfig.MinConfidence {
+		return "", false
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("The indexed documentation does not cover this question well enough to answer it reliably (best match %.2f, required %.2f).", best, llmConfig.MinConfidence))
+
+	if llmConfig.SuggestRelated {
+		seen := make(map[string]bool)
+		var related []string
+		for _, candidate := range candidates {
+			source := chunkSource(candidate.Record.Id)
+			if seen[source] {
+				continue
+			}
+			seen[source] = true
+
+			meta := extractChunkMeta(candidate.Record)
+			if meta.Section != "" {
+				related = append(related, fmt.Sprintf("- %s (%s)", source, meta.Section))
+			} else {
+				related = append(related, "- "+source)
+			}
+			if len(related) == maxRelatedSuggestions {
+				break
+			}
+		}
+		if len(related) > 0 {
+			b.WriteString("\n\nThe closest documents are:\n")
+			b.WriteString(strings.Join(related, "\n"))
+		}
+	}
+
+	return b.String(), true
+}
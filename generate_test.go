@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/parakeet-nest/parakeet/llm"
+)
+
+func TestInsufficientContext(t *testing.T) {
+	saved := llmConfig
+	t.Cleanup(func() { llmConfig = saved })
+
+	result := func(id string, score float64) searchResult {
+		return searchResult{Record: llm.VectorRecord{Id: id}, Score: score}
+	}
+	// Spec results followed by code results, as generate_code gathers them
+	gathered := []searchResult{result("nips/01-chunk-1", 0.55), result("go-nostr/relay.go-chunk-2", 0.8)}
+
+	tests := []struct {
+		name          string
+		minConfidence float64
+		results       []searchResult
+		wantRefuse    bool
+	}{
+		{name: "guardrail off", minConfidence: 0, results: nil},
+		{name: "no results", minConfidence: 0.6, results: nil, wantRefuse: true},
+		{name: "best result not first", minConfidence: 0.7, results: gathered},
+		{name: "every result too weak", minConfidence: 0.9, results: gathered, wantRefuse: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			llmConfig.MinConfidence = test.minConfidence
+			llmConfig.SuggestRelated = true
+			reply, refuse := insufficientContext(test.results, test.results)
+			if refuse != test.wantRefuse {
+				t.Fatalf("refuse: got %v, want %v (reply %q)", refuse, test.wantRefuse, reply)
+			}
+			if refuse && len(test.results) > 0 && !strings.Contains(reply, chunkSource(test.results[0].Record.Id)) {
+				t.Errorf("reply %q does not suggest the closest documents", reply)
+			}
+		})
+	}
+}
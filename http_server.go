@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// httpAddr is the address to serve MCP over HTTP/SSE on instead of stdio
+var httpAddr string
+
+// httpBaseURL is the public URL clients reach the HTTP server at; it defaults
+// to http://localhost with the port from httpAddr
+var httpBaseURL string
+
+// serveHTTP serves MCP over HTTP/SSE. When tenants are configured every
+// request must carry a tenant's API key, each tenant's tool calls are rate
+// limited separately, and only admin tenants are offered the admin tools.
+func serveHTTP() error {
+	baseURL := httpBaseURL
+	if baseURL == "" {
+		_, port, err := net.SplitHostPort(httpAddr)
+		if err != nil {
+			return fmt.Errorf("invalid HTTP address %q: %v", httpAddr, err)
+		}
+		baseURL = "http://localhost:" + port
+	}
+
+	// Admin and read-only clients talk to separate servers, so read-only
+	// clients never see the admin tools listed
+	contextFunc := server.WithSSEContextFunc(func(ctx context.Context, r *http.Request) context.Context {
+		// Messages name their session, which scratch documents are kept per
+		ctx = withSession(ctx, r.URL.Query().Get("sessionId"))
+		if tenant, ok := tenantForRequest(r); ok {
+			return withTenant(ctx, tenant)
+		}
+		return ctx
+	})
+	readOnly := server.NewSSEServer(newMCPServer(false), server.WithBaseURL(baseURL), contextFunc)
+	admin := server.NewSSEServer(newMCPServer(true), server.WithBaseURL(baseURL), contextFunc)
+
+	if len(tenants) == 0 {
+		log.Printf("Warning: no tenants configured in %s; the HTTP server accepts requests without an API key", tenantsFile)
+	}
+	log.Printf("Serving MCP over HTTP/SSE on %s (%s/sse)", httpAddr, baseURL)
+	log.Printf("Serving the JSON API on %s/api/", baseURL)
+
+	api := http.NewServeMux()
+	registerChunkAPI(api)
+	registerWebAPI(api)
+	var ui http.Handler
+	if webUIEnabled {
+		ui = webUIHandler()
+		log.Printf("Serving the web UI on %s/", baseURL)
+	}
+	return http.ListenAndServe(httpAddr, authenticate(readOnly, admin, api, ui))
+}
+
+// authenticate rejects requests without a valid API key, applies each
+// tenant's rate limit to its tool calls and API requests, and routes
+// admin tenants to the server with the admin tools. Without tenants, -admin
+// decides for everyone. Requests under /api/ go to the JSON API. The web UI's
+// page, when ui is set, is served at / without a key: it holds no data, and
+// asks for the key its API requests send.
+func authenticate(readOnly, admin, api, ui http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ui != nil && r.URL.Path == "/" && r.Method == http.MethodGet {
+			ui.ServeHTTP(w, r)
+			return
+		}
+		isAPI := strings.HasPrefix(r.URL.Path, "/api/")
+		if len(tenants) == 0 {
+			if isAPI {
+				api.ServeHTTP(w, r)
+			} else if adminMode {
+				admin.ServeHTTP(w, r)
+			} else {
+				readOnly.ServeHTTP(w, r)
+			}
+			return
+		}
+
+		tenant, ok := tenantForRequest(r)
+		if !ok {
+			http.Error(w, "missing or unknown API key", http.StatusUnauthorized)
+			return
+		}
+
+		// Only messages and API requests are counted; the long-lived event
+		// stream is not
+		if (r.Method == http.MethodPost || isAPI) && tenant.limiter != nil {
+			if allowed, wait := tenant.limiter.allow(); !allowed {
+				w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, fmt.Sprintf("rate limit of %d requests per minute exceeded", tenant.RateLimit), http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		if isAPI {
+			// The tenant's collections limit what the API lists and searches,
+			// and its admin flag what it may change
+			api.ServeHTTP(w, r.WithContext(withTenant(r.Context(), tenant)))
+		} else if tenant.Admin {
+			admin.ServeHTTP(w, r)
+		} else {
+			readOnly.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Retrieval modes
+const (
+	modeVector = "vector" // Embedding similarity alone (default)
+	modeHybrid = "hybrid" // Embedding similarity plus BM25 keyword scores
+)
+
+// retrievalMode is the mode queries use unless they ask for another one. Set
+// with -retrieval-mode.
+var retrievalMode = modeVector
+
+// vectorWeight and lexicalWeight weigh the similarity and the keyword score
+// of a chunk in hybrid mode. The keyword score is normalized so the best
+// keyword match of a query scores 1, which lets a chunk that contains the
+// exact terms of a query like "kind 30023" climb past the min score.
+var (
+	vectorWeight  = 1.0
+	lexicalWeight = 0.3
+)
+
+// BM25 parameters: how quickly repeated terms stop adding to the score, and
+// how much long chunks are penalized
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// retrievalModeDescription documents the mode argument of the search tools
+const retrievalModeDescription = "How chunks are scored: 'vector' (embedding similarity) or 'hybrid' (similarity plus keyword matches, for exact terms like 'kind 30023' or 'NIP-46'). Defaults to the server's -retrieval-mode."
+
+// parseRetrievalMode validates a retrieval mode name; "" is the default mode
+func parseRetrievalMode(name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "":
+		return retrievalMode, nil
+	case modeVector:
+		return modeVector, nil
+	case modeHybrid, "bm25":
+		return modeHybrid, nil
+	}
+	return "", fmt.Errorf("unknown retrieval mode %q; use vector or hybrid", name)
+}
+
+// modeArgument reads the mode argument of a search tool call
+func modeArgument(request mcp.CallToolRequest) (string, error) {
+	value, _ := request.Params.Arguments["mode"].(string)
+	return parseRetrievalMode(value)
+}
+
+// lexicalDoc is the term counts of a chunk's text
+type lexicalDoc struct {
+	text   string
+	terms  map[string]int
+	length int
+}
+
+// lexicalCache keeps the term counts of every chunk seen by a hybrid query,
+// so they are counted once rather than on every query. Entries are checked
+// against the chunk's text, so re-ingested chunks are counted again.
+var lexicalCache = struct {
+	sync.Mutex
+	docs map[string]lexicalDoc
+}{docs: make(map[string]lexicalDoc)}
+
+// maxLexicalCacheDocs caps the chunks lexicalCache holds before it is
+// emptied
+const maxLexicalCacheDocs = 200000
+
+// lexicalTerms splits text into lowercase words, so "NIP-46" becomes "nip"
+// and "46"
+func lexicalTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// lexicalDocs returns the term counts of the candidates' texts
+func lexicalDocs(candidates []searchResult) []lexicalDoc {
+	lexicalCache.Lock()
+	defer lexicalCache.Unlock()
+
+	// Chunks that left the index are only forgotten when the cache is full
+	if len(lexicalCache.docs)+len(candidates) > maxLexicalCacheDocs {
+		lexicalCache.docs = make(map[string]lexicalDoc)
+	}
+
+	docs := make([]lexicalDoc, len(candidates))
+	for i, candidate := range candidates {
+		record := candidate.Record
+		doc, ok := lexicalCache.docs[record.Id]
+		if !ok || doc.text != record.Prompt {
+			terms := lexicalTerms(record.Prompt)
+			doc = lexicalDoc{text: record.Prompt, terms: make(map[string]int), length: len(terms)}
+			for _, term := range terms {
+				doc.terms[term]++
+			}
+			lexicalCache.docs[record.Id] = doc
+		}
+		docs[i] = doc
+	}
+	return docs
+}
+
+// fuzzyTermWeight discounts a chunk term that only matches a query word
+// within maxEdits, so a misspelled query like "nosrt shnorr" still finds
+// "nostr" and "schnorr" while exact matches rank first
+const fuzzyTermWeight = 0.5
+
+// expandQueryTerms maps each chunk term the query matches to the weight of
+// the match: 1 for a query word, or two adjacent query words written as one
+// ("web socket" for "websocket"), and fuzzyTermWeight for a term within
+// maxEdits of one. Words with digits, such as kind numbers, match exactly.
+func expandQueryTerms(query string, docs []lexicalDoc) map[string]float64 {
+	words := lexicalTerms(query)
+	var candidates []string
+	for i, word := range words {
+		candidates = append(candidates, word)
+		if i+1 < len(words) {
+			candidates = append(candidates, word+words[i+1])
+		}
+	}
+
+	vocabulary := make(map[string]bool)
+	for _, doc := range docs {
+		for term := range doc.terms {
+			vocabulary[term] = true
+		}
+	}
+
+	expanded := make(map[string]float64)
+	for _, candidate := range candidates {
+		if vocabulary[candidate] {
+			expanded[candidate] = 1
+		}
+		limit := maxEdits(candidate)
+		if limit == 0 || strings.ContainsFunc(candidate, unicode.IsDigit) {
+			continue
+		}
+		for term := range vocabulary {
+			if expanded[term] < fuzzyTermWeight && withinEdits(candidate, term, limit) {
+				expanded[term] = fuzzyTermWeight
+			}
+		}
+	}
+	return expanded
+}
+
+// bm25Scores scores each candidate's text against the query terms with BM25,
+// counting document frequencies over the candidates. Terms matched through
+// expandQueryTerms count with the weight of their match.
+func bm25Scores(candidates []searchResult, query string) []float64 {
+	scores := make([]float64, len(candidates))
+	docs := lexicalDocs(candidates)
+	if len(docs) == 0 {
+		return scores
+	}
+
+	queryTerms := expandQueryTerms(query, docs)
+	totalLength := 0
+	for _, doc := range docs {
+		totalLength += doc.length
+	}
+	avgLength := math.Max(1, float64(totalLength)/float64(len(docs)))
+
+	n := float64(len(docs))
+	for term, weight := range queryTerms {
+		df := 0
+		for _, doc := range docs {
+			if doc.terms[term] > 0 {
+				df++
+			}
+		}
+		if df == 0 {
+			continue
+		}
+		idf := math.Log(1 + (n-float64(df)+0.5)/(float64(df)+0.5))
+		for i, doc := range docs {
+			tf := float64(doc.terms[term])
+			if tf == 0 {
+				continue
+			}
+			norm := bm25K1 * (1 - bm25B + bm25B*float64(doc.length)/avgLength)
+			scores[i] += weight * idf * tf * (bm25K1 + 1) / (tf + norm)
+		}
+	}
+	return scores
+}
+
+// applyLexicalScores merges the BM25 scores of the candidates into their
+// similarity scores with vectorWeight and lexicalWeight and reranks them. It
+// returns the normalized keyword score of each candidate that had one, by
+// chunk ID.
+func applyLexicalScores(candidates []searchResult, query string) map[string]float64 {
+	scores := bm25Scores(candidates, query)
+	best := 0.0
+	for _, score := range scores {
+		best = math.Max(best, score)
+	}
+
+	lexical := make(map[string]float64)
+	for i := range candidates {
+		keyword := 0.0
+		if best > 0 {
+			keyword = scores[i] / best
+		}
+		if keyword > 0 {
+			lexical[candidates[i].Record.Id] = keyword
+		}
+		candidates[i].Score = vectorWeight*candidates[i].Score + lexicalWeight*keyword
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+	return lexical
+}
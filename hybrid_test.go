@@ -0,0 +1,104 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/parakeet-nest/parakeet/llm"
+)
+
+// textCandidates returns candidates with the given texts and a similarity of 0.5
+func textCandidates(texts ...string) []searchResult {
+	var candidates []searchResult
+	for i, text := range texts {
+		candidates = append(candidates, searchResult{Record: llm.VectorRecord{Id: "nips/" + string(rune('a'+i)), Prompt: text}, Score: 0.5})
+	}
+	return candidates
+}
+
+func TestLexicalTerms(t *testing.T) {
+	got := lexicalTerms("NIP-46: kind 24133, Nostr Connect")
+	want := []string{"nip", "46", "kind", "24133", "nostr", "connect"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestBM25Scores(t *testing.T) {
+	tests := []struct {
+		name   string
+		texts  []string
+		query  string
+		higher int // Candidate expected to outscore the other
+		lower  int
+	}{
+		{name: "matching beats not matching", texts: []string{"long-form content", "kind 30023 long-form content"}, query: "kind 30023", higher: 1, lower: 0},
+		{name: "rare term beats common term", texts: []string{"event kind", "event kind", "event 30023"}, query: "kind 30023", higher: 2, lower: 0},
+		{name: "repeated term counts", texts: []string{"zap zap zap receipts", "zap event receipts"}, query: "zap", higher: 0, lower: 1},
+		{name: "shorter chunk wins", texts: []string{"relay list", "relay list metadata published by users for their outbox model", "contact list"}, query: "relay", higher: 0, lower: 1},
+		{name: "misspelled words", texts: []string{"lightning invoices", "nostr events carry schnorr signatures"}, query: "nosrt shnorr", higher: 1, lower: 0},
+		{name: "split word", texts: []string{"clients talk to relays over a websocket", "relays answer http requests"}, query: "web socket", higher: 0, lower: 1},
+		{name: "exact beats misspelled", texts: []string{"relay hints", "relays hints"}, query: "relays", higher: 1, lower: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scores := bm25Scores(textCandidates(test.texts...), test.query)
+			if !(scores[test.higher] > scores[test.lower]) {
+				t.Errorf("scores %v: candidate %d should outscore candidate %d", scores, test.higher, test.lower)
+			}
+		})
+	}
+
+	if scores := bm25Scores(textCandidates("metadata", "follow list"), "kind 30023"); scores[0] != 0 || scores[1] != 0 {
+		t.Errorf("scores without matching terms: %v", scores)
+	}
+	// Kind numbers one digit apart are different kinds
+	if scores := bm25Scores(textCandidates("kind 30024 drafts", "follow list"), "30023"); scores[0] != 0 {
+		t.Errorf("a kind number matched another: %v", scores)
+	}
+	if scores := bm25Scores(nil, "kind"); len(scores) != 0 {
+		t.Errorf("scores without candidates: %v", scores)
+	}
+}
+
+func TestApplyLexicalScores(t *testing.T) {
+	savedVector, savedLexical := vectorWeight, lexicalWeight
+	t.Cleanup(func() { vectorWeight, lexicalWeight = savedVector, savedLexical })
+	vectorWeight, lexicalWeight = 1, 0.3
+
+	candidates := textCandidates("long-form content articles", "kind 30023 for long-form content", "reactions")
+	candidates[0].Score = 0.8
+	candidates[1].Score = 0.7
+	candidates[2].Score = 0.75
+
+	lexical := applyLexicalScores(candidates, "kind 30023")
+
+	// The chunk with the exact terms climbs to the top with a keyword score
+	// of 1; the others keep their similarity
+	if candidates[0].Record.Prompt != "kind 30023 for long-form content" || math.Abs(candidates[0].Score-1.0) > 1e-9 {
+		t.Errorf("top result %q scored %.4f, want the exact match at 1.0", candidates[0].Record.Prompt, candidates[0].Score)
+	}
+	if candidates[1].Score != 0.8 || candidates[2].Score != 0.75 {
+		t.Errorf("scores of chunks without keywords changed: %.4f, %.4f", candidates[1].Score, candidates[2].Score)
+	}
+	if len(lexical) != 1 || lexical["nips/b"] != 1 {
+		t.Errorf("keyword scores: got %v, want nips/b at 1", lexical)
+	}
+}
+
+func TestParseRetrievalMode(t *testing.T) {
+	saved := retrievalMode
+	t.Cleanup(func() { retrievalMode = saved })
+	retrievalMode = modeHybrid
+
+	for input, want := range map[string]string{"": modeHybrid, "Vector": modeVector, " bm25 ": modeHybrid} {
+		if got, err := parseRetrievalMode(input); err != nil || got != want {
+			t.Errorf("parseRetrievalMode(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	if _, err := parseRetrievalMode("keyword"); err == nil {
+		t.Error("parseRetrievalMode accepted an unknown mode")
+	}
+}
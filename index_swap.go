@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// ingestSuffix names the temporary database that re-ingestion builds before
+// it is swapped in over the serving one
+const ingestSuffix = ".ingest"
+
+// storeWatchInterval is how often the MCP server checks whether the database
+// file was replaced by a finished ingest
+var storeWatchInterval = 5 * time.Second
+
+// prepareIngestDatabase copies the serving database to a temporary one next
+// to path and returns its location. Its chunks are carried over so chunks
+// whose text has not changed keep their vectors instead of being embedded
+// again; the ones the ingest does not store are swept when it is done, so
+// documents that no longer exist drop out of the rebuilt index.
+func prepareIngestDatabase(path string) (string, error) {
+	tmpPath := path + ingestSuffix
+	os.Remove(tmpPath)
+
+	// The serving database may be locked by a running MCP server, so it is
+	// copied byte for byte instead of being opened
+	if err := copyFile(path, tmpPath); err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("error copying %s: %v", path, err)
+	}
+
+	db, err := openRawDatabase(tmpPath, false)
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("error opening %s: %v", tmpPath, err)
+	}
+	db.Close()
+
+	return tmpPath, nil
+}
+
+// clearChunks deletes every chunk bucket, keeping only the settings in the
+// meta bucket
+func clearChunks(tx *bbolt.Tx) error {
+	var names [][]byte
+	err := tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+		if string(name) != metaBucket {
+			names = append(names, append([]byte(nil), name...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := tx.DeleteBucket(name); err != nil {
+			return fmt.Errorf("error clearing bucket %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// copyFile copies the file at src to dst, creating or truncating dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// swapInDatabase atomically replaces the serving database with a completed
+// temporary one. Readers that still have the old file open keep seeing it
+// until they reopen the store.
+func swapInDatabase(tmpPath, path string) error {
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error replacing %s: %v", path, err)
+	}
+	return nil
+}
+
+// closeStore releases the database file held by a vector store and its
+// lock, so the file can be opened again, also by this process
+func closeStore(store *vectorStore) {
+	if err := store.Close(); err != nil {
+		log.Printf("Error closing vector store: %v", err)
+	}
+}
+
+// watchStore reopens the serving store whenever the database file at path is
+// replaced, so a finished ingest is picked up without restarting the server
+func watchStore(path string) {
+	current, err := os.Stat(path)
+	if err != nil {
+		log.Printf("Error watching %s: %v", path, err)
+		return
+	}
+
+	for range time.Tick(storeWatchInterval) {
+		latest, err := os.Stat(path)
+		if err != nil || os.SameFile(current, latest) {
+			continue
+		}
+
+		// Queries keep running against the old index until the new one is
+		// fully loaded
+		index, err := openServingIndex(path)
+		if err != nil {
+			log.Printf("Error reopening vector store after swap: %v", err)
+			continue
+		}
+		// Queries that started on the old index finish on it; its store
+		// is closed after the last of them
+		publishIndex(index)
+		current = latest
+		log.Printf("Reopened vector store %s after re-ingestion", path)
+	}
+}
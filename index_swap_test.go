@@ -0,0 +1,155 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/parakeet-nest/parakeet/llm"
+)
+
+func TestCloseStoreReleasesDatabase(t *testing.T) {
+	defer func(timeout time.Duration) { dbOpenTimeout = timeout }(dbOpenTimeout)
+	dbOpenTimeout = 200 * time.Millisecond
+	path := filepath.Join(t.TempDir(), "embeddings.db")
+
+	store := vectorStore{}
+	if err := initializeStore(&store, path); err != nil {
+		t.Fatalf("initializeStore: %v", err)
+	}
+	record := llm.VectorRecord{Id: "nips/nips/01-0", Prompt: "basic protocol", Embedding: []float64{1, 0}}
+	if _, err := store.Save(record); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	closeStore(&store)
+
+	// Bookkeeping opens the file directly once the store is closed
+	if err := storeMetaJSON(path, corpusKey, corpusManifest{}); err != nil {
+		t.Fatalf("storeMetaJSON after closeStore: %v", err)
+	}
+
+	if err := initializeStore(&store, path); err != nil {
+		t.Fatalf("reopening after closeStore: %v", err)
+	}
+	got, err := store.Get(record.Id)
+	if err != nil || got.Prompt != record.Prompt {
+		t.Fatalf("Get after reopening = %+v, %v; want %q", got, err, record.Prompt)
+	}
+	closeStore(&store)
+	closeStore(&store)
+}
+
+func TestOpenStoreLocksDatabase(t *testing.T) {
+	defer func(timeout time.Duration) { dbOpenTimeout = timeout }(dbOpenTimeout)
+	dbOpenTimeout = 100 * time.Millisecond
+	path := filepath.Join(t.TempDir(), "embeddings.db")
+
+	store := vectorStore{}
+	if err := initializeStore(&store, path); err != nil {
+		t.Fatalf("initializeStore: %v", err)
+	}
+	defer closeStore(&store)
+	if db, err := openRawDatabase(path, false); err == nil {
+		db.Close()
+		t.Fatal("opened the database while the store holds it")
+	}
+}
+
+func TestServingIndexKeepsItsMetric(t *testing.T) {
+	dir := t.TempDir()
+	open := func(name, metric string) *servingIndex {
+		path := filepath.Join(dir, name)
+		if err := setStoreMetric(path, metric); err != nil {
+			t.Fatalf("setStoreMetric: %v", err)
+		}
+		store := vectorStore{}
+		if err := initializeStore(&store, path); err != nil {
+			t.Fatalf("initializeStore: %v", err)
+		}
+		record := llm.VectorRecord{Id: "nips/nips/01-0", Prompt: "basic protocol", Embedding: []float64{2, 0}}
+		if _, err := store.Save(record); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		closeStore(&store)
+
+		index, err := openServingIndex(path)
+		if err != nil {
+			t.Fatalf("openServingIndex: %v", err)
+		}
+		t.Cleanup(func() { closeStore(index.store) })
+		return index
+	}
+
+	euclidean := open("euclidean.db", metricEuclidean)
+	// Queries against the first index keep scoring while the next one opens
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 100 {
+			scoreStore(euclidean.reader, llm.VectorRecord{Embedding: []float64{1, 0}}, nil)
+		}
+	}()
+	dot := open("dot.db", metricDot)
+	<-done
+
+	if got := euclidean.reader.Metric(); got != metricEuclidean {
+		t.Errorf("first index metric: got %s, want %s", got, metricEuclidean)
+	}
+	results, err := scoreStore(dot.reader, llm.VectorRecord{Embedding: []float64{1, 0}}, nil)
+	if err != nil || len(results) != 1 || results[0].Score != 2 {
+		t.Errorf("dot index scored %v (%v), want a dot product of 2", results, err)
+	}
+}
+
+func TestRetiredIndexOutlivesItsQueries(t *testing.T) {
+	defer func(limit int) { maxMemoryVectors = limit }(maxMemoryVectors)
+	defer serving.Store(serving.Load())
+	// The index is searched from disk, so its store must stay open
+	maxMemoryVectors = 1
+
+	dir := t.TempDir()
+	open := func(name string) *servingIndex {
+		path := filepath.Join(dir, name)
+		store := vectorStore{}
+		if err := initializeStore(&store, path); err != nil {
+			t.Fatalf("initializeStore: %v", err)
+		}
+		for _, id := range []string{"nips/01-chunk-1", "nips/01-chunk-2"} {
+			if _, err := store.Save(llm.VectorRecord{Id: id, Prompt: "basic protocol", Embedding: []float64{1, 0}}); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+		}
+		closeStore(&store)
+		index, err := openServingIndex(path)
+		if err != nil {
+			t.Fatalf("openServingIndex: %v", err)
+		}
+		if index.inMemory {
+			t.Fatal("the index was loaded into memory")
+		}
+		return index
+	}
+
+	old := open("old.db")
+	publishIndex(old)
+	held, release := acquireIndex()
+
+	replacement := open("new.db")
+	publishIndex(replacement)
+	t.Cleanup(func() { closeStore(replacement.store) })
+
+	// A query that started before the swap still reads the old store
+	if records, err := held.reader.GetAll(); err != nil || len(records) != 2 {
+		t.Fatalf("reading the retired index: %d records, %v", len(records), err)
+	}
+	if index, release := acquireIndex(); index != replacement {
+		t.Error("a new query got the retired index")
+	} else {
+		release()
+	}
+
+	release()
+	if old.store.db != nil {
+		t.Error("the retired store was not closed after its last query")
+	}
+}
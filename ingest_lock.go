@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// ingestLockSuffix names the file an ingest holds locked from start to end,
+// so ingests started from the command line, in the background or by the
+// reingest tool never build the temporary database at the same time
+const ingestLockSuffix = ingestSuffix + ".lock"
+
+// errIngestRunning is returned when another process holds the ingest lock
+var errIngestRunning = errors.New("an ingest is already running")
+
+// lockIngest takes the ingest lock for the database at path without waiting
+// and returns the function that releases it. The lock file is a bbolt
+// database because bbolt holds an exclusive lock on its file for as long as
+// it is open, flock on Unix and LockFileEx on Windows, which the operating
+// system releases when the process exits however it ends.
+func lockIngest(path string) (func(), error) {
+	// A timeout this short makes a single attempt
+	db, err := bbolt.Open(path+ingestLockSuffix, 0600, &bbolt.Options{Timeout: time.Nanosecond})
+	if errors.Is(err, bbolt.ErrTimeout) {
+		return nil, errIngestRunning
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() { db.Close() }, nil
+}
+
+// ingestLocked reports whether an ingest holds the lock for the database at
+// path, in this process or another
+func ingestLocked(path string) bool {
+	release, err := lockIngest(path)
+	if err != nil {
+		return errors.Is(err, errIngestRunning)
+	}
+	release()
+	return false
+}
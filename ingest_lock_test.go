@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestLockIngest(t *testing.T) {
+	inTempDir(t)
+
+	release, err := lockIngest(dbPath)
+	if err != nil {
+		t.Fatalf("lockIngest: %v", err)
+	}
+	if _, err := lockIngest(dbPath); !errors.Is(err, errIngestRunning) {
+		t.Fatalf("second lockIngest: got %v, want errIngestRunning", err)
+	}
+	if !ingestLocked(dbPath) {
+		t.Fatal("ingestLocked is false while the lock is held")
+	}
+
+	// An ingest started while the lock is held leaves everything alone
+	createDatabase(false, false, "")
+	for _, path := range []string{dbPath, dbPath + ingestSuffix} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s was created while another ingest held the lock", path)
+		}
+	}
+	if _, ok := loadIngestProgress(dbPath); ok {
+		t.Error("ingest progress was recorded while another ingest held the lock")
+	}
+	if err := startIngestProcess(false); !errors.Is(err, errIngestRunning) {
+		t.Errorf("startIngestProcess: got %v, want errIngestRunning", err)
+	}
+
+	release()
+	if ingestLocked(dbPath) {
+		t.Fatal("ingestLocked is true after the lock was released")
+	}
+	release, err = lockIngest(dbPath)
+	if err != nil {
+		t.Fatalf("lockIngest after release: %v", err)
+	}
+	release()
+}
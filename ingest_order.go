@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// ingestFile is a file to embed and the repository it belongs to
+type ingestFile struct {
+	Path    string
+	Repo    RepoConfig
+	Handler fileHandler
+}
+
+// priorityNIPs are the NIPs asked about most often, embedded right after
+// NIP-01 when there are no usage statistics to go by yet
+var priorityNIPs = []string{"19", "10", "11", "65", "05", "02", "57", "44", "46", "07"}
+
+// checkpointSuffix names the copy of a first ingest's database that is
+// swapped in as a partial index while the ingest goes on
+const checkpointSuffix = ".checkpoint"
+
+// ingestCheckpointInterval is how often a first ingest publishes what it has
+// embedded so far, once the files most likely to be queried are done
+const ingestCheckpointInterval = 2 * time.Minute
+
+// emptyDatabaseMaxSize is the size above which a database is known to hold
+// an index without opening it
+const emptyDatabaseMaxSize = 1 << 20
+
+// collectRepoFiles lists the files of the types a repository includes, and
+// the files, symlinks, and vendored directories it leaves out
+func collectRepoFiles(repo RepoConfig) ([]ingestFile, []skippedFile, error) {
+	if err := checkExtensions(repo); err != nil {
+		return nil, nil, err
+	}
+	included := repoExtensions(repo)
+
+	root, err := cloneRoot(repo.CloneDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var files []ingestFile
+	var skipped []skippedFile
+	err = filepath.WalkDir(repo.CloneDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip .git directory
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if d.IsDir() && vendoredDirs[d.Name()] {
+			skipped = append(skipped, skippedFile{Path: repoRelativePath(repo, path) + "/", Reason: "vendored or generated directory"})
+			return filepath.SkipDir
+		}
+
+		handler, ok := fileHandlerFor(path, included)
+		if !ok || d.IsDir() {
+			return nil
+		}
+		// Symlinked directories are not walked, and symlinked files are only
+		// read when they are in the clone
+		if d.Type()&fs.ModeSymlink != 0 {
+			if reason := symlinkSkipReason(root, path); reason != "" {
+				skipped = append(skipped, skippedFile{Path: repoRelativePath(repo, path), Reason: reason})
+				return nil
+			}
+		}
+		if reason := skipReason(path); reason != "" {
+			skipped = append(skipped, skippedFile{Path: repoRelativePath(repo, path), Reason: reason})
+			return nil
+		}
+		files = append(files, ingestFile{Path: path, Repo: repo, Handler: handler})
+		return nil
+	})
+	return files, skipped, err
+}
+
+// prioritizeFiles orders files so the ones most likely to be queried are
+// embedded first: NIP-01, then the READMEs at the top of each repository,
+// then the sources queried most often according to the local usage
+// statistics and the commonly used NIPs, then everything else in walk
+// order. It returns how many files were moved to the front.
+func prioritizeFiles(files []ingestFile, hits map[string]uint64) int {
+	type priority struct {
+		tier int
+		hits uint64
+		nip  int
+	}
+	priorities := make(map[string]priority, len(files))
+	head := 0
+	for _, file := range files {
+		base := filepath.Base(file.Path)
+		nipsRepo := file.Repo.Role == roleNips || file.Repo.Name == "nips"
+		p := priority{
+			tier: 3,
+			hits: hits[fileSource(file.Repo.Name, file.Path)],
+			nip:  len(priorityNIPs),
+		}
+		if nipsRepo {
+			for i, nip := range priorityNIPs {
+				if base == nip+".md" {
+					p.nip = i
+				}
+			}
+		}
+		switch {
+		case nipsRepo && base == "01.md":
+			p.tier = 0
+		case strings.HasPrefix(strings.ToLower(base), "readme") && filepath.Dir(file.Path) == filepath.Clean(file.Repo.CloneDir):
+			p.tier = 1
+		case p.hits > 0 || p.nip < len(priorityNIPs):
+			p.tier = 2
+		}
+		if p.tier < 3 {
+			head++
+		}
+		priorities[file.Path] = p
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := priorities[files[i].Path], priorities[files[j].Path]
+		if a.tier != b.tier {
+			return a.tier < b.tier
+		}
+		if a.hits != b.hits {
+			return a.hits > b.hits
+		}
+		return a.nip < b.nip
+	})
+	return head
+}
+
+// readSourceHits returns how often each source appeared in query results,
+// from the local usage statistics. While a server holds them open, the counts
+// it wrote to sourceHitsPath when it started this ingest are used. It is empty
+// when there are none.
+func readSourceHits() map[string]uint64 {
+	hits := make(map[string]uint64)
+	if _, err := os.Stat(statsPath); err != nil {
+		return hits
+	}
+	db, err := openStatsForReading()
+	if err != nil {
+		data, readErr := os.ReadFile(sourceHitsPath)
+		if readErr == nil && json.Unmarshal(data, &hits) == nil {
+			return hits
+		}
+		fmt.Printf("Ordering files without usage statistics: %v\n", err)
+		return make(map[string]uint64)
+	}
+	defer db.Close()
+	db.View(func(tx *bbolt.Tx) error {
+		hits = readCounters(tx, statsSourcesBucket)
+		return nil
+	})
+	return hits
+}
+
+// servingIndexEmpty reports whether the database at path holds no index yet:
+// it is missing, or empty as a server creates it. Small databases are counted
+// on a copy, since a running server holds the database locked.
+func servingIndexEmpty(path string) bool {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return true
+	}
+	if err != nil || info.Size() > emptyDatabaseMaxSize {
+		return false
+	}
+
+	copyPath := path + checkpointSuffix
+	defer os.Remove(copyPath)
+	if err := copyFile(path, copyPath); err != nil {
+		return false
+	}
+	count, err := countStoredRecords(copyPath)
+	return err == nil && count == 0
+}
+
+// publishCheckpoint swaps a copy of the ingest database in as the serving
+// one, so a running server can already answer from the files embedded so
+// far. The store is closed while the database is copied; a checkpoint that
+// fails is skipped, but a store that cannot be reopened ends the ingest.
+func publishCheckpoint(store *vectorStore, tmpPath, path string) error {
+	closeStore(store)
+	checkpointPath := path + checkpointSuffix
+	err := copyFile(tmpPath, checkpointPath)
+	if err == nil {
+		err = swapInDatabase(checkpointPath, path)
+	}
+	if err != nil {
+		os.Remove(checkpointPath)
+		fmt.Printf("Warning: Error publishing partial index: %v\n", err)
+	} else {
+		fmt.Printf("Published a partial index with %d chunks to %s\n", embeddingCounter, path)
+	}
+	if err := initializeStore(store, tmpPath); err != nil {
+		return fmt.Errorf("error reopening ingest database: %v", err)
+	}
+	return nil
+}
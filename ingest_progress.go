@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// progressSuffix names the file next to the database where an ingest records
+// how far it got, so a server can report on an ingest running in another
+// process
+const progressSuffix = ".progress"
+
+// Stages of an ingest, in order
+const (
+	stagePreparing = "preparing"
+	stageCloning   = "cloning repositories"
+	stageEmbedding = "embedding files"
+	stageEvents    = "embedding ingested events"
+	stageSaving    = "saving the index"
+	stageDone      = "done"
+)
+
+// ingestProgress is how far an ingest got
+type ingestProgress struct {
+	Started    time.Time
+	Updated    time.Time
+	Finished   time.Time `json:",omitzero"`
+	Stage      string
+	Repo       string `json:",omitempty"` // Repository of the file being embedded
+	FilesDone  int
+	FilesTotal int
+	Chunks     int
+	Failed     bool `json:",omitempty"`
+}
+
+// currentIngest is the progress of the ingest run by this process
+var currentIngest ingestProgress
+
+// reportIngest updates the progress of the ingest run by this process and
+// records it next to the database. Progress is only informational, so a
+// failure to record it does not stop the ingest.
+func reportIngest(update func(progress *ingestProgress)) {
+	update(&currentIngest)
+	currentIngest.Updated = time.Now()
+
+	data, err := json.Marshal(currentIngest)
+	if err != nil {
+		return
+	}
+	// Written to a temporary file first so a server never reads half of it
+	path := dbPath + progressSuffix
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return
+	}
+	os.Rename(path+".tmp", path)
+}
+
+// finishIngest records the end of the ingest run by this process; an ingest
+// that did not reach stageDone failed at the stage it was in
+func finishIngest() {
+	reportIngest(func(progress *ingestProgress) {
+		progress.Finished = time.Now()
+		progress.Failed = progress.Stage != stageDone
+		progress.Repo = ""
+	})
+}
+
+// loadIngestProgress reads the progress of the last ingest, which may be
+// running in another process
+func loadIngestProgress(path string) (ingestProgress, bool) {
+	var progress ingestProgress
+	data, err := os.ReadFile(path + progressSuffix)
+	if err != nil {
+		return progress, false
+	}
+	if err := json.Unmarshal(data, &progress); err != nil {
+		return progress, false
+	}
+	return progress, true
+}
+
+// formatIngestProgress describes the last ingest, for the server status
+func formatIngestProgress() string {
+	progress, ok := loadIngestProgress(dbPath)
+	if !ok {
+		return "- No ingest recorded\n"
+	}
+
+	var b strings.Builder
+	switch {
+	case progress.Failed:
+		b.WriteString(fmt.Sprintf("- Failed while %s, %s ago; the index from before it is served\n", progress.Stage, time.Since(progress.Finished).Round(time.Second)))
+	case !progress.Finished.IsZero():
+		b.WriteString(fmt.Sprintf("- Finished %s ago after %s\n", time.Since(progress.Finished).Round(time.Second), progress.Finished.Sub(progress.Started).Round(time.Second)))
+	default:
+		b.WriteString(fmt.Sprintf("- Running for %s: %s", time.Since(progress.Started).Round(time.Second), progress.Stage))
+		if progress.Repo != "" {
+			b.WriteString(" from " + progress.Repo)
+		}
+		b.WriteString(fmt.Sprintf(", last update %s ago\n", time.Since(progress.Updated).Round(time.Second)))
+		b.WriteString("- Searches use the previous or partial index until it finishes\n")
+	}
+	if progress.FilesTotal > 0 {
+		b.WriteString(fmt.Sprintf("- Files: %d of %d (%d%%)\n", progress.FilesDone, progress.FilesTotal, progress.FilesDone*100/progress.FilesTotal))
+	}
+	b.WriteString(fmt.Sprintf("- Chunks embedded: %d\n", progress.Chunks))
+	return b.String()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+)
+
+// maxIngestFileSize is the size above which files are skipped rather than
+// chunked, in bytes (0 for no limit). Set with -max-file-size.
+var maxIngestFileSize int64 = 1 << 20
+
+// binarySniffSize is how much of a file is read to tell whether it is
+// binary, the same amount git looks at
+const binarySniffSize = 8000
+
+// maxSkippedShown caps the skipped files listed per repository while
+// ingesting and in the corpus manifest
+const maxSkippedShown = 20
+
+// vendoredDirs are directories of third-party or generated content, which
+// are not walked
+var vendoredDirs = map[string]bool{
+	"node_modules":     true,
+	"vendor":           true,
+	"third_party":      true,
+	"bower_components": true,
+}
+
+// skippedFile is a file or directory left out of the index and why
+type skippedFile struct {
+	Path   string // Path in the repository
+	Reason string
+}
+
+// skipReason returns why a file found by the walker should not be ingested,
+// or "" when it should be. The size of a symlinked file is its target's.
+func skipReason(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Sprintf("unreadable: %v", err)
+	}
+	if maxIngestFileSize > 0 && info.Size() > maxIngestFileSize {
+		return fmt.Sprintf("%s, larger than -max-file-size %s", formatBytes(info.Size()), formatBytes(maxIngestFileSize))
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Sprintf("unreadable: %v", err)
+	}
+	defer file.Close()
+	head := make([]byte, binarySniffSize)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return fmt.Sprintf("unreadable: %v", err)
+	}
+	return contentSkipReason(head[:n])
+}
+
+// contentSkipReason returns why the start of a file shows it is not text
+// that can be chunked: it contains a NUL byte, or is not valid UTF-8. A rune
+// cut off at the end of the sample does not count.
+func contentSkipReason(head []byte) string {
+	if bytes.IndexByte(head, 0) >= 0 {
+		return "binary content"
+	}
+	if len(head) == binarySniffSize {
+		start := len(head) - 1
+		for start > 0 && len(head)-start < utf8.UTFMax && !utf8.RuneStart(head[start]) {
+			start--
+		}
+		if !utf8.FullRune(head[start:]) {
+			head = head[:start]
+		}
+	}
+	if !utf8.Valid(head) {
+		return "not UTF-8 text"
+	}
+	return ""
+}
+
+// repoRelativePath returns a path in a clone relative to the clone
+func repoRelativePath(repo RepoConfig, path string) string {
+	if rel, err := filepath.Rel(repo.CloneDir, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
+
+// printSkippedFiles lists the files of a repository that were left out
+func printSkippedFiles(repoName string, skipped []skippedFile) {
+	if len(skipped) == 0 {
+		return
+	}
+	fmt.Printf("Skipped %d files in repository %s:\n", len(skipped), repoName)
+	for i, file := range skipped {
+		if i == maxSkippedShown {
+			fmt.Printf("  ... and %d more\n", len(skipped)-maxSkippedShown)
+			break
+		}
+		fmt.Printf("  %s: %s\n", file.Path, file.Reason)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// kindSampleSize is how many events of a kind are fetched from each relay to
+// see how it is used
+const kindSampleSize = 20
+
+// maxKindMentions caps the documents listed as mentioning a kind
+const maxKindMentions = 5
+
+// kindUsage is what the relays report about events of one kind
+type kindUsage struct {
+	Counts  map[string]int64 // NIP-45 COUNT per relay that answered
+	Sampled []*nostr.Event
+}
+
+// kindMentions returns the indexed documents whose chunks mention an event
+// kind, such as specs outside the NIPs repository that are not in its tables
+func kindMentions(ctx context.Context, index *servingIndex, kind int) ([]string, error) {
+	records, err := index.reader.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var sources []string
+	for _, record := range records {
+		if !tenantCanRead(ctx, record.Id) {
+			continue
+		}
+		source := chunkSource(record.Id)
+		if seen[source] {
+			continue
+		}
+		for _, mentioned := range extractChunkMeta(record).Kinds {
+			if mentioned == kind {
+				seen[source] = true
+				sources = append(sources, source)
+				break
+			}
+		}
+	}
+	sort.Strings(sources)
+	return sources, nil
+}
+
+// sampleKindUsage asks relays how many events of a kind they hold and fetches
+// a sample of them. Relays that do not support COUNT are only sampled.
+func sampleKindUsage(ctx context.Context, relays []string, kind int) kindUsage {
+	filters := []nostr.Filter{{Kinds: []int{kind}}}
+	usage := kindUsage{Counts: make(map[string]int64)}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, url := range relays {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			if count, ok := countEvents(ctx, url, filters); ok {
+				mutex.Lock()
+				usage.Counts[url] = count
+				mutex.Unlock()
+			}
+		}(url)
+	}
+	wg.Wait()
+
+	sample := []nostr.Filter{{Kinds: []int{kind}, Limit: kindSampleSize}}
+	usage.Sampled = fetchFromRelays(ctx, relays, sample, relayDeadline, kindSampleSize, func(ev *nostr.Event) bool {
+		return ev.Kind == kind
+	})
+	return usage
+}
+
+// formatKindUsage describes the events of a kind seen on relays
+func formatKindUsage(usage kindUsage) string {
+	var b strings.Builder
+	urls := make([]string, 0, len(usage.Counts))
+	for url := range usage.Counts {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	for _, url := range urls {
+		b.WriteString(fmt.Sprintf("- %s: %d events (COUNT)\n", url, usage.Counts[url]))
+	}
+
+	if len(usage.Sampled) == 0 {
+		b.WriteString("No events of this kind were returned by the relays.\n")
+		return b.String()
+	}
+
+	authors := make(map[string]bool)
+	tagCounts := make(map[string]int)
+	var newest nostr.Timestamp
+	for _, ev := range usage.Sampled {
+		authors[ev.PubKey] = true
+		if ev.CreatedAt > newest {
+			newest = ev.CreatedAt
+		}
+		names := make(map[string]bool)
+		for _, tag := range ev.Tags {
+			if len(tag) > 0 && !names[tag[0]] {
+				names[tag[0]] = true
+				tagCounts[tag[0]]++
+			}
+		}
+	}
+	b.WriteString(fmt.Sprintf("Sampled %d events from %d authors; the newest is from %s.\n",
+		len(usage.Sampled), len(authors), newest.Time().UTC().Format(time.DateOnly)))
+
+	if len(tagCounts) > 0 {
+		tags := make([]string, 0, len(tagCounts))
+		for name := range tagCounts {
+			tags = append(tags, name)
+		}
+		sort.Slice(tags, func(i, j int) bool {
+			if tagCounts[tags[i]] != tagCounts[tags[j]] {
+				return tagCounts[tags[i]] > tagCounts[tags[j]]
+			}
+			return tags[i] < tags[j]
+		})
+		if len(tags) > 10 {
+			tags = tags[:10]
+		}
+		for i, name := range tags {
+			tags[i] = fmt.Sprintf("`%s` (%d)", name, tagCounts[name])
+		}
+		b.WriteString(fmt.Sprintf("Tags used: %s\n", strings.Join(tags, ", ")))
+	}
+	return b.String()
+}
+
+func checkKindAvailableHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	number, ok := request.Params.Arguments["kind"].(float64)
+	if !ok || number < 0 || number > 65535 || number != float64(int(number)) {
+		return nil, errors.New("kind must be an integer between 0 and 65535")
+	}
+	kind := int(number)
+
+	checkRelays := true
+	if check, ok := request.Params.Arguments["check_relays"].(bool); ok {
+		checkRelays = check
+	}
+
+	// The registry and the mentions come from the same index
+	index, release := acquireIndex()
+	defer release()
+	registry := index.registry
+	var registered []registryKind
+	if registry != nil {
+		registered = registeredKinds(registry, kind)
+	}
+	mentions, err := kindMentions(ctx, index, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	var usage kindUsage
+	relayNote := ""
+	switch {
+	case !checkRelays:
+		relayNote = "Relays were not checked.\n"
+	case relaysUnavailable():
+		relayNote = offlineNotice("Checking relays for the kind") + "\n"
+	default:
+		usage = sampleKindUsage(ctx, rankRelays(searchRelays), kind)
+	}
+
+	var b strings.Builder
+	inUse := len(usage.Sampled) > 0
+	for _, count := range usage.Counts {
+		inUse = inUse || count > 0
+	}
+	switch {
+	case len(registered) > 0:
+		if from, to, _ := kindRange(registered[0].Kind); from == to {
+			b.WriteString(fmt.Sprintf("Kind %d is taken.\n", kind))
+		} else {
+			b.WriteString(fmt.Sprintf("Kind %d falls in the registered range %s.\n", kind, registered[0].Kind))
+		}
+	case len(mentions) > 0 || inUse:
+		b.WriteString(fmt.Sprintf("Kind %d is not registered, but indexed documents mention it or relays hold events of it; see below.\n", kind))
+	default:
+		b.WriteString(fmt.Sprintf("Kind %d looks available.\n", kind))
+	}
+	b.WriteString(fmt.Sprintf("It is a %s kind.\n", kindClass(kind)))
+
+	b.WriteString("\n## NIPs registry\n")
+	switch {
+	case registry == nil:
+		b.WriteString("No kind registry in the index; enable the NIPs repository and re-ingest with -ingest.\n")
+	case len(registered) == 0:
+		b.WriteString("Not listed in the event kinds table of the NIPs README.\n")
+	default:
+		for _, entry := range registered {
+			b.WriteString(fmt.Sprintf("- `%s`: %s (%s)\n", entry.Kind, entry.Description, formatNipRefs(entry.NIPs, entry.Reference)))
+		}
+	}
+
+	b.WriteString("\n## Indexed documents\n")
+	if len(mentions) == 0 {
+		b.WriteString("No indexed document mentions this kind.\n")
+	}
+	for i, source := range mentions {
+		if i == maxKindMentions {
+			b.WriteString(fmt.Sprintf("- and %d more\n", len(mentions)-maxKindMentions))
+			break
+		}
+		b.WriteString(fmt.Sprintf("- %s\n", source))
+	}
+
+	b.WriteString("\n## Relays\n")
+	if relayNote != "" {
+		b.WriteString(relayNote)
+	} else {
+		b.WriteString(formatKindUsage(usage))
+	}
+
+	return mcp.NewToolResultText(b.String()), nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// scriptLanguages maps Unicode scripts to the language most likely written in them
+var scriptLanguages = []struct {
+	Table    *unicode.RangeTable
+	Language string
+}{
+	{unicode.Hiragana, "Japanese"},
+	{unicode.Katakana, "Japanese"},
+	{unicode.Hangul, "Korean"},
+	{unicode.Han, "Chinese"},
+	{unicode.Cyrillic, "Russian"},
+	{unicode.Arabic, "Arabic"},
+	{unicode.Hebrew, "Hebrew"},
+	{unicode.Devanagari, "Hindi"},
+	{unicode.Thai, "Thai"},
+	{unicode.Greek, "Greek"},
+}
+
+// stopwords holds common function words for Latin-script languages. Ties are
+// resolved in list order, so English comes first.
+var stopwords = []struct {
+	Language string
+	Words    []string
+}{
+	{"English", []string{"the", "is", "how", "what", "and", "does", "of", "to", "in", "can", "which", "why"}},
+	{"Spanish", []string{"el", "la", "los", "las", "es", "cómo", "como", "qué", "que", "de", "y", "para", "una", "por", "cuál"}},
+	{"Portuguese", []string{"o", "os", "as", "é", "como", "que", "de", "e", "para", "uma", "não", "do", "da", "qual"}},
+	{"French", []string{"le", "la", "les", "est", "comment", "quoi", "que", "de", "et", "pour", "une", "des", "du", "quel"}},
+	{"German", []string{"der", "die", "das", "ist", "wie", "was", "und", "zu", "ein", "eine", "für", "nicht", "welche"}},
+	{"Italian", []string{"il", "lo", "la", "gli", "è", "come", "che", "di", "e", "per", "una", "del", "quale"}},
+}
+
+// detectLanguage makes a best-effort guess at the natural language of a query,
+// defaulting to English when there is too little signal
+func detectLanguage(text string) string {
+	scriptCounts := make(map[string]int)
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		for _, script := range scriptLanguages {
+			if unicode.Is(script.Table, r) {
+				scriptCounts[script.Language]++
+				break
+			}
+		}
+	}
+
+	// Japanese text mixes kana with Han characters, so any kana wins over Chinese
+	if scriptCounts["Japanese"] > 0 {
+		return "Japanese"
+	}
+	best, bestCount := "", 0
+	for _, script := range scriptLanguages {
+		if count := scriptCounts[script.Language]; count > bestCount {
+			best, bestCount = script.Language, count
+		}
+	}
+	if letters > 0 && bestCount*2 >= letters {
+		return best
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	best, bestCount = "English", 0
+	for _, list := range stopwords {
+		score := 0
+		for _, word := range words {
+			for _, stopword := range list.Words {
+				if word == stopword {
+					score++
+				}
+			}
+		}
+		if score > bestCount {
+			best, bestCount = list.Language, score
+		}
+	}
+	return best
+}
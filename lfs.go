@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// Git LFS handling modes for RepoConfig.LFS
+const (
+	lfsSkip  = "skip"
+	lfsFetch = "fetch"
+)
+
+// lfsPointerPrefix starts every Git LFS pointer file
+var lfsPointerPrefix = []byte("version https://git-lfs.github.com/spec/")
+
+// isLFSPointer reports whether file content is a Git LFS pointer rather than
+// the real file
+func isLFSPointer(content []byte) bool {
+	return len(content) < 1024 && bytes.HasPrefix(content, lfsPointerPrefix)
+}
+
+// submoduleDepth returns the submodule recursion depth for a repository
+func submoduleDepth(repo RepoConfig) git.SubmoduleRescursivity {
+	if repo.Submodules {
+		return git.DefaultSubmoduleRecursionDepth
+	}
+	return git.NoRecurseSubmodules
+}
+
+// fetchLFSObjects downloads the Git LFS objects of a clone when the repo is
+// configured to fetch them. go-git has no LFS support, so this runs the git
+// lfs command line tool.
+func fetchLFSObjects(repo RepoConfig) error {
+	switch strings.ToLower(repo.LFS) {
+	case "", lfsSkip:
+		return nil
+	case lfsFetch:
+	default:
+		return fmt.Errorf("unknown LFS mode %q (expected %q or %q)", repo.LFS, lfsSkip, lfsFetch)
+	}
+
+	if _, err := exec.LookPath("git-lfs"); err != nil {
+		return fmt.Errorf("LFS is set to fetch but git-lfs is not installed")
+	}
+
+	cmd := exec.Command("git", "lfs", "pull")
+	cmd.Dir = repo.CloneDir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("error fetching LFS objects: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	if repo.Submodules {
+		cmd = exec.Command("git", "submodule", "foreach", "--recursive", "git lfs pull")
+		cmd.Dir = repo.CloneDir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("error fetching submodule LFS objects: %v: %s", err, strings.TrimSpace(string(output)))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// llmConfigFile is the default path of the generation settings file
+const llmConfigFile = "llm.json"
+
+// LLMConfig holds the settings used by every generation-backed tool
+type LLMConfig struct {
+	Model        string  // Ollama chat model, e.g. "llama3.2", "qwen2.5:7b" or "mistral"
+	Temperature  float64 // Sampling temperature; lower values stay closer to the sources
+	MaxTokens    int     `json:",omitempty"` // Maximum number of tokens to generate (0 for the model default)
+	SystemPrompt string  `json:",omitempty"` // Replaces the default answer instructions
+
+	PromptTemplate string `json:",omitempty"` // Path to a text/template file that renders the question and context
+	CitationFormat string `json:",omitempty"` // fmt format for citing a chunk ID in templates, e.g. "[%s]" or "(source: %s)"
+
+	RerankModel    string `json:",omitempty"` // Ollama chat model that reranks retrieved chunks with -rerank (default: Model)
+	ExpansionModel string `json:",omitempty"` // Ollama chat model that rephrases queries with -expand-query (default: Model)
+
+	MinConfidence  float64 `json:",omitempty"` // Refuse to answer when the best retrieved chunk scores below this (0 to always answer)
+	SuggestRelated bool    // List the closest documents when refusing
+}
+
+// llmConfig holds the active generation settings
+var llmConfig = LLMConfig{
+	Model:          defaultChatModel,
+	Temperature:    0.2,
+	SuggestRelated: true,
+}
+
+// loadLLMConfig reads generation settings from a file. Settings missing from
+// the file keep their defaults. A missing default file is not an error.
+func loadLLMConfig(customConfigFile string) {
+	cfgFile := llmConfigFile
+	if customConfigFile != "" {
+		cfgFile = customConfigFile
+	}
+
+	file, err := os.ReadFile(cfgFile)
+	if os.IsNotExist(err) && cfgFile == llmConfigFile {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error reading LLM config file: %v\n", err)
+		os.Exit(1)
+	}
+
+	if problems := decodeConfigObject(file, &llmConfig); len(problems) > 0 {
+		printConfigProblems("LLM config file", cfgFile, problems)
+		os.Exit(1)
+	}
+	if llmConfig.Model == "" {
+		llmConfig.Model = defaultChatModel
+	}
+
+	if llmConfig.PromptTemplate != "" {
+		promptTemplate, err = loadPromptTemplate(llmConfig.PromptTemplate)
+		if err != nil {
+			fmt.Printf("Error loading LLM config file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// eventsFile is a JSONL file of events used instead of relays when set with -events-file
+var eventsFile string
+
+// maxEventLineSize bounds a single line of an events file
+const maxEventLineSize = 16 * 1024 * 1024
+
+// useLocalRelay points every relay list at a single local relay
+func useLocalRelay(url string) {
+	cacheRelays = []string{url}
+	searchRelays = []string{url}
+	queryRelays = []string{url}
+}
+
+// useEventsFile serves events from a JSONL export instead of relays. The
+// relay lists are cleared so no public relay is ever contacted.
+func useEventsFile(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	eventsFile = path
+	cacheRelays = nil
+	searchRelays = nil
+	queryRelays = nil
+	return nil
+}
+
+// loadEventsFile reads events matching the filters from a JSONL file with one
+// event per line, as produced by relay or nostrdb exports. Lines that are
+// relay messages such as ["EVENT", "sub", {...}] are also accepted.
+func loadEventsFile(path string, filters []nostr.Filter) ([]*nostr.Event, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []*nostr.Event
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxEventLineSize)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		ev, err := parseEventLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNumber, err)
+		}
+		if ev == nil || seen[ev.ID] {
+			continue
+		}
+
+		for _, filter := range filters {
+			if filter.Matches(ev) {
+				seen[ev.ID] = true
+				events = append(events, ev)
+				break
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return events, nil
+}
+
+// parseEventLine decodes a single event, unwrapping relay EVENT messages.
+// Other relay messages yield a nil event.
+func parseEventLine(line string) (*nostr.Event, error) {
+	if strings.HasPrefix(line, "[") {
+		var message []json.RawMessage
+		if err := json.Unmarshal([]byte(line), &message); err != nil {
+			return nil, err
+		}
+		var label string
+		if len(message) < 2 || json.Unmarshal(message[0], &label) != nil || label != "EVENT" {
+			return nil, nil
+		}
+		line = string(message[len(message)-1])
+	}
+
+	var ev nostr.Event
+	if err := json.Unmarshal([]byte(line), &ev); err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+// lowPowerProfile holds the reduced defaults applied by -low-power, for
+// running next to a relay on a Raspberry Pi or a small home server
+var lowPowerProfile = struct {
+	CloneWorkers      int
+	MaxRelays         int
+	SnippetFetchLimit int
+	MaxCachedSnippets int
+	MaxMemoryVectors  int
+}{
+	CloneWorkers:      1,
+	MaxRelays:         2,
+	SnippetFetchLimit: 100,
+	MaxCachedSnippets: 1000,
+	MaxMemoryVectors:  10000,
+}
+
+// applyLowPowerProfile lowers worker counts, fetch sizes, relay counts and
+// cache sizes. Settings given explicitly on the command line are kept.
+func applyLowPowerProfile() {
+	if !isFlagSet("clone-workers") {
+		cloneWorkers = lowPowerProfile.CloneWorkers
+	}
+	if !isFlagSet("max-relays") {
+		maxRelays = lowPowerProfile.MaxRelays
+	}
+	snippetFetchLimit = lowPowerProfile.SnippetFetchLimit
+	if !isFlagSet("max-cached-snippets") {
+		maxCachedSnippets = lowPowerProfile.MaxCachedSnippets
+	}
+	if !isFlagSet("max-memory-vectors") {
+		maxMemoryVectors = lowPowerProfile.MaxMemoryVectors
+	}
+}
@@ -1,64 +1,248 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"time"
 
-	"github.com/go-git/go-git/v5"
 	"github.com/parakeet-nest/parakeet/content"
-	"github.com/parakeet-nest/parakeet/embeddings"
-	"github.com/parakeet-nest/parakeet/llm"
 )
 
 const (
 	dataDir        = "./data"
 	dbPath         = "./embeddings.db"
-	ollamaURL      = "http://localhost:11434"
 	embeddingModel = "nomic-embed-text"
 )
 
+// ollamaURL is the Ollama server used for embeddings and generation; it can
+// be overridden with the BHN_OLLAMA_URL environment variable
+var ollamaURL = "http://localhost:11434"
+
 // RepoConfig holds configuration for a repository to be included in the RAG system
 type RepoConfig struct {
-	URL      string // Repository URL
-	Name     string // Repository name (used for directory naming)
-	CloneDir string // Directory where the repo will be cloned
-	Enabled  bool   // Whether this repo is enabled
+	URL        string   // Repository URL
+	Name       string   // Repository name (used for directory naming)
+	CloneDir   string   // Directory where the repo will be cloned
+	Enabled    bool     // Whether this repo is enabled
+	Role       string   `json:",omitempty"` // Special role of the repo, e.g. "nips" for the NIPs specification repository
+	Collection string   `json:",omitempty"` // Collection used for query routing, e.g. "specs", "code", "wiki" or "articles"
+	Mirrors    []string `json:",omitempty"` // Alternative URLs tried in order when cloning or pulling from URL fails
+	Submodules bool     `json:",omitempty"` // Whether to clone and update the repo's submodules
+	LFS        string   `json:",omitempty"` // Git LFS handling: "skip" (default) leaves pointer files unindexed, "fetch" downloads the objects
+	Branch     string   `json:",omitempty"` // Branch to clone and follow instead of the remote's default branch
+	Tag        string   `json:",omitempty"` // Tag to pin the clone to; it is checked out instead of pulled
+	Commit     string   `json:",omitempty"` // Commit to pin the clone to, instead of a Tag
+
+	PullBeforeIngest bool      `json:",omitempty"` // Whether to pull the clone before every ingest, even without -update-repos
+	Auth             *RepoAuth `json:",omitempty"` // Credentials for a private repository
+	Depth            int       `json:",omitempty"` // Number of commits of history to clone, e.g. 1 for a shallow clone (default: all)
+	SparsePaths      []string  `json:",omitempty"` // Directories to check out, e.g. ["docs"]; other files stay out of the worktree and the index
+	Extensions       []string  `json:",omitempty"` // File types to ingest, e.g. [".md", ".go"] (default: markdown, AsciiDoc, reStructuredText, and text files)
+	Trust            string    `json:",omitempty"` // Trust tier of the repository's chunks: spec, project, wiki, article, or snippet (default: by collection)
+	ChunkStrategy    string    `json:",omitempty"` // How files are split: semantic, fixed, sentence, or recursive (default: -chunk-strategy)
+	ChunkSize        int       `json:",omitempty"` // Characters per chunk for the fixed, sentence, and recursive strategies (default: -chunk-size)
+	ChunkOverlap     *int      `json:",omitempty"` // Characters repeated between consecutive chunks of those strategies (default: -chunk-overlap)
+	ContextOverlap   string    `json:",omitempty"` // End of the previous chunk embedded with each chunk, e.g. "2 sentences", "300 chars", "64 tokens", or "none" (default: -context-overlap)
 }
 
+// roleNips marks the repository that holds the NIP specifications
+const roleNips = "nips"
+
 // configFile is the path to the repository configuration file
 const configFile = "repos.json"
 
 // repos holds the repositories that are configured in the system
 var repos []RepoConfig
 
-// Global counter for generating unique IDs
+// reposConfigFile is the repository configuration file that was loaded
+var reposConfigFile = configFile
+
+// embeddingCounter counts the chunks stored by this ingest
 var embeddingCounter int = 0
 
 func main() {
 	// Define command-line flags
 	queryMode := flag.Bool("query", false, "Run in query mode")
-	queryText := flag.String("text", "", "The query text when in query mode")
+	askMode := flag.Bool("ask", false, "Generate an answer to the -text question from the retrieved documentation")
+	answerLanguage := flag.String("language", "", "The language to answer in with -ask (default: the language of the question)")
+	queryText := flag.String("text", "", "The query text when in query or ask mode")
 	similarity := flag.Float64("similarity", 0.6, "The similarity threshold for retrieving documents")
 	numResults := flag.Int("results", 3, "The number of similar documents to retrieve")
+	minScore := flag.Float64("min-score", 0, "Minimum similarity score for results; overrides -similarity when set")
+	maxPerFile := flag.Int("max-per-file", defaultMaxPerFile, "The maximum number of results from a single source file (0 for no limit)")
+	collections := flag.String("collections", "auto", "Comma-separated collections to search, 'auto' to route by query, or 'all'")
+	minTier := flag.String("min-tier", "", "Only return chunks at least this authoritative: spec, project, wiki, article, or snippet")
+	repoFilter := flag.String("repo", "", repoFilterDescription)
+	nipFilter := flag.String("nip", "", nipFilterDescription)
+	pathFilter := flag.String("path", "", pathFilterDescription)
+	sectionFilter := flag.String("section", "", sectionFilterDescription)
+	maxChars := flag.Int("max-chars", 0, "Character budget for the returned context (0 for no limit)")
+	debugQuery := flag.Bool("debug", false, "Explain why each candidate was included in or excluded from the query results")
+	debugQueryMode := flag.Bool("debug-query", false, "Instead of the -text query's results, print every retrieval step: the parsed filters, the embedded prompt, routing, candidate scores, and why each was kept or dropped")
+	staleAfterDaysFlag := flag.Int("stale-after-days", staleAfterDays, "Add a staleness note to query results from repositories not fetched from upstream in this many days (0 to disable)")
+	getChunkID := flag.String("get-chunk", "", "Print a stored chunk by the ID shown in query results")
+	neighbors := flag.Int("neighbors", 1, "The number of neighboring chunks to include on each side with -get-chunk")
+	embedder := flag.String("embedder", "", "Embedder to ingest with: ollama, hash (built in, no model), or static (word vectors from -embedder-model); queries always use the one stored in the database")
+	embedderModel := flag.String("embedder-model", "", "Word vector file for the static embedder, one 'word v1 v2 ...' line per word")
+	metric := flag.String("metric", "", "Similarity metric to ingest with: cosine, dot, or euclidean (default: the metric stored in the database, or cosine)")
 	_ = flag.Bool("mcp", true, "Run as an MCP server (default)")
+	httpAddrFlag := flag.String("http", "", "Serve MCP over HTTP/SSE on this address (e.g. :8080) instead of stdio")
+	httpBaseURLFlag := flag.String("http-base-url", "", "Public URL of the HTTP server, if it differs from http://localhost:<port>")
+	webUI := flag.Bool("web-ui", false, "With -http, also serve a web UI for searching, managing repositories, and following ingestion at the server's root")
+	adminFlag := flag.Bool("admin", false, "Also offer the admin tools that change the repository configuration or rebuild the index")
+	tenantsConfig := flag.String("tenants", "", "Path to a JSON file with the API keys, rate limits, and collections of HTTP tenants (default: tenants.json if present)")
 	ingestMode := flag.Bool("ingest", false, "Ingest data into the RAG database")
 	cloneRepos := flag.Bool("clone-repos", false, "Clone all enabled repositories into the data directory")
+	updateRepos := flag.Bool("update-repos", false, "Pull the existing clones of all enabled repositories and report which received new commits; with -ingest, pull them before ingesting")
+	cloneWorkersFlag := flag.Int("clone-workers", cloneWorkers, "The number of repositories to clone at the same time")
+	chunkStrategyFlag := flag.String("chunk-strategy", defaultChunkStrategy, "How files are split into chunks for repositories that do not set ChunkStrategy: semantic (sections and declarations), fixed, sentence, or recursive")
+	chunkSizeFlag := flag.Int("chunk-size", defaultChunkSize, "Characters per chunk for the fixed, sentence, and recursive strategies")
+	chunkOverlapFlag := flag.Int("chunk-overlap", defaultChunkOverlap, "Characters repeated between consecutive chunks of the fixed, sentence, and recursive strategies")
+	contextOverlapFlag := flag.String("context-overlap", defaultContextOverlap.String(), "End of the previous section embedded with each semantic chunk: e.g. '2 sentences', '300 chars', '64 tokens', or 'none'")
+
+	snippetKindsFlag := flag.String("snippet-kinds", "1337,1617,30023", "Comma-separated event kinds searched for code snippets")
+	localRelay := flag.String("local-relay", "", "Use this relay URL instead of public relays for snippets and articles")
+	eventsFileFlag := flag.String("events-file", "", "Read snippets and articles from a JSONL events export instead of relays (fully offline)")
+	exportSnippets := flag.String("export-snippets", "", "Refresh the code snippet cache from the relays and write it to this JSONL file")
+	importSnippets := flag.String("import-snippets", "", "Load the code snippet cache from a file written by -export-snippets, so the server starts with warm snippets")
+	maxCachedSnippetsFlag := flag.Int("max-cached-snippets", maxCachedSnippets, "The maximum number of code snippet events kept in memory; the newest are kept (0 for no limit)")
+	maxMemoryVectorsFlag := flag.Int("max-memory-vectors", maxMemoryVectors, "Search the index from disk instead of memory when it holds more vectors than this (0 for no limit)")
+	maxRelaysFlag := flag.Int("max-relays", maxRelays, "Query at most this many of the healthiest relays at once (0 for all)")
+	maxRelayConnectionsFlag := flag.Int("relay-connections", maxRelayConnections, "Open at most this many connections to any one relay at once (0 for no limit)")
+	timeoutsConfig := flag.String("timeouts-config", "", "Path to a JSON file with relay, HTTP, embedding, and database timeouts, e.g. {\"RelayFetch\": \"30s\"} (default: timeouts.json if present)")
+	bootstrapMode := flag.Bool("bootstrap", false, "Before serving, clone and ingest the configured repositories if the database is empty (also enabled by BHN_BOOTSTRAP=1)")
+	ingestInBackgroundMode := flag.Bool("ingest-in-background", false, "Serve the existing index at once and clone and ingest the configured repositories in the background meanwhile; with -bootstrap, only if the database is empty or its last ingest was interrupted (also enabled by BHN_INGEST_IN_BACKGROUND=1)")
+	lowPower := flag.Bool("low-power", false, "Use fewer workers, relays, and smaller fetches and caches, for a Raspberry Pi or small home server")
+	offline := flag.Bool("offline", false, "Disable all network access except to this machine; only the local database, Ollama, and local event sources are used")
+	allowHosts := flag.String("allow-hosts", "", "Comma-separated hosts the server may contact (e.g. 'github.com,*.damus.io'); all others are refused")
+	denyHosts := flag.String("deny-hosts", "", "Comma-separated hosts the server never contacts")
+	hostRateLimit := flag.String("host-rate-limit", "", "HTTP requests per minute to any one host, optionally with per-host overrides (e.g. '30,example.com=5')")
+	ingestEvents := flag.Bool("ingest-events", false, "Fetch the events matching -filter from the relays and embed them into the database")
+	eventFilter := flag.String("filter", "", "Nostr filter as JSON for -ingest-events, e.g. '{\"kinds\":[30023],\"authors\":[\"<hex pubkey>\"]}'")
+	eventsDaemon := flag.Bool("events-daemon", false, "Keep subscriptions open for the feeds in -feeds-config and embed new events as they are published")
+	feedsConfig := flag.String("feeds-config", "", "Path to a JSON file with the feeds followed by -events-daemon (default: event-feeds.json)")
+	allowAuthors := flag.String("allow-authors", "", "Comma-separated npubs or hex public keys; only their events are embedded from relays")
+	denyAuthors := flag.String("deny-authors", "", "Comma-separated npubs or hex public keys whose events are never embedded from relays")
+	archiveFile := flag.String("archive", "", "Append every event fetched from relays to this JSONL file, skipping duplicates")
 
 	// Repository configuration flags
 	customConfigFile := flag.String("repos-config", "", "Path to a custom JSON file containing repository configurations")
-	addRepo := flag.String("add-repo", "", "Add a repository in format 'url,name' (e.g., 'https://github.com/example/repo,example')")
+	addRepo := flag.String("add-repo", "", "Add a repository in format 'url,name' or 'url,name,role' (e.g., 'https://github.com/example/repo,example')")
 	listRepos := flag.Bool("list-repos", false, "List all configured repositories")
+	removeRepo := flag.String("remove-repo", "", "Remove the named repository from the configuration and its chunks from the database")
+	deleteClone := flag.Bool("delete-clone", false, "With -remove-repo, also delete the repository's clone")
+	enableRepo := flag.String("enable-repo", "", "Enable the named repository; it is indexed by the next -ingest")
+	disableRepo := flag.String("disable-repo", "", "Disable the named repository and remove its chunks from the database")
+	initRepos := flag.Bool("init", false, "Create the repository configuration from a curated preset (see -preset)")
+	preset := flag.String("preset", defaultPreset, "The preset used by -init: nostr-dev (NIPs, DVM kinds, Blossom BUDs, nostrbook wiki) or minimal (NIPs only)")
+
+	// Secrets flags
+	signerKey := flag.String("signer-key", signerKeyRef, "Where the private key for signing events is stored: env:NAME, keychain:service/account, file:path, or an ncryptsec key")
+	showSigner := flag.Bool("show-signer", false, "Show the public key of the configured signer key")
+	importKey := flag.Bool("import-key", false, "Import an ncryptsec (NIP-49) encrypted key as the signer key, asking for it and its passphrase")
+	exportKey := flag.Bool("export-key", false, "Print the signer key encrypted (NIP-49) with a new passphrase")
+	relayAuth := flag.Bool("relay-auth", false, "Authenticate (NIP-42) with the signer key to relays that require it before answering queries")
+
+	// Generation settings
+	aliasesPath := flag.String("aliases", "", "Path to a JSON file mapping query slang to the terms the specs use, e.g. {\"zap\": [\"NIP-57\"]} (default: aliases.json if present)")
+	llmConfigPath := flag.String("llm-config", "", "Path to a JSON file with the chat model and generation settings (default: llm.json if present)")
+
+	// Database maintenance flags
+	dbVerify := flag.Bool("db-verify", false, "Check the embeddings database for corrupt or inconsistent records")
+	dbRepair := flag.Bool("db-repair", false, "Check the embeddings database and drop corrupt records")
+	dbCompact := flag.Bool("db-compact", false, "Rewrite the embeddings database to reclaim free space")
+
+	// Data directory flags
+	diskUsageMode := flag.Bool("disk-usage", false, "Show the disk usage of the clones and the database")
+	cleanMode := flag.Bool("clean", false, "Remove clones of disabled or removed repositories and leftover temporary files")
+	maxFileSizeFlag := flag.String("max-file-size", "1MB", "Files larger than this, e.g. 1MB, are skipped when ingesting (0 for no limit)")
+	dataQuotaFlag := flag.String("data-quota", "", "Maximum combined size of the clones and the database, e.g. 5GB; cloning stops when it is exceeded")
+
+	// Usage statistics flags
+	showStats := flag.Bool("stats", false, "Show local usage statistics: queries per day, most-hit sources, and zero-result queries")
+	noStats := flag.Bool("no-stats", false, "Do not record local usage statistics")
+	retrievalModeFlag := flag.String("retrieval-mode", modeVector, "How queries score chunks unless they ask otherwise: vector (embedding similarity) or hybrid (similarity plus BM25 keyword scores)")
+	vectorWeightFlag := flag.Float64("vector-weight", vectorWeight, "Weight of the embedding similarity in hybrid retrieval")
+	lexicalWeightFlag := flag.Float64("lexical-weight", lexicalWeight, "Weight of the keyword score, from 0 to 1 for the best keyword match, in hybrid retrieval")
+	rerankFlag := flag.Bool("rerank", false, "Let the chat model reorder the best candidates of each query before the results are chosen (slower)")
+	rerankTopFlag := flag.Int("rerank-top", rerankTopN, "How many of the best candidates the chat model reranks with -rerank")
+	expandQueryFlag := flag.Bool("expand-query", false, "Let the chat model rephrase each query into variants that are searched along with it (slower)")
+	expandVariantsFlag := flag.Int("expand-variants", expansionVariants, "How many variants of a query, 1 to 5, the chat model writes with -expand-query")
+	mmrLambdaFlag := flag.Float64("mmr-lambda", mmrLambda, "Relevance weight, above 0 and at most 1, for choosing diverse results with Maximal Marginal Relevance (1 keeps the similarity order)")
+	feedbackWeightFlag := flag.Float64("feedback-weight", feedbackWeight, "The most that result ratings from rate_result can raise or lower a chunk's score (0 to ignore ratings)")
+	coverageReport := flag.String("coverage-report", "", "Print every NIP file with its chunk count, ingested commit, and query hits as a markdown or csv table")
+	gapReport := flag.Bool("gap-report", false, "Group zero-result queries by topic to show what the corpus is missing")
 
 	// Parse flags
 	flag.Parse()
 
+	// Keep private keys and tokens out of the logs
+	log.SetOutput(redactingWriter{os.Stderr})
+
+	// Load the timeouts before anything contacts the network
+	loadTimeouts(*timeoutsConfig)
+
+	statsEnabled = !*noStats
+	feedbackWeight = *feedbackWeightFlag
+	mode, err := parseRetrievalMode(*retrievalModeFlag)
+	if err != nil {
+		log.Fatalf("Error parsing -retrieval-mode: %v", err)
+	}
+	retrievalMode = mode
+	vectorWeight = *vectorWeightFlag
+	rerankEnabled = *rerankFlag
+	rerankTopN = *rerankTopFlag
+	expansionEnabled = *expandQueryFlag
+	expansionVariants = clampExpansionVariants(*expandVariantsFlag)
+	if mmrLambda, err = parseMMRLambda(*mmrLambdaFlag); err != nil {
+		log.Fatalf("Error parsing -mmr-lambda: %v", err)
+	}
+	lexicalWeight = *lexicalWeightFlag
+	cloneWorkers = *cloneWorkersFlag
+	defaultChunkStrategy = strings.ToLower(strings.TrimSpace(*chunkStrategyFlag))
+	defaultChunkSize = *chunkSizeFlag
+	defaultChunkOverlap = *chunkOverlapFlag
+	if err := checkChunking(defaultChunkStrategy, defaultChunkSize, defaultChunkOverlap); err != nil {
+		log.Fatalf("Error parsing the chunking flags: %v", err)
+	}
+	overlap, err := parseContextOverlap(*contextOverlapFlag)
+	if err != nil {
+		log.Fatalf("Error parsing -context-overlap: %v", err)
+	}
+	defaultContextOverlap = overlap
+	maxCachedSnippets = *maxCachedSnippetsFlag
+	if maxIngestFileSize, err = parseSize(*maxFileSizeFlag); err != nil {
+		log.Fatalf("Error parsing -max-file-size: %v", err)
+	}
+	maxMemoryVectors = *maxMemoryVectorsFlag
+	staleAfterDays = *staleAfterDaysFlag
+	maxRelays = *maxRelaysFlag
+	maxRelayConnections = *maxRelayConnectionsFlag
+	httpAddr = *httpAddrFlag
+	adminMode = *adminFlag
+	webUIEnabled = *webUI
+	signerKeyRef = *signerKey
+	relayAuthEnabled = *relayAuth
+	if !isFlagSet("signer-key") && os.Getenv(signerKeyEnv) == "" {
+		if _, err := os.Stat(signerKeyFile); err == nil {
+			signerKeyRef = "file:" + signerKeyFile
+		}
+	}
+	httpBaseURL = *httpBaseURLFlag
+	if url := os.Getenv("BHN_OLLAMA_URL"); url != "" {
+		ollamaURL = url
+	}
+	if *lowPower || envEnabled("BHN_LOW_POWER") {
+		applyLowPowerProfile()
+	}
+	bootstrapping := *bootstrapMode || envEnabled("BHN_BOOTSTRAP")
+
 	// Create data directory if it doesn't exist
 	if _, err := os.Stat(dataDir); os.IsNotExist(err) {
 		err := os.MkdirAll(dataDir, 0755)
@@ -67,35 +251,197 @@ func main() {
 		}
 	}
 
+	if *dataQuotaFlag != "" {
+		quota, err := parseSize(*dataQuotaFlag)
+		if err != nil {
+			log.Fatalf("Error parsing -data-quota: %v", err)
+		}
+		dataQuota = quota
+	}
+
+	offlineMode = *offline
+	if *embedder != "" {
+		parsed, err := parseEmbedder(*embedder)
+		if err != nil {
+			log.Fatalf("Error parsing -embedder: %v", err)
+		}
+		requestedEmbedder = parsed
+	}
+	requestedModel = *embedderModel
+	outboundPolicy.AllowHosts = parseHostList(*allowHosts)
+	outboundPolicy.DenyHosts = parseHostList(*denyHosts)
+	if err := parseHostRateLimits(*hostRateLimit); err != nil {
+		log.Fatalf("Error parsing -host-rate-limit: %v", err)
+	}
+	installGitTransport()
+
+	kinds, err := parseSnippetKinds(*snippetKindsFlag)
+	if err != nil {
+		log.Fatalf("Error parsing -snippet-kinds: %v", err)
+	}
+	snippetKinds = kinds
+
+	if authorPolicy.AllowAuthors, err = parseAuthorList(*allowAuthors); err != nil {
+		log.Fatalf("Error parsing -allow-authors: %v", err)
+	}
+	if authorPolicy.DenyAuthors, err = parseAuthorList(*denyAuthors); err != nil {
+		log.Fatalf("Error parsing -deny-authors: %v", err)
+	}
+
+	if *localRelay != "" {
+		useLocalRelay(*localRelay)
+	}
+	if *eventsFileFlag != "" {
+		if err := useEventsFile(*eventsFileFlag); err != nil {
+			log.Fatalf("Error opening -events-file: %v", err)
+		}
+	}
+	if *archiveFile != "" {
+		archive, err = openEventArchive(*archiveFile)
+		if err != nil {
+			log.Fatalf("Error opening -archive: %v", err)
+		}
+	}
+
+	// Start from the seed repositories on a fresh volume
+	if bootstrapping && *customConfigFile == "" {
+		seedReposConfig()
+	}
+
 	// Load repository configurations
 	loadReposConfig(*customConfigFile)
 
+	// Load generation settings
+	loadLLMConfig(*llmConfigPath)
+	loadAliases(*aliasesPath)
+
+	// Load the API keys of HTTP tenants
+	if httpAddr != "" {
+		loadTenants(*tenantsConfig)
+	}
+
 	// Add a new repository if requested
 	if *addRepo != "" {
 		addRepository(*addRepo)
 	}
 
-	if *listRepos {
+	if *initRepos {
+		// Start from a curated set of repositories
+		initReposConfig(*preset)
+	} else if *listRepos {
 		// List all configured repositories
 		listRepositories()
-	} else if *cloneRepos {
+	} else if *removeRepo != "" {
+		// Remove a repository from the configuration and the index
+		removeRepository(*removeRepo, *deleteClone)
+	} else if *enableRepo != "" {
+		setRepositoryEnabled(*enableRepo, true)
+	} else if *disableRepo != "" {
+		// Disable a repository and drop it from the index
+		setRepositoryEnabled(*disableRepo, false)
+	} else if *showSigner {
+		// Check that the signer key can be loaded
+		printSigner()
+	} else if *importKey {
+		// Store an encrypted signer key
+		importSignerKey()
+	} else if *exportKey {
+		// Print the signer key in encrypted form
+		exportSignerKey()
+	} else if *ingestEvents {
+		// Embed events from relays into the existing database
+		if *eventFilter == "" {
+			fmt.Println("Error: -ingest-events requires a -filter, e.g. -filter '{\"kinds\":[30023]}'")
+			os.Exit(1)
+		}
+		ingestRelayEvents(*eventFilter)
+	} else if *eventsDaemon {
+		// Embed events from the configured feeds as they arrive
+		runEventsDaemon(*feedsConfig)
+	} else if *exportSnippets != "" {
+		// Write the snippet cache to a file for another machine
+		exportSnippetCache(*exportSnippets)
+	} else if *importSnippets != "" {
+		// Replace the saved snippet cache with an exported one
+		importSnippetCache(*importSnippets)
+	} else if *dbVerify || *dbRepair {
+		// Check the database and optionally repair it
+		verifyDatabase(dbPath, *dbRepair)
+	} else if *showStats {
+		// Print the local usage statistics
+		printStats()
+	} else if *gapReport {
+		// Cluster the zero-result queries into missing topics
+		printGapReport()
+	} else if *coverageReport != "" {
+		// Show which NIP files made it into the index
+		printCoverageReport(*coverageReport)
+	} else if *diskUsageMode {
+		// Show what takes up space in the data directory
+		printDiskUsage()
+	} else if *cleanMode {
+		// Remove unused clones and temporary files
+		cleanDataDirectory()
+	} else if *dbCompact {
+		// Rewrite the database file without its free pages
+		compactDatabase(dbPath)
+	} else if *cloneRepos && !*ingestMode {
 		// Just clone the repositories without ingestion
 		cloneAllRepositories()
+	} else if *updateRepos && !*ingestMode {
+		// Just pull the existing clones without ingestion
+		updateRepositories(true)
 	} else if *ingestMode {
 		// Run in database creation mode
 		fmt.Println("Starting data ingestion...")
-		createDatabase(*cloneRepos)
-	} else if *queryMode {
-		// Run in query mode
+		createDatabase(*cloneRepos, *updateRepos, *metric)
+	} else if *getChunkID != "" {
+		// Fetch a chunk and its neighbors by ID
+		getChunk(*getChunkID, *neighbors)
+	} else if *queryMode || *askMode || *debugQueryMode {
+		// Run in query, ask, or retrieval debug mode
 		if *queryText == "" {
 			fmt.Println("Please provide a query using the -text flag")
 			flag.Usage()
 			os.Exit(1)
 		}
-		queryDatabase(*queryText, *similarity, *numResults)
+		opts := searchOptions{
+			Threshold:  *similarity,
+			NumResults: *numResults,
+			MaxPerFile: *maxPerFile,
+			MMRLambda:  mmrLambda,
+		}
+		if isFlagSet("min-score") {
+			opts.Threshold = *minScore
+		}
+		opts.Collections = parseCollections(*collections, *queryText)
+		if *minTier != "" {
+			tier, err := parseTier(*minTier)
+			if err != nil {
+				log.Fatalf("Error parsing -min-tier: %v", err)
+			}
+			opts.MinTier = tier
+		}
+		filter, err := parseFilterParams(map[string]string{"repo": *repoFilter, "nip": *nipFilter, "path": *pathFilter, "section": *sectionFilter})
+		if err != nil {
+			log.Fatalf("Error parsing filters: %v", err)
+		}
+		opts.Filter = filter
+		if *debugQueryMode {
+			printRetrievalDebug(*queryText, *collections == "auto", opts, *maxChars)
+		} else if *askMode {
+			askDatabase(*queryText, *answerLanguage, opts)
+		} else {
+			queryDatabase(*queryText, opts, *maxChars, *debugQuery)
+		}
 	} else {
 		// Run as an MCP server (default)
 		// fmt.Println("Starting in MCP server mode...")
+		if *ingestInBackgroundMode || envEnabled("BHN_INGEST_IN_BACKGROUND") {
+			ingestInBackground(bootstrapping)
+		} else if bootstrapping {
+			bootstrapIndex(*metric)
+		}
 		err := StartMCPServer()
 		if err != nil {
 			log.Fatalf("Error running MCP server: %v", err)
@@ -103,102 +449,272 @@ func main() {
 	}
 }
 
-// cloneAllRepositories clones all enabled repositories in the configuration
-func cloneAllRepositories() {
-	if len(repos) == 0 {
-		fmt.Println("No repositories configured. Create a repos.json file or use -add-repo to add repositories.")
+func createDatabase(cloneRepos, updateRepos bool, metric string) {
+	// Hold the ingest lock throughout so a second ingest cannot remove the
+	// temporary database or overwrite the progress of this one
+	release, err := lockIngest(dbPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
 		return
 	}
+	defer release()
 
-	fmt.Println("Cloning all enabled repositories...")
-	for _, repo := range repos {
-		if !repo.Enabled {
-			continue
-		}
+	// Record progress for the status tool of a server serving meanwhile
+	reportIngest(func(progress *ingestProgress) {
+		*progress = ingestProgress{Started: time.Now(), Stage: stagePreparing}
+	})
+	defer finishIngest()
 
-		fmt.Printf("Cloning repository: %s...\n", repo.Name)
-		_, err := git.PlainClone(repo.CloneDir, false, &git.CloneOptions{
-			URL:      repo.URL,
-			Progress: os.Stdout,
-		})
-		if err != nil && err != git.ErrRepositoryAlreadyExists {
-			fmt.Printf("Error cloning repository %s: %v\n", repo.Name, err)
-			// Continue with other repositories even if one fails
+	// Build the new index in a temporary database so that a failed or
+	// interrupted ingest never leaves the serving one half-updated
+	tmpPath, err := prepareIngestDatabase(dbPath)
+	if err != nil {
+		fmt.Printf("Error preparing ingest database: %v\n", err)
+		return
+	}
+
+	// Record the similarity metric before opening the store so vectors are prepared for it
+	if metric != "" {
+		parsed, err := parseMetric(metric)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Remove(tmpPath)
+			return
+		}
+		if err := migrateDatabase(tmpPath); err != nil {
+			fmt.Printf("Error migrating database: %v\n", err)
+			os.Remove(tmpPath)
+			return
+		}
+		if err := setStoreMetric(tmpPath, parsed); err != nil {
+			fmt.Printf("Error setting similarity metric: %v\n", err)
+			os.Remove(tmpPath)
+			return
 		}
 	}
-	fmt.Println("Cloning completed.")
-}
 
-func createDatabase(cloneRepos bool) {
+	// Record the embedder so queries embed the same way as the documents
+	if err := setStoreEmbedder(tmpPath); err != nil {
+		fmt.Printf("Error setting embedder: %v\n", err)
+		os.Remove(tmpPath)
+		return
+	}
+
 	// Create a new vector store
-	store := embeddings.BboltVectorStore{}
-	err := store.Initialize(dbPath)
+	store := vectorStore{}
+	err = initializeStore(&store, tmpPath)
 	if err != nil {
 		fmt.Printf("Error initializing vector store: %v\n", err)
+		os.Remove(tmpPath)
 		return
 	}
 
-	// Clone all enabled repositories if requested
+	// Clone all enabled repositories if requested, which also pulls existing
+	// clones; otherwise pull the repositories that ask for it
+	reportIngest(func(progress *ingestProgress) { progress.Stage = stageCloning })
 	if cloneRepos {
 		cloneAllRepositories()
+	} else {
+		updateRepositories(updateRepos)
 	}
 
 	// Process all markdown files in the data directory
 	fmt.Println("Processing markdown files in data directory...")
-	err = processDataDirectory(&store)
+	ingestManifest = corpusManifest{IngestedAt: time.Now()}
+	ingestedIDs = make(map[string]bool)
+	keptSources = make(map[string]bool)
+	keptRepos = make(map[string]bool)
+	reusedEmbeddings = 0
+
+	// A first ingest publishes partial indexes as it goes, so a server
+	// has something to answer from before the long tail is embedded
+	var checkpoint func() error
+	firstIngest := servingIndexEmpty(dbPath)
+	if firstIngest {
+		checkpoint = func() error { return publishCheckpoint(&store, tmpPath, dbPath) }
+	}
+	err = processDataDirectory(&store, checkpoint)
 	if err != nil {
+		closeStore(&store)
 		fmt.Printf("Error processing data directory: %v\n", err)
+		if firstIngest {
+			fmt.Printf("The database at %s holds a partial index at most.\n", dbPath)
+		} else {
+			fmt.Printf("The existing database at %s was left unchanged.\n", dbPath)
+		}
+		os.Remove(tmpPath)
+		return
+	}
+
+	// Chunks carried over from the previous index that this ingest did not
+	// store belong to files that were removed or now split into fewer
+	// chunks. The store holds the database locked, so they are swept, and
+	// the bookkeeping below written, through its handle.
+	removed, err := sweepStaleChunks(&store)
+	if err != nil {
+		closeStore(&store)
+		fmt.Printf("Error removing stale chunks: %v\n", err)
+		os.Remove(tmpPath)
+		return
+	}
+	fmt.Printf("Stored %d chunks, %d of them unchanged and not embedded again; removed %d stale chunks\n", len(ingestedIDs), reusedEmbeddings, removed)
+	if len(keptSources) > 0 || len(keptRepos) > 0 {
+		fmt.Printf("Kept the chunks of %d files and %d repositories from the previous ingest since errors stopped them from being ingested\n", len(keptSources), len(keptRepos))
+	}
+
+	// Record what the index was built from so agents can judge its freshness
+	reportIngest(func(progress *ingestProgress) { progress.Stage = stageSaving })
+	if err := store.saveMetaJSON(corpusKey, ingestManifest); err != nil {
+		closeStore(&store)
+		fmt.Printf("Error recording corpus manifest: %v\n", err)
+		os.Remove(tmpPath)
+		return
+	}
+
+	// Index the kinds and tags once here instead of re-reading the README on every lookup
+	if registry, err := buildKindRegistry(); err != nil {
+		fmt.Printf("Skipping kind registry: %v\n", err)
+	} else if err := store.saveMetaJSON(registryKey, registry); err != nil {
+		closeStore(&store)
+		fmt.Printf("Error recording kind registry: %v\n", err)
+		os.Remove(tmpPath)
+		return
+	} else {
+		fmt.Printf("Recorded %d event kinds and %d tags from %d NIPs\n", len(registry.Kinds), len(registry.Tags), len(registry.NIPs))
+	}
+	closeStore(&store)
+
+	if err := swapInDatabase(tmpPath, dbPath); err != nil {
+		fmt.Printf("Error: %v\n", err)
 		return
 	}
+	reportIngest(func(progress *ingestProgress) {
+		progress.Stage = stageDone
+		progress.Chunks = embeddingCounter
+	})
 
 	fmt.Println("RAG database created successfully!")
 }
 
-func queryDatabase(query string, similarity float64, numResults int) {
-	// Initialize the vector store
-	store := embeddings.BboltVectorStore{}
-	err := store.Initialize(dbPath)
+func queryDatabase(query string, opts searchOptions, maxChars int, debug bool) {
+	// The manifest is read before the store locks the database
+	corpus, err := loadCorpusManifest(dbPath)
 	if err != nil {
-		log.Fatalf("Error initializing vector store: %v", err)
+		log.Fatalf("%v", err)
 	}
 
-	// Create embedding from the query
-	fmt.Println("Creating embedding from query...")
-	queryWithPrefix := fmt.Sprintf("search_query: %s", query)
-	queryEmbedding, err := embeddings.CreateEmbedding(
-		ollamaURL,
-		llm.Query4Embedding{
-			Model:  embeddingModel,
-			Prompt: queryWithPrefix,
-		},
-		"query",
-	)
+	// Initialize the vector store
+	store := vectorStore{}
+	err = initializeStore(&store, dbPath)
 	if err != nil {
-		log.Fatalf("Error creating embedding: %v", err)
+		log.Fatalf("Error initializing vector store: %v", err)
 	}
 
-	// Search for similar documents
+	// Create embedding from the query and search for similar documents
 	fmt.Println("Searching for similar documents...")
-	similarities, err := store.SearchTopNSimilarities(queryEmbedding, similarity, numResults)
+	candidates, err := retrieveCandidates(context.Background(), &store, query)
 	if err != nil {
 		log.Fatalf("Error searching for similarities: %v", err)
 	}
+	selected, _ := rerankResults(query, candidates, opts, rerankEnabled)
+	results := applyContextBudget(selected, maxChars)
+	recordQueryStats(query, candidates, results)
+	// The process exits after this query, before a periodic write
+	flushQueryStats()
+
+	if debug {
+		fmt.Println(explainSearch(store.Metric(), candidates, opts))
+	}
 
-	if len(similarities) == 0 {
+	if len(results) == 0 {
 		fmt.Println("No similar documents found")
 		return
 	}
 
-	fmt.Printf("Found %d similar documents\n\n", len(similarities))
+	fmt.Printf("Found %d similar documents\n\n", len(results))
 
-	// Generate context from similarities
-	context := embeddings.GenerateContextFromSimilarities(similarities)
+	// Generate context from similarities, including each result's score
+	context := formatResults(results)
 
 	fmt.Println(context)
+	if note := stalenessNote(corpus, results); note != "" {
+		fmt.Println(strings.TrimSpace(note))
+	}
 
 	fmt.Println("")
 }
 
+// askDatabase retrieves context for a question and prints the generated
+// answer progressively as it streams from the model
+func askDatabase(question, language string, opts searchOptions) {
+	// The manifest is read before the store locks the database
+	corpus, err := loadCorpusManifest(dbPath)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	store := vectorStore{}
+	err = initializeStore(&store, dbPath)
+	if err != nil {
+		log.Fatalf("Error initializing vector store: %v", err)
+	}
+
+	fmt.Println("Searching for relevant documentation...")
+	candidates, err := retrieveCandidates(context.Background(), &store, question)
+	if err != nil {
+		log.Fatalf("Error searching for similarities: %v", err)
+	}
+	results, _ := rerankResults(question, candidates, opts, rerankEnabled)
+	recordQueryStats(question, candidates, results)
+	// The process exits after this query, before a periodic write
+	flushQueryStats()
+
+	if reply, refuse := insufficientContext(candidates, results); refuse {
+		fmt.Println(reply)
+		return
+	}
+
+	fmt.Printf("Generating answer from %d documents...\n\n", len(results))
+	_, err = generateAnswer(question, language, results, func(token string) error {
+		fmt.Print(token)
+		return nil
+	})
+	fmt.Println()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if note := stalenessNote(corpus, results); note != "" {
+		fmt.Printf("\n%s\n", strings.TrimSpace(note))
+	}
+}
+
+// getChunk prints a stored chunk and its neighbors without running a similarity search
+func getChunk(id string, neighbors int) {
+	store := vectorStore{}
+	err := initializeStore(&store, dbPath)
+	if err != nil {
+		log.Fatalf("Error initializing vector store: %v", err)
+	}
+
+	chunks, err := getChunkWithNeighbors(&store, id, neighbors)
+	if err != nil {
+		log.Fatalf("Error fetching chunk: %v", err)
+	}
+
+	fmt.Println(formatChunks(chunks, id))
+}
+
+// isFlagSet reports whether a command-line flag was explicitly provided
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 // loadReposConfig loads the repository configuration from a file
 func loadReposConfig(customConfigFile string) {
 	// Determine which config file to use
@@ -206,6 +722,7 @@ func loadReposConfig(customConfigFile string) {
 	if customConfigFile != "" {
 		cfgFile = customConfigFile
 	}
+	reposConfigFile = cfgFile
 
 	// Check if the config file exists
 	if _, err := os.Stat(cfgFile); os.IsNotExist(err) {
@@ -229,18 +746,13 @@ func loadReposConfig(customConfigFile string) {
 		os.Exit(1)
 	}
 
-	err = json.Unmarshal(file, &repos)
-	if err != nil {
-		fmt.Printf("Error parsing repository config file: %v\n", err)
+	// Nothing is applied unless the whole file is valid
+	list, problems := parseReposConfig(file)
+	if len(problems) > 0 {
+		printConfigProblems("repository config file", cfgFile, problems)
 		os.Exit(1)
 	}
-
-	// Ensure clone directories are properly set
-	for i := range repos {
-		if repos[i].CloneDir == "" {
-			repos[i].CloneDir = filepath.Join(dataDir, repos[i].Name+"-repo")
-		}
-	}
+	repos = list
 
 	// Ensure at least one repository is enabled if we have repositories
 	if len(repos) > 0 {
@@ -258,25 +770,37 @@ func loadReposConfig(customConfigFile string) {
 			saveReposToFile(cfgFile)
 		}
 	}
+
+	findDuplicateRepos()
 }
 
 // addRepository adds a new repository to the configuration
 func addRepository(addRepoStr string) {
 	parts := strings.Split(addRepoStr, ",")
 	if len(parts) < 2 {
-		fmt.Println("Error: Repository must be specified as 'url,name'")
+		fmt.Println("Error: Repository must be specified as 'url,name' or 'url,name,role'")
 		os.Exit(1)
 	}
 
 	url := parts[0]
 	name := parts[1]
+	role := ""
+	if len(parts) > 2 {
+		role = parts[2]
+	}
+	if err := checkRepoName(name); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := checkRepoURL(url); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
 
-	// Check if repository already exists
-	for _, repo := range repos {
-		if repo.URL == url {
-			fmt.Printf("Repository with URL %s already exists\n", url)
-			return
-		}
+	// Check if repository already exists, also as a mirror of another entry
+	if i := findRepoByURL(url); i >= 0 {
+		fmt.Printf("Repository with URL %s already exists as %s\n", url, repos[i].Name)
+		return
 	}
 
 	// Add the new repository
@@ -285,6 +809,7 @@ func addRepository(addRepoStr string) {
 		Name:     name,
 		CloneDir: filepath.Join(dataDir, name+"-repo"),
 		Enabled:  true,
+		Role:     role,
 	}
 
 	repos = append(repos, newRepo)
@@ -324,136 +849,182 @@ func listRepositories() {
 
 		fmt.Printf("%d. %s (%s)\n", i+1, repo.Name, status)
 		fmt.Printf("   URL: %s\n", repo.URL)
+		if repo.Role != "" {
+			fmt.Printf("   Role: %s\n", repo.Role)
+		}
+		if pin := pinnedRef(repo); pin != "" {
+			fmt.Printf("   Pinned to: %s\n", pin)
+		}
 		fmt.Printf("   Clone Directory: %s\n", repo.CloneDir)
 		fmt.Println()
 	}
 }
 
-func processDataDirectory(store *embeddings.BboltVectorStore) error {
+// processDataDirectory embeds the files of all enabled repositories, the
+// ones most likely to be queried first. When checkpoint is set it is called
+// once those are done and then every ingestCheckpointInterval, so a partial
+// index can be served while the rest is embedded.
+func processDataDirectory(store *vectorStore, checkpoint func() error) error {
 	if len(repos) == 0 {
 		fmt.Println("No repositories configured. Use -add-repo to add a repository.")
 		return fmt.Errorf("no repositories configured")
 	}
 
-	// Process all enabled repositories
+	// Gather the files of all enabled repositories
+	var files []ingestFile
+	entries := make(map[string]*corpusRepo)
+	failed := make(map[string]bool)
+	var order []string
 	for _, repo := range repos {
-		if !repo.Enabled {
+		if !isActiveRepo(repo) {
 			continue
 		}
 
-		fmt.Printf("Processing repository: %s\n", repo.Name)
-		err := processRepository(repo.CloneDir, store, repo.Name)
+		entry := newCorpusRepo(repo)
+		repoFiles, skipped, err := collectRepoFiles(repo)
 		if err != nil {
 			fmt.Printf("Error processing repository %s: %v\n", repo.Name, err)
+			entry.Notes = append(entry.Notes, fmt.Sprintf("ingestion stopped early: %v", err))
+			// Files the walk did not reach keep their chunks
+			keptRepos[repo.Name] = true
 			// Continue with other repositories even if one fails
 		}
+		fmt.Printf("Found %d files in repository %s\n", len(repoFiles), repo.Name)
+		printSkippedFiles(repo.Name, skipped)
+		entry.Skipped = skipped
+		files = append(files, repoFiles...)
+		entries[repo.Name] = &entry
+		order = append(order, repo.Name)
 	}
 
-	return nil
-}
-
-// processRepository processes all markdown files in a specific repository
-func processRepository(repoDir string, store *embeddings.BboltVectorStore, repoName string) error {
-	// Walk through the repository directory and process markdown files
-	var processedCount int
+	head := prioritizeFiles(files, readSourceHits())
+	fmt.Printf("Embedding %d files, the %d most likely to be queried first\n", len(files), head)
+	reportIngest(func(progress *ingestProgress) {
+		progress.Stage = stageEmbedding
+		progress.FilesTotal = len(files)
+	})
 
-	return filepath.WalkDir(repoDir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+	lastCheckpoint := time.Now()
+	for i, file := range files {
+		reportIngest(func(progress *ingestProgress) {
+			progress.Repo = file.Repo.Name
+			progress.FilesDone = i
+			progress.Chunks = embeddingCounter
+		})
+		entry := entries[file.Repo.Name]
+		if failed[file.Repo.Name] {
+			keptSources[fileSource(file.Repo.Name, file.Path)] = true
+			continue
 		}
 
-		// Skip .git directory
-		if d.IsDir() && d.Name() == ".git" {
-			return filepath.SkipDir
+		fmt.Printf("Processing file %d of %d from %s: %s\n", i+1, len(files), file.Repo.Name, file.Path)
+		chunksBefore := embeddingCounter
+		err := processFile(file.Path, file.Handler.Kind, repoChunker(file.Repo, file.Handler), store, file.Repo.Name)
+		entry.Files++
+		entry.Chunks += embeddingCounter - chunksBefore
+		if err != nil {
+			fmt.Printf("Error processing repository %s: %v\n", file.Repo.Name, err)
+			entry.Notes = append(entry.Notes, fmt.Sprintf("ingestion stopped early: %v", err))
+			failed[file.Repo.Name] = true
+			// The file may have been stored part way
+			keptSources[fileSource(file.Repo.Name, file.Path)] = true
 		}
 
-		// Process only markdown files
-		if !d.IsDir() && strings.HasSuffix(strings.ToLower(d.Name()), ".md") {
-			processedCount++
-			fmt.Printf("Processing file %d from %s: %s\n", processedCount, repoName, path)
-			err := processFile(path, store, repoName)
-			return err
+		if checkpoint != nil && i+1 < len(files) && (i+1 == head || i+1 > head && time.Since(lastCheckpoint) >= ingestCheckpointInterval) {
+			if err := checkpoint(); err != nil {
+				return err
+			}
+			lastCheckpoint = time.Now()
 		}
+	}
+	for _, name := range order {
+		ingestManifest.Repos = append(ingestManifest.Repos, *entries[name])
+	}
 
-		return nil
+	// Events ingested from relays are not in any clone, so they are embedded again here
+	reportIngest(func(progress *ingestProgress) {
+		progress.Stage = stageEvents
+		progress.Repo = ""
+		progress.FilesDone = len(files)
+		progress.Chunks = embeddingCounter
 	})
+	processIngestedEvents(store)
+
+	return nil
 }
 
-func processFile(filePath string, store *embeddings.BboltVectorStore, repoName string) error {
+// readIngestFile reads a file to embed. Tests replace it to make a read fail.
+var readIngestFile = os.ReadFile
+
+func processFile(filePath, kind string, chunker Chunker, store *vectorStore, repoName string) error {
 	// Read file content
-	fileContent, err := os.ReadFile(filePath)
+	fileContent, err := readIngestFile(filePath)
 	if err != nil {
 		return fmt.Errorf("error reading file %s: %v", filePath, err)
 	}
 
-	// For protocol specifications, we'll always use semantic chunking
-	// as it's the most effective for structured markdown documents
-	return processMarkdownChunks(filePath, fileContent, store, repoName)
-}
+	// Files stored in Git LFS are only pointers until the objects are fetched
+	if isLFSPointer(fileContent) {
+		fmt.Printf("Skipping Git LFS pointer %s (set \"LFS\": \"fetch\" to download it)\n", filePath)
+		return nil
+	}
 
-// processMarkdownChunks parses markdown into semantic chunks and creates embeddings for each
-func processMarkdownChunks(filePath string, fileContent []byte, store *embeddings.BboltVectorStore, repoName string) error {
-	// Extract filename for better metadata
-	filename := filepath.Base(filePath)
+	// By default each file type is split into semantically meaningful
+	// chunks by its handler: sections for documentation, declarations for
+	// source code. Repositories can choose another chunking strategy.
+	fmt.Printf("Parsing %s file: %s\n", kind, filePath)
+	return processChunks(filePath, kind, chunker.Chunk(string(fileContent), filePath), store, repoName)
+}
 
-	// Use Parakeet's markdown parser to create semantically meaningful chunks
-	fmt.Printf("Parsing markdown file: %s\n", filePath)
-	chunks := content.ParseMarkdownWithLineage(string(fileContent))
+// processChunks creates and stores embeddings for the chunks of a file
+func processChunks(filePath, kind string, chunks []content.Chunk, store *vectorStore, repoName string) error {
+	// Keep fenced code blocks, such as the JSON examples of the NIPs, whole
+	chunks = keepCodeFences(chunks)
+	overlap := repoContextOverlap(repoName)
 
 	// Process all chunks from the file
-	fmt.Printf("Found %d markdown chunks in %s\n", len(chunks), filePath)
-	fmt.Printf("Processing %d markdown chunks from %s\n", len(chunks), filePath)
+	fmt.Printf("Found %d %s chunks in %s\n", len(chunks), kind, filePath)
+	fmt.Printf("Processing %d %s chunks from %s\n", len(chunks), kind, filePath)
 
-	// Extract NIP number from filename if possible (for protocol specifications)
-	nipNumber := extractNipIdentifier(filename)
+	// Chunk IDs follow from the file's path and the chunk's position, e.g.
+	// "nips/01-chunk-3" for NIP-01, so a re-ingest replaces the same chunks
+	source := fileSource(repoName, filePath)
 
 	// Create embeddings for each chunk and store them
 	for i, chunk := range chunks {
-		// Increment the counter to generate a unique ID
 		embeddingCounter++
-		id := fmt.Sprintf("%s-chunk-%d", nipNumber, embeddingCounter)
-
-		parentHeaders := extractParentHeaders(chunk.Lineage)
-		metadata := fmt.Sprintf("search_document: Section: %s\nParent Sections: %s\n\n%s",
-			chunk.Header,
-			parentHeaders,
-			chunk.Content)
-
-		if i > 0 && len(chunks[i-1].Content) > 0 {
-			prevContent := chunks[i-1].Content
-			overlapText := extractOverlap(prevContent)
-			if overlapText != "" {
-				metadata = fmt.Sprintf("%s\n\nContext from previous section:\n%s", metadata, overlapText)
-			}
-		}
-
-		fmt.Printf("Creating embedding for chunk %s (header: %s)\n", id, chunk.Header)
+		id := fmt.Sprintf("%s-chunk-%d", source, i+1)
 
-		// Create embedding
-		embedding, err := embeddings.CreateEmbedding(
-			ollamaURL,
-			llm.Query4Embedding{
-				Model:  embeddingModel,
-				Prompt: metadata,
-			},
-			id,
-		)
+		metadata := chunkDocument(chunks, i, overlap)
 
-		if err != nil {
-			fmt.Printf("Warning: Error creating embedding for %s: %v\n", id, err)
-			continue
-		}
-
-		// Save embedding to the store
-		_, err = store.Save(embedding)
-		if err != nil {
-			fmt.Printf("Warning: Error saving embedding for %s: %v\n", id, err)
+		fmt.Printf("Creating embedding for chunk %s (header: %s)\n", id, chunk.Header)
+		if err := upsertChunk(store, id, metadata, fileChunkMetadata(repoName, filePath, chunk)); err != nil {
+			fmt.Printf("Warning: %v\n", err)
 		}
 	}
 
 	return nil
 }
 
+// chunkDocument returns the text embedded for the i-th markdown chunk: the
+// chunk under its section headers, followed by the end of the previous
+// section that overlap covers
+func chunkDocument(chunks []content.Chunk, i int, overlap contextOverlap) string {
+	chunk := chunks[i]
+	parentHeaders := extractParentHeaders(chunk.Lineage)
+	metadata := fmt.Sprintf("search_document: Section: %s\nParent Sections: %s\n\n%s",
+		chunk.Header,
+		parentHeaders,
+		chunk.Content)
+
+	if i > 0 {
+		if overlapText := overlap.extract(chunks[i-1].Content); overlapText != "" {
+			metadata += overlapMarker + overlapText
+		}
+	}
+	return metadata
+}
+
 // extractParentHeaders extracts parent section headers from the lineage string
 func extractParentHeaders(lineage string) string {
 	if lineage == "" {
@@ -474,22 +1045,8 @@ func extractParentHeaders(lineage string) string {
 	return strings.Join(cleanParts, " > ")
 }
 
-// extractOverlap extracts the last 1-2 sentences from text for overlap
-func extractOverlap(text string) string {
-	sentenceRegex := regexp.MustCompile(`[.!?]\s+`)
-	sentences := sentenceRegex.Split(text, -1)
-	if len(sentences) <= 1 {
-		return text
-	} else if len(sentences[len(sentences)-1]) < 20 && len(sentences) > 2 {
-		// If last sentence is very short, include 2 sentences
-		return strings.Join(sentences[len(sentences)-2:], ". ") + "."
-	} else {
-		// Otherwise just the last sentence
-		return sentences[len(sentences)-1] + "."
-	}
-}
-
-// extractNipIdentifier extracts a simple identifier from a filename
+// extractNipIdentifier extracts a simple identifier from a filename. Only the
+// .md extension is dropped, so "relay.go" and "relay.md" stay apart.
 func extractNipIdentifier(filename string) string {
 	return strings.TrimSuffix(filename, ".md")
 }
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// inTempDir runs the test in an empty working directory, where dataDir and
+// dbPath resolve, and restores the repositories it configures
+func inTempDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	savedRepos := repos
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		repos = savedRepos
+	})
+}
+
+// writeFixture writes files relative to the working directory
+func writeFixture(t *testing.T, files map[string]string) {
+	t.Helper()
+	for name, text := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// storedIDs returns the IDs of the chunks in the database at dbPath
+func storedIDs(t *testing.T) []string {
+	t.Helper()
+	store := vectorStore{}
+	if err := initializeStore(&store, dbPath); err != nil {
+		t.Fatalf("opening the ingested database: %v", err)
+	}
+	defer closeStore(&store)
+	records, err := store.GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, record := range records {
+		ids = append(ids, record.Id)
+	}
+	return ids
+}
+
+func TestCreateDatabase(t *testing.T) {
+	inTempDir(t)
+	defer func(embedder string) { requestedEmbedder = embedder }(requestedEmbedder)
+	requestedEmbedder = embedderHash
+
+	cloneDir := filepath.Join(dataDir, "fixture-repo")
+	repos = []RepoConfig{{Name: "fixture", URL: "https://example.com/fixture.git", CloneDir: cloneDir, Enabled: true}}
+	writeFixture(t, map[string]string{
+		filepath.Join(cloneDir, "01.md"): "# NIP-01\n\nBasic protocol flow description.\n\nEvents are signed JSON objects with a kind.\n",
+		filepath.Join(cloneDir, "25.md"): "# NIP-25\n\nReactions are kind 7 events.\n",
+	})
+
+	createDatabase(false, false, "")
+	if _, err := os.Stat(dbPath + ingestSuffix); !os.IsNotExist(err) {
+		t.Fatalf("the ingest database was not swapped in: %v", err)
+	}
+	ids := storedIDs(t)
+	if len(ids) == 0 {
+		t.Fatal("no chunks were stored")
+	}
+	corpus, err := loadCorpusManifest(dbPath)
+	if err != nil || corpus == nil {
+		t.Fatalf("loadCorpusManifest = %v, %v; want the manifest of the ingest", corpus, err)
+	}
+	if len(corpus.Repos) != 1 || corpus.Repos[0].Name != "fixture" {
+		t.Errorf("manifest repositories = %+v, want fixture only", corpus.Repos)
+	}
+
+	// A re-ingest carries the chunks over and sweeps those of removed files
+	if err := os.Remove(filepath.Join(cloneDir, "25.md")); err != nil {
+		t.Fatal(err)
+	}
+	createDatabase(false, false, "")
+	for _, id := range storedIDs(t) {
+		if strings.Contains(id, "/25") {
+			t.Errorf("chunk %s of a removed file was not swept", id)
+		}
+	}
+	if len(storedIDs(t)) == 0 {
+		t.Error("the re-ingest stored no chunks")
+	}
+}
+
+func TestCreateDatabaseKeepsChunksOfFailedFiles(t *testing.T) {
+	inTempDir(t)
+	defer func(embedder string) { requestedEmbedder = embedder }(requestedEmbedder)
+	requestedEmbedder = embedderHash
+	t.Cleanup(func() { readIngestFile = os.ReadFile })
+
+	cloneDir := filepath.Join(dataDir, "fixture-repo")
+	repos = []RepoConfig{{Name: "fixture", URL: "https://example.com/fixture.git", CloneDir: cloneDir, Enabled: true}}
+	writeFixture(t, map[string]string{
+		filepath.Join(cloneDir, "01.md"): "# NIP-01\n\nBasic protocol flow description.\n",
+		filepath.Join(cloneDir, "02.md"): "# NIP-02\n\nFollow lists are kind 3 events.\n",
+		filepath.Join(cloneDir, "03.md"): "# NIP-03\n\nOpenTimestamps attestations for events.\n",
+	})
+	createDatabase(false, false, "")
+	before := storedIDs(t)
+
+	// Reading NIP-02 fails, so the rest of the repository is skipped; the
+	// chunks of the files that were not ingested stay in the index
+	readIngestFile = func(name string) ([]byte, error) {
+		if filepath.Base(name) == "02.md" {
+			return nil, os.ErrDeadlineExceeded
+		}
+		return os.ReadFile(name)
+	}
+	createDatabase(false, false, "")
+	after := strings.Join(storedIDs(t), " ")
+	for _, id := range before {
+		if !strings.Contains(after, id) {
+			t.Errorf("chunk %s was swept after a failed ingest", id)
+		}
+	}
+}
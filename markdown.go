@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// nipTitlePattern splits link text such as "NIP-01: Basic protocol flow description"
+var nipTitlePattern = regexp.MustCompile(`^NIP-([0-9A-Fa-f]+)\s*[:\-–]\s*(.+)$`)
+
+// nipEntry is a single NIP in the NIPs index
+type nipEntry struct {
+	Number string   `json:"number"`
+	Title  string   `json:"title"`
+	File   string   `json:"file"`
+	Status []string `json:"status,omitempty"`
+}
+
+// markdownHeading is a heading found in a markdown document
+type markdownHeading struct {
+	Level int
+	Text  string
+	Start int // Byte offset of the start of the heading line
+}
+
+// parseHeadings returns the document's top-level headings in order. Text that
+// only looks like a heading, such as "##" inside a code block, is ignored.
+func parseHeadings(source []byte) []markdownHeading {
+	doc := goldmark.DefaultParser().Parse(text.NewReader(source))
+
+	var headings []markdownHeading
+	for node := doc.FirstChild(); node != nil; node = node.NextSibling() {
+		heading, ok := node.(*ast.Heading)
+		if !ok || heading.Lines().Len() == 0 {
+			continue
+		}
+
+		// Heading segments start after the "#" markers, so back up to the line start
+		start := heading.Lines().At(0).Start
+		if idx := bytes.LastIndexByte(source[:start], '\n'); idx != -1 {
+			start = idx + 1
+		} else {
+			start = 0
+		}
+
+		headings = append(headings, markdownHeading{
+			Level: heading.Level,
+			Text:  strings.TrimSpace(inlineText(heading, source)),
+			Start: start,
+		})
+	}
+	return headings
+}
+
+// inlineText concatenates the plain text of a node's inline children
+func inlineText(node ast.Node, source []byte) string {
+	var b strings.Builder
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		switch n := child.(type) {
+		case *ast.Text:
+			b.Write(n.Segment.Value(source))
+			if n.SoftLineBreak() || n.HardLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.String:
+			b.Write(n.Value)
+		default:
+			b.WriteString(inlineText(child, source))
+		}
+	}
+	return b.String()
+}
+
+// extractSection returns the section of a markdown document under the heading
+// with the given title, at any level, up to the next heading of the same or
+// a higher level. Subsections are included. It returns "" if no heading matches.
+func extractSection(content, title string) string {
+	source := []byte(content)
+	headings := parseHeadings(source)
+
+	for i, heading := range headings {
+		if !strings.EqualFold(heading.Text, title) {
+			continue
+		}
+
+		end := len(source)
+		for _, next := range headings[i+1:] {
+			if next.Level <= heading.Level {
+				end = next.Start
+				break
+			}
+		}
+		return strings.TrimSpace(content[heading.Start:end])
+	}
+	return ""
+}
+
+// extractNipIndex collects the NIPs linked from the "List" section of the
+// NIPs README, falling back to every NIP link in the document
+func extractNipIndex(content string) []nipEntry {
+	section := extractSection(content, "List")
+	if section == "" {
+		section = content
+	}
+
+	source := []byte(section)
+	doc := goldmark.DefaultParser().Parse(text.NewReader(source))
+
+	var entries []nipEntry
+	seen := make(map[string]bool)
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		link, ok := node.(*ast.Link)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+
+		destination := string(link.Destination)
+		match := nipTitlePattern.FindStringSubmatch(strings.TrimSpace(inlineText(link, source)))
+		if match == nil || !strings.HasSuffix(destination, ".md") || seen[destination] {
+			return ast.WalkSkipChildren, nil
+		}
+
+		seen[destination] = true
+		entries = append(entries, nipEntry{
+			Number: strings.ToUpper(match[1]),
+			Title:  strings.TrimSpace(match[2]),
+			File:   path.Base(destination),
+		})
+		return ast.WalkSkipChildren, nil
+	})
+
+	return entries
+}
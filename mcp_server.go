@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -14,16 +15,13 @@ import (
 	"github.com/mark3labs/mcp-go/server"
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip19"
-	"github.com/parakeet-nest/parakeet/embeddings"
-	"github.com/parakeet-nest/parakeet/llm"
 )
 
-var globalStore embeddings.BboltVectorStore
-
 // CodeSnippetCache stores code snippet events from Nostr relays
 type CodeSnippetCache struct {
 	events     []*nostr.Event
 	lastUpdate time.Time
+	dropped    int
 	mutex      sync.RWMutex
 }
 
@@ -36,17 +34,39 @@ func StartMCPServer() error {
 		loadReposConfig("")
 	}
 
-	err := globalStore.Initialize(dbPath)
+	index, err := openServingIndex(dbPath)
 	if err != nil {
-		return fmt.Errorf("error initializing vector store: %v", err)
+		return err
 	}
+	publishIndex(index)
+
+	// Pick up databases swapped in by re-ingestion without a restart
+	go watchStore(dbPath)
 	
+	// Restore relay scores so healthy relays are preferred from the start
+	loadRelayScores()
+
+	// Serve the snippets saved by the last run until the relays answer
+	loadSnippetCache()
+
 	// Start background process to populate code snippet cache
 	go populateCodeSnippetCache()
 
+	// fmt.Println("Starting MCP server for Nostr RAG system...")
+	if httpAddr != "" {
+		return serveHTTP()
+	}
+	err = server.ServeStdio(newMCPServer(adminMode))
+	flushQueryStats()
+	return err
+}
+
+// newMCPServer creates an MCP server with the read-only tools and resources,
+// and the admin tools when admin is true
+func newMCPServer(admin bool) *server.MCPServer {
 	s := server.NewMCPServer(
 		"Beating Heart Nostr RAG System",
-		"1.0.0",
+		serverVersion,
 		server.WithLogging(),
 	)
 
@@ -54,7 +74,7 @@ func StartMCPServer() error {
 		mcp.WithDescription("Searches the Nostr documentation for documents semantically similar to the input query."),
 		mcp.WithString("query",
 			mcp.Required(),
-			mcp.Description("The query text to search for in the Nostr documentation"),
+			mcp.Description("The query text to search for in the Nostr documentation. May include filters such as repo:nips, nip:01, kind:>=30000, or header:\"zap\"; use OR between alternatives and a leading - to negate a filter"),
 		),
 		mcp.WithNumber("similarity",
 			mcp.Description("The similarity threshold for retrieving documents (0.0 to 1.0)"),
@@ -62,10 +82,336 @@ func StartMCPServer() error {
 		mcp.WithNumber("num_results",
 			mcp.Description("The number of similar documents to retrieve"),
 		),
+		mcp.WithNumber("min_score",
+			mcp.Description("Minimum similarity score for a result to be returned; overrides 'similarity'"),
+		),
+		mcp.WithNumber("max_per_file",
+			mcp.Description("The maximum number of results from a single source file (default: 2, 0 for no limit)"),
+		),
+		mcp.WithString("collections",
+			mcp.Description("Comma-separated collections to search (e.g. 'specs,code'), 'auto' to route by the query (default), or 'all'"),
+		),
+		mcp.WithNumber("max_tokens",
+			mcp.Description("Approximate token budget for the returned context; lower-ranked and overlapping text is trimmed to fit"),
+		),
+		mcp.WithNumber("max_chars",
+			mcp.Description("Character budget for the returned context; takes precedence over max_tokens"),
+		),
+		mcp.WithString("min_tier",
+			mcp.Description(minTierDescription),
+		),
+		mcp.WithString("repo",
+			mcp.Description(repoFilterDescription),
+		),
+		mcp.WithString("nip",
+			mcp.Description(nipFilterDescription),
+		),
+		mcp.WithString("path",
+			mcp.Description(pathFilterDescription),
+		),
+		mcp.WithString("section",
+			mcp.Description(sectionFilterDescription),
+		),
+		mcp.WithString("mode",
+			mcp.Description(retrievalModeDescription),
+		),
+		mcp.WithBoolean("expand",
+			mcp.Description(expandDescription),
+		),
+		mcp.WithBoolean("rerank",
+			mcp.Description(rerankDescription),
+		),
+		mcp.WithNumber("mmr_lambda",
+			mcp.Description(mmrLambdaDescription),
+		),
+		mcp.WithBoolean("include_snippets",
+			mcp.Description("Append cached kind 1337 code snippets that reference the kinds or NIPs in the results; skipped when min_tier excludes snippets"),
+		),
+		mcp.WithBoolean("debug",
+			mcp.Description("Include an explanation of why candidates were included or excluded"),
+		),
 	)
 
 	s.AddTool(queryTool, queryNostrDataHandler)
 
+	assistantTool := mcp.NewTool("nostr_assistant",
+		mcp.WithDescription("Answers any Nostr development question in one call. The question is classified and sent to the documentation search, the code snippet search, and the relay health check as needed, and their output is returned together. Use this when unsure which of the other tools fits."),
+		mcp.WithString("question",
+			mcp.Required(),
+			mcp.Description("The question, in plain language, e.g. 'How do I send a zap in TypeScript?' or 'Is wss://relay.damus.io up?'"),
+		),
+		mcp.WithBoolean("generate_answer",
+			mcp.Description("Have the local model write an answer from the documentation instead of returning the matching sections"),
+		),
+	)
+
+	s.AddTool(assistantTool, nostrAssistantHandler)
+
+	debugQueryTool := mcp.NewTool("debug_query",
+		mcp.WithDescription("Explains how a query_nostr_data search is carried out, to diagnose why an expected document was not returned: the parsed filters, alias expansions, the exact prompt that was embedded, collection routing, every top candidate's score, and why each was kept or dropped."),
+		mcp.WithString("query",
+			mcp.Required(),
+			mcp.Description("The query to explain, exactly as it would be passed to query_nostr_data"),
+		),
+		mcp.WithNumber("min_score",
+			mcp.Description("Minimum similarity score for a result (default: 0.6)"),
+		),
+		mcp.WithNumber("num_results",
+			mcp.Description("The number of results to select (default: 3)"),
+		),
+		mcp.WithNumber("max_per_file",
+			mcp.Description("The maximum number of results from a single source file (default: 2, 0 for no limit)"),
+		),
+		mcp.WithString("collections",
+			mcp.Description("Comma-separated collections to search, 'auto' to route by the query (default), or 'all'"),
+		),
+		mcp.WithNumber("max_chars",
+			mcp.Description("Character budget for the returned context"),
+		),
+		mcp.WithString("min_tier",
+			mcp.Description(minTierDescription),
+		),
+		mcp.WithString("repo",
+			mcp.Description(repoFilterDescription),
+		),
+		mcp.WithString("nip",
+			mcp.Description(nipFilterDescription),
+		),
+		mcp.WithString("path",
+			mcp.Description(pathFilterDescription),
+		),
+		mcp.WithString("section",
+			mcp.Description(sectionFilterDescription),
+		),
+		mcp.WithString("mode",
+			mcp.Description(retrievalModeDescription),
+		),
+		mcp.WithBoolean("expand",
+			mcp.Description(expandDescription),
+		),
+		mcp.WithBoolean("rerank",
+			mcp.Description(rerankDescription),
+		),
+		mcp.WithNumber("mmr_lambda",
+			mcp.Description(mmrLambdaDescription),
+		),
+	)
+
+	s.AddTool(debugQueryTool, debugQueryHandler)
+
+	rateResultTool := mcp.NewTool("rate_result",
+		mcp.WithDescription("Rates a chunk returned by query_nostr_data as helpful (up) or not (down) for a query. Ratings are kept locally and nudge the chunk up or down in future rankings, most strongly for the same query."),
+		mcp.WithString("query",
+			mcp.Required(),
+			mcp.Description("The query the chunk was returned for"),
+		),
+		mcp.WithString("chunk_id",
+			mcp.Required(),
+			mcp.Description("The chunk ID shown in the query results"),
+		),
+		mcp.WithString("rating",
+			mcp.Required(),
+			mcp.Description("\"up\" if the chunk answered the query, \"down\" if it did not"),
+			mcp.Enum("up", "down"),
+		),
+	)
+
+	s.AddTool(rateResultTool, rateResultHandler)
+
+	addScratchTool := mcp.NewTool("add_scratch_document",
+		mcp.WithDescription("Adds a markdown or text document, such as a draft NIP, to a scratch collection that only this session sees. query_nostr_data, ask_nostr, and get_chunk then search it together with the documentation, e.g. to review a draft against the existing NIPs. Scratch documents are kept in memory and dropped when the session ends."),
+		mcp.WithString("name",
+			mcp.Required(),
+			mcp.Description("A short name for the document, used in its chunk IDs (scratch/<name>-chunk-<n>); adding a document with the same name replaces it"),
+		),
+		mcp.WithString("content",
+			mcp.Required(),
+			mcp.Description("The document text; markdown headings are used to split it into sections"),
+		),
+	)
+
+	s.AddTool(addScratchTool, addScratchDocumentHandler)
+
+	listScratchTool := mcp.NewTool("list_scratch_documents",
+		mcp.WithDescription("Lists the documents in this session's scratch collection."),
+	)
+
+	s.AddTool(listScratchTool, listScratchDocumentsHandler)
+
+	dropScratchTool := mcp.NewTool("drop_scratch",
+		mcp.WithDescription("Removes a document, or all documents, from this session's scratch collection."),
+		mcp.WithString("name",
+			mcp.Description("The document to remove (default: all of them)"),
+		),
+	)
+
+	s.AddTool(dropScratchTool, dropScratchHandler)
+
+	reviewDraftTool := mcp.NewTool("review_draft_nip",
+		mcp.WithDescription("Reviews a draft NIP against the indexed NIPs: lists the existing NIPs it is closest to, the event kinds and tags it shares with registered ones, and where it departs from the structure and conventions of the NIPs repository."),
+		mcp.WithString("content",
+			mcp.Required(),
+			mcp.Description("The markdown of the draft NIP"),
+		),
+		mcp.WithNumber("num_related",
+			mcp.Description("Number of related NIPs to list (default: 5)"),
+		),
+	)
+
+	s.AddTool(reviewDraftTool, reviewDraftNipHandler)
+
+	checkKindTool := mcp.NewTool("check_kind_available",
+		mcp.WithDescription("Checks whether an event kind number is free to use in a new NIP: whether the NIPs README registers it or a range containing it, which indexed documents mention it, and whether relays already hold events of that kind, with a sample of who uses it and with which tags."),
+		mcp.WithNumber("kind",
+			mcp.Required(),
+			mcp.Description("The proposed kind number (0-65535)"),
+		),
+		mcp.WithBoolean("check_relays",
+			mcp.Description("Ask relays for events of the kind with COUNT and a sample (default: true)"),
+		),
+	)
+
+	s.AddTool(checkKindTool, checkKindAvailableHandler)
+
+	tagExamplesTool := mcp.NewTool("tag_examples",
+		mcp.WithDescription("Shows how a tag is used in practice: its definition from the standardized tags table next to recent real events from relays that carry it, sampled across kinds. Examples are anonymized: IDs, authors, signatures, and timestamps are removed, keys and identifiers are replaced with placeholders, and long values are shortened."),
+		mcp.WithString("tag",
+			mcp.Required(),
+			mcp.Description("The tag name, e.g. \"t\", \"a\", or \"relay\""),
+		),
+		mcp.WithString("value",
+			mcp.Description("Only show events where the tag has this value"),
+		),
+		mcp.WithString("kinds",
+			mcp.Description("Comma-separated kinds to sample (default: the kinds of the NIPs that define the tag)"),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description("Maximum number of examples (default: 6)"),
+		),
+	)
+
+	s.AddTool(tagExamplesTool, tagExamplesHandler)
+
+	eventSchemaTool := mcp.NewTool("event_schema",
+		mcp.WithDescription("Generates a JSON Schema, TypeScript interface, or Go struct for the events of a kind. The local LLM derives it from the specification text of the NIPs that define the kind and quotes the spec lines it used; each quote is checked against the retrieved text, and quotes that cannot be found are flagged."),
+		mcp.WithNumber("kind",
+			mcp.Required(),
+			mcp.Description("The event kind"),
+		),
+		mcp.WithString("format",
+			mcp.Description("Output format (default: json-schema)"),
+			mcp.Enum("json-schema", "typescript", "go"),
+		),
+	)
+
+	s.AddTool(eventSchemaTool, eventSchemaHandler)
+
+	generateCodeTool := mcp.NewTool("generate_code",
+		mcp.WithDescription("Writes example code for a Nostr task with a given library, using the local LLM. The code is grounded in the NIP sections retrieved for the task and in cached code snippets that use the library, and cites both."),
+		mcp.WithString("task",
+			mcp.Required(),
+			mcp.Description("What the code should do, e.g. \"subscribe to kind 30023 by author\""),
+		),
+		mcp.WithString("library",
+			mcp.Required(),
+			mcp.Description("The library to use"),
+			mcp.Enum("go-nostr", "ndk", "nostr-tools"),
+		),
+	)
+
+	s.AddTool(generateCodeTool, generateCodeHandler)
+
+	flowDiagramTool := mcp.NewTool("flow_diagram",
+		mcp.WithDescription("Draws a protocol flow, such as the NIP-46 connect handshake or the NIP-57 zap flow, as a Mermaid sequence diagram generated by the local LLM from the retrieved specification text. Returns a fenced mermaid block that clients can render."),
+		mcp.WithString("flow",
+			mcp.Required(),
+			mcp.Description("The flow to draw, e.g. \"NIP-57 zap flow\" or \"NIP-46 remote signer connect\""),
+		),
+	)
+
+	s.AddTool(flowDiagramTool, flowDiagramHandler)
+
+	getChunkTool := mcp.NewTool("get_chunk",
+		mcp.WithDescription("Retrieves a stored documentation chunk, and optionally its neighbors, by the ID returned in query results."),
+		mcp.WithString("id",
+			mcp.Required(),
+			mcp.Description("The chunk ID as shown in query_nostr_data results"),
+		),
+		mcp.WithNumber("neighbors",
+			mcp.Description("The number of neighboring chunks from the same file to include on each side (default: 1)"),
+		),
+	)
+
+	s.AddTool(getChunkTool, getChunkHandler)
+
+	listChunksTool := mcp.NewTool("list_chunks",
+		mcp.WithDescription("Lists the stored chunks page by page with their metadata: ID, repository, source file, position in the file, collection, trust tier, section headers, and event kinds mentioned. Returns JSON, for browsing the indexed corpus."),
+		mcp.WithString("repo",
+			mcp.Description("Only list chunks from this repository"),
+		),
+		mcp.WithString("collection",
+			mcp.Description("Only list chunks in this collection"),
+		),
+		mcp.WithNumber("offset",
+			mcp.Description("The number of chunks to skip; use the next value of the previous page (default: 0)"),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description("The number of chunks per page (default: 50, at most 500)"),
+		),
+		mcp.WithBoolean("include_text",
+			mcp.Description("Include the text of each chunk (default: false)"),
+		),
+	)
+
+	s.AddTool(listChunksTool, listChunksHandler)
+
+	getFileChunksTool := mcp.NewTool("get_file_chunks",
+		mcp.WithDescription("Returns the chunks of one source file in order, with their metadata and text, page by page as JSON."),
+		mcp.WithString("file",
+			mcp.Required(),
+			mcp.Description("The source file as shown in list_chunks results, e.g. nips/01"),
+		),
+		mcp.WithNumber("offset",
+			mcp.Description("The number of chunks to skip (default: 0)"),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description("The number of chunks per page (default: 50, at most 500)"),
+		),
+	)
+
+	s.AddTool(getFileChunksTool, getFileChunksHandler)
+
+	askTool := mcp.NewTool("ask_nostr",
+		mcp.WithDescription("Answers a question about the Nostr protocol using a local LLM grounded in the retrieved documentation. The answer is streamed as progress notifications when the client provides a progress token."),
+		mcp.WithString("query",
+			mcp.Required(),
+			mcp.Description("The question to answer. May include the same filters as query_nostr_data"),
+		),
+		mcp.WithNumber("similarity",
+			mcp.Description("The similarity threshold for retrieving documents (0.0 to 1.0)"),
+		),
+		mcp.WithNumber("num_results",
+			mcp.Description("The number of documents to give the model as context"),
+		),
+		mcp.WithString("language",
+			mcp.Description("The language to answer in (default: the language the question is written in)"),
+		),
+		mcp.WithString("min_tier",
+			mcp.Description(minTierDescription),
+		),
+		mcp.WithBoolean("expand",
+			mcp.Description(expandDescription),
+		),
+		mcp.WithBoolean("rerank",
+			mcp.Description(rerankDescription),
+		),
+		mcp.WithNumber("mmr_lambda",
+			mcp.Description(mmrLambdaDescription),
+		),
+	)
+
+	s.AddTool(askTool, askNostrHandler)
+
 	eventKindsResource := mcp.NewResource(
 		"nostr://event-kinds",
 		"Nostr Event Kinds",
@@ -82,27 +428,90 @@ func StartMCPServer() error {
 	)
 	s.AddResource(standardTagsResource, standardTagsResourceHandler)
 
+	nipsIndexResource := mcp.NewResource(
+		"nostr://nips",
+		"NIPs Index",
+		mcp.WithResourceDescription("List of all NIPs with their numbers and titles"),
+		mcp.WithMIMEType("text/markdown"),
+	)
+	s.AddResource(nipsIndexResource, nipsIndexResourceHandler)
+
+	corpusResource := mcp.NewResource(
+		"nostr://corpus",
+		"Corpus Manifest",
+		mcp.WithResourceDescription("The repositories this server has indexed, with the ingested commit and its date, ingestion time, file and chunk counts, and notes on incomplete coverage"),
+		mcp.WithMIMEType("text/markdown"),
+	)
+	s.AddResource(corpusResource, corpusResourceHandler)
+
+	kindRegistryResource := mcp.NewResource(
+		"nostr://kind-registry",
+		"Nostr Kind Registry",
+		mcp.WithResourceDescription("Every NIP, event kind, and standardized tag as JSON, with the NIPs that define each kind and tag"),
+		mcp.WithMIMEType("application/json"),
+	)
+	s.AddResource(kindRegistryResource, kindRegistryResourceHandler)
+
+	listNipsTool := mcp.NewTool("list_nips",
+		mcp.WithDescription("Lists every NIP in the cloned NIPs repository as JSON, with its number, title, file name, and status labels, read from the NIP files themselves."),
+	)
+
+	s.AddTool(listNipsTool, listNipsHandler)
+
 	// Add the code snippets search tool
 	codeSnippetsTool := mcp.NewTool("search_code_snippets",
-		mcp.WithDescription("Searches for code snippets in the Nostr network using kind 1337 events."),
+		mcp.WithDescription("Searches for code in the Nostr network: kind 1337 code snippets, kind 1617 git patches, and kind 30023 long-form articles containing code blocks. Each result is labelled with its source."),
 		mcp.WithString("language",
 			mcp.Description("The programming language to search for (e.g., 'javascript', 'python', 'rust'). Optional but recommended."),
 		),
 		mcp.WithString("author",
 			mcp.Description("Optional author's public key or npub to filter by"),
 		),
+		mcp.WithString("from_follows_of",
+			mcp.Description("Optional npub or public key; only code from the accounts in its contact list (kind 3) is returned"),
+		),
 		mcp.WithString("query",
 			mcp.Description("Optional search query to match against name, description, license, runtime, etc."),
 		),
 		mcp.WithNumber("limit",
 			mcp.Description("Maximum number of code snippets to return (default: 10)"),
 		),
+		mcp.WithNumber("max_snippet_length",
+			mcp.Description("Maximum characters of code per snippet (default: 4000, 0 for no limit). Long snippets keep their imports and the region matching the query"),
+		),
+		mcp.WithString("id",
+			mcp.Description("Event ID (hex, note, or nevent) of a single snippet to fetch, e.g. one that was truncated"),
+		),
+		mcp.WithBoolean("full",
+			mcp.Description("Return the complete content without truncation; use with id"),
+		),
+		mcp.WithString("group_by",
+			mcp.Description("Group results by 'language' or 'author', with a count for each group"),
+		),
 	)
 
 	s.AddTool(codeSnippetsTool, searchCodeSnippetsHandler)
 
-	// fmt.Println("Starting MCP server for Nostr RAG system...")
-	return server.ServeStdio(s)
+	relayHealthTool := mcp.NewTool("relay_health",
+		mcp.WithDescription("Checks the configured relays concurrently and reports connect latency, NIP-11 availability, and whether a test subscription reaches EOSE."),
+		mcp.WithString("relays",
+			mcp.Description("Optional comma-separated relay URLs to check instead of the configured relays"),
+		),
+	)
+
+	s.AddTool(relayHealthTool, relayHealthHandler)
+
+	// Add the server status tool
+	statusTool := mcp.NewTool("server_status",
+		mcp.WithDescription("Reports the size of the search index and code snippet cache, whether the index is held in memory, the progress of a running ingest, and the server's memory use."),
+	)
+	s.AddTool(statusTool, serverStatusHandler)
+
+	if admin {
+		registerAdminTools(s)
+	}
+
+	return s
 }
 
 func queryNostrDataHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -117,64 +526,278 @@ func queryNostrDataHandler(ctx context.Context, request mcp.CallToolRequest) (*m
 		similarity = sim
 	}
 
+	if minScore, ok := request.Params.Arguments["min_score"].(float64); ok {
+		similarity = minScore
+	}
+
 	numResults := 3
 	if num, ok := request.Params.Arguments["num_results"].(float64); ok {
 		numResults = int(num)
 	}
 
-	queryWithPrefix := fmt.Sprintf("search_query: %s", query)
-	queryEmbedding, err := embeddings.CreateEmbedding(
-		ollamaURL,
-		llm.Query4Embedding{
-			Model:  embeddingModel,
-			Prompt: queryWithPrefix,
-		},
-		"query",
-	)
+	maxPerFile := defaultMaxPerFile
+	if num, ok := request.Params.Arguments["max_per_file"].(float64); ok {
+		maxPerFile = int(num)
+	}
+
+	maxChars := 0
+	if num, ok := request.Params.Arguments["max_tokens"].(float64); ok {
+		maxChars = int(num) * charsPerToken
+	}
+	if num, ok := request.Params.Arguments["max_chars"].(float64); ok {
+		maxChars = int(num)
+	}
+
+	debug, _ := request.Params.Arguments["debug"].(bool)
+	includeSnippets, _ := request.Params.Arguments["include_snippets"].(bool)
+
+	collections, _ := request.Params.Arguments["collections"].(string)
+
+	allowed, err := tenantCollections(ctx, parseCollections(collections, query))
+	if err != nil {
+		return nil, err
+	}
+
+	minTier, err := minTierArgument(request)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := filterArguments(request)
 	if err != nil {
-		return nil, fmt.Errorf("error creating embedding: %v", err)
+		return nil, err
 	}
 
-	similarities, err := globalStore.SearchTopNSimilarities(queryEmbedding, similarity, numResults)
+	mode, err := modeArgument(request)
 	if err != nil {
-		return nil, fmt.Errorf("error searching for similarities: %v", err)
+		return nil, err
 	}
 
-	if len(similarities) == 0 {
-		return mcp.NewToolResultText("No similar documents found"), nil
+	lambda, err := mmrLambdaArgument(request)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := searchOptions{
+		Threshold:   similarity,
+		NumResults:  numResults,
+		MaxPerFile:  maxPerFile,
+		Collections: allowed,
+		MinTier:     minTier,
+		Filter:      filter,
+		MMRLambda:   lambda,
+	}
+
+	index, release := acquireIndex()
+	defer release()
+	reader := sessionReader(ctx, index)
+	candidates, err := retrieveWithMode(ctx, reader, query, mode, expandArgument(request))
+	if err != nil {
+		return nil, err
 	}
+	selected, _ := rerankResults(query, candidates, opts, rerankArgument(request))
+	results := applyContextBudget(selected, maxChars)
+	recordQueryStats(query, candidates, results)
 
-	context := embeddings.GenerateContextFromSimilarities(similarities)
+	explanation := ""
+	if debug {
+		explanation = explainSearch(reader.Metric(), candidates, opts) + "\n"
+	}
 
-	return mcp.NewToolResultText(context), nil
+	if len(results) == 0 {
+		return mcp.NewToolResultText(explanation + "No similar documents found"), nil
+	}
+
+	context := formatResults(results)
+	if includeSnippets && tierAllowed(tierSnippet, minTier) {
+		context += formatLinkedSnippets(findLinkedSnippets(results, defaultLinkedSnippets))
+	}
+	context += stalenessNote(index.corpus, results)
+
+	return mcp.NewToolResultText(explanation + context), nil
 }
 
-func eventKindsResourceHandler(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
-	// Find the nips repository in repos
-	var nipsRepo RepoConfig
+func askNostrHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	query, ok := request.Params.Arguments["query"].(string)
+	if !ok || query == "" {
+		return nil, errors.New("query must be a non-empty string")
+	}
+
+	opts := searchOptions{
+		Threshold:  0.6,
+		NumResults: 3,
+		MaxPerFile: defaultMaxPerFile,
+	}
+	if sim, ok := request.Params.Arguments["similarity"].(float64); ok {
+		opts.Threshold = sim
+	}
+	if num, ok := request.Params.Arguments["num_results"].(float64); ok {
+		opts.NumResults = int(num)
+	}
+	allowed, err := tenantCollections(ctx, routeQuery(query))
+	if err != nil {
+		return nil, err
+	}
+	opts.Collections = allowed
+	if opts.MinTier, err = minTierArgument(request); err != nil {
+		return nil, err
+	}
+	if opts.MMRLambda, err = mmrLambdaArgument(request); err != nil {
+		return nil, err
+	}
+
+	index, release := acquireIndex()
+	defer release()
+	candidates, err := retrieveWithMode(ctx, sessionReader(ctx, index), query, retrievalMode, expandArgument(request))
+	if err != nil {
+		return nil, err
+	}
+	results, _ := rerankResults(query, candidates, opts, rerankArgument(request))
+	recordQueryStats(query, candidates, results)
+
+	if reply, refuse := insufficientContext(candidates, results); refuse {
+		return mcp.NewToolResultText(reply), nil
+	}
+
+	language, _ := request.Params.Arguments["language"].(string)
+
+	answer, err := generateAnswer(query, language, results, progressStreamer(ctx, request))
+	if err != nil {
+		return nil, err
+	}
+
+	return mcp.NewToolResultText(answer + stalenessNote(index.corpus, results)), nil
+}
+
+// progressStreamer returns a callback that forwards generated text to the
+// client as progress notifications, or nil if the client did not send a
+// progress token with its request
+func progressStreamer(ctx context.Context, request mcp.CallToolRequest) func(string) error {
+	if request.Params.Meta == nil || request.Params.Meta.ProgressToken == nil {
+		return nil
+	}
+	srv := server.ServerFromContext(ctx)
+	if srv == nil {
+		return nil
+	}
+
+	token := request.Params.Meta.ProgressToken
+	progress := 0
+	return func(text string) error {
+		if text == "" {
+			return nil
+		}
+		progress++
+		// A failed notification should not abort generation; the full
+		// answer is still returned in the tool result
+		_ = srv.SendNotificationToClient(ctx, "notifications/progress", map[string]any{
+			"progressToken": token,
+			"progress":      progress,
+			"message":       text,
+		})
+		return ctx.Err()
+	}
+}
+
+func getChunkHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	id, ok := request.Params.Arguments["id"].(string)
+	if !ok || id == "" {
+		return nil, errors.New("id must be a non-empty string")
+	}
+
+	neighbors := 1
+	if num, ok := request.Params.Arguments["neighbors"].(float64); ok {
+		neighbors = int(num)
+	}
+
+	if !tenantCanRead(ctx, id) {
+		return nil, fmt.Errorf("chunk %s not found", id)
+	}
+
+	index, release := acquireIndex()
+	defer release()
+	chunks, err := getChunkWithNeighbors(sessionReader(ctx, index), id, neighbors)
+	if err != nil {
+		return nil, err
+	}
+
+	return mcp.NewToolResultText(formatChunks(chunks, id)), nil
+}
+
+// findNipsRepo returns the enabled NIPs repository: the one marked with the
+// "nips" role, then one named "nips", then any clone that looks like a NIPs
+// repository, so forks and mirrors under other names work too
+func findNipsRepo() (RepoConfig, error) {
 	for _, repo := range repos {
-		if repo.Name == "nips" && repo.Enabled {
-			nipsRepo = repo
-			break
+		if repo.Enabled && repo.Role == roleNips {
+			return repo, nil
 		}
 	}
+	for _, repo := range repos {
+		if repo.Enabled && repo.Name == "nips" {
+			return repo, nil
+		}
+	}
+	for _, repo := range repos {
+		if repo.Enabled && looksLikeNipsRepo(repo.CloneDir) {
+			return repo, nil
+		}
+	}
+	return RepoConfig{}, fmt.Errorf("NIPs repository not found or not enabled; mark it with \"Role\": \"nips\" in %s", configFile)
+}
 
-	if nipsRepo.CloneDir == "" {
-		return nil, fmt.Errorf("NIPs repository not found or not enabled")
+// looksLikeNipsRepo reports whether a clone has the layout of the NIPs
+// repository: numbered NIP files and a README that indexes them
+func looksLikeNipsRepo(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, "01.md")); err != nil {
+		return false
+	}
+	content, err := readCloneFile(dir, "README.md")
+	if err != nil {
+		return false
+	}
+	return len(extractNipIndex(string(content))) > 0
+}
+
+// readNipsReadme returns the README of the enabled NIPs repository
+func readNipsReadme() (string, error) {
+	nipsRepo, err := findNipsRepo()
+	if err != nil {
+		return "", err
 	}
 
 	readmePath := filepath.Join(nipsRepo.CloneDir, "README.md")
 
 	if _, err := os.Stat(readmePath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("NIPs repository README not found at %s", readmePath)
+		return "", fmt.Errorf("NIPs repository README not found at %s", readmePath)
 	}
 
-	content, err := os.ReadFile(readmePath)
+	content, err := readCloneFile(nipsRepo.CloneDir, "README.md")
 	if err != nil {
-		return nil, fmt.Errorf("error reading README: %v", err)
+		return "", fmt.Errorf("error reading README: %v", err)
 	}
 
-	eventKindsSection := extractSection(string(content), "## Event Kinds", "##")
+	return string(content), nil
+}
+
+func eventKindsResourceHandler(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	if registry := currentIndex().registry; registry != nil {
+		return []mcp.ResourceContents{
+			mcp.TextResourceContents{
+				URI:      request.Params.URI,
+				MIMEType: "text/markdown",
+				Text:     formatRegistryKinds(registry),
+			},
+		}, nil
+	}
+
+	// Indexes ingested without a registry fall back to the README
+	content, err := readNipsReadme()
+	if err != nil {
+		return nil, err
+	}
+
+	eventKindsSection := extractSection(content, "Event Kinds")
 	if eventKindsSection == "" {
 		return nil, errors.New("event kinds section not found in README")
 	}
@@ -191,31 +814,22 @@ func eventKindsResourceHandler(ctx context.Context, request mcp.ReadResourceRequ
 }
 
 func standardTagsResourceHandler(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
-	// Find the nips repository in repos
-	var nipsRepo RepoConfig
-	for _, repo := range repos {
-		if repo.Name == "nips" && repo.Enabled {
-			nipsRepo = repo
-			break
-		}
-	}
-
-	if nipsRepo.CloneDir == "" {
-		return nil, fmt.Errorf("NIPs repository not found or not enabled")
-	}
-
-	readmePath := filepath.Join(nipsRepo.CloneDir, "README.md")
-
-	if _, err := os.Stat(readmePath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("NIPs repository README not found at %s", readmePath)
+	if registry := currentIndex().registry; registry != nil && len(registry.Tags) > 0 {
+		return []mcp.ResourceContents{
+			mcp.TextResourceContents{
+				URI:      request.Params.URI,
+				MIMEType: "text/markdown",
+				Text:     formatRegistryTags(registry),
+			},
+		}, nil
 	}
 
-	content, err := os.ReadFile(readmePath)
+	content, err := readNipsReadme()
 	if err != nil {
-		return nil, fmt.Errorf("error reading README: %v", err)
+		return nil, err
 	}
 
-	tagsSection := extractSection(string(content), "## Standardized Tags", "##")
+	tagsSection := extractSection(content, "Standardized Tags")
 	if tagsSection == "" {
 		return nil, errors.New("standardized tags section not found in README")
 	}
@@ -231,13 +845,71 @@ func standardTagsResourceHandler(ctx context.Context, request mcp.ReadResourceRe
 	}, nil
 }
 
+func nipsIndexResourceHandler(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	var entries []nipEntry
+	if registry := currentIndex().registry; registry != nil {
+		entries = registry.NIPs
+	} else {
+		content, err := readNipsReadme()
+		if err != nil {
+			return nil, err
+		}
+		entries = extractNipIndex(content)
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("no NIPs found in README")
+	}
+
+	var result strings.Builder
+	result.WriteString("# Nostr Implementation Possibilities\n\n")
+	for _, entry := range entries {
+		result.WriteString(fmt.Sprintf("- NIP-%s: %s (%s)\n", entry.Number, entry.Title, entry.File))
+	}
+
+	return []mcp.ResourceContents{
+		mcp.TextResourceContents{
+			URI:      request.Params.URI,
+			MIMEType: "text/markdown",
+			Text:     result.String(),
+		},
+	}, nil
+}
+
+func listNipsHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	var entries []nipEntry
+	if registry := currentIndex().registry; registry != nil {
+		entries = registry.NIPs
+	} else {
+		nipsRepo, err := findNipsRepo()
+		if err != nil {
+			return nil, err
+		}
+
+		entries, err = scanNips(nipsRepo.CloneDir)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning NIPs repository: %v", err)
+		}
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error serializing NIPs index: %v", err)
+	}
+
+	return mcp.NewToolResultText(string(data)), nil
+}
+
+func serverStatusHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return mcp.NewToolResultText(formatServerStatus()), nil
+}
+
 // populateCodeSnippetCache fetches code snippets from relays and stores them in memory
 func populateCodeSnippetCache() {
 	// Run initial population
 	updateCodeSnippetCache()
 
-	// Set up ticker to refresh cache periodically (every 30 minutes)
-	ticker := time.NewTicker(30 * time.Minute)
+	// Set up ticker to refresh cache periodically
+	ticker := time.NewTicker(snippetRefreshInterval)
 	defer ticker.Stop()
 
 	for range ticker.C {
@@ -248,112 +920,171 @@ func populateCodeSnippetCache() {
 // updateCodeSnippetCache refreshes the code snippet cache with events from relays
 func updateCodeSnippetCache() {
 	// fmt.Println("Updating code snippet cache...")
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	// List of relays to connect to
-	relays := []string{
-		"wss://relay.damus.io",
-		"wss://relay.nostr.band",
-		"wss://nos.lol",
-		"wss://relay.snort.social",
+	if relaysUnavailable() {
+		return
 	}
-
-	// Create a filter for all code snippets (kind 1337)
-	filter := nostr.Filter{
-		Kinds: []int{1337}, // Code snippet kind
-		Limit: 500,        // Get a good number of snippets
+	if eventsFile != "" {
+		updateCodeSnippetCacheFromFile()
+		return
 	}
 
-	// Collect events from relays
-	var newEvents []*nostr.Event
-	for _, url := range relays {
-		relay, err := nostr.RelayConnect(ctx, url)
-		if err != nil {
-			// fmt.Printf("Cache update: Failed to connect to relay %s: %v\n", url, err)
-			continue
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), snippetFetchTimeout)
+	defer cancel()
 
-		// Subscribe to the relay with our filter
-		sub, err := relay.Subscribe(ctx, []nostr.Filter{filter})
-		if err != nil {
-			// fmt.Printf("Cache update: Failed to subscribe to relay %s: %v\n", url, err)
-			relay.Close()
-			continue
-		}
+	// List of relays to connect to, healthiest first
+	relays := rankRelays(cacheRelays)
 
-		// Collect events from this relay
-		for ev := range sub.Events {
-			newEvents = append(newEvents, ev)
-		}
+	// Create a filter for all code-bearing events
+	filters := snippetFilters("", nil, snippetFetchLimit) // Get a good number of snippets
 
-		// Close the subscription and relay connection
-		sub.Unsub()
-		relay.Close()
+	// Collect code-bearing events from all relays at once
+	newEvents := fetchFromRelays(ctx, relays, filters, relayDeadline, 0, isCodeBearing)
+
+	if err := saveRelayScores(); err != nil {
+		fmt.Printf("Failed to save relay scores: %v\n", err)
 	}
 
 	// Update the cache with new events
 	if len(newEvents) > 0 {
+		newEvents, dropped := capSnippetEvents(newEvents)
 		codeSnippetCache.mutex.Lock()
 		codeSnippetCache.events = newEvents
+		codeSnippetCache.dropped = dropped
 		codeSnippetCache.lastUpdate = time.Now()
 		codeSnippetCache.mutex.Unlock()
 		// fmt.Printf("Code snippet cache updated with %d events\n", len(newEvents))
+
+		if err := saveSnippetCache(); err != nil {
+			fmt.Printf("Failed to save snippet cache: %v\n", err)
+		}
 	} else {
 		fmt.Println("No new code snippets found for cache update")
 	}
 }
 
+// updateCodeSnippetCacheFromFile fills the cache with every code-bearing
+// event in the events file
+func updateCodeSnippetCacheFromFile() {
+	events, err := loadEventsFile(eventsFile, snippetFilters("", nil, 0))
+	if err != nil {
+		fmt.Printf("Failed to read events file: %v\n", err)
+		return
+	}
+
+	var newEvents []*nostr.Event
+	for _, ev := range events {
+		if isCodeBearing(ev) {
+			newEvents = append(newEvents, ev)
+		}
+	}
+
+	newEvents, dropped := capSnippetEvents(newEvents)
+	codeSnippetCache.mutex.Lock()
+	codeSnippetCache.events = newEvents
+	codeSnippetCache.dropped = dropped
+	codeSnippetCache.lastUpdate = time.Now()
+	codeSnippetCache.mutex.Unlock()
+}
+
 // searchCodeSnippetsHandler handles requests to search for code snippets in the Nostr network
 func searchCodeSnippetsHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	// Extract parameters from the request
 	language, _ := request.Params.Arguments["language"].(string)
 	author, _ := request.Params.Arguments["author"].(string)
+	followsOf, _ := request.Params.Arguments["from_follows_of"].(string)
 	query, _ := request.Params.Arguments["query"].(string)
 
+	id, _ := request.Params.Arguments["id"].(string)
+	groupBy, _ := request.Params.Arguments["group_by"].(string)
+	if groupBy != "" && groupBy != groupByLanguage && groupBy != groupByAuthor {
+		return nil, fmt.Errorf("invalid group_by %q: expected '%s' or '%s'", groupBy, groupByLanguage, groupByAuthor)
+	}
+
 	// Default limit to 10 if not specified
 	limit := 10
 	if limitVal, ok := request.Params.Arguments["limit"].(float64); ok {
 		limit = int(limitVal)
 	}
 
+	maxLength := defaultMaxSnippetLength
+	if maxVal, ok := request.Params.Arguments["max_snippet_length"].(float64); ok {
+		maxLength = int(maxVal)
+	}
+	if full, ok := request.Params.Arguments["full"].(bool); ok && full {
+		maxLength = 0
+	}
+
+	if relaysUnavailable() {
+		return mcp.NewToolResultText(offlineNotice("Searching code snippets")), nil
+	}
+
+	// Fetch a single snippet by ID
+	if id != "" {
+		ev, err := findSnippetByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return formatCodeSnippetResults([]*nostr.Event{ev}, language, author, query, 1, maxLength, groupBy)
+	}
+
 	// Ensure we have at least one search parameter
-	if language == "" && author == "" && query == "" {
-		return nil, errors.New("at least one of 'language', 'author', or 'query' must be provided")
+	if language == "" && author == "" && followsOf == "" && query == "" {
+		return nil, errors.New("at least one of 'language', 'author', 'from_follows_of', 'query', or 'id' must be provided")
 	}
 
-	// Process author if provided (convert npub to hex if needed)
-	if author != "" && strings.HasPrefix(author, "npub") {
-		_, decodedAuthor, err := nip19.Decode(author)
-		if err == nil {
-			author = decodedAuthor.(string)
+	// Validate the author if provided (converting npub or nprofile to hex)
+	var authors []string
+	if author != "" {
+		hex, err := parseAuthor(author)
+		if err != nil {
+			return nil, err
+		}
+		author = hex
+		authors = []string{hex}
+	}
+
+	// Restrict the search to the accounts a user follows
+	if followsOf != "" {
+		hex, err := parseAuthor(followsOf)
+		if err != nil {
+			return nil, fmt.Errorf("from_follows_of: %v", err)
+		}
+		follows, err := fetchFollows(ctx, hex)
+		if err != nil {
+			return nil, err
+		}
+		if author != "" {
+			if !contains(follows, author) {
+				return mcp.NewToolResultText("The author is not in the contact list of from_follows_of."), nil
+			}
 		} else {
-			fmt.Printf("Failed to decode npub %s: %v\n", author, err)
+			authors = follows
+			author = fmt.Sprintf("followed by %s", followsOf)
 		}
 	}
 
 	// First try to find events in the cache
-	cachedEvents := searchCachedEvents(language, author, query, limit)
+	cachedEvents := searchCachedEvents(language, authors, query, limit)
 	
 	// If we found enough events in the cache, return them
 	if len(cachedEvents) >= limit {
-		return formatCodeSnippetResults(cachedEvents, language, author, query, limit)
+		return formatCodeSnippetResults(cachedEvents, language, author, query, limit, maxLength, groupBy)
 	}
 	
 	// If cache is empty or doesn't have enough results, fall back to live relay search
 	if len(cachedEvents) == 0 {
 		// Special case for query-only searches
-		if language == "" && author == "" && query != "" {
+		if language == "" && len(authors) == 0 && query != "" {
 			relayEvents := searchByQueryOnly(ctx, query, limit)
-			return formatCodeSnippetResults(relayEvents, language, author, query, limit)
+			return formatCodeSnippetResults(relayEvents, language, author, query, limit, maxLength, groupBy)
 		}
 		
-		relayEvents := searchRelayEvents(ctx, language, author, query, limit)
-		return formatCodeSnippetResults(relayEvents, language, author, query, limit)
+		relayEvents := searchRelayEvents(ctx, language, authors, query, limit)
+		return formatCodeSnippetResults(relayEvents, language, author, query, limit, maxLength, groupBy)
 	} else {
 		// We have some results from cache but not enough, so get more from relays
 		neededEvents := limit - len(cachedEvents)
-		relayEvents := searchRelayEvents(ctx, language, author, query, neededEvents)
+		relayEvents := searchRelayEvents(ctx, language, authors, query, neededEvents)
 		
 		// Combine cache and relay results
 		combinedEvents := append(cachedEvents, relayEvents...)
@@ -361,12 +1092,12 @@ func searchCodeSnippetsHandler(ctx context.Context, request mcp.CallToolRequest)
 			combinedEvents = combinedEvents[:limit]
 		}
 		
-		return formatCodeSnippetResults(combinedEvents, language, author, query, limit)
+		return formatCodeSnippetResults(combinedEvents, language, author, query, limit, maxLength, groupBy)
 	}
 }
 
 // searchCachedEvents searches the in-memory cache for matching code snippets
-func searchCachedEvents(language, author, query string, limit int) []*nostr.Event {
+func searchCachedEvents(language string, authors []string, query string, limit int) []*nostr.Event {
 	// Lock for reading from cache
 	codeSnippetCache.mutex.RLock()
 	defer codeSnippetCache.mutex.RUnlock()
@@ -380,21 +1111,12 @@ func searchCachedEvents(language, author, query string, limit int) []*nostr.Even
 	var matchingEvents []*nostr.Event
 	for _, ev := range codeSnippetCache.events {
 		// Check language filter
-		if language != "" {
-			langMatch := false
-			for _, tag := range ev.Tags {
-				if len(tag) >= 2 && tag[0] == "l" && strings.EqualFold(tag[1], language) {
-					langMatch = true
-					break
-				}
-			}
-			if !langMatch {
-				continue
-			}
+		if !snippetMatchesLanguage(ev, language) {
+			continue
 		}
 		
 		// Check author filter
-		if author != "" && ev.PubKey != author {
+		if len(authors) > 0 && !contains(authors, ev.PubKey) {
 			continue
 		}
 		
@@ -414,86 +1136,64 @@ func searchCachedEvents(language, author, query string, limit int) []*nostr.Even
 }
 
 // searchRelayEvents searches live relays for matching code snippets
-func searchRelayEvents(ctx context.Context, language, author, query string, limit int) []*nostr.Event {
+func searchRelayEvents(ctx context.Context, language string, authors []string, query string, limit int) []*nostr.Event {
 	// If we have a query but no language or author, use a more general approach
-	if query != "" && language == "" && author == "" {
+	if query != "" && language == "" && len(authors) == 0 {
 		return searchByQueryOnly(ctx, query, limit)
 	}
 	
-	// List of relays to connect to
-	relays := []string{
-		"wss://relay.damus.io",
-		"wss://purplepag.es",
-		"wss://relay.current.fyi",
-		"wss://relay.nostr.band",
-		"wss://nos.lol",
-		"wss://relay.snort.social",
-	}
+	// List of relays to connect to, healthiest first
+	relays := rankRelays(searchRelays)
 
-	// Create a filter for code snippets (kind 1337)
-	filter := nostr.Filter{
-		Kinds: []int{1337}, // Code snippet kind
-		Limit: limit,
-	}
+	// Create filters for code-bearing events, narrowed by language and author
+	filters := snippetFilters(language, authors, limit)
 
-	// Add language filter if provided
-	if language != "" {
-		filter.Tags = map[string][]string{"l": {strings.ToLower(language)}}
+	// Narrow searches often match nothing, so skip relays whose COUNT says so
+	if len(authors) > 0 {
+		relays = relaysWithMatches(ctx, relays, filters)
 	}
 
-	// Add author filter if provided
-	if author != "" {
-		filter.Authors = []string{author}
-	}
-
-	// Connect to relays and collect events
-	var events []*nostr.Event
-	for _, url := range relays {
-		relay, err := nostr.RelayConnect(ctx, url)
-		if err != nil {
-			fmt.Printf("Failed to connect to relay %s: %v\n", url, err)
-			continue
-		}
-
-		// Set a timeout for subscription - use a longer timeout to ensure we get results
-		subCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
-		defer cancel()
-
-		// Subscribe to the relay with our filters
-		sub, err := relay.Subscribe(subCtx, []nostr.Filter{filter})
-		if err != nil {
-			fmt.Printf("Failed to subscribe to relay %s: %v\n", url, err)
-			continue
+	// Query all relays concurrently and keep matching events
+	events := fetchFromRelays(ctx, relays, filters, relayDeadline, limit, func(ev *nostr.Event) bool {
+		if !isCodeBearing(ev) || !snippetMatchesLanguage(ev, language) {
+			return false
 		}
 
-		// Collect events from this relay
-		for ev := range sub.Events {
-			// Apply additional filtering based on query if provided
-			if query == "" || matchesQuery(ev, query) {
-				events = append(events, ev)
-			}
+		// Apply additional filtering based on query if provided
+		return query == "" || matchesQuery(ev, query)
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	
+	return events
+}
 
-			// Break if we've reached our limit
-			if len(events) >= limit {
-				break
+// relayHealthHandler probes relays and reports their health as a table
+func relayHealthHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	if relaysUnavailable() {
+		return mcp.NewToolResultText(offlineNotice("Checking relay health")), nil
+	}
+	urls := configuredRelays()
+	if list, ok := request.Params.Arguments["relays"].(string); ok && strings.TrimSpace(list) != "" {
+		urls = nil
+		for _, url := range strings.Split(list, ",") {
+			if url = strings.TrimSpace(url); url != "" {
+				urls = append(urls, url)
 			}
 		}
+	}
 
-		// Close the subscription
-		sub.Unsub()
-		relay.Close()
-
-		// If we've collected enough events, stop connecting to more relays
-		if len(events) >= limit {
-			break
-		}
+	results := checkRelays(ctx, urls)
+	if err := saveRelayScores(); err != nil {
+		fmt.Printf("Failed to save relay scores: %v\n", err)
 	}
-	
-	return events
+
+	return mcp.NewToolResultText(formatRelayHealth(results)), nil
 }
 
 // formatCodeSnippetResults formats the code snippet events into a readable result
-func formatCodeSnippetResults(events []*nostr.Event, language, author, query string, limit int) (*mcp.CallToolResult, error) {
+func formatCodeSnippetResults(events []*nostr.Event, language, author, query string, limit, maxLength int, groupBy string) (*mcp.CallToolResult, error) {
 	// Format the results
 	if len(events) == 0 {
 		return mcp.NewToolResultText("No code snippets found matching the criteria."), nil
@@ -513,51 +1213,139 @@ func formatCodeSnippetResults(events []*nostr.Event, language, author, query str
 		result.WriteString(fmt.Sprintf("Found %d code snippets matching query '%s':\n\n", len(events), query))
 	}
 
-	for i, ev := range events {
-		// Extract tags for display
-		// Check for 'name' tag first, then 'f' tag as fallback, then default to "Unnamed Snippet"
-		snippetName := getTagValue(ev, "name", "")
-		if snippetName == "" {
-			snippetName = getTagValue(ev, "f", "Unnamed Snippet")
-		}
-		
-		snippetExt := getTagValue(ev, "extension", "")
-		snippetDesc := getTagValue(ev, "description", "No description provided")
-		snippetRuntime := getTagValue(ev, "runtime", "")
-		snippetLicense := getTagValue(ev, "license", "")
-		
-		// Get language from tag if not provided in search
-		snippetLang := language
-		if snippetLang == "" {
-			snippetLang = getTagValue(ev, "l", "text")
+	if groupBy != "" {
+		index := 1
+		for _, group := range groupSnippets(events, groupBy) {
+			result.WriteString(fmt.Sprintf("# %s (%d)\n\n", group.Name, len(group.Events)))
+			for _, ev := range group.Events {
+				writeCodeSnippet(&result, index, ev, language, query, maxLength)
+				index++
+			}
 		}
+		return mcp.NewToolResultText(result.String()), nil
+	}
 
-		// Format the snippet metadata
-		result.WriteString(fmt.Sprintf("## Snippet %d: %s\n", i+1, snippetName))
-		result.WriteString(fmt.Sprintf("**Description:** %s\n", snippetDesc))
-		
-		// Add additional metadata if available
-		if snippetExt != "" {
-			result.WriteString(fmt.Sprintf("**Extension:** %s\n", snippetExt))
-		}
-		if snippetRuntime != "" {
-			result.WriteString(fmt.Sprintf("**Runtime:** %s\n", snippetRuntime))
-		}
-		if snippetLicense != "" {
-			result.WriteString(fmt.Sprintf("**License:** %s\n", snippetLicense))
+	for i, ev := range events {
+		writeCodeSnippet(&result, i+1, ev, language, query, maxLength)
+	}
+
+	return mcp.NewToolResultText(result.String()), nil
+}
+
+// writeCodeSnippet renders a single code snippet event as markdown. When a
+// query is given, the lines that match it are listed above the code. Code
+// longer than maxLength is truncated (0 for no limit).
+func writeCodeSnippet(result *strings.Builder, index int, ev *nostr.Event, language, query string, maxLength int) {
+	// Extract tags for display
+	// Check for 'name' tag first, then 'f' tag, then the patch subject or article title
+	snippetName := getTagValue(ev, "name", "")
+	if snippetName == "" {
+		snippetName = getTagValue(ev, "f", "")
+	}
+	if snippetName == "" && ev.Kind == kindPatch {
+		snippetName = patchSubject(ev.Content)
+	}
+	if snippetName == "" {
+		snippetName = getTagValue(ev, "title", "Unnamed Snippet")
+	}
+
+	snippetExt := getTagValue(ev, "extension", "")
+	snippetDesc := getTagValue(ev, "description", getTagValue(ev, "summary", "No description provided"))
+	snippetRuntime := getTagValue(ev, "runtime", "")
+	snippetLicense := getTagValue(ev, "license", "")
+
+	// Get language from the event if not provided in search
+	snippetLang := language
+	if ev.Kind == kindPatch {
+		snippetLang = "diff"
+	} else if snippetLang == "" {
+		snippetLang = "text"
+		if languages := snippetLanguages(ev); len(languages) > 0 {
+			snippetLang = languages[0]
 		}
+	}
 
-		// Add author information
-		npub, _ := nip19.EncodePublicKey(ev.PubKey)
-		result.WriteString(fmt.Sprintf("**Author:** %s\n", npub))
+	// Format the snippet metadata
+	result.WriteString(fmt.Sprintf("## Snippet %d: %s\n", index, snippetName))
+	result.WriteString(fmt.Sprintf("**Source:** %s\n", snippetSource(ev)))
+	result.WriteString(fmt.Sprintf("**Trust tier:** %s (%s)\n", tierSnippet, tierDescriptions[tierSnippet]))
+	result.WriteString(fmt.Sprintf("**Description:** %s\n", snippetDesc))
 
-		// Add the code snippet with proper markdown formatting
-		result.WriteString("```" + snippetLang + "\n")
-		result.WriteString(ev.Content)
-		result.WriteString("\n```\n\n")
+	// Add additional metadata if available
+	if snippetExt != "" {
+		result.WriteString(fmt.Sprintf("**Extension:** %s\n", snippetExt))
+	}
+	if snippetRuntime != "" {
+		result.WriteString(fmt.Sprintf("**Runtime:** %s\n", snippetRuntime))
+	}
+	if snippetLicense != "" {
+		result.WriteString(fmt.Sprintf("**License:** %s\n", snippetLicense))
 	}
 
-	return mcp.NewToolResultText(result.String()), nil
+	// Add author information
+	npub, _ := nip19.EncodePublicKey(ev.PubKey)
+	result.WriteString(fmt.Sprintf("**Author:** %s\n", npub))
+
+	// Show why the snippet matched before the full code
+	code := snippetCode(ev)
+	if excerpt := matchExcerpt(code, query); excerpt != "" {
+		result.WriteString("**Matching lines:**\n```\n")
+		result.WriteString(excerpt)
+		result.WriteString("```\n")
+	}
+
+	// Add the code snippet with proper markdown formatting
+	shown, truncated := truncateSnippet(code, query, maxLength)
+	result.WriteString("```" + snippetLang + "\n")
+	result.WriteString(shown)
+	result.WriteString("\n```\n")
+	if truncated {
+		result.WriteString(fmt.Sprintf("_Truncated to %d of %d characters. Fetch the complete content with id \"%s\" and full set to true._\n", len(shown), len(code), ev.ID))
+	}
+	result.WriteString("\n")
+}
+
+// findSnippetByID looks up a single event by hex ID, note, or nevent, first in
+// the cache and then on the relays or events file
+func findSnippetByID(ctx context.Context, id string) (*nostr.Event, error) {
+	if prefix, value, err := nip19.Decode(id); err == nil {
+		switch prefix {
+		case "note":
+			id = value.(string)
+		case "nevent":
+			id = value.(nostr.EventPointer).ID
+		}
+	}
+	if !nostr.IsValid32ByteHex(id) {
+		return nil, fmt.Errorf("invalid event id %q: expected a 64-character hex ID, note, or nevent", id)
+	}
+
+	codeSnippetCache.mutex.RLock()
+	for _, ev := range codeSnippetCache.events {
+		if ev.ID == id {
+			codeSnippetCache.mutex.RUnlock()
+			return ev, nil
+		}
+	}
+	codeSnippetCache.mutex.RUnlock()
+
+	filters := []nostr.Filter{{IDs: []string{id}}}
+	var events []*nostr.Event
+	if eventsFile != "" {
+		var err error
+		events, err = loadEventsFile(eventsFile, filters)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		events = fetchFromRelays(ctx, rankRelays(searchRelays), filters, relayDeadline, 1, func(ev *nostr.Event) bool {
+			return ev.ID == id
+		})
+	}
+	if len(events) == 0 {
+		return nil, fmt.Errorf("event %s not found", id)
+	}
+	return events[0], nil
 }
 
 // matchesQuery checks if an event matches the query string across multiple tag fields
@@ -616,6 +1404,30 @@ func matchesQuery(ev *nostr.Event, query string) bool {
 			}
 		}
 	}
+
+	// Slang in the query also matches the terms it stands for
+	for _, expansion := range aliasExpansions(query) {
+		expansion = strings.ToLower(expansion)
+		if strings.Contains(strings.ToLower(ev.Content), expansion) {
+			return true
+		}
+		for _, tag := range ev.Tags {
+			if len(tag) >= 2 && strings.Contains(strings.ToLower(tag[1]), expansion) {
+				return true
+			}
+		}
+	}
+
+	// Finally tolerate typos and split words, e.g. "nosrt" or "web socket"
+	text := strings.ToLower(ev.Content)
+	for _, tag := range ev.Tags {
+		if len(tag) >= 2 {
+			text += " " + strings.ToLower(tag[1])
+		}
+	}
+	if fuzzyContains(text, words) {
+		return true
+	}
 	
 	return false
 }
@@ -657,72 +1469,23 @@ func min(a, b int) int {
 // searchByQueryOnly performs a broader search when only a query is provided
 func searchByQueryOnly(ctx context.Context, query string, limit int) []*nostr.Event {
 	// First check the cache for matches
-	cachedResults := searchCachedEvents("", "", query, limit)
+	cachedResults := searchCachedEvents("", nil, query, limit)
 	if len(cachedResults) > 0 {
 		return cachedResults
 	}
 	
-	// List of relays to connect to - just use a few reliable ones
-	relays := []string{
-		"wss://relay.damus.io",
-		"wss://purplepag.es",
-	}
-	
-	// Just get all code snippets and filter locally
-	filter := nostr.Filter{
-		Kinds: []int{1337}, // Code snippet kind
-		Limit: 50,         // Get a reasonable number to filter locally
-		// No time filter to ensure we get results
-	}
+	// List of relays to connect to - just use a few reliable ones, healthiest first
+	relays := rankRelays(queryRelays)
 	
-	// Connect to relays and collect events
-	var events []*nostr.Event
-	var eventIDs = make(map[string]bool) // To avoid duplicates
+	// Just get all code-bearing events and filter locally
+	filters := snippetFilters("", nil, 50) // Get a reasonable number to filter locally
 	
-	for _, url := range relays {
-		relay, err := nostr.RelayConnect(ctx, url)
-		if err != nil {
-			continue
-		}
-
-		// Set a shorter timeout for subscription to avoid hanging
-		subCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-		defer cancel()
-
-		// Subscribe to the relay with our filters
-		sub, err := relay.Subscribe(subCtx, []nostr.Filter{filter})
-		if err != nil {
-			relay.Close()
-			continue
-		}
-
-		// Collect events from this relay
-		for ev := range sub.Events {
-			// Skip if we've seen this event before
-			if eventIDs[ev.ID] {
-				continue
-			}
-			
-			// Apply query filtering
-			if matchesQuery(ev, query) {
-				events = append(events, ev)
-				eventIDs[ev.ID] = true
-				
-				// Break if we've reached our limit
-				if len(events) >= limit {
-					break
-				}
-			}
-		}
-
-		// Close the subscription
-		sub.Unsub()
-		relay.Close()
-
-		// If we've collected enough events, stop connecting to more relays
-		if len(events) >= limit {
-			break
-		}
+	// Query all relays concurrently, with a shorter deadline to avoid hanging
+	events := fetchFromRelays(ctx, relays, filters, relayDeadline/2, limit, func(ev *nostr.Event) bool {
+		return isCodeBearing(ev) && matchesQuery(ev, query)
+	})
+	if len(events) > limit {
+		events = events[:limit]
 	}
 	
 	return events
@@ -737,18 +1500,3 @@ func getTagValue(ev *nostr.Event, tagName, defaultValue string) string {
 	}
 	return defaultValue
 }
-
-func extractSection(content, startMarker, endMarker string) string {
-	startIndex := strings.Index(content, startMarker)
-	if startIndex == -1 {
-		return ""
-	}
-
-	endIndex := strings.Index(content[startIndex+len(startMarker):], endMarker)
-	if endIndex == -1 {
-		return strings.TrimSpace(content[startIndex:])
-	}
-
-	sectionContent := content[startIndex : startIndex+len(startMarker)+endIndex]
-	return strings.TrimSpace(sectionContent)
-}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"go.etcd.io/bbolt"
+)
+
+// maxCachedSnippets caps the code snippet cache; the newest events are kept
+var maxCachedSnippets = 5000
+
+// snippetFetchLimit is the number of events requested from each relay when
+// the code snippet cache is refreshed
+var snippetFetchLimit = 500
+
+// snippetFetchTimeout bounds a refresh of the code snippet cache
+var snippetFetchTimeout = 30 * time.Second
+
+// snippetRefreshInterval is how often the code snippet cache is refreshed
+var snippetRefreshInterval = 30 * time.Minute
+
+// maxMemoryVectors caps the number of vectors the MCP server keeps in memory.
+// Larger indexes are searched from disk on every query instead (0 for no limit).
+var maxMemoryVectors = 0
+
+// countStoredRecords returns the number of records in the vector store at
+// path without loading them. It must be called before the store is opened,
+// since the database cannot be opened twice.
+func countStoredRecords(path string) (int, error) {
+	db, err := openRawDatabase(path, true)
+	if err != nil {
+		return 0, fmt.Errorf("error opening database %s: %v", path, err)
+	}
+	defer db.Close()
+
+	count := 0
+	err = db.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bbolt.Bucket) error {
+			if string(name) != metaBucket {
+				count += bucket.Stats().KeyN
+			}
+			return nil
+		})
+	})
+	return count, err
+}
+
+// capSnippetEvents keeps the newest maxCachedSnippets events and reports how
+// many were dropped
+func capSnippetEvents(events []*nostr.Event) ([]*nostr.Event, int) {
+	if maxCachedSnippets <= 0 || len(events) <= maxCachedSnippets {
+		return events, 0
+	}
+
+	newest := make([]*nostr.Event, len(events))
+	copy(newest, events)
+	sort.SliceStable(newest, func(i, j int) bool {
+		return newest[i].CreatedAt > newest[j].CreatedAt
+	})
+	return newest[:maxCachedSnippets], len(events) - maxCachedSnippets
+}
+
+// formatServerStatus describes the index, the last ingest, the caches, and
+// the process memory
+func formatServerStatus() string {
+	var b strings.Builder
+
+	index := currentIndex()
+	b.WriteString("## Index\n")
+	b.WriteString(fmt.Sprintf("- Vectors: %d\n", index.vectors))
+	if index.inMemory {
+		b.WriteString("- Held in memory")
+	} else {
+		b.WriteString(fmt.Sprintf("- Searched from disk (more than the limit of %d vectors in memory)", maxMemoryVectors))
+	}
+	b.WriteString(fmt.Sprintf(", loaded %s ago\n", time.Since(index.loaded).Round(time.Second)))
+	if maxMemoryVectors > 0 && index.inMemory {
+		b.WriteString(fmt.Sprintf("- Memory limit: %d vectors\n", maxMemoryVectors))
+	}
+
+	codeSnippetCache.mutex.RLock()
+	cached := len(codeSnippetCache.events)
+	dropped := codeSnippetCache.dropped
+	lastUpdate := codeSnippetCache.lastUpdate
+	codeSnippetCache.mutex.RUnlock()
+
+	b.WriteString("\n## Ingestion\n")
+	b.WriteString(formatIngestProgress())
+
+	b.WriteString("\n## Code snippet cache\n")
+	if maxCachedSnippets > 0 {
+		b.WriteString(fmt.Sprintf("- Events: %d of %d\n", cached, maxCachedSnippets))
+	} else {
+		b.WriteString(fmt.Sprintf("- Events: %d\n", cached))
+	}
+	if dropped > 0 {
+		b.WriteString(fmt.Sprintf("- Oldest %d events dropped at the last refresh to stay within the limit\n", dropped))
+	}
+	if lastUpdate.IsZero() {
+		b.WriteString("- Not populated yet\n")
+	} else {
+		b.WriteString(fmt.Sprintf("- Refreshed %s ago\n", time.Since(lastUpdate).Round(time.Second)))
+	}
+
+	b.WriteString("\n## Relays\n")
+	b.WriteString(formatRelayCircuits())
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	b.WriteString("\n## Process memory\n")
+	b.WriteString(fmt.Sprintf("- Heap in use: %s\n", formatBytes(int64(mem.HeapAlloc))))
+	b.WriteString(fmt.Sprintf("- Obtained from the OS: %s\n", formatBytes(int64(mem.Sys))))
+
+	return b.String()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// repoURLs returns the URLs a repository can be cloned from: the primary URL
+// followed by its mirrors, without duplicates
+func repoURLs(repo RepoConfig) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, url := range append([]string{repo.URL}, repo.Mirrors...) {
+		key := normalizeRepoURL(url)
+		if url == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		urls = append(urls, url)
+	}
+	return urls
+}
+
+// normalizeRepoURL reduces a repository URL to a comparable form, so that
+// https://github.com/a/b, https://github.com/a/b.git and git@github.com:a/b
+// are recognized as the same repository
+func normalizeRepoURL(url string) string {
+	url = strings.ToLower(strings.TrimSpace(url))
+	for _, prefix := range []string{"https://", "http://", "ssh://", "git://"} {
+		url = strings.TrimPrefix(url, prefix)
+	}
+	if rest, ok := strings.CutPrefix(url, "git@"); ok {
+		url = strings.Replace(rest, ":", "/", 1)
+	}
+	url = strings.TrimSuffix(url, "/")
+	url = strings.TrimSuffix(url, ".git")
+	return url
+}
+
+// findRepoByURL returns the index of the configured repository that has url
+// as its primary URL or as one of its mirrors, or -1
+func findRepoByURL(url string) int {
+	key := normalizeRepoURL(url)
+	for i, repo := range repos {
+		for _, candidate := range repoURLs(repo) {
+			if normalizeRepoURL(candidate) == key {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// duplicateRepos holds the names of enabled repositories that share a URL or
+// mirror with an earlier enabled repository
+var duplicateRepos = make(map[string]bool)
+
+// findDuplicateRepos records enabled repositories that share a URL or mirror
+// with an earlier enabled repository, so the same content is not cloned and
+// ingested twice. The configuration itself is left unchanged.
+func findDuplicateRepos() {
+	duplicateRepos = make(map[string]bool)
+	owners := make(map[string]string)
+	for _, repo := range repos {
+		if !repo.Enabled {
+			continue
+		}
+
+		duplicateOf := ""
+		for _, url := range repoURLs(repo) {
+			if owner, ok := owners[normalizeRepoURL(url)]; ok {
+				duplicateOf = owner
+				break
+			}
+		}
+		if duplicateOf != "" {
+			fmt.Printf("Repository %s duplicates %s (shared URL or mirror); skipping it\n", repo.Name, duplicateOf)
+			duplicateRepos[repo.Name] = true
+			continue
+		}
+
+		for _, url := range repoURLs(repo) {
+			owners[normalizeRepoURL(url)] = repo.Name
+		}
+	}
+}
+
+// isActiveRepo reports whether a repository should be cloned and ingested
+func isActiveRepo(repo RepoConfig) bool {
+	return repo.Enabled && !duplicateRepos[repo.Name]
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// mmrLambda weighs relevance against novelty when results are chosen with
+// Maximal Marginal Relevance: 1 keeps the similarity order, lower values
+// favor chunks unlike the ones already chosen, so mirrored copies of a NIP
+// in several repositories do not fill every result. Set with -mmr-lambda.
+var mmrLambda = 0.7
+
+// mmrPoolFactor is how many candidates per result MMR chooses from. The
+// rest are too far down the ranking to be worth comparing.
+const mmrPoolFactor = 5
+
+// mmrDuplicateSimilarity is the similarity to a chosen chunk above which a
+// candidate left out by MMR is reported as a near-duplicate of it
+const mmrDuplicateSimilarity = 0.95
+
+// mmrLambdaDescription documents the mmr_lambda argument of the search tools
+const mmrLambdaDescription = "Relevance weight, above 0 and at most 1, for choosing diverse results: 1 keeps the similarity order, lower values skip chunks that repeat ones already chosen, like the same NIP text mirrored in several repositories. Defaults to the server's -mmr-lambda."
+
+// parseMMRLambda validates an MMR lambda. 0 would ignore relevance
+// altogether, so it is not accepted.
+func parseMMRLambda(lambda float64) (float64, error) {
+	if !(lambda > 0 && lambda <= 1) {
+		return 0, fmt.Errorf("mmr lambda %v must be above 0 and at most 1", lambda)
+	}
+	return lambda, nil
+}
+
+// mmrLambdaArgument reads the mmr_lambda argument of a search tool call,
+// which defaults to -mmr-lambda
+func mmrLambdaArgument(request mcp.CallToolRequest) (float64, error) {
+	value, ok := request.Params.Arguments["mmr_lambda"].(float64)
+	if !ok {
+		return mmrLambda, nil
+	}
+	if _, err := parseMMRLambda(value); err != nil {
+		return 0, errors.New("mmr_lambda must be above 0 and at most 1")
+	}
+	return value, nil
+}
+
+// diversifies reports whether opts choose results with MMR. A zero lambda
+// means opts never set one. Without a result limit every eligible chunk is
+// returned, so there is nothing to choose.
+func (opts searchOptions) diversifies() bool {
+	return opts.MMRLambda > 0 && opts.MMRLambda < 1 && opts.NumResults > 0
+}
+
+// chunkSimilarity is the cosine similarity of two chunks' embeddings, which
+// MMR uses as their redundancy whatever the metric of the index
+func chunkSimilarity(a, b searchResult) float64 {
+	return similarityScore(metricCosine, a.Record.Embedding, b.Record.Embedding)
+}
+
+// judgeDiverse chooses up to opts.NumResults of the eligible candidates with
+// Maximal Marginal Relevance, repeatedly taking the one with the best
+// lambda × score - (1 - lambda) × similarity to the chunks already chosen,
+// and records why the others were left out in verdicts
+func judgeDiverse(candidates []searchResult, eligible []int, opts searchOptions, verdicts []string) {
+	pool := eligible[:min(len(eligible), opts.NumResults*mmrPoolFactor)]
+	for _, i := range eligible[len(pool):] {
+		verdicts[i] = fmt.Sprintf("result limit of %d reached", opts.NumResults)
+	}
+
+	// redundancy[k] is the highest similarity of pool[k] to a chosen chunk,
+	// and closest[k] that chunk
+	redundancy := make([]float64, len(pool))
+	closest := make([]int, len(pool))
+	chosen := make([]bool, len(pool))
+	perFile := make(map[string]int)
+	included := 0
+
+	for included < opts.NumResults {
+		best, bestValue := -1, math.Inf(-1)
+		for k, i := range pool {
+			if chosen[k] || (opts.MaxPerFile > 0 && perFile[chunkSource(candidates[i].Record.Id)] >= opts.MaxPerFile) {
+				continue
+			}
+			value := opts.MMRLambda*candidates[i].Score - (1-opts.MMRLambda)*redundancy[k]
+			if value > bestValue {
+				best, bestValue = k, value
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		chosen[best] = true
+		perFile[chunkSource(candidates[pool[best]].Record.Id)]++
+		included++
+		for k, i := range pool {
+			if chosen[k] {
+				continue
+			}
+			if similarity := chunkSimilarity(candidates[i], candidates[pool[best]]); similarity > redundancy[k] {
+				redundancy[k], closest[k] = similarity, pool[best]
+			}
+		}
+	}
+
+	for k, i := range pool {
+		source := chunkSource(candidates[i].Record.Id)
+		switch {
+		case chosen[k]:
+		case opts.MaxPerFile > 0 && perFile[source] >= opts.MaxPerFile:
+			verdicts[i] = fmt.Sprintf("already %d results from %s", opts.MaxPerFile, source)
+		case included > 0 && redundancy[k] >= mmrDuplicateSimilarity:
+			verdicts[i] = fmt.Sprintf("near-duplicate of %s (similarity %.4f)", candidates[closest[k]].Record.Id, redundancy[k])
+		default:
+			verdicts[i] = fmt.Sprintf("result limit of %d reached", opts.NumResults)
+		}
+	}
+}
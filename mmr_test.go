@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/parakeet-nest/parakeet/llm"
+)
+
+func TestJudgeDiverse(t *testing.T) {
+	candidate := func(id string, score float64, embedding ...float64) searchResult {
+		return searchResult{Record: llm.VectorRecord{Id: id, Embedding: embedding}, Score: score}
+	}
+	// The NIP-01 text mirrored in nostr-tools is the second best match, but
+	// repeats the best one
+	mirrored := []searchResult{
+		candidate("nips/01-chunk-1", 0.9, 1, 0),
+		candidate("nostr-tools/nips/01-chunk-1", 0.89, 1, 0),
+		candidate("go-nostr/relay.go-chunk-3", 0.7, 0, 1),
+		candidate("nips/02-chunk-1", 0.6, 0.7, 0.7),
+	}
+	sameFile := []searchResult{
+		candidate("nips/01-chunk-1", 0.9, 1, 0),
+		candidate("nips/01-chunk-2", 0.85, 0, 1),
+		candidate("go-nostr/relay.go-chunk-3", 0.7, 0.6, 0.8),
+	}
+
+	tests := []struct {
+		name       string
+		candidates []searchResult
+		opts       searchOptions
+		want       []string // Expected verdict of each candidate; "" when included
+	}{
+		{
+			name:       "mirrored chunk left out",
+			candidates: mirrored,
+			opts:       searchOptions{NumResults: 2, MMRLambda: 0.7},
+			want:       []string{"", "near-duplicate of nips/01-chunk-1 (similarity 1.0000)", "", "result limit of 2 reached"},
+		},
+		{
+			name:       "relevance only",
+			candidates: mirrored,
+			opts:       searchOptions{NumResults: 2, MMRLambda: 1},
+			want:       []string{"", "", "result limit of 2 reached", "result limit of 2 reached"},
+		},
+		{
+			name:       "per-file limit",
+			candidates: sameFile,
+			opts:       searchOptions{NumResults: 2, MMRLambda: 0.7, MaxPerFile: 1},
+			want:       []string{"", "already 1 results from nips/01", ""},
+		},
+		{
+			name:       "beyond the pool",
+			candidates: append(mirrored, mirrored[2], mirrored[3]),
+			opts:       searchOptions{NumResults: 1, MMRLambda: 0.7},
+			want:       []string{"", "near-duplicate of", "result limit of 1 reached", "result limit of 1 reached", "result limit of 1 reached", "result limit of 1 reached"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			eligible := make([]int, len(test.candidates))
+			for i := range eligible {
+				eligible[i] = i
+			}
+			verdicts := make([]string, len(test.candidates))
+			judgeDiverse(test.candidates, eligible, test.opts, verdicts)
+			for i, want := range test.want {
+				if (want == "") != (verdicts[i] == "") || !strings.HasPrefix(verdicts[i], want) {
+					t.Errorf("%s: got verdict %q, want %q", test.candidates[i].Record.Id, verdicts[i], want)
+				}
+			}
+		})
+	}
+}
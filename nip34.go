@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// kindRepoAnnouncement is the NIP-34 repository announcement kind
+const kindRepoAnnouncement = 30617
+
+// isNostrRepoURL reports whether a repository URL is a NIP-34 announcement
+// address (nostr:naddr1...) rather than a git URL
+func isNostrRepoURL(url string) bool {
+	return strings.HasPrefix(strings.TrimPrefix(url, "nostr:"), "naddr1")
+}
+
+// resolveCloneURLs expands NIP-34 announcement addresses among a repository's
+// URLs into the git clone URLs they announce, keeping the order
+func resolveCloneURLs(urls []string) ([]string, error) {
+	var resolved []string
+	var errs []string
+	for _, url := range urls {
+		if !isNostrRepoURL(url) {
+			resolved = append(resolved, url)
+			continue
+		}
+		cloneURLs, err := resolveRepoAnnouncement(url)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", url, err))
+			continue
+		}
+		resolved = append(resolved, cloneURLs...)
+	}
+	if len(resolved) == 0 && len(errs) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return resolved, nil
+}
+
+// decodeRepoAnnouncement decodes the naddr of a NIP-34 repository
+// announcement
+func decodeRepoAnnouncement(address string) (nostr.EntityPointer, error) {
+	prefix, value, err := nip19.Decode(strings.TrimPrefix(address, "nostr:"))
+	if err != nil {
+		return nostr.EntityPointer{}, fmt.Errorf("invalid naddr: %v", err)
+	}
+	pointer, ok := value.(nostr.EntityPointer)
+	if prefix != "naddr" || !ok || pointer.Kind != kindRepoAnnouncement {
+		return nostr.EntityPointer{}, fmt.Errorf("not a NIP-34 repository announcement (kind %d)", kindRepoAnnouncement)
+	}
+	return pointer, nil
+}
+
+// resolveRepoAnnouncement fetches the kind 30617 event an naddr points to and
+// returns the git URLs from its clone tags
+func resolveRepoAnnouncement(address string) ([]string, error) {
+	pointer, err := decodeRepoAnnouncement(address)
+	if err != nil {
+		return nil, err
+	}
+
+	filters := []nostr.Filter{{
+		Kinds:   []int{kindRepoAnnouncement},
+		Authors: []string{pointer.PublicKey},
+		Tags:    nostr.TagMap{"d": {pointer.Identifier}},
+	}}
+
+	// Prefer the relays named in the address, then the configured ones
+	relays := append([]string{}, pointer.Relays...)
+	for _, url := range rankRelays(searchRelays) {
+		if !contains(relays, url) {
+			relays = append(relays, url)
+		}
+	}
+
+	var events []*nostr.Event
+	if eventsFile != "" {
+		events, err = loadEventsFile(eventsFile, filters)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		events = fetchFromRelays(context.Background(), relays, filters, relayDeadline, 1, func(ev *nostr.Event) bool {
+			return ev.Kind == kindRepoAnnouncement && ev.PubKey == pointer.PublicKey
+		})
+	}
+
+	// Announcements are replaceable, so only the newest one counts
+	var latest *nostr.Event
+	for _, ev := range events {
+		if latest == nil || ev.CreatedAt > latest.CreatedAt {
+			latest = ev
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("repository announcement %q not found on relays", pointer.Identifier)
+	}
+
+	var urls []string
+	for _, tag := range latest.Tags {
+		if len(tag) >= 2 && tag[0] == "clone" {
+			urls = append(urls, tag[1:]...)
+		}
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("repository announcement %q has no clone URLs", pointer.Identifier)
+	}
+	return urls, nil
+}
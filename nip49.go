@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/nip49"
+)
+
+// signerKeyFile stores the signer key imported with -import-key, encrypted
+var signerKeyFile = filepath.Join(dataDir, "signer.ncryptsec")
+
+// exportLogN is the scrypt work factor for exported keys; 16 is the value
+// NIP-49 suggests for interactive use
+const exportLogN = 16
+
+// stdinReader is shared by all prompts so buffered input is not lost
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// isTerminal reports whether stdin is an interactive terminal
+func isTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// promptLine asks for a line of input on the terminal
+func promptLine(prompt string) (string, error) {
+	if !isTerminal() {
+		return "", errors.New("stdin is not a terminal")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := stdinReader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// promptPassphrase asks for a passphrase on the terminal without echoing it
+func promptPassphrase(prompt string) (string, error) {
+	if !isTerminal() {
+		return "", errors.New("stdin is not a terminal")
+	}
+
+	// Echo is turned back on even if the prompt is interrupted
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupted)
+	if err := setEcho(false); err != nil {
+		return "", fmt.Errorf("cannot hide passphrase input: %v", err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-interrupted:
+			setEcho(true)
+			fmt.Fprintln(os.Stderr)
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+
+	line, err := promptLine(prompt)
+	setEcho(true)
+	fmt.Fprintln(os.Stderr)
+	return line, err
+}
+
+// setEcho turns terminal echo on or off
+func setEcho(on bool) error {
+	mode := "-echo"
+	if on {
+		mode = "echo"
+	}
+	cmd := exec.Command("stty", mode)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
+
+// keyPassphrase returns the passphrase for NIP-49 keys from BHN_KEY_PASSPHRASE,
+// or asks for it when running in a terminal
+func keyPassphrase() (string, error) {
+	if passphrase := os.Getenv(keyPassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	if !isTerminal() {
+		return "", fmt.Errorf("the key is encrypted (NIP-49); set %s to its passphrase", keyPassphraseEnv)
+	}
+	return promptPassphrase("Passphrase for the encrypted key: ")
+}
+
+// importSignerKey asks for an ncryptsec key and its passphrase, checks that
+// they decrypt, and stores the still-encrypted key as the signer key
+func importSignerKey() {
+	ncryptsec, err := promptLine("ncryptsec key to import: ")
+	if err != nil {
+		fmt.Printf("Error reading key: %v\n", err)
+		os.Exit(1)
+	}
+	ncryptsec = strings.TrimSpace(ncryptsec)
+	if !strings.HasPrefix(ncryptsec, "ncryptsec1") {
+		fmt.Println("Error: expected a NIP-49 key starting with ncryptsec1")
+		os.Exit(1)
+	}
+
+	passphrase, err := keyPassphrase()
+	if err != nil {
+		fmt.Printf("Error reading passphrase: %v\n", err)
+		os.Exit(1)
+	}
+	sk, err := nip49.Decrypt(ncryptsec, passphrase)
+	if err != nil {
+		fmt.Printf("Error decrypting key: %v\n", err)
+		os.Exit(1)
+	}
+	rememberSecret(sk)
+
+	if err := os.MkdirAll(filepath.Dir(signerKeyFile), 0700); err != nil {
+		fmt.Printf("Error creating %s: %v\n", filepath.Dir(signerKeyFile), err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(signerKeyFile, []byte(ncryptsec+"\n"), 0600); err != nil {
+		fmt.Printf("Error saving key: %v\n", err)
+		os.Exit(1)
+	}
+
+	pk, _ := nostr.GetPublicKey(sk)
+	npub, _ := nip19.EncodePublicKey(pk)
+	fmt.Printf("Imported signer key for %s into %s\n", npub, signerKeyFile)
+	fmt.Printf("It is used by default; the passphrase is asked for when the key is needed, or read from %s.\n", keyPassphraseEnv)
+}
+
+// exportSignerKey prints the configured signer key encrypted with a new passphrase
+func exportSignerKey() {
+	sk, err := loadPrivateKey(signerKeyRef)
+	if err != nil {
+		fmt.Printf("Error loading signer key from %s: %v\n", describeSecretRef(signerKeyRef), err)
+		os.Exit(1)
+	}
+
+	passphrase, err := promptPassphrase("New passphrase for the exported key: ")
+	if err == nil && passphrase != "" {
+		var confirm string
+		confirm, err = promptPassphrase("Repeat the passphrase: ")
+		if err == nil && confirm != passphrase {
+			err = errors.New("the passphrases do not match")
+		}
+	} else if err == nil {
+		err = errors.New("the passphrase must not be empty")
+	}
+	if err != nil {
+		fmt.Printf("Error reading passphrase: %v\n", err)
+		os.Exit(1)
+	}
+
+	ncryptsec, err := nip49.Encrypt(sk, passphrase, exportLogN, nip49.ClientDoesNotTrackThisData)
+	if err != nil {
+		fmt.Printf("Error encrypting key: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(ncryptsec)
+}
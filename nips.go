@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// nipFilePattern matches NIP specification file names such as "01.md" or "7D.md"
+var nipFilePattern = regexp.MustCompile(`^([0-9A-Fa-f]{2,3})\.md$`)
+
+// nipHeadingPattern matches a heading that only names the NIP, e.g. "NIP-01",
+// or "NIP-XX" in drafts that have no number yet
+var nipHeadingPattern = regexp.MustCompile(`^NIP-([0-9A-Fa-f]+|XX)$`)
+
+// nipStatusPattern matches the backticked status labels under a NIP's title
+var nipStatusPattern = regexp.MustCompile("`(draft|final|mandatory|optional|relay|unrecommended|deprecated)`")
+
+// scanNips reads every NIP file in a NIPs repository clone and returns the
+// NIPs sorted by number, with titles taken from the documents themselves
+func scanNips(dir string) ([]nipEntry, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []nipEntry
+	for _, file := range files {
+		match := nipFilePattern.FindStringSubmatch(file.Name())
+		if file.IsDir() || match == nil {
+			continue
+		}
+
+		content, err := readCloneFile(dir, file.Name())
+		if errors.Is(err, errOutsideClone) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		title, preamble := nipHeader(content)
+		entry := nipEntry{
+			Number: strings.ToUpper(match[1]),
+			File:   file.Name(),
+			Title:  title,
+		}
+		for _, status := range nipStatusPattern.FindAllStringSubmatch(preamble, -1) {
+			entry.Status = append(entry.Status, status[1])
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Number < entries[j].Number
+	})
+	return entries, nil
+}
+
+// nipHeader returns the title of a NIP document and the text up to its first
+// body section, where the status labels live. NIPs usually open with a
+// "NIP-XX" heading followed by the descriptive title as a second heading.
+func nipHeader(content []byte) (string, string) {
+	headings := parseHeadings(content)
+	if len(headings) == 0 {
+		return "", string(content)
+	}
+
+	titleIdx := 0
+	if nipHeadingPattern.MatchString(headings[0].Text) && len(headings) > 1 {
+		titleIdx = 1
+	}
+
+	preamble := string(content)
+	if titleIdx+1 < len(headings) {
+		preamble = string(content[:headings[titleIdx+1].Start])
+	}
+	return headings[titleIdx].Text, preamble
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// offlineMode disables all network access except to the local machine; set
+// with -offline
+var offlineMode bool
+
+// errOffline is returned for any attempt to reach the network while offline
+var errOffline = errors.New("offline mode: network access is disabled")
+
+// isLoopbackHost reports whether host is the local machine
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// hasLocalEventSource reports whether snippets and articles can still be
+// read while offline, from an events file or a relay on this machine
+func hasLocalEventSource() bool {
+	if eventsFile != "" {
+		return true
+	}
+	for _, url := range configuredRelays() {
+		if isLoopbackHost(outboundHost(url)) {
+			return true
+		}
+	}
+	return false
+}
+
+// relaysUnavailable reports whether relay-backed tools cannot work because
+// the server is offline without a local event source
+func relaysUnavailable() bool {
+	return offlineMode && !hasLocalEventSource()
+}
+
+// offlineNotice explains to a tool caller why a network-backed feature returned nothing
+func offlineNotice(feature string) string {
+	return fmt.Sprintf("Offline mode: %s needs network access, which is disabled. Run without -offline, or use -events-file or -local-relay to serve events locally.", feature)
+}
+
+// checkOllamaReachable refuses to contact a remote Ollama server while offline
+func checkOllamaReachable() error {
+	if offlineMode && !isLoopbackHost(outboundHost(ollamaURL)) {
+		return fmt.Errorf("%w: the Ollama server at %s is not on this machine", errOffline, ollamaURL)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// serverVersion is reported to MCP clients and in the user agent
+const serverVersion = "1.0.0"
+
+// userAgent identifies this server to relays and web servers
+const userAgent = "beating-heart-nostr/" + serverVersion + " (+https://github.com/gzuuus/beating-heart-nostr)"
+
+// outboundTimeout bounds a single outgoing HTTP request
+var outboundTimeout = 30 * time.Second
+
+// outboundPolicy controls which hosts the server talks to and how often
+var outboundPolicy = struct {
+	AllowHosts []string       // When set, only these hosts are contacted
+	DenyHosts  []string       // Hosts that are never contacted
+	RateLimit  int            // HTTP requests per minute to any one host (0 for no limit)
+	HostLimits map[string]int // Per-host overrides of RateLimit
+}{}
+
+// hostLimiters holds the rate limiter of every host contacted over HTTP
+var hostLimiters = struct {
+	mutex    sync.Mutex
+	limiters map[string]*rateLimiter
+}{limiters: make(map[string]*rateLimiter)}
+
+// outboundClient is used for every outgoing HTTP request, such as NIP-11
+// documents, NIP-05 lookups and web pages
+var outboundClient = &http.Client{
+	Timeout:   outboundTimeout,
+	Transport: politeTransport{http.DefaultTransport},
+}
+
+// parseHostList parses a comma-separated list of host patterns
+func parseHostList(value string) []string {
+	var hosts []string
+	for _, host := range strings.Split(value, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// parseHostRateLimits parses "30" or "30,example.com=5": a default number of
+// requests per minute followed by per-host overrides
+func parseHostRateLimits(value string) error {
+	outboundPolicy.HostLimits = make(map[string]int)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		host, limit, hasHost := strings.Cut(part, "=")
+		if !hasHost {
+			host, limit = "", part
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(limit))
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid rate limit %q: expected requests per minute", part)
+		}
+		if hasHost {
+			outboundPolicy.HostLimits[strings.ToLower(strings.TrimSpace(host))] = n
+		} else {
+			outboundPolicy.RateLimit = n
+		}
+	}
+	return nil
+}
+
+// hostMatches reports whether host matches a pattern; "*.example.com"
+// matches example.com and all its subdomains
+func hostMatches(host, pattern string) bool {
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return host == suffix || strings.HasSuffix(host, "."+suffix)
+	}
+	return host == pattern
+}
+
+// outboundHost extracts the host from a URL or an scp-style git address
+// such as git@github.com:owner/repo.git
+func outboundHost(rawURL string) string {
+	if parsed, err := url.Parse(rawURL); err == nil && parsed.Host != "" {
+		return strings.ToLower(parsed.Hostname())
+	}
+	if at := strings.Index(rawURL, "@"); at >= 0 {
+		if host, _, ok := strings.Cut(rawURL[at+1:], ":"); ok {
+			return strings.ToLower(host)
+		}
+	}
+	return ""
+}
+
+// checkOutbound returns an error if the policy forbids contacting the host of rawURL
+func checkOutbound(rawURL string) error {
+	host := outboundHost(rawURL)
+	if host == "" {
+		return nil
+	}
+	if offlineMode && !isLoopbackHost(host) {
+		return errOffline
+	}
+	for _, pattern := range outboundPolicy.DenyHosts {
+		if hostMatches(host, pattern) {
+			return fmt.Errorf("outbound connections to %s are denied", host)
+		}
+	}
+	if len(outboundPolicy.AllowHosts) == 0 {
+		return nil
+	}
+	for _, pattern := range outboundPolicy.AllowHosts {
+		if hostMatches(host, pattern) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not in the list of allowed hosts", host)
+}
+
+// waitForHost blocks until the host's rate limit allows another request
+func waitForHost(ctx context.Context, host string) error {
+	limit, ok := outboundPolicy.HostLimits[host]
+	if !ok {
+		limit = outboundPolicy.RateLimit
+	}
+	if limit <= 0 {
+		return nil
+	}
+
+	hostLimiters.mutex.Lock()
+	limiter, ok := hostLimiters.limiters[host]
+	if !ok {
+		limiter = newRateLimiter(limit, time.Minute)
+		hostLimiters.limiters[host] = limiter
+	}
+	hostLimiters.mutex.Unlock()
+
+	for {
+		allowed, wait := limiter.allow()
+		if allowed {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// politeTransport identifies the server, enforces the outbound host policy,
+// and spaces out requests to each host
+type politeTransport struct {
+	base http.RoundTripper
+}
+
+func (t politeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := checkOutbound(req.URL.String()); err != nil {
+		return nil, err
+	}
+	if err := waitForHost(req.Context(), strings.ToLower(req.URL.Hostname())); err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", userAgent)
+	return t.base.RoundTrip(req)
+}
+
+// connectRelay opens a relay connection that identifies the server and
+// respects the outbound host policy, the relay's circuit breaker, and the
+// limit on connections to one relay. The connection's slot is freed when it
+// closes.
+func connectRelay(ctx context.Context, url string) (*nostr.Relay, error) {
+	if err := checkOutbound(url); err != nil {
+		return nil, err
+	}
+	if err := allowRelay(url); err != nil {
+		return nil, err
+	}
+	release, err := acquireRelaySlot(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	relay, err := nostr.RelayConnect(ctx, url, nostr.WithRequestHeader(http.Header{"User-Agent": {userAgent}}))
+	if err != nil {
+		release()
+		relayFailed(ctx, url, err)
+		return nil, err
+	}
+	go func() {
+		<-relay.Context().Done()
+		release()
+	}()
+	return relay, nil
+}
+
+// installGitTransport routes git's HTTP traffic through politeTransport. Git
+// requests have no overall timeout since a clone may take a long time.
+func installGitTransport() {
+	gitClient := githttp.NewClient(&http.Client{Transport: politeTransport{http.DefaultTransport}})
+	client.InstallProtocol("https", gitClient)
+	client.InstallProtocol("http", gitClient)
+}
+
+// allowedURLs drops the URLs the outbound policy forbids, failing if none are left
+func allowedURLs(urls []string) ([]string, error) {
+	var allowed, denied []string
+	for _, url := range urls {
+		if err := checkOutbound(url); err != nil {
+			denied = append(denied, err.Error())
+			continue
+		}
+		allowed = append(allowed, url)
+	}
+	if len(allowed) == 0 && len(denied) > 0 {
+		return nil, fmt.Errorf("no URL may be contacted: %s", strings.Join(denied, "; "))
+	}
+	return allowed, nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// pinnedRef describes the ref a repository is pinned to, e.g. "tag v1.0",
+// or returns "" when it follows the remote's default branch. A tag or commit
+// takes precedence over a branch.
+func pinnedRef(repo RepoConfig) string {
+	switch {
+	case repo.Commit != "":
+		return "commit " + repo.Commit
+	case repo.Tag != "":
+		return "tag " + repo.Tag
+	case repo.Branch != "":
+		return "branch " + repo.Branch
+	}
+	return ""
+}
+
+// pinnedToRevision reports whether a repository is pinned to a fixed
+// revision, which is checked out instead of pulled
+func pinnedToRevision(repo RepoConfig) bool {
+	return repo.Commit != "" || repo.Tag != ""
+}
+
+// validatePin checks that a repository is pinned to at most one revision
+func validatePin(repo RepoConfig) error {
+	if repo.Commit != "" && repo.Tag != "" {
+		return errors.New("both Commit and Tag are set; pin to one of them")
+	}
+	return nil
+}
+
+// pinTarget resolves the commit a repository pinned to a tag or commit
+// should have checked out
+func pinTarget(r *git.Repository, repo RepoConfig) (plumbing.Hash, error) {
+	if repo.Commit != "" {
+		hash, err := r.ResolveRevision(plumbing.Revision(repo.Commit))
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("commit %s not found: %v", repo.Commit, err)
+		}
+		return *hash, nil
+	}
+
+	ref, err := r.Tag(repo.Tag)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("tag %s not found: %v", repo.Tag, err)
+	}
+	// Annotated tags point at a tag object rather than at the commit
+	if tag, err := r.TagObject(ref.Hash()); err == nil {
+		commit, err := tag.Commit()
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("tag %s does not point at a commit: %v", repo.Tag, err)
+		}
+		return commit.Hash, nil
+	}
+	return ref.Hash(), nil
+}
+
+// checkoutPinnedRevision checks out the tag or commit a repository is pinned
+// to, fetching from url first when the clone does not have it yet, and
+// reports whether HEAD moved
+func checkoutPinnedRevision(r *git.Repository, repo RepoConfig, url string, progress io.Writer) (string, bool, error) {
+	before, err := r.Head()
+	if err != nil {
+		return "", false, fmt.Errorf("error reading HEAD: %v", err)
+	}
+
+	target, err := pinTarget(r, repo)
+	if err != nil {
+		auth, err := repoAuth(repo, url)
+		if err != nil {
+			return "", false, err
+		}
+		err = r.Fetch(&git.FetchOptions{
+			RemoteName: "origin",
+			RemoteURL:  url,
+			Auth:       auth,
+			Progress:   progress,
+			Tags:       git.AllTags,
+		})
+		if err != nil && err != git.NoErrAlreadyUpToDate {
+			return "", false, fmt.Errorf("error fetching: %v", err)
+		}
+		if target, err = pinTarget(r, repo); err != nil {
+			return "", false, err
+		}
+	}
+
+	switch {
+	case isSparse(repo):
+		// Checking out again also applies changes to SparsePaths
+		if err := sparseCheckout(repo, "", target); err != nil {
+			return "", false, err
+		}
+	case before.Hash() != target:
+		if err := checkoutRevision(r, repo, target); err != nil {
+			return "", false, err
+		}
+	}
+	if before.Hash() == target {
+		return fmt.Sprintf("already at %s (%s)", pinnedRef(repo), shortHash(target)), false, nil
+	}
+	return fmt.Sprintf("checked out %s (%s, was %s)", pinnedRef(repo), shortHash(target), shortHash(before.Hash())), true, nil
+}
+
+// checkoutRevision detaches HEAD at a commit and brings the submodules and
+// LFS objects along
+func checkoutRevision(r *git.Repository, repo RepoConfig, hash plumbing.Hash) error {
+	w, err := r.Worktree()
+	if err != nil {
+		return fmt.Errorf("error opening worktree: %v", err)
+	}
+	if err := w.Checkout(&git.CheckoutOptions{Hash: hash, Force: true}); err != nil {
+		return fmt.Errorf("error checking out %s: %v", pinnedRef(repo), err)
+	}
+	if repo.Submodules {
+		if err := updateSubmodules(w, repo); err != nil {
+			return err
+		}
+	}
+	return fetchLFSObjects(repo)
+}
+
+// updateSubmodules brings the submodules of a worktree to the commits its
+// HEAD records
+func updateSubmodules(w *git.Worktree, repo RepoConfig) error {
+	submodules, err := w.Submodules()
+	if err != nil {
+		return fmt.Errorf("error reading submodules: %v", err)
+	}
+	err = submodules.Update(&git.SubmoduleUpdateOptions{Init: true, RecurseSubmodules: submoduleDepth(repo)})
+	if err != nil {
+		return fmt.Errorf("error updating submodules: %v", err)
+	}
+	return nil
+}
+
+// switchToPinnedBranch checks out the branch a repository is pinned to when
+// its clone is on another branch, e.g. after Branch was added to repos.json.
+// The branch is created from the remote's when the clone does not have it.
+func switchToPinnedBranch(r *git.Repository, repo RepoConfig, url string, progress io.Writer) error {
+	branch := plumbing.NewBranchReferenceName(repo.Branch)
+	head, err := r.Head()
+	if err != nil {
+		return fmt.Errorf("error reading HEAD: %v", err)
+	}
+	if head.Name() == branch {
+		return nil
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return fmt.Errorf("error opening worktree: %v", err)
+	}
+	if _, err := r.Reference(branch, false); err == nil {
+		if err := w.Checkout(&git.CheckoutOptions{Branch: branch, Force: true}); err != nil {
+			return fmt.Errorf("error checking out branch %s: %v", repo.Branch, err)
+		}
+		return nil
+	}
+
+	remoteBranch := plumbing.NewRemoteReferenceName("origin", repo.Branch)
+	auth, err := repoAuth(repo, url)
+	if err != nil {
+		return err
+	}
+	err = r.Fetch(&git.FetchOptions{
+		RemoteName: "origin",
+		RemoteURL:  url,
+		Auth:       auth,
+		Progress:   progress,
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", branch, remoteBranch))},
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return fmt.Errorf("error fetching branch %s: %v", repo.Branch, err)
+	}
+	ref, err := r.Reference(remoteBranch, true)
+	if err != nil {
+		return fmt.Errorf("branch %s not found: %v", repo.Branch, err)
+	}
+	err = w.Checkout(&git.CheckoutOptions{Branch: branch, Hash: ref.Hash(), Create: true, Force: true})
+	if err != nil {
+		return fmt.Errorf("error checking out branch %s: %v", repo.Branch, err)
+	}
+	return nil
+}
+
+// pinMismatch describes how a clone's HEAD differs from the revision its
+// repository is pinned to, e.g. when the pin changed since the last clone,
+// or returns "" when it does not
+func pinMismatch(repo RepoConfig, head string) string {
+	if !pinnedToRevision(repo) {
+		return ""
+	}
+	r, err := git.PlainOpen(repo.CloneDir)
+	if err != nil {
+		return ""
+	}
+	target, err := pinTarget(r, repo)
+	if err != nil {
+		return fmt.Sprintf("pinned to %s, which the clone does not have; run with -clone-repos to fetch it", pinnedRef(repo))
+	}
+	if target.String() != head {
+		return fmt.Sprintf("pinned to %s (%s) but %s is checked out; run with -clone-repos to check it out", pinnedRef(repo), shortHash(target), head[:min(7, len(head))])
+	}
+	return ""
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// defaultPreset is the repository preset used by -init
+const defaultPreset = "nostr-dev"
+
+// presetEnv names a preset to start a fresh container volume from
+const presetEnv = "BHN_PRESET"
+
+// presetRepo returns an enabled repository entry for a preset
+func presetRepo(name, url, role, collection string) RepoConfig {
+	return RepoConfig{
+		URL:        url,
+		Name:       name,
+		CloneDir:   filepath.Join(dataDir, name+"-repo"),
+		Enabled:    true,
+		Role:       role,
+		Collection: collection,
+	}
+}
+
+// repoPresets are curated repository sets for new installations
+var repoPresets = map[string][]RepoConfig{
+	// minimal indexes only the NIP specifications
+	"minimal": {
+		presetRepo("nips", "https://github.com/nostr-protocol/nips", roleNips, collectionSpecs),
+	},
+	// nostr-dev covers what client and relay developers usually need: the
+	// NIPs, the Data Vending Machine kinds registry, the Blossom BUDs, and
+	// the nostrbook wiki
+	"nostr-dev": {
+		presetRepo("nips", "https://github.com/nostr-protocol/nips", roleNips, collectionSpecs),
+		presetRepo("data-vending-machines", "https://github.com/nostr-protocol/data-vending-machines", "", collectionSpecs),
+		presetRepo("blossom", "https://github.com/hzrd149/blossom", "", collectionSpecs),
+		presetRepo("nostrbook", "https://gitlab.com/soapbox-pub/nostrbook", "", collectionWiki),
+	},
+}
+
+// presetNames lists the available presets in alphabetical order
+func presetNames() []string {
+	var names []string
+	for name := range repoPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// initReposConfig fills an empty repository configuration from a preset
+func initReposConfig(preset string) {
+	presetRepos, ok := repoPresets[preset]
+	if !ok {
+		fmt.Printf("Error: unknown preset %q; available presets: %s\n", preset, strings.Join(presetNames(), ", "))
+		os.Exit(1)
+	}
+	if len(repos) > 0 {
+		fmt.Printf("%s already lists %d repositories; remove it first to start over from a preset.\n", reposConfigFile, len(repos))
+		os.Exit(1)
+	}
+
+	repos = append([]RepoConfig(nil), presetRepos...)
+	saveReposToFile(reposConfigFile)
+
+	fmt.Printf("Created %s from the %s preset:\n", reposConfigFile, preset)
+	for _, repo := range repos {
+		fmt.Printf("  %s (%s)\n", repo.Name, repo.URL)
+	}
+	fmt.Println("\nRun with -ingest -clone-repos to clone and index them.")
+}
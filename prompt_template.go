@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// promptTemplate renders the user message for answer generation when a
+// template file is configured; nil means the built-in prompt is used
+var promptTemplate *template.Template
+
+// promptSource describes one retrieved chunk for use in a prompt template
+type promptSource struct {
+	ID         string
+	Collection string
+	Score      float64
+	Text       string
+}
+
+// promptData holds the values available to a prompt template
+type promptData struct {
+	Question  string         // The user's question as asked
+	Language  string         // The language to answer in
+	Context   string         // All retrieved chunks in the default <context> format
+	Sources   []promptSource // The retrieved chunks, best match first
+	Citations string         // The chunk IDs in the configured citation format, comma separated
+}
+
+// defaultCitationFormat is how a chunk ID is cited when no format is configured
+const defaultCitationFormat = "[%s]"
+
+// loadPromptTemplate parses the prompt template file
+func loadPromptTemplate(path string) (*template.Template, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading prompt template: %v", err)
+	}
+	tmpl, err := template.New(path).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing prompt template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// renderPrompt fills the prompt template with the question and retrieved chunks
+func renderPrompt(tmpl *template.Template, question, language string, results []searchResult) (string, error) {
+	citationFormat := llmConfig.CitationFormat
+	if citationFormat == "" {
+		citationFormat = defaultCitationFormat
+	}
+
+	data := promptData{
+		Question: question,
+		Language: language,
+		Context:  formatResults(results),
+	}
+	var citations []string
+	for _, result := range results {
+		data.Sources = append(data.Sources, promptSource{
+			ID:         result.Record.Id,
+			Collection: chunkCollection(result.Record.Id),
+			Score:      result.Score,
+			Text:       result.Record.Prompt,
+		})
+		citations = append(citations, fmt.Sprintf(citationFormat, result.Record.Id))
+	}
+	data.Citations = strings.Join(citations, ", ")
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("error rendering prompt template: %v", err)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/parakeet-nest/parakeet/completion"
+	"github.com/parakeet-nest/parakeet/llm"
+)
+
+// expansionEnabled has the chat model rephrase each query unless it asks
+// otherwise. Set with -expand-query.
+var expansionEnabled bool
+
+// expansionVariants is how many rephrasings of a query the chat model is
+// asked for. Set with -expand-variants.
+var expansionVariants = 3
+
+// maxExpansionVariants bounds -expand-variants: each variant is embedded and
+// scored against the whole index, so a large count multiplies query time
+const maxExpansionVariants = 5
+
+// expansionSystemPrompt asks the model for variants that can be parsed one
+// per line
+const expansionSystemPrompt = `You rewrite questions about the Nostr protocol into search queries for its documentation. Write each rewrite on its own line, using the terms the specifications use, such as NIP numbers, event kinds, and tag names, where you know them. Reply with the rewrites only.`
+
+// expandDescription documents the expand argument of the search tools
+const expandDescription = "Let the chat model rephrase the query into a few variants, with the NIP numbers and terms the specifications use, and search with all of them. Slower, since it runs the model; defaults to the server's -expand-query."
+
+// variantPrefix matches the numbering, bullets, and labels the model puts in
+// front of its variants
+var variantPrefix = regexp.MustCompile(`^(?:\s*(?:\d+[.)]|[-*•]|(?i:query|variant|rewrite)\s*\d*:))*\s*`)
+
+// clampExpansionVariants keeps a variant count between 1 and
+// maxExpansionVariants
+func clampExpansionVariants(n int) int {
+	clamped := max(1, min(n, maxExpansionVariants))
+	if clamped != n {
+		log.Printf("Using %d query variants instead of %d (at least 1, at most %d)", clamped, n, maxExpansionVariants)
+	}
+	return clamped
+}
+
+// expandArgument reads the expand argument of a search tool call, which
+// defaults to -expand-query
+func expandArgument(request mcp.CallToolRequest) bool {
+	if value, ok := request.Params.Arguments["expand"].(bool); ok {
+		return value
+	}
+	return expansionEnabled
+}
+
+// expansionModel returns the chat model that rephrases queries
+func expansionModel() string {
+	if llmConfig.ExpansionModel != "" {
+		return llmConfig.ExpansionModel
+	}
+	return llmConfig.Model
+}
+
+// queryVariants asks the chat model for up to expansionVariants rephrasings
+// of the search text of a query
+func queryVariants(queryText string) ([]string, error) {
+	if err := checkOllamaReachable(); err != nil {
+		return nil, err
+	}
+	answer, err := completion.Chat(ollamaURL, llm.Query{
+		Model: expansionModel(),
+		Messages: []llm.Message{
+			{Role: "system", Content: expansionSystemPrompt},
+			{Role: "user", Content: fmt.Sprintf("Write %d different search queries for: %s", expansionVariants, queryText)},
+		},
+		Options: llm.Options{Temperature: 0},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error expanding the query with %s: %v", expansionModel(), err)
+	}
+	variants := parseVariants(answer.Message.Content, queryText, expansionVariants)
+	if len(variants) == 0 {
+		return nil, fmt.Errorf("no query variants in the reply %q", strings.TrimSpace(answer.Message.Content))
+	}
+	return variants, nil
+}
+
+// parseVariants reads up to n variants from the model's reply, one per line,
+// leaving out repeats of the query and of each other, and lines such as
+// "Here are the queries:" that introduce them
+func parseVariants(reply, queryText string, n int) []string {
+	seen := map[string]bool{strings.ToLower(queryText): true}
+	var variants []string
+	for _, line := range strings.Split(reply, "\n") {
+		variant := strings.Trim(variantPrefix.ReplaceAllString(line, ""), " \t\"'`")
+		key := strings.ToLower(variant)
+		if variant == "" || seen[key] || strings.HasSuffix(variant, ":") {
+			continue
+		}
+		seen[key] = true
+		variants = append(variants, variant)
+		if len(variants) == n {
+			break
+		}
+	}
+	return variants
+}
+
+// expandedQueries returns the search text of a query with its alias
+// expansions, followed by the model's variants when expand is set. When the
+// model cannot be reached the query is searched on its own.
+func expandedQueries(queryText string, expand bool, trace *retrievalTrace) []string {
+	queries := []string{expandAliases(queryText)}
+	if !expand {
+		return queries
+	}
+	variants, err := queryVariants(queryText)
+	if err != nil {
+		log.Printf("Searching without query variants: %v", err)
+		trace.VariantErr = err
+		return queries
+	}
+	trace.Variants = variants
+	for _, variant := range variants {
+		queries = append(queries, expandAliases(variant))
+	}
+	return queries
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseVariants(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		n     int
+		want  []string
+	}{
+		{
+			name:  "one per line",
+			reply: "NIP-17 private direct messages\nkind 14 chat message\n",
+			n:     3,
+			want:  []string{"NIP-17 private direct messages", "kind 14 chat message"},
+		},
+		{
+			name:  "numbering and bullets",
+			reply: "1. NIP-57 zap request\n2) zap receipt kind 9735\n- lightning payment\n* bolt11 invoice",
+			n:     5,
+			want:  []string{"NIP-57 zap request", "zap receipt kind 9735", "lightning payment", "bolt11 invoice"},
+		},
+		{
+			name:  "labels after bullets",
+			reply: "- Query 1: NIP-65 relay list\n- Variant: outbox model\nRewrite 3: kind 10002",
+			n:     3,
+			want:  []string{"NIP-65 relay list", "outbox model", "kind 10002"},
+		},
+		{
+			name:  "introduction and quotes",
+			reply: "Here are the queries:\n\n\"NIP-46 remote signer\"\n`Nostr Connect bunker`",
+			n:     3,
+			want:  []string{"NIP-46 remote signer", "Nostr Connect bunker"},
+		},
+		{
+			name:  "repeats of the query and of each other",
+			reply: "how do DMs work\nNIP-17 direct messages\nnip-17 DIRECT messages\ngift wrap",
+			n:     3,
+			want:  []string{"NIP-17 direct messages", "gift wrap"},
+		},
+		{
+			name:  "at most n",
+			reply: "a\nb\nc\nd",
+			n:     2,
+			want:  []string{"a", "b"},
+		},
+		{
+			name:  "nothing usable",
+			reply: "Sure:\n\n1.\n",
+			n:     3,
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseVariants(tt.reply, "how do DMs work", tt.n)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseVariants(%q) = %q, want %q", tt.reply, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClampExpansionVariants(t *testing.T) {
+	for n, want := range map[int]int{-2: 1, 0: 1, 1: 1, 3: 3, 5: 5, 50: maxExpansionVariants} {
+		if got := clampExpansionVariants(n); got != want {
+			t.Errorf("clampExpansionVariants(%d) = %d, want %d", n, got, want)
+		}
+	}
+}
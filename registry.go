@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// registryKey is the meta key holding the kind registry built at ingest
+const registryKey = "kind_registry"
+
+// nipRefPattern matches a link to a NIP file in a README table cell, e.g. "[57](57.md)"
+var nipRefPattern = regexp.MustCompile(`\[([0-9A-Fa-f]{2,3})\]\([0-9A-Fa-f]{2,3}\.md\)`)
+
+// markdownLinkPattern matches inline and reference links so cells can be reduced to their text
+var markdownLinkPattern = regexp.MustCompile(`\[([^\]]+)\](\([^)]*\)|\[[^\]]*\])`)
+
+// tableSeparatorPattern matches the "| --- | :---: |" row under a table header
+var tableSeparatorPattern = regexp.MustCompile(`^:?-+:?$`)
+
+// registryKind is an event kind and the NIPs that define it
+type registryKind struct {
+	Kind        string   `json:"kind"` // A number or a range such as "5000-5999"
+	Description string   `json:"description"`
+	NIPs        []string `json:"nips,omitempty"`
+	Reference   string   `json:"reference,omitempty"` // Where the kind is defined when it is not a NIP
+}
+
+// registryTag is a standardized tag and the NIPs that define it
+type registryTag struct {
+	Name      string   `json:"name"`
+	Value     string   `json:"value"`
+	Other     string   `json:"other_parameters,omitempty"`
+	NIPs      []string `json:"nips,omitempty"`
+	Reference string   `json:"reference,omitempty"`
+}
+
+// kindRegistry is the structured index of NIPs, kinds, and tags built from
+// the NIPs repository when the database is ingested
+type kindRegistry struct {
+	Commit string         `json:"commit,omitempty"`
+	NIPs   []nipEntry     `json:"nips"`
+	Kinds  []registryKind `json:"kinds"`
+	Tags   []registryTag  `json:"tags"`
+}
+
+// buildKindRegistry parses the event kind and tag tables of the NIPs README
+// and the title and status labels of every NIP file
+func buildKindRegistry() (*kindRegistry, error) {
+	nipsRepo, err := findNipsRepo()
+	if err != nil {
+		return nil, err
+	}
+	readme, err := readNipsReadme()
+	if err != nil {
+		return nil, err
+	}
+	nips, err := scanNips(nipsRepo.CloneDir)
+	if err != nil {
+		return nil, fmt.Errorf("error scanning NIPs repository: %v", err)
+	}
+
+	registry := &kindRegistry{NIPs: nips}
+	if commit, _, err := repoHeadCommit(nipsRepo.CloneDir); err == nil {
+		registry.Commit = commit
+	}
+
+	for _, row := range parseTableRows(extractSection(readme, "Event Kinds")) {
+		if len(row) < 3 {
+			continue
+		}
+		nips, reference := tableReferences(row[2])
+		registry.Kinds = append(registry.Kinds, registryKind{
+			Kind:        cellText(row[0]),
+			Description: cellText(row[1]),
+			NIPs:        nips,
+			Reference:   reference,
+		})
+	}
+
+	for _, row := range parseTableRows(extractSection(readme, "Standardized Tags")) {
+		if len(row) < 4 {
+			continue
+		}
+		nips, reference := tableReferences(row[3])
+		registry.Tags = append(registry.Tags, registryTag{
+			Name:      cellText(row[0]),
+			Value:     cellText(row[1]),
+			Other:     cellText(row[2]),
+			NIPs:      nips,
+			Reference: reference,
+		})
+	}
+
+	if len(registry.Kinds) == 0 {
+		return nil, fmt.Errorf("no event kinds found in %s", filepath.Join(nipsRepo.CloneDir, "README.md"))
+	}
+	return registry, nil
+}
+
+// parseTableRows returns the body rows of the markdown tables in section,
+// split into cells. Header and separator rows are skipped.
+func parseTableRows(section string) [][]string {
+	var rows [][]string
+	inTable := false
+	for _, line := range strings.Split(section, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "|") {
+			inTable = false
+			continue
+		}
+
+		// Escaped pipes belong to the cell text
+		line = strings.ReplaceAll(line, `\|`, "\x00")
+		var cells []string
+		for _, cell := range strings.Split(strings.Trim(line, "|"), "|") {
+			cells = append(cells, strings.TrimSpace(strings.ReplaceAll(cell, "\x00", "|")))
+		}
+
+		if !inTable {
+			// The first row of a table is its header
+			inTable = true
+			continue
+		}
+		if isSeparatorRow(cells) {
+			continue
+		}
+		rows = append(rows, cells)
+	}
+	return rows
+}
+
+// isSeparatorRow reports whether every cell of a table row is a dash rule
+func isSeparatorRow(cells []string) bool {
+	for _, cell := range cells {
+		if !tableSeparatorPattern.MatchString(cell) {
+			return false
+		}
+	}
+	return true
+}
+
+// cellText reduces a table cell to plain text
+func cellText(cell string) string {
+	cell = markdownLinkPattern.ReplaceAllString(cell, "$1")
+	return strings.TrimSpace(strings.ReplaceAll(cell, "`", ""))
+}
+
+// tableReferences returns the NIP numbers linked from a table cell, or the
+// cell's text when it links to something other than a NIP
+func tableReferences(cell string) ([]string, string) {
+	var nips []string
+	for _, match := range nipRefPattern.FindAllStringSubmatch(cell, -1) {
+		nips = append(nips, strings.ToUpper(match[1]))
+	}
+	if len(nips) > 0 {
+		return nips, ""
+	}
+	return nil, cellText(cell)
+}
+
+// loadKindRegistry reads the registry stored in the database at path. It
+// returns nil when none was recorded, e.g. because no NIPs repository was
+// enabled at ingest.
+func loadKindRegistry(path string) (*kindRegistry, error) {
+	var registry kindRegistry
+	found, err := loadMetaJSON(path, registryKey, &registry)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &registry, nil
+}
+
+// formatNipRefs renders the definitions of a kind or tag, e.g. "NIP-01, NIP-02"
+func formatNipRefs(nips []string, reference string) string {
+	if len(nips) == 0 {
+		return reference
+	}
+	refs := make([]string, len(nips))
+	for i, nip := range nips {
+		refs[i] = "NIP-" + nip
+	}
+	return strings.Join(refs, ", ")
+}
+
+// formatRegistryKinds renders the registry's event kinds as a markdown table
+func formatRegistryKinds(registry *kindRegistry) string {
+	var b strings.Builder
+	b.WriteString("# Nostr Event Kinds\n\n")
+	b.WriteString("| kind | description | NIP |\n")
+	b.WriteString("| ---- | ----------- | --- |\n")
+	for _, kind := range registry.Kinds {
+		b.WriteString(fmt.Sprintf("| `%s` | %s | %s |\n", kind.Kind, kind.Description, formatNipRefs(kind.NIPs, kind.Reference)))
+	}
+	return b.String()
+}
+
+// formatRegistryTags renders the registry's standardized tags as a markdown table
+func formatRegistryTags(registry *kindRegistry) string {
+	var b strings.Builder
+	b.WriteString("# Nostr Standardized Tags\n\n")
+	b.WriteString("| name | value | other parameters | NIP |\n")
+	b.WriteString("| ---- | ----- | ---------------- | --- |\n")
+	for _, tag := range registry.Tags {
+		b.WriteString(fmt.Sprintf("| `%s` | %s | %s | %s |\n", tag.Name, tag.Value, tag.Other, formatNipRefs(tag.NIPs, tag.Reference)))
+	}
+	return b.String()
+}
+
+func kindRegistryResourceHandler(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+	registry := currentIndex().registry
+	if registry == nil {
+		return nil, fmt.Errorf("no kind registry in the index; enable the NIPs repository and re-ingest with -ingest")
+	}
+
+	data, err := json.MarshalIndent(registry, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error serializing kind registry: %v", err)
+	}
+
+	return []mcp.ResourceContents{
+		mcp.TextResourceContents{
+			URI:      request.Params.URI,
+			MIMEType: "application/json",
+			Text:     string(data),
+		},
+	}, nil
+}
+
+// kindRange returns the bounds of a registry kind, which is a single number
+// or a range such as "5000-5999"
+func kindRange(kind string) (int, int, bool) {
+	low, high, isRange := strings.Cut(kind, "-")
+	from, err := strconv.Atoi(strings.TrimSpace(low))
+	if err != nil {
+		return 0, 0, false
+	}
+	if !isRange {
+		return from, from, true
+	}
+	to, err := strconv.Atoi(strings.TrimSpace(high))
+	if err != nil {
+		return 0, 0, false
+	}
+	return from, to, true
+}
+
+// registeredKinds returns the registry entries that cover an event kind,
+// exact matches before ranges
+func registeredKinds(registry *kindRegistry, kind int) []registryKind {
+	var exact, ranges []registryKind
+	for _, entry := range registry.Kinds {
+		from, to, ok := kindRange(entry.Kind)
+		if !ok || kind < from || kind > to {
+			continue
+		}
+		if from == to {
+			exact = append(exact, entry)
+		} else {
+			ranges = append(ranges, entry)
+		}
+	}
+	return append(exact, ranges...)
+}
+
+// kindClass names how relays store events of a kind, following the ranges
+// defined in NIP-01
+func kindClass(kind int) string {
+	switch {
+	case kind == 0 || kind == 3 || (kind >= 10000 && kind < 20000):
+		return "replaceable"
+	case kind >= 20000 && kind < 30000:
+		return "ephemeral"
+	case kind >= 30000 && kind < 40000:
+		return "addressable"
+	default:
+		return "regular"
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// relayAuthEnabled makes relay queries answer the NIP-42 AUTH challenge of
+// relays that only serve authenticated clients, signing with the signer key
+// (-relay-auth). It is off by default since it tells those relays who is
+// asking.
+var relayAuthEnabled bool
+
+// relaySigner holds the signer key, loaded the first time a relay asks for
+// authentication, so an encrypted key's passphrase is asked for only once
+var relaySigner struct {
+	once sync.Once
+	sk   string
+	err  error
+}
+
+// authRequired reports whether a relay closed a subscription because the
+// client has to authenticate first
+func authRequired(reason string) bool {
+	return strings.HasPrefix(reason, "auth-required:")
+}
+
+// authenticateRelay answers the relay's latest AUTH challenge with an event
+// signed by the signer key and waits for the relay to accept it
+func authenticateRelay(ctx context.Context, relay *nostr.Relay) error {
+	relaySigner.once.Do(func() {
+		relaySigner.sk, relaySigner.err = loadPrivateKey(signerKeyRef)
+	})
+	if relaySigner.err != nil {
+		return fmt.Errorf("cannot authenticate to %s: error loading signer key from %s: %v", relay.URL, describeSecretRef(signerKeyRef), relaySigner.err)
+	}
+	if err := relay.Auth(ctx, func(ev *nostr.Event) error { return ev.Sign(relaySigner.sk) }); err != nil {
+		return fmt.Errorf("error authenticating to %s: %v", relay.URL, err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// authRelay is a relay that only answers subscriptions after a client has
+// authenticated with NIP-42
+func authRelay(stored *nostr.Event) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.CloseNow()
+		ctx := r.Context()
+		send := func(message ...any) {
+			data, _ := json.Marshal(message)
+			conn.Write(ctx, websocket.MessageText, data)
+		}
+
+		send("AUTH", "challenge-1")
+		authenticated := false
+		for {
+			_, data, err := conn.Read(ctx)
+			if err != nil {
+				return
+			}
+			var message []json.RawMessage
+			if json.Unmarshal(data, &message) != nil || len(message) < 2 {
+				continue
+			}
+			var label, subID string
+			json.Unmarshal(message[0], &label)
+			switch label {
+			case "AUTH":
+				var ev nostr.Event
+				json.Unmarshal(message[1], &ev)
+				ok, _ := ev.CheckSignature()
+				authenticated = ok && ev.Kind == nostr.KindClientAuthentication && ev.Tags.GetFirst([]string{"challenge", "challenge-1"}) != nil
+				send("OK", ev.ID, authenticated, "")
+			case "REQ":
+				json.Unmarshal(message[1], &subID)
+				if !authenticated {
+					send("CLOSED", subID, "auth-required: this relay only serves its members")
+					continue
+				}
+				send("EVENT", subID, stored)
+				send("EOSE", subID)
+			}
+		}
+	}))
+}
+
+func TestCollectStoredEventsAuthenticates(t *testing.T) {
+	author := nostr.GeneratePrivateKey()
+	stored := &nostr.Event{CreatedAt: nostr.Now(), Kind: 1, Content: "members only"}
+	if err := stored.Sign(author); err != nil {
+		t.Fatal(err)
+	}
+	server := authRelay(stored)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	t.Setenv("BHN_TEST_SIGNER", nostr.GeneratePrivateKey())
+	savedRef, savedEnabled := signerKeyRef, relayAuthEnabled
+	t.Cleanup(func() {
+		signerKeyRef, relayAuthEnabled = savedRef, savedEnabled
+		relaySigner.once = sync.Once{}
+		relaySigner.sk, relaySigner.err = "", nil
+	})
+	signerKeyRef = "env:BHN_TEST_SIGNER"
+
+	collect := func() []*nostr.Event {
+		ctx := context.Background()
+		relay, err := nostr.RelayConnect(ctx, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer relay.Close()
+		var events []*nostr.Event
+		err = collectStoredEvents(ctx, relay, []nostr.Filter{{Kinds: []int{1}}}, 5*time.Second, func(ev *nostr.Event) bool {
+			events = append(events, ev)
+			return true
+		})
+		if err != nil {
+			t.Fatalf("collectStoredEvents: %v", err)
+		}
+		return events
+	}
+
+	relayAuthEnabled = false
+	if events := collect(); len(events) != 0 {
+		t.Errorf("got %d events without -relay-auth, want none", len(events))
+	}
+
+	relayAuthEnabled = true
+	events := collect()
+	if len(events) != 1 || events[0].ID != stored.ID {
+		t.Errorf("got %v after authenticating, want the stored event", events)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// relayBreakerThreshold is the number of failures in a row after which
+	// a relay's circuit opens and it is skipped
+	relayBreakerThreshold = 3
+
+	// An open circuit lets a probe through after the first cooldown, which
+	// doubles after every failed probe up to the last
+	relayBreakerCooldown    = time.Minute
+	maxRelayBreakerCooldown = 30 * time.Minute
+)
+
+// maxRelayConnections limits the connections open to any one relay at once,
+// since many relays refuse clients that open too many (0 for no limit)
+var maxRelayConnections = 4
+
+var (
+	// errRelayCircuitOpen is returned instead of connecting to a relay that
+	// has been skipped since it kept failing
+	errRelayCircuitOpen = errors.New("relay skipped after repeated failures")
+
+	// errRelayHung is returned when a relay accepted a subscription but sent
+	// neither events nor EOSE before the deadline
+	errRelayHung = errors.New("relay sent nothing before the deadline")
+)
+
+// relayCircuit is the circuit breaker of one relay. It is closed while the
+// relay works. After relayBreakerThreshold failures in a row it opens, and
+// connections are refused without contacting the relay until the cooldown
+// passes. Then a single probe is let through: if it succeeds the circuit
+// closes, otherwise it opens again with a longer cooldown.
+type relayCircuit struct {
+	failures   int           // Failures in a row
+	openUntil  time.Time     // When a probe is let through; zero while closed
+	cooldown   time.Duration // How long the circuit stays open after its last failure
+	probeSince time.Time     // When the probe in flight started; zero if none
+}
+
+// relayCircuits holds the circuit breaker and connection slots of every
+// relay that has been contacted
+var relayCircuits = struct {
+	mutex    sync.Mutex
+	circuits map[string]*relayCircuit
+	slots    map[string]chan struct{}
+}{circuits: make(map[string]*relayCircuit), slots: make(map[string]chan struct{})}
+
+// circuitFor returns the circuit of a relay. The mutex must be held.
+func circuitFor(url string) *relayCircuit {
+	circuit, ok := relayCircuits.circuits[url]
+	if !ok {
+		circuit = &relayCircuit{}
+		relayCircuits.circuits[url] = circuit
+	}
+	return circuit
+}
+
+// allowRelay reports whether a relay may be contacted, refusing relays whose
+// circuit is open. Once the cooldown has passed, one caller at a time is
+// allowed through to probe the relay.
+func allowRelay(url string) error {
+	relayCircuits.mutex.Lock()
+	defer relayCircuits.mutex.Unlock()
+
+	circuit := circuitFor(url)
+	now := time.Now()
+	switch {
+	case circuit.openUntil.IsZero():
+		return nil
+	case now.Before(circuit.openUntil):
+		return fmt.Errorf("%w; retrying in %s", errRelayCircuitOpen, circuit.openUntil.Sub(now).Round(time.Second))
+	case !circuit.probeSince.IsZero() && now.Sub(circuit.probeSince) < relayBreakerCooldown:
+		// Another probe is in flight; a probe whose caller never reported
+		// back is given up on after a cooldown
+		return fmt.Errorf("%w; probing it", errRelayCircuitOpen)
+	}
+	circuit.probeSince = now
+	log.Printf("Probing relay %s, skipped for %s after repeated failures", url, circuit.cooldown)
+	return nil
+}
+
+// relaySucceeded closes the circuit of a relay that completed a request
+func relaySucceeded(url string) {
+	relayCircuits.mutex.Lock()
+	defer relayCircuits.mutex.Unlock()
+
+	circuit := circuitFor(url)
+	if !circuit.openUntil.IsZero() {
+		log.Printf("Relay %s recovered; using it again", url)
+	}
+	*circuit = relayCircuit{}
+}
+
+// relayFailed counts a failure of a relay, opening its circuit after
+// relayBreakerThreshold failures in a row or when a probe fails. Failures
+// caused by the caller giving up are not the relay's fault and not counted.
+func relayFailed(ctx context.Context, url string, err error) {
+	if errors.Is(err, errRelayCircuitOpen) || errors.Is(ctx.Err(), context.Canceled) {
+		return
+	}
+	relayCircuits.mutex.Lock()
+	defer relayCircuits.mutex.Unlock()
+
+	circuit := circuitFor(url)
+	circuit.failures++
+	switch {
+	case !circuit.probeSince.IsZero():
+		if circuit.cooldown *= 2; circuit.cooldown > maxRelayBreakerCooldown {
+			circuit.cooldown = maxRelayBreakerCooldown
+		}
+		circuit.probeSince = time.Time{}
+		log.Printf("Probe of relay %s failed: %v; skipping it for %s", url, err, circuit.cooldown)
+	case circuit.openUntil.IsZero() && circuit.failures >= relayBreakerThreshold:
+		circuit.cooldown = relayBreakerCooldown
+		log.Printf("Relay %s failed %d times in a row (last: %v); skipping it for %s", url, circuit.failures, err, circuit.cooldown)
+	default:
+		return
+	}
+	circuit.openUntil = time.Now().Add(circuit.cooldown)
+}
+
+// relayCircuitOpen reports whether a relay is being skipped, so ranking can
+// put it last
+func relayCircuitOpen(url string) bool {
+	relayCircuits.mutex.Lock()
+	defer relayCircuits.mutex.Unlock()
+
+	circuit, ok := relayCircuits.circuits[url]
+	return ok && time.Now().Before(circuit.openUntil)
+}
+
+// acquireRelaySlot waits until fewer than maxRelayConnections connections
+// are open to a relay and takes a slot, returning the function that frees it
+func acquireRelaySlot(ctx context.Context, url string) (func(), error) {
+	if maxRelayConnections <= 0 {
+		return func() {}, nil
+	}
+	relayCircuits.mutex.Lock()
+	slots, ok := relayCircuits.slots[url]
+	if !ok {
+		slots = make(chan struct{}, maxRelayConnections)
+		relayCircuits.slots[url] = slots
+	}
+	relayCircuits.mutex.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		var once sync.Once
+		return func() { once.Do(func() { <-slots }) }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for a free connection to %s: %w", url, ctx.Err())
+	}
+}
+
+// formatRelayCircuits lists the relays that are being skipped, for the server
+// status
+func formatRelayCircuits() string {
+	relayCircuits.mutex.Lock()
+	defer relayCircuits.mutex.Unlock()
+
+	var lines []string
+	now := time.Now()
+	for url, circuit := range relayCircuits.circuits {
+		if circuit.openUntil.IsZero() {
+			continue
+		}
+		if now.Before(circuit.openUntil) {
+			lines = append(lines, fmt.Sprintf("- %s: skipped after %d failures in a row, probed again in %s\n", url, circuit.failures, circuit.openUntil.Sub(now).Round(time.Second)))
+		} else {
+			lines = append(lines, fmt.Sprintf("- %s: skipped after %d failures in a row, probed at the next request\n", url, circuit.failures))
+		}
+	}
+	if len(lines) == 0 {
+		return "- All relays in use\n"
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "")
+}
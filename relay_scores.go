@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// relayScoresFile persists relay statistics between runs
+var relayScoresFile = filepath.Join(dataDir, "relay-scores.json")
+
+// relayExploreRate is the chance that a lower-ranked relay is moved to the
+// front of the list, so recovering relays get a chance to prove themselves
+const relayExploreRate = 0.1
+
+// relayLatencyWeight controls how quickly the latency average follows new samples
+const relayLatencyWeight = 0.3
+
+// relayStats tracks how a relay has behaved over time
+type relayStats struct {
+	Successes   int           `json:"successes"`
+	Failures    int           `json:"failures"`
+	Latency     time.Duration `json:"latency"` // Moving average of connect latency
+	LastSuccess time.Time     `json:"last_success,omitempty"`
+	LastFailure time.Time     `json:"last_failure,omitempty"`
+}
+
+// relayScoreboard holds the stats for every relay that has been contacted
+type relayScoreboard struct {
+	mutex  sync.Mutex
+	relays map[string]*relayStats
+}
+
+var relayScores = &relayScoreboard{relays: make(map[string]*relayStats)}
+
+// score combines the success rate and latency into a single ranking value.
+// Unknown relays start at a neutral score.
+func (s *relayStats) score() float64 {
+	rate := float64(s.Successes+1) / float64(s.Successes+s.Failures+2)
+	return rate / (1 + s.Latency.Seconds())
+}
+
+// loadRelayScores reads persisted relay statistics, if any
+func loadRelayScores() {
+	data, err := os.ReadFile(relayScoresFile)
+	if err != nil {
+		return
+	}
+
+	relays := make(map[string]*relayStats)
+	if err := json.Unmarshal(data, &relays); err != nil {
+		return
+	}
+
+	relayScores.mutex.Lock()
+	relayScores.relays = relays
+	relayScores.mutex.Unlock()
+}
+
+// saveRelayScores writes the relay statistics to disk
+func saveRelayScores() error {
+	relayScores.mutex.Lock()
+	data, err := json.MarshalIndent(relayScores.relays, "", "  ")
+	relayScores.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(relayScoresFile), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(relayScoresFile, data, 0644)
+}
+
+// recordRelayResult updates a relay's statistics after an attempt to use it
+func recordRelayResult(url string, latency time.Duration, err error) {
+	if errors.Is(err, errRelayCircuitOpen) {
+		// The relay was skipped, not tried
+		return
+	}
+	relayScores.mutex.Lock()
+	defer relayScores.mutex.Unlock()
+
+	stats, ok := relayScores.relays[url]
+	if !ok {
+		stats = &relayStats{}
+		relayScores.relays[url] = stats
+	}
+
+	if err != nil {
+		stats.Failures++
+		stats.LastFailure = time.Now()
+		return
+	}
+
+	stats.Successes++
+	stats.LastSuccess = time.Now()
+	if stats.Latency == 0 {
+		stats.Latency = latency
+	} else {
+		stats.Latency = time.Duration(relayLatencyWeight*float64(latency) + (1-relayLatencyWeight)*float64(stats.Latency))
+	}
+}
+
+// rankRelays orders relays from healthiest to least healthy, keeping at most
+// maxRelays of them. Relays skipped by their circuit breaker come last.
+// Occasionally a relay from the lower half is promoted to the front for
+// exploration.
+func rankRelays(urls []string) []string {
+	ranked := make([]string, len(urls))
+	copy(ranked, urls)
+
+	relayScores.mutex.Lock()
+	scores := make(map[string]float64, len(ranked))
+	for _, url := range ranked {
+		stats, ok := relayScores.relays[url]
+		if !ok {
+			stats = &relayStats{}
+		}
+		scores[url] = stats.score()
+	}
+	relayScores.mutex.Unlock()
+	for _, url := range ranked {
+		if relayCircuitOpen(url) {
+			scores[url] = -1
+		}
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return scores[ranked[i]] > scores[ranked[j]]
+	})
+
+	usable := len(ranked)
+	for usable > 0 && scores[ranked[usable-1]] < 0 {
+		usable--
+	}
+	if usable > 1 && rand.Float64() < relayExploreRate {
+		half := usable / 2
+		pick := half + rand.Intn(usable-half)
+		explored := ranked[pick]
+		copy(ranked[1:pick+1], ranked[:pick])
+		ranked[0] = explored
+	}
+
+	if maxRelays > 0 && len(ranked) > maxRelays {
+		ranked = ranked[:maxRelays]
+	}
+	return ranked
+}
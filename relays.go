@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"golang.org/x/sync/errgroup"
+)
+
+// Relays used by the code snippet subsystem
+var (
+	// cacheRelays are swept periodically to populate the snippet cache
+	cacheRelays = []string{
+		"wss://relay.damus.io",
+		"wss://relay.nostr.band",
+		"wss://nos.lol",
+		"wss://relay.snort.social",
+	}
+
+	// searchRelays are queried live when the cache has too few results
+	searchRelays = []string{
+		"wss://relay.damus.io",
+		"wss://purplepag.es",
+		"wss://relay.current.fyi",
+		"wss://relay.nostr.band",
+		"wss://nos.lol",
+		"wss://relay.snort.social",
+	}
+
+	// queryRelays are queried for broad, query-only searches
+	queryRelays = []string{
+		"wss://relay.damus.io",
+		"wss://purplepag.es",
+	}
+)
+
+// maxRelays limits how many of the ranked relays are queried at once (0 for all)
+var maxRelays = 0
+
+// relayDeadline bounds how long a single relay may take to deliver its stored
+// events before collection moves on to the next relay
+var relayDeadline = 10 * time.Second
+
+// relayHealthTimeout bounds each step of a relay health check
+var relayHealthTimeout = 10 * time.Second
+
+// relayHealth is the result of probing a single relay
+type relayHealth struct {
+	URL            string
+	Connected      bool
+	ConnectLatency time.Duration
+	NIP11          bool
+	NIP11Name      string
+	EOSE           bool
+	EOSELatency    time.Duration
+	Error          string
+}
+
+// configuredRelays returns every relay the server uses, without duplicates
+func configuredRelays() []string {
+	seen := make(map[string]bool)
+	var relays []string
+	for _, list := range [][]string{cacheRelays, searchRelays, queryRelays} {
+		for _, url := range list {
+			if !seen[url] {
+				seen[url] = true
+				relays = append(relays, url)
+			}
+		}
+	}
+	return relays
+}
+
+// checkRelays probes all relays concurrently and returns the results in input order
+func checkRelays(ctx context.Context, urls []string) []relayHealth {
+	results := make([]relayHealth, len(urls))
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			results[i] = checkRelay(ctx, url)
+		}(i, url)
+	}
+	wg.Wait()
+	return results
+}
+
+// checkRelay measures connect latency, NIP-11 availability, and whether a
+// small test subscription reaches EOSE
+func checkRelay(ctx context.Context, url string) relayHealth {
+	// The NIP-11 document is fetched while the relay is probed
+	var name string
+	var nip11 bool
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		name, nip11 = fetchRelayName(ctx, url)
+	}()
+
+	health := probeRelay(ctx, url)
+	wg.Wait()
+	health.NIP11Name, health.NIP11 = name, nip11
+	return health
+}
+
+// probeRelay connects to a relay and runs a small test subscription,
+// measuring the connect latency and the time to EOSE
+func probeRelay(ctx context.Context, url string) relayHealth {
+	health := relayHealth{URL: url}
+
+	connectCtx, cancel := context.WithTimeout(ctx, relayHealthTimeout)
+	defer cancel()
+
+	start := time.Now()
+	relay, err := connectRelay(connectCtx, url)
+	recordRelayResult(url, time.Since(start), err)
+	if err != nil {
+		health.Error = fmt.Sprintf("connect: %v", err)
+		return health
+	}
+	defer relay.Close()
+	health.Connected = true
+	health.ConnectLatency = time.Since(start)
+
+	subCtx, subCancel := context.WithTimeout(ctx, relayHealthTimeout)
+	defer subCancel()
+
+	start = time.Now()
+	sub, err := relay.Subscribe(subCtx, nostr.Filters{{Kinds: []int{kindCodeSnippet}, Limit: 1}})
+	if err != nil {
+		health.Error = fmt.Sprintf("subscribe: %v", err)
+		return health
+	}
+	defer sub.Unsub()
+
+	for {
+		select {
+		case <-sub.Events:
+			// Drain events until the relay signals the end of stored events
+		case <-sub.EndOfStoredEvents:
+			health.EOSE = true
+			health.EOSELatency = time.Since(start)
+			relaySucceeded(url)
+			return health
+		case <-subCtx.Done():
+			health.Error = "no EOSE before timeout"
+			relayFailed(ctx, url, errRelayHung)
+			return health
+		}
+	}
+}
+
+// fetchRelayName requests the relay's NIP-11 information document and
+// returns the advertised name and whether the document was available
+func fetchRelayName(ctx context.Context, url string) (string, bool) {
+	httpURL := strings.Replace(strings.Replace(url, "wss://", "https://", 1), "ws://", "http://", 1)
+
+	reqCtx, cancel := context.WithTimeout(ctx, relayHealthTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, httpURL, nil)
+	if err != nil {
+		return "", false
+	}
+	req.Header.Set("Accept", "application/nostr+json")
+
+	resp, err := outboundClient.Do(req)
+	if err != nil {
+		return "", false
+	}
+	defer resp.Body.Close()
+
+	var info struct {
+		Name string `json:"name"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&info) != nil {
+		return "", false
+	}
+	return info.Name, true
+}
+
+// formatRelayHealth renders relay health results as a markdown table,
+// healthiest relays first
+func formatRelayHealth(results []relayHealth) string {
+	sorted := make([]relayHealth, len(results))
+	copy(sorted, results)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].EOSE != sorted[j].EOSE {
+			return sorted[i].EOSE
+		}
+		return sorted[i].ConnectLatency < sorted[j].ConnectLatency
+	})
+
+	var b strings.Builder
+	b.WriteString("| Relay | Connect | EOSE | NIP-11 | Notes |\n")
+	b.WriteString("| ----- | ------- | ---- | ------ | ----- |\n")
+
+	healthy := 0
+	for _, r := range sorted {
+		connect := "failed"
+		if r.Connected {
+			connect = r.ConnectLatency.Round(time.Millisecond).String()
+		}
+		eose := "no"
+		if r.EOSE {
+			eose = r.EOSELatency.Round(time.Millisecond).String()
+			healthy++
+		}
+		nip11 := "no"
+		if r.NIP11 {
+			nip11 = "yes"
+			if r.NIP11Name != "" {
+				nip11 = fmt.Sprintf("yes (%s)", r.NIP11Name)
+			}
+		}
+		b.WriteString(fmt.Sprintf("| %s | %s | %s | %s | %s |\n", r.URL, connect, eose, nip11, r.Error))
+	}
+
+	b.WriteString(fmt.Sprintf("\n%d of %d relays healthy\n", healthy, len(results)))
+	return b.String()
+}
+
+// collectStoredEvents subscribes to a relay and passes each stored event to
+// handle. Collection stops as soon as the relay sends EOSE, the deadline
+// passes, or handle returns false. Every received event is archived. A relay
+// that sends nothing at all before the deadline returns errRelayHung. With
+// -relay-auth, a relay that requires authentication is answered and the
+// subscription is sent once more.
+func collectStoredEvents(ctx context.Context, relay *nostr.Relay, filters []nostr.Filter, deadline time.Duration, handle func(*nostr.Event) bool) error {
+	subCtx, cancel := context.WithTimeout(ctx, deadline)
+	defer cancel()
+
+	received := false
+	for authenticated := false; ; authenticated = true {
+		closed, err := readStoredEvents(subCtx, relay, filters, &received, handle)
+		if err != nil || authenticated || !relayAuthEnabled || !authRequired(closed) {
+			return err
+		}
+		if err := authenticateRelay(subCtx, relay); err != nil {
+			return err
+		}
+	}
+}
+
+// readStoredEvents runs one subscription of collectStoredEvents and returns
+// the reason the relay gave if it closed the subscription
+func readStoredEvents(ctx context.Context, relay *nostr.Relay, filters []nostr.Filter, received *bool, handle func(*nostr.Event) bool) (string, error) {
+	sub, err := relay.Subscribe(ctx, filters)
+	if err != nil {
+		return "", err
+	}
+	defer sub.Unsub()
+
+	for {
+		select {
+		case ev, ok := <-sub.Events:
+			if !ok {
+				// A CLOSED message's reason is sent before Events is closed
+				select {
+				case reason := <-sub.ClosedReason:
+					return reason, nil
+				default:
+					return "", nil
+				}
+			}
+			*received = true
+			archiveEvent(ev)
+			if !handle(ev) {
+				return "", nil
+			}
+		case reason := <-sub.ClosedReason:
+			return reason, nil
+		case <-sub.EndOfStoredEvents:
+			return "", nil
+		case <-ctx.Done():
+			if !*received && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return "", errRelayHung
+			}
+			return "", nil
+		}
+	}
+}
+
+// fetchFromRelays queries every relay concurrently, each under its own
+// deadline, and merges the accepted events in relay order without duplicates.
+// Each relay contributes at most limit events (0 for no limit), so a slow or
+// failing relay never keeps the others from being heard.
+func fetchFromRelays(ctx context.Context, urls []string, filters []nostr.Filter, deadline time.Duration, limit int, accept func(*nostr.Event) bool) []*nostr.Event {
+	perRelay := make([][]*nostr.Event, len(urls))
+
+	// Every relay is queried on its own: one that fails must not cancel the
+	// others, so no goroutine returns an error
+	var g errgroup.Group
+	for i, url := range urls {
+		g.Go(func() error {
+			relayCtx, cancel := context.WithTimeout(ctx, deadline)
+			defer cancel()
+
+			start := time.Now()
+			relay, err := connectRelay(relayCtx, url)
+			recordRelayResult(url, time.Since(start), err)
+			if err != nil {
+				// A failing relay is not an error for the search as a whole
+				return nil
+			}
+			defer relay.Close()
+
+			err = collectStoredEvents(relayCtx, relay, filters, deadline, func(ev *nostr.Event) bool {
+				if accept(ev) {
+					perRelay[i] = append(perRelay[i], ev)
+				}
+				return limit <= 0 || len(perRelay[i]) < limit
+			})
+			if errors.Is(err, errRelayHung) {
+				relayFailed(ctx, url, err)
+				return nil
+			}
+			if err != nil {
+				log.Printf("Error querying relay %s: %v", url, err)
+				return nil
+			}
+			relaySucceeded(url)
+			return nil
+		})
+	}
+	g.Wait()
+
+	var events []*nostr.Event
+	seen := make(map[string]bool)
+	for _, relayEvents := range perRelay {
+		for _, ev := range relayEvents {
+			if !seen[ev.ID] {
+				seen[ev.ID] = true
+				events = append(events, ev)
+			}
+		}
+	}
+	return events
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+)
+
+// RepoAuth holds the credentials for cloning and pulling a private
+// repository. Secrets are given as references (see resolveSecret), never as
+// plain values.
+type RepoAuth struct {
+	Username      string `json:",omitempty"` // HTTPS user name sent with Token (default: "git"); some hosts require a specific one, e.g. "oauth2" on GitLab
+	Token         string `json:",omitempty"` // Reference to an HTTPS token or password, e.g. "env:GITHUB_TOKEN"
+	SSHKey        string `json:",omitempty"` // Path to a private SSH key, used for ssh:// and git@host:path URLs
+	SSHPassphrase string `json:",omitempty"` // Reference to the passphrase of an encrypted SSH key
+	SSHUser       string `json:",omitempty"` // SSH user name (default: the user in the URL, or "git")
+}
+
+// defaultGitUser is the user name used when a repository's auth names none
+const defaultGitUser = "git"
+
+// isSSHURL reports whether a clone URL uses SSH, as ssh://host/path or in
+// the scp-like form user@host:path
+func isSSHURL(url string) bool {
+	if strings.HasPrefix(url, "ssh://") {
+		return true
+	}
+	return !strings.Contains(url, "://") && strings.Contains(url, "@") && strings.Contains(url, ":")
+}
+
+// sshURLUser returns the user name of an SSH URL, e.g. "git" for
+// git@github.com:org/repo.git
+func sshURLUser(url string) string {
+	url = strings.TrimPrefix(url, "ssh://")
+	if user, _, ok := strings.Cut(url, "@"); ok && !strings.Contains(user, "/") {
+		return user
+	}
+	return ""
+}
+
+// repoAuth returns the go-git authentication for fetching a repository from
+// url, or nil for public repositories. Credentials are only sent to the host
+// of the repository's own URL, so a mirror on another host never sees them.
+func repoAuth(repo RepoConfig, url string) (transport.AuthMethod, error) {
+	auth := repo.Auth
+	if auth == nil || outboundHost(url) != outboundHost(repo.URL) {
+		return nil, nil
+	}
+
+	if isSSHURL(url) {
+		if auth.SSHKey == "" {
+			// Leave SSH to go-git's default, the SSH agent
+			return nil, nil
+		}
+		if _, err := os.Stat(auth.SSHKey); err != nil {
+			return nil, fmt.Errorf("SSH key of %s: %v", repo.Name, err)
+		}
+		passphrase := ""
+		if auth.SSHPassphrase != "" {
+			var err error
+			if passphrase, err = resolveSecret(auth.SSHPassphrase); err != nil {
+				return nil, fmt.Errorf("SSH key passphrase of %s: %v", repo.Name, err)
+			}
+		}
+		user := auth.SSHUser
+		if user == "" {
+			user = sshURLUser(url)
+		}
+		if user == "" {
+			user = defaultGitUser
+		}
+		keys, err := gitssh.NewPublicKeysFromFile(user, auth.SSHKey, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("error loading SSH key of %s: %v", repo.Name, err)
+		}
+		return keys, nil
+	}
+
+	if auth.Token == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(url, "http://") {
+		return nil, fmt.Errorf("refusing to send the token of %s over unencrypted HTTP", repo.Name)
+	}
+	token, err := resolveSecret(auth.Token)
+	if err != nil {
+		return nil, fmt.Errorf("token of %s: %v", repo.Name, err)
+	}
+	user := auth.Username
+	if user == "" {
+		user = defaultGitUser
+	}
+	return &githttp.BasicAuth{Username: user, Password: token}, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.etcd.io/bbolt"
+)
+
+// findRepoByName returns the index of the configured repository with the
+// given name, or -1
+func findRepoByName(name string) int {
+	for i, repo := range repos {
+		if repo.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// removeRepository deletes a repository from the configuration, drops its
+// chunks from the database, and optionally deletes its clone
+func removeRepository(name string, deleteClone bool) {
+	i := findRepoByName(name)
+	if i < 0 {
+		fmt.Printf("Error: no repository named %s\n", name)
+		os.Exit(1)
+	}
+	repo := repos[i]
+
+	repos = append(repos[:i:i], repos[i+1:]...)
+	saveReposToFile(reposConfigFile)
+	fmt.Printf("Removed repository %s (%s) from %s\n", repo.Name, repo.URL, reposConfigFile)
+
+	purgeRepository(repo.Name)
+
+	if !deleteClone {
+		fmt.Printf("The clone in %s was kept; use -delete-clone to delete it, or -clean to remove all unused clones.\n", repo.CloneDir)
+		return
+	}
+	for _, other := range repos {
+		if filepath.Clean(other.CloneDir) == filepath.Clean(repo.CloneDir) {
+			fmt.Printf("Kept %s, which is also the clone of %s.\n", repo.CloneDir, other.Name)
+			return
+		}
+	}
+	// Like -clean, only git clones are deleted; anything else may be user data
+	if _, err := os.Stat(filepath.Join(repo.CloneDir, ".git")); err != nil {
+		fmt.Printf("Kept %s, which is not a git clone.\n", repo.CloneDir)
+		return
+	}
+	size := pathSize(repo.CloneDir)
+	if err := os.RemoveAll(repo.CloneDir); err != nil {
+		fmt.Printf("Error deleting %s: %v\n", repo.CloneDir, err)
+		return
+	}
+	fmt.Printf("Deleted %s (%s)\n", repo.CloneDir, formatBytes(size))
+}
+
+// setRepositoryEnabled enables or disables a repository. The chunks of a
+// disabled repository are dropped from the database so that it is no longer
+// searched; an enabled one is indexed by the next ingest.
+func setRepositoryEnabled(name string, enabled bool) {
+	i := findRepoByName(name)
+	if i < 0 {
+		fmt.Printf("Error: no repository named %s\n", name)
+		os.Exit(1)
+	}
+	if repos[i].Enabled == enabled {
+		fmt.Printf("Repository %s is already %s.\n", name, enabledState(enabled))
+		return
+	}
+
+	repos[i].Enabled = enabled
+	saveReposToFile(reposConfigFile)
+	fmt.Printf("Repository %s is now %s.\n", name, enabledState(enabled))
+
+	if enabled {
+		fmt.Println("Run with -ingest (and -clone-repos if it is not cloned yet) to index it.")
+		return
+	}
+	purgeRepository(name)
+}
+
+// enabledState describes whether a repository is enabled
+func enabledState(enabled bool) string {
+	if enabled {
+		return "enabled"
+	}
+	return "disabled"
+}
+
+// purgeRepository drops a repository's chunks and corpus entry from the
+// database and reports the outcome
+func purgeRepository(name string) {
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		return
+	}
+
+	removed, err := purgeRepoEmbeddings(dbPath, name)
+	if err != nil {
+		fmt.Printf("Error removing the chunks of %s from %s: %v\n", name, dbPath, err)
+		fmt.Println("Stop any running server and try again, or run -ingest to rebuild the index.")
+		return
+	}
+
+	var manifest corpusManifest
+	if found, err := loadMetaJSON(dbPath, corpusKey, &manifest); err == nil && found {
+		kept := manifest.Repos[:0]
+		for _, entry := range manifest.Repos {
+			if entry.Name != name {
+				kept = append(kept, entry)
+			}
+		}
+		manifest.Repos = kept
+		if err := storeMetaJSON(dbPath, corpusKey, manifest); err != nil {
+			fmt.Printf("Error updating the corpus manifest: %v\n", err)
+		}
+	}
+	fmt.Printf("Removed %d chunks of %s from %s\n", removed, name, dbPath)
+}
+
+// purgeRepoEmbeddings deletes every stored chunk of a repository and returns
+// how many were deleted
+func purgeRepoEmbeddings(path, name string) (int, error) {
+	return purgeChunks(path, func(id string) bool {
+		return chunkRepo(id) == name
+	})
+}
+
+// purgeChunks deletes the stored chunks whose IDs match and returns how many
+// were deleted
+func purgeChunks(path string, match func(id string) bool) (int, error) {
+	db, err := openRawDatabase(path, false)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	removed := 0
+	err = db.Update(func(tx *bbolt.Tx) error {
+		removed, err = deleteChunks(tx, match)
+		return err
+	})
+	return removed, err
+}
+
+// deleteChunks deletes the chunks whose IDs match in a write transaction and
+// returns how many there were
+func deleteChunks(tx *bbolt.Tx, match func(id string) bool) (int, error) {
+	removed := 0
+	err := tx.ForEach(func(bucketName []byte, bucket *bbolt.Bucket) error {
+		if string(bucketName) == metaBucket {
+			return nil
+		}
+
+		// Keys are collected first since a bucket cannot change while it is iterated
+		var keys [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			if v != nil && match(string(k)) {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		removed += len(keys)
+		return nil
+	})
+	return removed, err
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errOutsideClone is returned for files of a clone that are symlinks to
+// files elsewhere on the host
+var errOutsideClone = errors.New("symlink out of the clone")
+
+// checkRepoName rejects repository names that would not stay a single
+// directory name in the default clone directory, such as "../etc", and the
+// name of scratch documents' chunk IDs, whose chunks every session can read
+func checkRepoName(name string) error {
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid repository name %q", name)
+	}
+	if name == scratchRepo {
+		return fmt.Errorf("repository name %q is reserved for scratch documents", name)
+	}
+	return nil
+}
+
+// checkRepoCollection rejects the scratch collection, which is searched for
+// every query and readable with any key
+func checkRepoCollection(collection string) error {
+	if collection == collectionScratch {
+		return fmt.Errorf("collection %q is reserved for scratch documents", collection)
+	}
+	return nil
+}
+
+// checkCloneDir checks the clone directory of a repository. Clone
+// directories are walked when ingesting and replaced by clones, so each must
+// be a directory of its own inside the data directory; a repository could
+// otherwise index or overwrite any directory on the host.
+func checkCloneDir(repo RepoConfig) error {
+	data, err := filepath.Abs(dataDir)
+	if err != nil {
+		return fmt.Errorf("error resolving data directory %s: %v", dataDir, err)
+	}
+	dir, err := filepath.Abs(repo.CloneDir)
+	if err != nil {
+		return fmt.Errorf("error resolving clone directory %s: %v", repo.CloneDir, err)
+	}
+	if dir == data || !insideDir(data, dir) {
+		return fmt.Errorf("clone directory %s must be a directory inside the data directory %s", repo.CloneDir, dataDir)
+	}
+	return nil
+}
+
+// insideDir reports whether path is dir or a path below it. Both must be
+// absolute and clean.
+func insideDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolveCloneFile resolves the symlinks in the path of a file in a clone,
+// whose own symlinks are resolved in root, and returns errOutsideClone when
+// it leads out of the clone
+func resolveCloneFile(root, path string) (string, error) {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if abs, err := filepath.Abs(target); err != nil || !insideDir(root, abs) {
+		return "", errOutsideClone
+	}
+	return target, nil
+}
+
+// cloneRoot returns the absolute clone directory with its symlinks resolved
+func cloneRoot(cloneDir string) (string, error) {
+	root, err := filepath.EvalSymlinks(cloneDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(root)
+}
+
+// symlinkSkipReason returns why a symlink found by the walker should not be
+// ingested: it is broken, leads out of the clone, or is not a file. It
+// returns "" for a symlink to a file in the clone.
+func symlinkSkipReason(root, path string) string {
+	target, err := resolveCloneFile(root, path)
+	if errors.Is(err, errOutsideClone) {
+		return errOutsideClone.Error()
+	}
+	if err != nil {
+		return "broken symlink"
+	}
+	if info, err := os.Stat(target); err != nil || !info.Mode().IsRegular() {
+		return "symlink to something other than a file"
+	}
+	return ""
+}
+
+// readCloneFile reads a file of a clone, refusing to follow a symlink out of
+// it
+func readCloneFile(cloneDir, name string) ([]byte, error) {
+	root, err := cloneRoot(cloneDir)
+	if err != nil {
+		return nil, err
+	}
+	target, err := resolveCloneFile(root, filepath.Join(cloneDir, name))
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(target)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/parakeet-nest/parakeet/completion"
+	"github.com/parakeet-nest/parakeet/llm"
+)
+
+// rerankEnabled reranks the selected chunks with the chat model unless a
+// query asks otherwise. Set with -rerank.
+var rerankEnabled bool
+
+// rerankTopN is how many of the best candidates are given to the model to
+// rerank. Set with -rerank-top.
+var rerankTopN = 10
+
+// rerankExcerptChars caps the text of each chunk sent to the model, so the
+// prompt fits small local models
+const rerankExcerptChars = 1500
+
+// rerankSystemPrompt asks the model for a ranking it can be parsed from
+const rerankSystemPrompt = `You rank documentation excerpts by how well they answer a question about the Nostr protocol. Reply with the numbers of all the excerpts, most useful first, separated by commas, e.g. "3, 1, 2". Reply with nothing else.`
+
+// rerankDescription documents the rerank argument of the search tools
+const rerankDescription = "Let the chat model reorder the best candidates by how well they answer the query before the results are chosen. Slower, since it runs the model; defaults to the server's -rerank."
+
+// rerankNumberPattern finds the excerpt numbers in the model's reply
+var rerankNumberPattern = regexp.MustCompile(`\d+`)
+
+// rerankTrace records how the model reordered the candidates, for debug_query
+type rerankTrace struct {
+	Model    string
+	Pool     []searchResult // The candidates given to the model, in their original order
+	Order    []int          // Indexes into Pool, in the model's order
+	Elapsed  time.Duration
+	Err      error // Why the original order was kept
+	Reranked []searchResult
+}
+
+// rerankArgument reads the rerank argument of a search tool call, which
+// defaults to -rerank
+func rerankArgument(request mcp.CallToolRequest) bool {
+	if value, ok := request.Params.Arguments["rerank"].(bool); ok {
+		return value
+	}
+	return rerankEnabled
+}
+
+// rerankModel returns the chat model that reranks chunks
+func rerankModel() string {
+	if llmConfig.RerankModel != "" {
+		return llmConfig.RerankModel
+	}
+	return llmConfig.Model
+}
+
+// rerankResults selects the results like selectResults, optionally letting
+// the chat model reorder the best rerankTopN of them first, so the results
+// are the ones it found most useful. When the model cannot be reached or its
+// reply cannot be read, the results keep their similarity order.
+func rerankResults(query string, candidates []searchResult, opts searchOptions, rerank bool) ([]searchResult, *rerankTrace) {
+	if !rerank || rerankTopN <= 1 {
+		return selectResults(candidates, opts), nil
+	}
+
+	poolOpts := opts
+	if opts.NumResults > 0 {
+		poolOpts.NumResults = max(opts.NumResults, rerankTopN)
+	}
+	selected := selectResults(candidates, poolOpts)
+	pool := selected[:min(len(selected), rerankTopN)]
+	trace := &rerankTrace{Model: rerankModel(), Pool: pool}
+
+	start := time.Now()
+	trace.Order, trace.Err = rankWithModel(query, pool)
+	trace.Elapsed = time.Since(start)
+	if trace.Err != nil {
+		log.Printf("Keeping the similarity order: %v", trace.Err)
+		trace.Reranked = pool
+	} else {
+		trace.Reranked = make([]searchResult, len(pool))
+		for i, index := range trace.Order {
+			trace.Reranked[i] = pool[index]
+		}
+	}
+
+	// Without a result limit, the chunks past the ones reranked follow them
+	results := append(append([]searchResult{}, trace.Reranked...), selected[len(pool):]...)
+	if opts.NumResults > 0 && len(results) > opts.NumResults {
+		results = results[:opts.NumResults]
+	}
+	return results, trace
+}
+
+// rankWithModel asks the chat model to order the chunks by how well they
+// answer the query and returns their indexes in that order. Chunks the model
+// leaves out keep their relative order after the ones it ranked.
+func rankWithModel(query string, pool []searchResult) ([]int, error) {
+	if len(pool) < 2 {
+		return make([]int, len(pool)), nil
+	}
+	if err := checkOllamaReachable(); err != nil {
+		return nil, err
+	}
+	queryText, _ := parseQueryFilter(query)
+
+	var prompt strings.Builder
+	prompt.WriteString(fmt.Sprintf("Question: %s\n", queryText))
+	for i, result := range pool {
+		text := strings.TrimPrefix(result.Record.Prompt, "search_document: ")
+		if len(text) > rerankExcerptChars {
+			text = strings.ToValidUTF8(text[:rerankExcerptChars], "") + "..."
+		}
+		prompt.WriteString(fmt.Sprintf("\n[%d] %s\n%s\n", i+1, result.Record.Id, text))
+	}
+
+	answer, err := completion.Chat(ollamaURL, llm.Query{
+		Model: rerankModel(),
+		Messages: []llm.Message{
+			{Role: "system", Content: rerankSystemPrompt},
+			{Role: "user", Content: prompt.String()},
+		},
+		Options: llm.Options{Temperature: 0},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reranking with %s: %v", rerankModel(), err)
+	}
+	return parseRanking(answer.Message.Content, len(pool))
+}
+
+// parseRanking reads the excerpt numbers from the model's reply and returns
+// the indexes of n chunks in the order given, followed by any it left out
+func parseRanking(reply string, n int) ([]int, error) {
+	seen := make([]bool, n)
+	var order []int
+	for _, match := range rerankNumberPattern.FindAllString(reply, -1) {
+		number, err := strconv.Atoi(match)
+		if err != nil || number < 1 || number > n || seen[number-1] {
+			continue
+		}
+		seen[number-1] = true
+		order = append(order, number-1)
+	}
+	if len(order) == 0 {
+		return nil, fmt.Errorf("no excerpt numbers in the reranking reply %q", strings.TrimSpace(reply))
+	}
+	for i := range seen {
+		if !seen[i] {
+			order = append(order, i)
+		}
+	}
+	return order, nil
+}
+
+// formatRerank describes how the model reordered the candidates, for
+// debug_query
+func formatRerank(trace *rerankTrace) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("- Model: %s, %d candidates, %s\n", trace.Model, len(trace.Pool), trace.Elapsed.Round(time.Millisecond)))
+	if trace.Err != nil {
+		b.WriteString(fmt.Sprintf("- Failed, so the similarity order was kept: %v\n", trace.Err))
+		return b.String()
+	}
+	for i, index := range trace.Order {
+		b.WriteString(fmt.Sprintf("%d. %s (was #%d, score=%.4f)\n", i+1, trace.Pool[index].Record.Id, index+1, trace.Pool[index].Score))
+	}
+	return b.String()
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Limits on how much of a draft is compared with the corpus
+const (
+	maxReviewSections    = 8    // Sections embedded to find related NIPs
+	maxReviewSectionText = 1000 // Characters of each section that are embedded
+	defaultReviewRelated = 5
+)
+
+// draftTagPattern finds tags written as JSON arrays, e.g. ["d", "..."]
+var draftTagPattern = regexp.MustCompile(`\[\s*"([A-Za-z0-9_-]{1,32})"\s*[,\]]`)
+
+// draftTagMention finds tags named in prose, e.g. "the `d` tag"
+var draftTagMention = regexp.MustCompile("`([A-Za-z0-9_-]{1,32})`\\s+tags?\\b")
+
+// relayMessagePattern matches relay protocol messages, which share the JSON
+// array syntax of tags
+var relayMessagePattern = regexp.MustCompile(`^[A-Z]{2,}$`)
+
+// relatedNip is an existing NIP found close to a section of the draft
+type relatedNip struct {
+	File    string // Chunk source, e.g. "nips/57"
+	Score   float64
+	Section string // Draft section it was closest to
+	ChunkID string
+}
+
+// draftSection is a section of a draft and its heading
+type draftSection struct {
+	Heading string
+	Text    string
+}
+
+// draftKinds returns the event kinds a draft mentions, in numeric order
+func draftKinds(draft string) []int {
+	seen := make(map[int]bool)
+	var kinds []int
+	for _, match := range kindMention.FindAllStringSubmatch(draft, -1) {
+		kind, err := strconv.Atoi(match[1])
+		if err != nil || seen[kind] {
+			continue
+		}
+		seen[kind] = true
+		kinds = append(kinds, kind)
+	}
+	sort.Ints(kinds)
+	return kinds
+}
+
+// draftTags returns the tag names a draft uses in examples or mentions in
+// its text, in order of appearance
+func draftTags(draft string) []string {
+	seen := make(map[string]bool)
+	var tags []string
+	for _, pattern := range []*regexp.Regexp{draftTagPattern, draftTagMention} {
+		for _, match := range pattern.FindAllStringSubmatch(draft, -1) {
+			name := match[1]
+			if seen[name] || relayMessagePattern.MatchString(name) {
+				continue
+			}
+			seen[name] = true
+			tags = append(tags, name)
+		}
+	}
+	return tags
+}
+
+// draftSections splits a draft into its sections, each with its heading
+func draftSections(draft string) []draftSection {
+	source := []byte(draft)
+	headings := parseHeadings(source)
+
+	var sections []draftSection
+	for i, heading := range headings {
+		end := len(source)
+		if i+1 < len(headings) {
+			end = headings[i+1].Start
+		}
+		text := strings.TrimSpace(draft[heading.Start:end])
+		if _, body, ok := strings.Cut(text, "\n"); ok && strings.TrimSpace(body) != "" {
+			sections = append(sections, draftSection{heading.Text, text})
+		}
+	}
+	if len(sections) == 0 && strings.TrimSpace(draft) != "" {
+		sections = append(sections, draftSection{"", draft})
+	}
+	return sections
+}
+
+// findRelatedNips embeds each section of a draft and returns the NIPs whose
+// chunks come closest to any of them, best match first
+func findRelatedNips(ctx context.Context, index *servingIndex, draft string, limit int) ([]relatedNip, error) {
+	store := index.reader
+	best := make(map[string]relatedNip)
+
+	sections := draftSections(draft)
+	if len(sections) > maxReviewSections {
+		sections = sections[:maxReviewSections]
+	}
+	for _, section := range sections {
+		text := section.Text
+		if len(text) > maxReviewSectionText {
+			text = text[:maxReviewSectionText]
+		}
+		embedding, err := embedQuery(text)
+		if err != nil {
+			return nil, err
+		}
+		candidates, err := scoreStore(store, embedding, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error searching for similarities: %v", err)
+		}
+
+		for _, candidate := range candidates {
+			id := candidate.Record.Id
+			if chunkCollection(id) != collectionSpecs || !tenantCanRead(ctx, id) {
+				continue
+			}
+			file := chunkSource(id)
+			if current, ok := best[file]; !ok || candidate.Score > current.Score {
+				best[file] = relatedNip{File: file, Score: candidate.Score, Section: section.Heading, ChunkID: id}
+			}
+		}
+	}
+
+	related := make([]relatedNip, 0, len(best))
+	for _, nip := range best {
+		related = append(related, nip)
+	}
+	sort.Slice(related, func(i, j int) bool {
+		return related[i].Score > related[j].Score
+	})
+	if len(related) > limit {
+		related = related[:limit]
+	}
+	return related, nil
+}
+
+// nipLabel names the NIP a chunk source belongs to, with its title when the
+// registry knows it, e.g. "NIP-57 (Lightning Zaps)"
+func nipLabel(registry *kindRegistry, source string) string {
+	number := strings.ToUpper(source[strings.LastIndex(source, "/")+1:])
+	if registry != nil {
+		for _, nip := range registry.NIPs {
+			if nip.Number == number && nip.Title != "" {
+				return fmt.Sprintf("NIP-%s (%s)", number, nip.Title)
+			}
+		}
+	}
+	return "NIP-" + number
+}
+
+// reviewStructure checks a draft against the conventions every NIP follows
+// and returns the problems found
+func reviewStructure(draft string, kinds []int, tags []string) []string {
+	var problems []string
+	headings := parseHeadings([]byte(draft))
+
+	if len(headings) == 0 || !nipHeadingPattern.MatchString(headings[0].Text) {
+		problems = append(problems, "The draft should open with a `NIP-XX` heading followed by its title, like every NIP.")
+	}
+	title, preamble := nipHeader([]byte(draft))
+	if title == "" || nipHeadingPattern.MatchString(title) {
+		problems = append(problems, "The draft has no descriptive title under the `NIP-XX` heading.")
+	}
+
+	labels := nipStatusPattern.FindAllStringSubmatch(preamble, -1)
+	switch {
+	case len(labels) == 0:
+		problems = append(problems, "Status labels are missing under the title; new NIPs use `draft` `optional`.")
+	case labels[0][1] != "draft":
+		problems = append(problems, fmt.Sprintf("The first status label is `%s`; NIPs are proposed as `draft`.", labels[0][1]))
+	}
+
+	if len(headings) < 3 {
+		problems = append(problems, "The draft has no sections below its title; NIPs usually explain the motivation and specify events in separate sections.")
+	}
+
+	if len(kinds) > 0 && !strings.Contains(draft, `"kind"`) {
+		problems = append(problems, "The draft mentions event kinds but has no example event; NIPs show a JSON example for every event they define.")
+	}
+
+	hasD := contains(tags, "d")
+	for _, kind := range kinds {
+		if kindClass(kind) == "addressable" && !hasD {
+			problems = append(problems, fmt.Sprintf("Kind %d is addressable (30000-39999), so its events need a `d` tag, which the draft does not mention.", kind))
+		}
+	}
+	return problems
+}
+
+// reviewDraftNip produces a review of a draft NIP: the existing NIPs it is
+// closest to, the kinds and tags it shares with registered ones, and how it
+// departs from the conventions of the NIPs repository
+func reviewDraftNip(ctx context.Context, draft string, numRelated int) (string, error) {
+	index, release := acquireIndex()
+	defer release()
+	registry := index.registry
+	kinds := draftKinds(draft)
+	tags := draftTags(draft)
+
+	related, err := findRelatedNips(ctx, index, draft, numRelated)
+	if err != nil {
+		return "", err
+	}
+
+	title, _ := nipHeader([]byte(draft))
+	if title == "" {
+		title = "untitled draft"
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("# Review of %s\n", title))
+
+	b.WriteString("\n## Related NIPs\n")
+	if len(related) == 0 {
+		b.WriteString("No NIPs in the index are related to this draft.\n")
+	}
+	for _, nip := range related {
+		closest := ""
+		if nip.Section != "" {
+			closest = fmt.Sprintf(", closest to the draft's %q section", nip.Section)
+		}
+		b.WriteString(fmt.Sprintf("- %s: score %.2f%s (see %s)\n", nipLabel(registry, nip.File), nip.Score, closest, nip.ChunkID))
+	}
+	if len(related) > 0 {
+		b.WriteString("Check that the draft does not redefine what these NIPs already specify, and link the ones it builds on.\n")
+	}
+
+	b.WriteString("\n## Kinds\n")
+	switch {
+	case len(kinds) == 0:
+		b.WriteString("The draft mentions no event kinds.\n")
+	case registry == nil:
+		b.WriteString("No kind registry in the index, so kinds could not be checked; enable the NIPs repository and re-ingest with -ingest.\n")
+	default:
+		for _, kind := range kinds {
+			entries := registeredKinds(registry, kind)
+			if len(entries) == 0 {
+				b.WriteString(fmt.Sprintf("- %d (%s): not registered\n", kind, kindClass(kind)))
+				continue
+			}
+			var uses []string
+			for _, entry := range entries {
+				uses = append(uses, fmt.Sprintf("%s in %s", entry.Description, formatNipRefs(entry.NIPs, entry.Reference)))
+			}
+			b.WriteString(fmt.Sprintf("- %d (%s): already registered as %s. If the draft defines this kind rather than refers to it, choose another number.\n", kind, kindClass(kind), strings.Join(uses, "; ")))
+		}
+	}
+
+	b.WriteString("\n## Tags\n")
+	switch {
+	case len(tags) == 0:
+		b.WriteString("The draft uses no tags.\n")
+	case registry == nil:
+		b.WriteString("No kind registry in the index, so tags could not be checked.\n")
+	default:
+		for _, name := range tags {
+			var uses []string
+			for _, tag := range registry.Tags {
+				if tag.Name == name {
+					uses = append(uses, fmt.Sprintf("%s in %s", tag.Value, formatNipRefs(tag.NIPs, tag.Reference)))
+				}
+			}
+			if len(uses) == 0 {
+				b.WriteString(fmt.Sprintf("- `%s`: not a standardized tag; add it to the tag table if other NIPs may reuse it\n", name))
+				continue
+			}
+			b.WriteString(fmt.Sprintf("- `%s`: standardized as %s. Use it with the same meaning or pick another name.\n", name, strings.Join(uses, "; ")))
+		}
+	}
+
+	b.WriteString("\n## Structure and conventions\n")
+	problems := reviewStructure(draft, kinds, tags)
+	if len(problems) == 0 {
+		b.WriteString("The draft follows the structure of existing NIPs.\n")
+	}
+	for _, problem := range problems {
+		b.WriteString(fmt.Sprintf("- %s\n", problem))
+	}
+	return b.String(), nil
+}
+
+func reviewDraftNipHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	draft, ok := request.Params.Arguments["content"].(string)
+	if !ok || strings.TrimSpace(draft) == "" {
+		return nil, errors.New("content must be a non-empty string")
+	}
+	if len(draft) > maxScratchChars {
+		return nil, fmt.Errorf("draft is %d characters; drafts are limited to %d", len(draft), maxScratchChars)
+	}
+
+	numRelated := defaultReviewRelated
+	if num, ok := request.Params.Arguments["num_related"].(float64); ok && num > 0 {
+		numRelated = int(num)
+	}
+
+	review, err := reviewDraftNip(ctx, draft, numRelated)
+	if err != nil {
+		return nil, err
+	}
+	return mcp.NewToolResultText(review), nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Built-in collections that repositories can be assigned to
+const (
+	collectionSpecs    = "specs"
+	collectionCode     = "code"
+	collectionWiki     = "wiki"
+	collectionArticles = "articles"
+	collectionDocs     = "docs"
+)
+
+// collectionSignals are the keyword patterns that route a query to a collection
+var collectionSignals = map[string]*regexp.Regexp{
+	collectionSpecs:    regexp.MustCompile(`(?i)\b(nip-?\d+|nips?|kinds?|tags?|spec(ification)?s?|protocol|event format|must|should|relay messages?|req|eose|bech32|npub|nevent|naddr)\b`),
+	collectionCode:     regexp.MustCompile(`(?i)\b(code|implement(ation)?|librar(y|ies)|sdk|ndk|nostr-tools|go-nostr|rust-nostr|function|api|example|snippet|typescript|javascript|python|rust|golang|swift|kotlin|compile|install)\b`),
+	collectionWiki:     regexp.MustCompile(`(?i)\b(how (do|to|can)|guide|tutorial|what is|explain|overview|introduction|getting started|faq|beginner)\b`),
+	collectionArticles: regexp.MustCompile(`(?i)\b(article|blog|post|opinion|history|news|discussion|why does|debate)\b`),
+}
+
+// repoCollection returns the collection a repository's chunks belong to
+func repoCollection(repoName string) string {
+	if repoName == scratchRepo {
+		return collectionScratch
+	}
+	if repoName == eventsRepo {
+		return collectionArticles
+	}
+	for _, repo := range repos {
+		if repo.Name != repoName {
+			continue
+		}
+		if repo.Collection != "" {
+			return repo.Collection
+		}
+		if repo.Role == roleNips {
+			return collectionSpecs
+		}
+		break
+	}
+	return collectionDocs
+}
+
+// chunkCollection returns the collection a stored chunk belongs to
+func chunkCollection(id string) string {
+	return repoCollection(chunkRepo(id))
+}
+
+// availableCollections lists the collections of all enabled repositories
+func availableCollections() []string {
+	seen := make(map[string]bool)
+	var collections []string
+	for _, repo := range repos {
+		if !repo.Enabled {
+			continue
+		}
+		collection := repoCollection(repo.Name)
+		if !seen[collection] {
+			seen[collection] = true
+			collections = append(collections, collection)
+		}
+	}
+	if !seen[collectionArticles] && hasIngestedEvents() {
+		collections = append(collections, collectionArticles)
+	}
+	sort.Strings(collections)
+	return collections
+}
+
+// routeQuery classifies a query and returns the collections it should be
+// searched in. It returns nil, meaning every collection, when fewer than two
+// collections exist or the query gives no clear signal.
+func routeQuery(query string) []string {
+	collections := availableCollections()
+	if len(collections) < 2 {
+		return nil
+	}
+
+	var targets []string
+	for _, collection := range collections {
+		if signal, ok := collectionSignals[collection]; ok && signal.MatchString(query) {
+			targets = append(targets, collection)
+		}
+	}
+
+	// Collections without keyword signals, like the generic docs, are always searched
+	if len(targets) > 0 {
+		for _, collection := range collections {
+			if _, ok := collectionSignals[collection]; !ok {
+				targets = append(targets, collection)
+			}
+		}
+	}
+
+	if len(targets) == 0 || len(targets) == len(collections) {
+		return nil
+	}
+	return targets
+}
+
+// parseCollections splits a comma-separated collection list; "auto" or an
+// empty string means the router decides
+func parseCollections(value string, query string) []string {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "auto" {
+		return routeQuery(query)
+	}
+	if value == "all" {
+		return nil
+	}
+
+	var collections []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			collections = append(collections, part)
+		}
+	}
+	return collections
+}
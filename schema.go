@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"go.etcd.io/bbolt"
+)
+
+// metaBucket holds bookkeeping data that is not part of the vector store itself
+const metaBucket = "bhn-meta"
+
+// Keys stored under metaBucket
+const (
+	schemaVersionKey = "schema_version"
+	metricKey        = "similarity_metric"
+)
+
+// migration upgrades the database layout by one schema version
+type migration struct {
+	Version     int
+	Description string
+	Apply       func(tx *bbolt.Tx) error
+}
+
+// migrations lists every schema change in order. Append new entries here when
+// the stored layout changes; never edit or reorder existing ones.
+var migrations = []migration{
+	{
+		Version:     1,
+		Description: "stamp database with schema version",
+		Apply:       func(tx *bbolt.Tx) error { return nil },
+	},
+}
+
+// currentSchemaVersion is the schema version produced by this build
+func currentSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// initializeStore upgrades the database at path to the current schema and
+// then opens it as a vector store
+func initializeStore(store *vectorStore, path string) error {
+	if err := migrateDatabase(path); err != nil {
+		return err
+	}
+	metric, err := loadStoreSettings(path)
+	if err != nil {
+		return err
+	}
+	if err := store.Initialize(path); err != nil {
+		return err
+	}
+	store.metric = metric
+	return nil
+}
+
+// loadStoreSettings reads the collection settings persisted in the meta bucket
+// and returns the similarity metric of the collection
+func loadStoreSettings(path string) (string, error) {
+	db, err := openRawDatabase(path, true)
+	if err != nil {
+		return "", fmt.Errorf("error opening database %s: %v", path, err)
+	}
+	defer db.Close()
+
+	metric := metricCosine
+	err = db.View(func(tx *bbolt.Tx) error {
+		if stored := readMeta(tx, metricKey); stored != "" {
+			parsed, err := parseMetric(stored)
+			if err != nil {
+				return err
+			}
+			metric = parsed
+		}
+		return useStoredEmbedder(readMeta(tx, embedderKey), readMeta(tx, embedderModelKey))
+	})
+	return metric, err
+}
+
+// setStoreMetric records the similarity metric for the collection. The metric
+// cannot be changed once set because stored vectors were prepared for it.
+func setStoreMetric(path, metric string) error {
+	db, err := openRawDatabase(path, false)
+	if err != nil {
+		return fmt.Errorf("error opening database %s: %v", path, err)
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bbolt.Tx) error {
+		existing := readMeta(tx, metricKey)
+		if existing != "" && existing != metric {
+			return fmt.Errorf("database was ingested with the %s metric; delete %s to re-ingest with %s", existing, path, metric)
+		}
+		return writeMeta(tx, metricKey, metric)
+	})
+}
+
+// readMeta returns a string value from the meta bucket, or "" if it is not set
+func readMeta(tx *bbolt.Tx, key string) string {
+	bucket := tx.Bucket([]byte(metaBucket))
+	if bucket == nil {
+		return ""
+	}
+	return string(bucket.Get([]byte(key)))
+}
+
+// writeMeta stores a string value in the meta bucket
+func writeMeta(tx *bbolt.Tx, key, value string) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+	if err != nil {
+		return fmt.Errorf("error creating meta bucket: %v", err)
+	}
+	return bucket.Put([]byte(key), []byte(value))
+}
+
+// storeMetaJSON stores a value as JSON under key in the meta bucket of the
+// database at path
+func storeMetaJSON(path, key string, value any) error {
+	db, err := openRawDatabase(path, false)
+	if err != nil {
+		return fmt.Errorf("error opening database %s: %v", path, err)
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bbolt.Tx) error {
+		return putMetaJSON(tx, key, value)
+	})
+}
+
+// saveMetaJSON stores a value as JSON under key in the meta bucket of the
+// store's database, through the handle the store holds it open with
+func (s *vectorStore) saveMetaJSON(key string, value any) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return putMetaJSON(tx, key, value)
+	})
+}
+
+// putMetaJSON stores a value as JSON under key in the meta bucket, for
+// callers that already hold the database open, such as through a vector store
+func putMetaJSON(tx *bbolt.Tx, key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return writeMeta(tx, key, string(data))
+}
+
+// loadMetaJSON decodes the JSON value stored under key in the meta bucket of
+// the database at path into value. It reports false if the database or the
+// key does not exist.
+func loadMetaJSON(path, key string, value any) (bool, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	db, err := openRawDatabase(path, true)
+	if err != nil {
+		return false, fmt.Errorf("error opening database %s: %v", path, err)
+	}
+	defer db.Close()
+
+	var data string
+	err = db.View(func(tx *bbolt.Tx) error {
+		data = readMeta(tx, key)
+		return nil
+	})
+	if err != nil || data == "" {
+		return false, err
+	}
+	if err := json.Unmarshal([]byte(data), value); err != nil {
+		return false, fmt.Errorf("error reading %s from %s: %v", key, path, err)
+	}
+	return true, nil
+}
+
+// migrateDatabase applies any pending migrations to the database at path
+func migrateDatabase(path string) error {
+	db, err := openRawDatabase(path, false)
+	if err != nil {
+		return fmt.Errorf("error opening database %s: %v", path, err)
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bbolt.Tx) error {
+		version, err := readSchemaVersion(tx)
+		if err != nil {
+			return err
+		}
+
+		if version > currentSchemaVersion() {
+			return fmt.Errorf("database schema version %d is newer than supported version %d", version, currentSchemaVersion())
+		}
+
+		for _, m := range migrations {
+			if m.Version <= version {
+				continue
+			}
+			if err := m.Apply(tx); err != nil {
+				return fmt.Errorf("error applying migration %d (%s): %v", m.Version, m.Description, err)
+			}
+			if err := writeSchemaVersion(tx, m.Version); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// readSchemaVersion returns the schema version stamped on the database, or 0
+// for databases created before versioning was introduced
+func readSchemaVersion(tx *bbolt.Tx) (int, error) {
+	bucket := tx.Bucket([]byte(metaBucket))
+	if bucket == nil {
+		return 0, nil
+	}
+
+	value := bucket.Get([]byte(schemaVersionKey))
+	if value == nil {
+		return 0, nil
+	}
+	if len(value) != 8 {
+		return 0, fmt.Errorf("invalid schema version record")
+	}
+
+	return int(binary.BigEndian.Uint64(value)), nil
+}
+
+// writeSchemaVersion stamps the database with the given schema version
+func writeSchemaVersion(tx *bbolt.Tx, version int) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+	if err != nil {
+		return fmt.Errorf("error creating meta bucket: %v", err)
+	}
+
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(version))
+	return bucket.Put([]byte(schemaVersionKey), value)
+}
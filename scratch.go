@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/parakeet-nest/parakeet/content"
+	"github.com/parakeet-nest/parakeet/llm"
+)
+
+// scratchRepo is the repository name in the chunk IDs of scratch documents,
+// e.g. "scratch/my-draft-chunk-3"
+const scratchRepo = "scratch"
+
+// collectionScratch holds the documents a client added for its own session.
+// It is always searched alongside the collections a query is routed to.
+const collectionScratch = "scratch"
+
+// scratchIdleTimeout is how long an HTTP session's scratch documents are kept
+// after its last use. The stdio transport serves one session, whose
+// documents last as long as the process.
+var scratchIdleTimeout = time.Hour
+
+// Limits that keep one session, or many, from filling the server's memory
+const (
+	maxScratchDocuments = 20
+	maxScratchChars     = 200000
+
+	// maxScratchSessions and maxScratchTotalChars bound the scratch
+	// documents of all sessions together
+	maxScratchSessions   = 100
+	maxScratchTotalChars = 20000000
+)
+
+// scratchNamePattern matches the characters not allowed in a scratch document name
+var scratchNamePattern = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// sessionContextKey stores the MCP session ID of an HTTP request in its context
+type sessionContextKey struct{}
+
+// stdioSession is the session key of the single stdio client
+const stdioSession = "stdio"
+
+// scratchDocument is a document added by a client, chunked and embedded
+type scratchDocument struct {
+	name    string
+	records []llm.VectorRecord
+	chars   int
+}
+
+// scratchSession holds one session's documents
+type scratchSession struct {
+	documents map[string]*scratchDocument
+	lastUsed  time.Time
+}
+
+// scratchSessions holds the scratch documents of every session
+var scratchSessions = struct {
+	mutex    sync.Mutex
+	sessions map[string]*scratchSession
+}{sessions: make(map[string]*scratchSession)}
+
+// withSession records the MCP session of an HTTP request in its context
+func withSession(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionContextKey{}, sessionID)
+}
+
+// sessionFromContext returns the session making a tool call
+func sessionFromContext(ctx context.Context) string {
+	if sessionID, ok := ctx.Value(sessionContextKey{}).(string); ok && sessionID != "" {
+		return sessionID
+	}
+	return stdioSession
+}
+
+// scratchSessionFor returns the session's scratch documents, creating them
+// if create is true, and forgets sessions that have been idle too long. The
+// caller must hold scratchSessions.mutex.
+func scratchSessionFor(ctx context.Context, create bool) *scratchSession {
+	now := time.Now()
+	for id, session := range scratchSessions.sessions {
+		if id != stdioSession && now.Sub(session.lastUsed) > scratchIdleTimeout {
+			delete(scratchSessions.sessions, id)
+		}
+	}
+
+	id := sessionFromContext(ctx)
+	session, ok := scratchSessions.sessions[id]
+	if !ok {
+		if !create {
+			return nil
+		}
+		session = &scratchSession{documents: make(map[string]*scratchDocument)}
+		scratchSessions.sessions[id] = session
+	}
+	session.lastUsed = now
+	return session
+}
+
+// scratchName turns a document name into the form used in chunk IDs
+func scratchName(name string) string {
+	return strings.Trim(scratchNamePattern.ReplaceAllString(name, "-"), "-")
+}
+
+// addScratchDocument chunks and embeds a markdown document into the calling
+// session's scratch collection, replacing a document of the same name
+func addScratchDocument(ctx context.Context, name, text string) (*scratchDocument, error) {
+	name = scratchName(name)
+	if name == "" {
+		return nil, errors.New("name must contain letters or digits")
+	}
+	if len(text) > maxScratchChars {
+		return nil, fmt.Errorf("document is %d characters; scratch documents are limited to %d", len(text), maxScratchChars)
+	}
+
+	scratchSessions.mutex.Lock()
+	err := checkScratchRoom(ctx, name, len(text))
+	scratchSessions.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// Embedding happens outside the lock since it can take a while
+	chunks := keepCodeFences(content.ParseMarkdownWithLineage(text))
+	document := &scratchDocument{name: name, chars: len(text)}
+	metric := currentIndex().reader.Metric()
+	for i := range chunks {
+		id := fmt.Sprintf("%s/%s-chunk-%d", scratchRepo, name, i+1)
+		record, err := createEmbedding(chunkDocument(chunks, i, defaultContextOverlap), id)
+		if err != nil {
+			return nil, fmt.Errorf("error embedding %s: %v", id, err)
+		}
+		prepareEmbedding(&record, metric)
+		document.records = append(document.records, record)
+	}
+	if len(document.records) == 0 {
+		return nil, errors.New("document has no content to index")
+	}
+
+	// Other documents may have been added while this one was embedded
+	scratchSessions.mutex.Lock()
+	defer scratchSessions.mutex.Unlock()
+	if err := checkScratchRoom(ctx, name, document.chars); err != nil {
+		return nil, err
+	}
+	scratchSessionFor(ctx, true).documents[name] = document
+	return document, nil
+}
+
+// checkScratchRoom returns an error if storing a document of the given size
+// under name in the calling session would go over the limits of the session
+// or of the server. The caller must hold scratchSessions.mutex.
+func checkScratchRoom(ctx context.Context, name string, chars int) error {
+	session := scratchSessionFor(ctx, false)
+	if session == nil && len(scratchSessions.sessions) >= maxScratchSessions {
+		return errors.New("the server holds scratch documents for too many sessions; try again later")
+	}
+	if session != nil && len(session.documents) >= maxScratchDocuments && session.documents[name] == nil {
+		return fmt.Errorf("this session already has %d scratch documents; drop some first", maxScratchDocuments)
+	}
+
+	// A document replaced by this one no longer counts
+	total := chars
+	for _, other := range scratchSessions.sessions {
+		for otherName, document := range other.documents {
+			if other != session || otherName != name {
+				total += document.chars
+			}
+		}
+	}
+	if total > maxScratchTotalChars {
+		return fmt.Errorf("the scratch documents of all sessions are limited to %d characters; drop some first or try again later", maxScratchTotalChars)
+	}
+	return nil
+}
+
+// dropScratchDocuments removes the named document, or every document when
+// name is empty, from the calling session and returns how many were removed
+func dropScratchDocuments(ctx context.Context, name string) int {
+	scratchSessions.mutex.Lock()
+	defer scratchSessions.mutex.Unlock()
+
+	session := scratchSessionFor(ctx, false)
+	if session == nil {
+		return 0
+	}
+	name = scratchName(name)
+	if name == "" {
+		dropped := len(session.documents)
+		delete(scratchSessions.sessions, sessionFromContext(ctx))
+		return dropped
+	}
+	if _, ok := session.documents[name]; !ok {
+		return 0
+	}
+	delete(session.documents, name)
+	return 1
+}
+
+// scratchRecords returns every chunk of the calling session's scratch documents
+func scratchRecords(ctx context.Context) []llm.VectorRecord {
+	scratchSessions.mutex.Lock()
+	defer scratchSessions.mutex.Unlock()
+
+	session := scratchSessionFor(ctx, false)
+	if session == nil {
+		return nil
+	}
+	var records []llm.VectorRecord
+	for _, document := range session.documents {
+		records = append(records, document.records...)
+	}
+	return records
+}
+
+// scratchReader searches a session's scratch documents together with the index
+type scratchReader struct {
+	index   vectorReader
+	records []llm.VectorRecord
+}
+
+// sessionReader returns what the calling session's queries read from: the
+// serving index, which the caller holds, plus the session's scratch documents
+// if it has any
+func sessionReader(ctx context.Context, index *servingIndex) vectorReader {
+	records := scratchRecords(ctx)
+	if len(records) == 0 {
+		return index.reader
+	}
+	return &scratchReader{index: index.reader, records: records}
+}
+
+// Get returns a scratch chunk or a chunk from the index by ID
+func (r *scratchReader) Get(id string) (llm.VectorRecord, error) {
+	for _, record := range r.records {
+		if record.Id == id {
+			return record, nil
+		}
+	}
+	return r.index.Get(id)
+}
+
+// GetAll returns every chunk of the index followed by the scratch chunks
+func (r *scratchReader) GetAll() ([]llm.VectorRecord, error) {
+	records, err := r.index.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	// The index's slice may be shared, so the scratch chunks go into a copy
+	all := make([]llm.VectorRecord, 0, len(records)+len(r.records))
+	all = append(all, records...)
+	return append(all, r.records...), nil
+}
+
+// Metric returns the metric of the index; scratch chunks are prepared for it
+func (r *scratchReader) Metric() string {
+	return r.index.Metric()
+}
+
+func addScratchDocumentHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	name, _ := request.Params.Arguments["name"].(string)
+	text, ok := request.Params.Arguments["content"].(string)
+	if !ok || strings.TrimSpace(text) == "" {
+		return nil, errors.New("content must be a non-empty string")
+	}
+
+	document, err := addScratchDocument(ctx, name, text)
+	if err != nil {
+		return nil, err
+	}
+	return mcp.NewToolResultText(fmt.Sprintf("Added %s to the scratch collection as %d chunks (IDs %s/%s-chunk-1 to -%d). query_nostr_data, ask_nostr, and get_chunk now include it for this session.",
+		document.name, len(document.records), scratchRepo, document.name, len(document.records))), nil
+}
+
+func listScratchDocumentsHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	scratchSessions.mutex.Lock()
+	session := scratchSessionFor(ctx, false)
+	var lines []string
+	if session != nil {
+		for _, document := range session.documents {
+			lines = append(lines, fmt.Sprintf("- %s: %d chunks, %d characters", document.name, len(document.records), document.chars))
+		}
+	}
+	scratchSessions.mutex.Unlock()
+
+	if len(lines) == 0 {
+		return mcp.NewToolResultText("The scratch collection of this session is empty."), nil
+	}
+	sort.Strings(lines)
+	return mcp.NewToolResultText("Scratch documents of this session:\n" + strings.Join(lines, "\n")), nil
+}
+
+func dropScratchHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	name, _ := request.Params.Arguments["name"].(string)
+	name = scratchName(name)
+	dropped := dropScratchDocuments(ctx, name)
+	if name != "" && dropped == 0 {
+		return nil, fmt.Errorf("no scratch document named %s", name)
+	}
+	return mcp.NewToolResultText(fmt.Sprintf("Dropped %d scratch documents.", dropped)), nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withScratchSessions runs the test with the given scratch sessions, each
+// used just now, and restores the server's afterwards
+func withScratchSessions(t *testing.T, sessions map[string]*scratchSession) {
+	t.Helper()
+	saved := scratchSessions.sessions
+	for _, session := range sessions {
+		session.lastUsed = time.Now()
+	}
+	scratchSessions.sessions = sessions
+	t.Cleanup(func() { scratchSessions.sessions = saved })
+}
+
+// sessionWith returns a session holding documents of the given sizes, named
+// doc-1, doc-2, ...
+func sessionWith(sizes ...int) *scratchSession {
+	session := &scratchSession{documents: make(map[string]*scratchDocument)}
+	for i, chars := range sizes {
+		name := fmt.Sprintf("doc-%d", i+1)
+		session.documents[name] = &scratchDocument{name: name, chars: chars}
+	}
+	return session
+}
+
+func TestCheckScratchRoom(t *testing.T) {
+	ctx := withSession(context.Background(), "mine")
+	manyDocuments := make([]int, maxScratchDocuments)
+
+	tests := []struct {
+		name     string
+		sessions map[string]*scratchSession
+		document string
+		chars    int
+		wantErr  string
+	}{
+		{
+			name:     "first document",
+			sessions: map[string]*scratchSession{},
+			document: "draft",
+			chars:    1000,
+		},
+		{
+			name:     "session full",
+			sessions: map[string]*scratchSession{"mine": sessionWith(manyDocuments...)},
+			document: "draft",
+			chars:    1000,
+			wantErr:  "already has",
+		},
+		{
+			name:     "replacing in a full session",
+			sessions: map[string]*scratchSession{"mine": sessionWith(manyDocuments...)},
+			document: "doc-1",
+			chars:    1000,
+		},
+		{
+			name: "too many sessions",
+			sessions: func() map[string]*scratchSession {
+				sessions := make(map[string]*scratchSession)
+				for i := 0; i < maxScratchSessions; i++ {
+					sessions[fmt.Sprintf("other-%d", i)] = sessionWith(10)
+				}
+				return sessions
+			}(),
+			document: "draft",
+			chars:    1000,
+			wantErr:  "too many sessions",
+		},
+		{
+			name:     "over the budget of all sessions",
+			sessions: map[string]*scratchSession{"other": sessionWith(maxScratchTotalChars - 500)},
+			document: "draft",
+			chars:    1000,
+			wantErr:  "all sessions",
+		},
+		{
+			name:     "replacing frees the replaced document's characters",
+			sessions: map[string]*scratchSession{"mine": sessionWith(maxScratchTotalChars - 500)},
+			document: "doc-1",
+			chars:    1000,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withScratchSessions(t, test.sessions)
+			scratchSessions.mutex.Lock()
+			err := checkScratchRoom(ctx, test.document, test.chars)
+			scratchSessions.mutex.Unlock()
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+				t.Errorf("got error %v, want one containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestScratchNamesReserved(t *testing.T) {
+	config := `[
+  {"URL": "https://github.com/example/scratch", "Name": "scratch", "Enabled": true},
+  {"URL": "https://github.com/example/drafts", "Name": "drafts", "Enabled": true, "Collection": "scratch"}
+]`
+	_, problems := parseReposConfig([]byte(config))
+	var messages []string
+	for _, problem := range problems {
+		messages = append(messages, problem.Message)
+	}
+	for _, want := range []string{`repository name "scratch" is reserved`, `collection "scratch" is reserved`} {
+		found := false
+		for _, message := range messages {
+			found = found || strings.Contains(message, want)
+		}
+		if !found {
+			t.Errorf("no problem reported containing %q; got %q", want, messages)
+		}
+	}
+}
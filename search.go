@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/parakeet-nest/parakeet/llm"
+)
+
+// Supported similarity metrics
+const (
+	metricCosine    = "cosine"
+	metricDot       = "dot"
+	metricEuclidean = "euclidean"
+)
+
+// searchResult is a stored chunk together with its similarity to the query
+type searchResult struct {
+	Record llm.VectorRecord
+	Score  float64
+}
+
+// parseMetric validates a similarity metric name
+func parseMetric(name string) (string, error) {
+	switch name {
+	case metricCosine, metricDot, metricEuclidean:
+		return name, nil
+	case "dot-product", "dotproduct":
+		return metricDot, nil
+	case "l2":
+		return metricEuclidean, nil
+	}
+	return "", fmt.Errorf("unknown similarity metric %q (expected cosine, dot, or euclidean)", name)
+}
+
+// similarityScore compares two vectors using the given metric. Higher scores
+// always mean more similar, so thresholds work the same for every metric.
+func similarityScore(metric string, a, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	switch metric {
+	case metricDot:
+		return dotProduct(a, b)
+	case metricEuclidean:
+		var sum float64
+		for i := range a {
+			d := a[i] - b[i]
+			sum += d * d
+		}
+		// Map distance into (0, 1] so it can be compared against a threshold
+		return 1 / (1 + math.Sqrt(sum))
+	default:
+		normA, normB := vectorNorm(a), vectorNorm(b)
+		if normA == 0 || normB == 0 {
+			return 0
+		}
+		return dotProduct(a, b) / (normA * normB)
+	}
+}
+
+// normalizeVector scales a vector to unit length in place
+func normalizeVector(v []float64) {
+	norm := vectorNorm(v)
+	if norm == 0 {
+		return
+	}
+	for i := range v {
+		v[i] /= norm
+	}
+}
+
+// prepareEmbedding applies the ingest-time transformation metric expects
+func prepareEmbedding(record *llm.VectorRecord, metric string) {
+	if metric == metricCosine {
+		normalizeVector(record.Embedding)
+	}
+}
+
+func dotProduct(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+func vectorNorm(v []float64) float64 {
+	return math.Sqrt(dotProduct(v, v))
+}
+
+// searchOptions controls which scored candidates end up in the results
+type searchOptions struct {
+	Threshold  float64 // Minimum similarity score
+	NumResults int     // Maximum number of results (0 for no limit)
+	MaxPerFile int     // Maximum results from a single source file (0 for no limit)
+	// Collections restricts results to the given collections (nil for all)
+	Collections []string
+	// MinTier restricts results to chunks at least this trustworthy ("" for all)
+	MinTier string
+	// Filter holds the terms every result must match, from the repo, nip,
+	// path, and section parameters (nil for none)
+	Filter []filterTerm
+	// MMRLambda chooses diverse results with Maximal Marginal Relevance when
+	// below 1 (0 for the similarity order)
+	MMRLambda float64
+}
+
+// defaultMaxPerFile keeps one long document from monopolizing broad queries
+const defaultMaxPerFile = 2
+
+// retrievalTrace records how a query was turned into the text that was
+// embedded, so that surprising results can be explained
+type retrievalTrace struct {
+	QueryText  string // The search text left after filter terms were removed
+	Filter     *queryFilter
+	Expansions []string // Spec terms added for aliases in the query
+	Prompt     string   // The text that was embedded
+	Mode       string   // Retrieval mode the candidates were scored with
+
+	// Variants holds the rephrasings of the query that were embedded along
+	// with it, and VariantErr why there are none when expansion failed
+	Variants   []string
+	VariantErr error
+
+	// Lexical holds the normalized keyword scores of hybrid retrieval, by
+	// chunk ID
+	Lexical map[string]float64
+
+	// Feedback holds the score adjustments from result ratings, by chunk ID
+	Feedback map[string]float64
+}
+
+// queryPrompt adds the query task prefix to search text
+func queryPrompt(text string) string {
+	return fmt.Sprintf("search_query: %s", text)
+}
+
+// embedQuery creates the embedding for a search query using the query task prefix
+func embedQuery(text string) (llm.VectorRecord, error) {
+	queryEmbedding, err := createEmbedding(queryPrompt(text), "query")
+	if err != nil {
+		return llm.VectorRecord{}, fmt.Errorf("error creating embedding: %v", err)
+	}
+	return queryEmbedding, nil
+}
+
+// retrieveCandidates parses filters out of a query, embeds the remaining text
+// and scores the store against it in the default retrieval mode, returning
+// every candidate best match first. Ratings given by the caller, found in
+// ctx, adjust the scores.
+func retrieveCandidates(ctx context.Context, store vectorReader, query string) ([]searchResult, error) {
+	return retrieveWithMode(ctx, store, query, retrievalMode, expansionEnabled)
+}
+
+// retrieveWithMode retrieves candidates like retrieveCandidates, scoring them
+// in the given retrieval mode, and also against variants of the query from
+// the chat model when expand is set
+func retrieveWithMode(ctx context.Context, store vectorReader, query, mode string, expand bool) ([]searchResult, error) {
+	candidates, _, err := traceCandidates(ctx, store, query, mode, expand)
+	return candidates, err
+}
+
+// traceCandidates retrieves candidates like retrieveWithMode and also returns
+// how the query was interpreted
+func traceCandidates(ctx context.Context, store vectorReader, query, mode string, expand bool) ([]searchResult, *retrievalTrace, error) {
+	queryText, filter := parseQueryFilter(query)
+	if queryText == "" {
+		return nil, nil, errors.New("query must contain search text in addition to filters")
+	}
+
+	trace := &retrievalTrace{
+		QueryText:  queryText,
+		Filter:     filter,
+		Expansions: aliasExpansions(queryText),
+		Mode:       mode,
+	}
+	queries := expandedQueries(queryText, expand, trace)
+	trace.Prompt = queryPrompt(queries[0])
+
+	queryEmbeddings := make([]llm.VectorRecord, len(queries))
+	for i, text := range queries {
+		var err error
+		if queryEmbeddings[i], err = embedQuery(text); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	candidates, err := scoreStoreAll(store, queryEmbeddings, filter)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error searching for similarities: %v", err)
+	}
+	if mode == modeHybrid {
+		trace.Lexical = applyLexicalScores(candidates, strings.Join(queries, " "))
+	}
+	trace.Feedback = applyFeedback(ctx, queryText, candidates)
+	return candidates, trace, nil
+}
+
+// searchStore scores every stored chunk against the query embedding using the
+// active metric and returns the candidates selected by opts
+func searchStore(store vectorReader, query llm.VectorRecord, filter *queryFilter, opts searchOptions) ([]searchResult, error) {
+	candidates, err := scoreStore(store, query, filter)
+	if err != nil {
+		return nil, err
+	}
+	return selectResults(candidates, opts), nil
+}
+
+// scoreStore scores every stored chunk that passes the filter against the
+// query embedding and returns them all, best match first
+func scoreStore(store vectorReader, query llm.VectorRecord, filter *queryFilter) ([]searchResult, error) {
+	return scoreStoreAll(store, []llm.VectorRecord{query}, filter)
+}
+
+// scoreStoreAll scores every stored chunk that passes the filter against
+// each of the query embeddings, keeping its best score, and returns them all,
+// best match first. This merges the results of the variants of a query.
+func scoreStoreAll(store vectorReader, queries []llm.VectorRecord, filter *queryFilter) ([]searchResult, error) {
+	records, err := store.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	metric := store.Metric()
+	results := make([]searchResult, 0, len(records))
+	for _, record := range records {
+		if !filter.Matches(record) {
+			continue
+		}
+		score := math.Inf(-1)
+		for _, query := range queries {
+			score = math.Max(score, similarityScore(metric, query.Embedding, record.Embedding))
+		}
+		results = append(results, searchResult{Record: record, Score: score})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	return results, nil
+}
+
+// judgeCandidates walks the ranked candidates and returns, for each one, the
+// reason it was excluded, or "" if it made it into the results. Candidates
+// past the result limit are over-fetched so that chunks dropped by the
+// per-file cap are replaced by the next best chunks from other files.
+func judgeCandidates(candidates []searchResult, opts searchOptions) []string {
+	verdicts := make([]string, len(candidates))
+	var eligible []int
+	for i, candidate := range candidates {
+		term, filteredOut := failedTerm(opts.Filter, candidate.Record)
+		switch {
+		case len(opts.Collections) > 0 && !contains(opts.Collections, chunkCollection(candidate.Record.Id)) && chunkCollection(candidate.Record.Id) != collectionScratch:
+			verdicts[i] = fmt.Sprintf("collection %s not searched", chunkCollection(candidate.Record.Id))
+		case filteredOut:
+			verdicts[i] = fmt.Sprintf("does not match filter %s", term)
+		case !meetsMinTier(candidate.Record.Id, opts.MinTier):
+			verdicts[i] = fmt.Sprintf("trust tier %s below min tier %s", chunkTier(candidate.Record.Id), opts.MinTier)
+		case candidate.Score < opts.Threshold:
+			verdicts[i] = fmt.Sprintf("score below min score by %.4f", opts.Threshold-candidate.Score)
+		default:
+			eligible = append(eligible, i)
+		}
+	}
+
+	if opts.diversifies() {
+		judgeDiverse(candidates, eligible, opts, verdicts)
+		return verdicts
+	}
+
+	perFile := make(map[string]int)
+	included := 0
+	for _, i := range eligible {
+		source := chunkSource(candidates[i].Record.Id)
+		switch {
+		case opts.NumResults > 0 && included >= opts.NumResults:
+			verdicts[i] = fmt.Sprintf("result limit of %d reached", opts.NumResults)
+		case opts.MaxPerFile > 0 && perFile[source] >= opts.MaxPerFile:
+			verdicts[i] = fmt.Sprintf("already %d results from %s", opts.MaxPerFile, source)
+		default:
+			perFile[source]++
+			included++
+		}
+	}
+	return verdicts
+}
+
+// selectResults keeps the candidates that pass the options in opts
+func selectResults(candidates []searchResult, opts searchOptions) []searchResult {
+	var results []searchResult
+	for i, verdict := range judgeCandidates(candidates, opts) {
+		if verdict == "" {
+			results = append(results, candidates[i])
+		}
+	}
+	return results
+}
+
+// chunkSource returns the source file identifier an embedding ID belongs to
+func chunkSource(id string) string {
+	if source, _, ok := parseChunkID(id); ok {
+		return source
+	}
+	return id
+}
+
+// charsPerToken approximates how many characters make up one model token
+const charsPerToken = 4
+
+// overlapMarker introduces the text carried over from the previous section at ingest
+const overlapMarker = "\n\nContext from previous section:\n"
+
+// applyContextBudget trims results so their formatted size stays within
+// maxChars. Injected overlap text is dropped first, then lower-ranked chunks,
+// and the last chunk that only partly fits is truncated. A maxChars of 0
+// means no budget.
+func applyContextBudget(results []searchResult, maxChars int) []searchResult {
+	if maxChars <= 0 {
+		return results
+	}
+
+	trimmed := make([]searchResult, len(results))
+	total := len(formatResults(nil))
+	for i, result := range results {
+		trimmed[i] = result
+		trimmed[i].Record.Prompt = strings.TrimPrefix(result.Record.Prompt, "search_document: ")
+		total += formattedSize(trimmed[i])
+	}
+
+	// Overlaps duplicate text from neighboring chunks, so they go first
+	if total > maxChars {
+		for i := range trimmed {
+			if idx := strings.Index(trimmed[i].Record.Prompt, overlapMarker); idx != -1 {
+				trimmed[i].Record.Prompt = trimmed[i].Record.Prompt[:idx]
+			}
+		}
+	}
+
+	var kept []searchResult
+	used := len(formatResults(nil))
+	for _, result := range trimmed {
+		size := formattedSize(result)
+		if used+size <= maxChars {
+			kept = append(kept, result)
+			used += size
+			continue
+		}
+
+		// Truncate the first chunk that doesn't fit if a useful part of it does
+		const marker = " [truncated]"
+		remaining := maxChars - used - (size - len(result.Record.Prompt)) - len(marker)
+		if remaining >= 200 || (len(kept) == 0 && remaining > 0) {
+			for remaining > 0 && !utf8.RuneStart(result.Record.Prompt[remaining]) {
+				remaining--
+			}
+			result.Record.Prompt = result.Record.Prompt[:remaining] + marker
+			kept = append(kept, result)
+		}
+		break
+	}
+
+	return kept
+}
+
+// formattedSize is the number of characters a result adds to formatResults output
+func formattedSize(result searchResult) int {
+	return len(formatResults([]searchResult{result})) - len(formatResults(nil))
+}
+
+// formatResults renders search results as a context block that includes
+// each chunk's ID, collection, trust tier, similarity score, and source file,
+// commit, and URL, and the author and event of chunks ingested from relays
+func formatResults(results []searchResult) string {
+	var b strings.Builder
+	b.WriteString("<context>\n")
+	for _, result := range results {
+		b.WriteString(fmt.Sprintf("<doc id=\"%s\" source=\"%s\" tier=\"%s\" score=\"%.4f\"%s%s>\n%s\n</doc>\n",
+			result.Record.Id, chunkCollection(result.Record.Id), chunkTier(result.Record.Id), result.Score, sourceAttributes(result.Record), provenanceAttributes(result.Record.Id), result.Record.Prompt))
+	}
+	b.WriteString("</context>")
+	return b.String()
+}
+
+// explainSearch describes why the top candidates were included in or
+// excluded from the results, to help tune the similarity threshold
+func explainSearch(metric string, candidates []searchResult, opts searchOptions) string {
+	show := opts.NumResults * 3
+	if show < 10 {
+		show = 10
+	}
+	if show > len(candidates) {
+		show = len(candidates)
+	}
+
+	var b strings.Builder
+	searched := "all"
+	if len(opts.Collections) > 0 {
+		searched = strings.Join(opts.Collections, ", ")
+	}
+	minTier := "any"
+	if opts.MinTier != "" {
+		minTier = opts.MinTier
+	}
+	diversity := "off"
+	if opts.diversifies() {
+		diversity = fmt.Sprintf("mmr lambda %.2f", opts.MMRLambda)
+	}
+	b.WriteString(fmt.Sprintf("Search explanation (metric: %s, min score: %.4f, max results: %d, max per file: %d, collections: %s, min tier: %s, diversity: %s, candidates: %d)\n",
+		metric, opts.Threshold, opts.NumResults, opts.MaxPerFile, searched, minTier, diversity, len(candidates)))
+	if len(opts.Filter) > 0 {
+		b.WriteString(fmt.Sprintf("Filter parameters: %s\n", formatTerms(opts.Filter)))
+	}
+
+	verdicts := judgeCandidates(candidates, opts)
+	for i, candidate := range candidates[:show] {
+		verdict := "included"
+		if verdicts[i] != "" {
+			verdict = "excluded: " + verdicts[i]
+		}
+		b.WriteString(fmt.Sprintf("%2d. %s score=%.4f %s\n", i+1, candidate.Record.Id, candidate.Score, verdict))
+	}
+
+	if len(candidates) > show {
+		b.WriteString(fmt.Sprintf("... %d lower-scoring candidates not shown\n", len(candidates)-show))
+	}
+	return b.String()
+}
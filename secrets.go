@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/nip49"
+)
+
+// Secrets such as private keys and tokens are never stored in configuration
+// files directly. Configuration holds a reference to where the secret lives:
+//
+//	env:NAME                  an environment variable
+//	keychain:service/account  the OS keychain (macOS Keychain, or the Secret Service via secret-tool)
+//	file:path                 a file holding the secret, e.g. an ncryptsec key
+//	ncryptsec1...             a NIP-49 encrypted private key
+//
+// NIP-49 keys are decrypted with the passphrase in BHN_KEY_PASSPHRASE, or one
+// typed at the terminal.
+const keyPassphraseEnv = "BHN_KEY_PASSPHRASE"
+
+// signerKeyEnv is the environment variable the signer key is read from by default
+const signerKeyEnv = "BHN_NSEC"
+
+// signerKeyRef references the private key used to sign events; set with
+// -signer-key. A key imported with -import-key is used when BHN_NSEC is unset.
+var signerKeyRef = "env:" + signerKeyEnv
+
+// secretPattern matches private keys that must never appear in output
+var secretPattern = regexp.MustCompile(`\b(nsec1|ncryptsec1)[02-9ac-hj-np-z]+\b`)
+
+// knownSecrets holds every secret resolved so far, so it can be redacted
+var knownSecrets struct {
+	mutex  sync.RWMutex
+	values []string
+}
+
+// isSecretReference reports whether value names a secret rather than being one
+func isSecretReference(value string) bool {
+	for _, prefix := range []string{"env:", "keychain:", "file:", "ncryptsec1"} {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveSecret returns the secret a reference points to
+func resolveSecret(ref string) (string, error) {
+	var value string
+	switch {
+	case strings.HasPrefix(ref, "env:"):
+		name := strings.TrimPrefix(ref, "env:")
+		value = os.Getenv(name)
+		if value == "" {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+	case strings.HasPrefix(ref, "keychain:"):
+		var err error
+		if value, err = readKeychain(strings.TrimPrefix(ref, "keychain:")); err != nil {
+			return "", err
+		}
+	case strings.HasPrefix(ref, "file:"):
+		path := strings.TrimPrefix(ref, "file:")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("error reading secret file %s: %v", path, err)
+		}
+		value = strings.TrimSpace(string(data))
+	case strings.HasPrefix(ref, "ncryptsec1"):
+		value = ref
+	case strings.HasPrefix(ref, "nsec1") || nostr.IsValid32ByteHex(ref):
+		return "", fmt.Errorf("plain private keys are not accepted; use env:NAME, keychain:service/account, file:path, or an ncryptsec key")
+	default:
+		return "", fmt.Errorf("invalid secret reference; expected env:NAME, keychain:service/account, file:path, or an ncryptsec key")
+	}
+
+	if strings.HasPrefix(value, "ncryptsec1") {
+		passphrase, err := keyPassphrase()
+		if err != nil {
+			return "", err
+		}
+		decrypted, err := nip49.Decrypt(value, passphrase)
+		if err != nil {
+			return "", fmt.Errorf("error decrypting key: %v", err)
+		}
+		value = decrypted
+	}
+
+	rememberSecret(value)
+	return value, nil
+}
+
+// readKeychain looks up a "service/account" entry in the OS keychain
+func readKeychain(entry string) (string, error) {
+	service, account, ok := strings.Cut(entry, "/")
+	if !ok || service == "" || account == "" {
+		return "", fmt.Errorf("invalid keychain reference %q: expected keychain:service/account", entry)
+	}
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w")
+	case "linux", "freebsd", "openbsd":
+		cmd = exec.Command("secret-tool", "lookup", "service", service, "account", account)
+	default:
+		return "", fmt.Errorf("the OS keychain is not supported on %s; use env: or file: instead", runtime.GOOS)
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("error reading %s/%s from the keychain: %v", service, account, err)
+	}
+	value := strings.TrimRight(string(out), "\r\n")
+	if value == "" {
+		return "", fmt.Errorf("keychain entry %s/%s is empty", service, account)
+	}
+	return value, nil
+}
+
+// loadPrivateKey resolves a reference to a Nostr private key and returns it as hex
+func loadPrivateKey(ref string) (string, error) {
+	value, err := resolveSecret(ref)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.HasPrefix(value, "nsec1") {
+		prefix, decoded, err := nip19.Decode(value)
+		if err != nil || prefix != "nsec" {
+			return "", fmt.Errorf("invalid nsec in %s", describeSecretRef(ref))
+		}
+		value, _ = decoded.(string)
+	}
+	if !nostr.IsValid32ByteHex(value) {
+		return "", fmt.Errorf("%s does not hold a Nostr private key", describeSecretRef(ref))
+	}
+
+	rememberSecret(value)
+	return value, nil
+}
+
+// printSigner shows which public key the configured signer key belongs to,
+// without revealing the key itself
+func printSigner() {
+	sk, err := loadPrivateKey(signerKeyRef)
+	if err != nil {
+		fmt.Printf("Error loading signer key from %s: %v\n", describeSecretRef(signerKeyRef), err)
+		os.Exit(1)
+	}
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		fmt.Printf("Error deriving public key: %v\n", err)
+		os.Exit(1)
+	}
+	npub, _ := nip19.EncodePublicKey(pk)
+	fmt.Printf("Signer: %s (from %s)\n", npub, describeSecretRef(signerKeyRef))
+}
+
+// describeSecretRef names where a secret comes from without revealing it
+func describeSecretRef(ref string) string {
+	if strings.HasPrefix(ref, "ncryptsec1") {
+		return "an ncryptsec key"
+	}
+	if isSecretReference(ref) {
+		return ref
+	}
+	return "an inline value"
+}
+
+// rememberSecret registers a resolved secret for redaction
+func rememberSecret(value string) {
+	knownSecrets.mutex.Lock()
+	knownSecrets.values = append(knownSecrets.values, value)
+	knownSecrets.mutex.Unlock()
+}
+
+// redactSecrets replaces private keys and resolved secrets in text
+func redactSecrets(text string) string {
+	text = secretPattern.ReplaceAllString(text, "[redacted]")
+
+	knownSecrets.mutex.RLock()
+	defer knownSecrets.mutex.RUnlock()
+	for _, value := range knownSecrets.values {
+		text = strings.ReplaceAll(text, value, "[redacted]")
+	}
+	return text
+}
+
+// redactingWriter removes secrets from everything written through it
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (r redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, redactSecrets(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
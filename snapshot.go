@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/parakeet-nest/parakeet/llm"
+)
+
+// vectorReader is the read side of a vector store, implemented both by the
+// on-disk store and by in-memory snapshots of it
+type vectorReader interface {
+	Get(id string) (llm.VectorRecord, error)
+	GetAll() ([]llm.VectorRecord, error)
+
+	// Metric is the similarity metric the stored vectors were prepared for
+	Metric() string
+}
+
+// storeSnapshot is an immutable copy of every record in the store, read in a
+// single transaction. A query that works from one snapshot sees the index
+// either entirely before or entirely after a refresh, never halfway through.
+type storeSnapshot struct {
+	records []llm.VectorRecord
+	byID    map[string]int
+	metric  string
+}
+
+// servingIndex is what MCP queries read from: a snapshot, or the store itself
+// when the index is too large to hold in memory. Each index carries the metric
+// of its own database, so a swap never changes how an older query scores.
+type servingIndex struct {
+	reader   vectorReader
+	store    *vectorStore // The open store the index was loaded from
+	vectors  int
+	inMemory bool
+	loaded   time.Time
+	corpus   *corpusManifest
+	registry *kindRegistry
+
+	// readers counts the queries holding the index. A replaced index is
+	// retired, and its store closed once the last of them is done.
+	mutex   sync.Mutex
+	readers int
+	retired bool
+}
+
+// serving is the index MCP queries currently read from
+var serving atomic.Pointer[servingIndex]
+
+// openServingIndex opens the vector store at path and loads it for serving,
+// keeping a snapshot in memory unless it holds more than maxMemoryVectors
+func openServingIndex(path string) (*servingIndex, error) {
+	count := 0
+	var corpus *corpusManifest
+	var registry *kindRegistry
+	if _, err := os.Stat(path); err == nil {
+		if count, err = countStoredRecords(path); err != nil {
+			return nil, err
+		}
+		if corpus, err = loadCorpusManifest(path); err != nil {
+			return nil, err
+		}
+		if registry, err = loadKindRegistry(path); err != nil {
+			return nil, err
+		}
+	}
+
+	store := &vectorStore{}
+	if err := initializeStore(store, path); err != nil {
+		return nil, fmt.Errorf("error initializing vector store: %v", err)
+	}
+
+	if maxMemoryVectors > 0 && count > maxMemoryVectors {
+		// Each query reads the store in its own transaction, so results are
+		// still never a mix of two index versions
+		return &servingIndex{reader: store, store: store, vectors: count, loaded: time.Now(), corpus: corpus, registry: registry}, nil
+	}
+
+	snapshot, err := takeSnapshot(store)
+	if err != nil {
+		closeStore(store)
+		return nil, err
+	}
+	return &servingIndex{reader: snapshot, store: store, vectors: len(snapshot.records), inMemory: true, loaded: time.Now(), corpus: corpus, registry: registry}, nil
+}
+
+// takeSnapshot reads every record from store into a new snapshot
+func takeSnapshot(store *vectorStore) (*storeSnapshot, error) {
+	// GetAll reads the whole store in one read transaction
+	records, err := store.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error reading vector store: %v", err)
+	}
+
+	byID := make(map[string]int, len(records))
+	for i, record := range records {
+		byID[record.Id] = i
+	}
+	return &storeSnapshot{records: records, byID: byID, metric: store.Metric()}, nil
+}
+
+// currentIndex returns the index queries should read from. Queries that
+// read chunks from it hold it with acquireIndex instead.
+func currentIndex() *servingIndex {
+	return serving.Load()
+}
+
+// acquireIndex returns the index queries should read from and keeps its store
+// open until the returned function is called
+func acquireIndex() (*servingIndex, func()) {
+	for {
+		index := serving.Load()
+		if index.acquire() {
+			return index, index.release
+		}
+		// The index was replaced and closed after it was loaded here
+	}
+}
+
+// publishIndex makes queries read from index and retires the index they read
+// from before
+func publishIndex(index *servingIndex) {
+	if previous := serving.Swap(index); previous != nil {
+		previous.retire()
+	}
+}
+
+// acquire holds the index for a query, unless it is retired and its store
+// closed or about to be
+func (index *servingIndex) acquire() bool {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	if index.retired && !index.inMemory {
+		return false
+	}
+	index.readers++
+	return true
+}
+
+// release ends a query's hold on the index, closing the store of a retired
+// index when it was the last one
+func (index *servingIndex) release() {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.readers--
+	if index.retired && index.readers == 0 && !index.inMemory {
+		closeStore(index.store)
+	}
+}
+
+// retire closes the store of a replaced index once no query holds it. A
+// snapshot in memory does not need its store, so that is closed right away.
+func (index *servingIndex) retire() {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.retired = true
+	if index.inMemory || index.readers == 0 {
+		closeStore(index.store)
+	}
+}
+
+// Get returns the record with the given ID
+func (s *storeSnapshot) Get(id string) (llm.VectorRecord, error) {
+	i, ok := s.byID[id]
+	if !ok {
+		return llm.VectorRecord{}, fmt.Errorf("record %s not found", id)
+	}
+	return s.records[i], nil
+}
+
+// Metric returns the similarity metric of the database the snapshot was taken from
+func (s *storeSnapshot) Metric() string {
+	return s.metric
+}
+
+// GetAll returns every record in the snapshot. The slice is shared between
+// queries and must not be modified.
+func (s *storeSnapshot) GetAll() ([]llm.VectorRecord, error) {
+	return s.records, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// snippetCacheFile keeps the snippet cache between runs, so the server starts
+// with the snippets it last fetched instead of an empty cache
+var snippetCacheFile = filepath.Join(dataDir, "snippet-cache.jsonl")
+
+// writeEventsFile writes events as JSONL, one event per line, in the format
+// read by -events-file. The file is replaced atomically.
+func writeEventsFile(path string, events []*nostr.Event) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for _, ev := range events {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			file.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+		w.Write(data)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// readSnippetEvents reads the code-bearing events of an events file,
+// dropping any whose signature does not verify
+func readSnippetEvents(path string) ([]*nostr.Event, int, error) {
+	events, err := loadEventsFile(path, snippetFilters("", nil, 0))
+	if err != nil {
+		return nil, 0, err
+	}
+	var snippets []*nostr.Event
+	invalid := 0
+	for _, ev := range events {
+		if !isCodeBearing(ev) {
+			continue
+		}
+		if ok, err := ev.CheckSignature(); !ok || err != nil {
+			invalid++
+			continue
+		}
+		snippets = append(snippets, ev)
+	}
+	return snippets, invalid, nil
+}
+
+// saveSnippetCache writes the snippet cache to the data directory
+func saveSnippetCache() error {
+	codeSnippetCache.mutex.RLock()
+	events := codeSnippetCache.events
+	codeSnippetCache.mutex.RUnlock()
+	return writeEventsFile(snippetCacheFile, events)
+}
+
+// loadSnippetCache fills the snippet cache with the snippets saved by the
+// last run or imported with -import-snippets, until relays are reached
+func loadSnippetCache() {
+	info, err := os.Stat(snippetCacheFile)
+	if err != nil {
+		return
+	}
+	events, _, err := readSnippetEvents(snippetCacheFile)
+	if err != nil {
+		fmt.Printf("Failed to read snippet cache: %v\n", err)
+		return
+	}
+
+	events, dropped := capSnippetEvents(events)
+	codeSnippetCache.mutex.Lock()
+	defer codeSnippetCache.mutex.Unlock()
+	// Relays may have answered already
+	if len(codeSnippetCache.events) == 0 {
+		codeSnippetCache.events = events
+		codeSnippetCache.dropped = dropped
+		codeSnippetCache.lastUpdate = info.ModTime()
+	}
+}
+
+// exportSnippetCache refreshes the snippet cache from the relays and writes
+// it to path. When the relays cannot be reached, the cache saved by the last
+// run is exported instead.
+func exportSnippetCache(path string) {
+	start := time.Now()
+	updateCodeSnippetCache()
+
+	codeSnippetCache.mutex.RLock()
+	events := codeSnippetCache.events
+	codeSnippetCache.mutex.RUnlock()
+	source := "the relays"
+	if eventsFile != "" {
+		source = eventsFile
+	}
+
+	if len(events) == 0 {
+		var err error
+		events, _, err = readSnippetEvents(snippetCacheFile)
+		if os.IsNotExist(err) {
+			fmt.Println("No snippets could be fetched from the relays and there is no saved snippet cache to export.")
+			os.Exit(1)
+		}
+		if err != nil {
+			log.Fatalf("Error reading snippet cache: %v", err)
+		}
+		source = snippetCacheFile
+	}
+
+	if err := writeEventsFile(path, events); err != nil {
+		log.Fatalf("Error writing %s: %v", path, err)
+	}
+	fmt.Printf("Exported %d snippets from %s to %s in %s.\n", len(events), source, path, time.Since(start).Round(time.Second/10))
+}
+
+// importSnippetCache replaces the saved snippet cache with the snippets in an
+// exported file, which the server loads at startup
+func importSnippetCache(path string) {
+	events, invalid, err := readSnippetEvents(path)
+	if err != nil {
+		log.Fatalf("Error reading %s: %v", path, err)
+	}
+	if len(events) == 0 {
+		fmt.Printf("%s contains no code snippets; the snippet cache was left unchanged.\n", path)
+		os.Exit(1)
+	}
+
+	if err := writeEventsFile(snippetCacheFile, events); err != nil {
+		log.Fatalf("Error writing snippet cache: %v", err)
+	}
+	fmt.Printf("Imported %d snippets into %s; the server starts with them and refreshes them from the relays.\n", len(events), snippetCacheFile)
+	if invalid > 0 {
+		fmt.Printf("Skipped %d events with invalid signatures.\n", invalid)
+	}
+}
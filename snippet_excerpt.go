@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// excerptMaxLines caps the number of matching lines shown above a snippet
+const excerptMaxLines = 5
+
+// excerptMaxLineLength caps the length of a single excerpt line
+const excerptMaxLineLength = 120
+
+// defaultMaxSnippetLength is the number of characters of code shown per
+// snippet unless the caller asks for more
+const defaultMaxSnippetLength = 4000
+
+// importLine matches the import, package, and include lines at the top of a
+// source file, which are kept when a snippet is truncated
+var importLine = regexp.MustCompile(`^\s*(import\b|from\s+\S+\s+import\b|package\b|use\b|using\b|#include\b|require\b|(const|let|var)\s+.*=\s*require\()`)
+
+// queryTerms splits a query into the lowercased terms matchesQuery looks for:
+// the whole query plus each word of at least two characters
+func queryTerms(query string) []string {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return nil
+	}
+	terms := []string{query}
+	for _, word := range strings.Fields(query) {
+		if len(word) >= 2 && word != query {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+// matchExcerpt returns the lines of code that contain the query, prefixed
+// with their line numbers, or an empty string when nothing matches
+func matchExcerpt(code, query string) string {
+	terms := queryTerms(query)
+	if len(terms) == 0 {
+		return ""
+	}
+
+	var excerpt strings.Builder
+	shown, total := 0, 0
+	for i, line := range strings.Split(code, "\n") {
+		lower := strings.ToLower(line)
+		matched := false
+		for _, term := range terms {
+			if strings.Contains(lower, term) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		total++
+		if shown < excerptMaxLines {
+			line = strings.TrimRight(line, " \t\r")
+			if len(line) > excerptMaxLineLength {
+				line = truncateUTF8(line, excerptMaxLineLength) + "…"
+			}
+			excerpt.WriteString(fmt.Sprintf("%4d | %s\n", i+1, line))
+			shown++
+		}
+	}
+
+	if total > shown {
+		excerpt.WriteString(fmt.Sprintf("     … and %d more matching lines\n", total-shown))
+	}
+	return excerpt.String()
+}
+
+// truncateSnippet shortens code to roughly maxLength characters. The leading
+// imports are kept, followed by the region around the first line matching the
+// query (or the start of the code when nothing matches), with markers where
+// lines were left out. It reports whether the code was truncated.
+func truncateSnippet(code, query string, maxLength int) (string, bool) {
+	if maxLength <= 0 || len(code) <= maxLength {
+		return code, false
+	}
+
+	lines := strings.Split(code, "\n")
+
+	// Leading imports, allowing blank lines and comments between them
+	header := 0
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		grouped := header > 0 && (strings.HasPrefix(trimmed, "\"") || trimmed == ")")
+		if importLine.MatchString(line) || grouped {
+			header = i + 1
+			continue
+		}
+		if trimmed != "" && !strings.HasPrefix(trimmed, "//") && !strings.HasPrefix(trimmed, "#") {
+			break
+		}
+	}
+
+	// The region starts a few lines before the first match
+	start := header
+	terms := queryTerms(query)
+	for i := header; i < len(lines) && len(terms) > 0; i++ {
+		lower := strings.ToLower(lines[i])
+		found := false
+		for _, term := range terms {
+			if strings.Contains(lower, term) {
+				found = true
+				break
+			}
+		}
+		if found {
+			start = max(header, i-3)
+			break
+		}
+	}
+
+	var kept []string
+	used := 0
+	for i := 0; i < header && used < maxLength/2; i++ {
+		kept = append(kept, lines[i])
+		used += len(lines[i]) + 1
+	}
+	if start > len(kept) {
+		kept = append(kept, fmt.Sprintf("… %d lines omitted …", start-len(kept)))
+	}
+
+	end := start
+	for end < len(lines) && used+len(lines[end])+1 <= maxLength {
+		kept = append(kept, lines[end])
+		used += len(lines[end]) + 1
+		end++
+	}
+	if end == start && end < len(lines) && used < maxLength {
+		// A single line longer than the budget is cut rather than dropped
+		kept = append(kept, truncateUTF8(lines[end], maxLength-used))
+		end++
+	}
+	if end < len(lines) {
+		kept = append(kept, fmt.Sprintf("… %d lines omitted …", len(lines)-end))
+	}
+
+	return strings.Join(kept, "\n"), true
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// Ways snippet results can be grouped
+const (
+	groupByLanguage = "language"
+	groupByAuthor   = "author"
+)
+
+// snippetGroup is a named set of snippet results
+type snippetGroup struct {
+	Name   string
+	Events []*nostr.Event
+}
+
+// groupSnippets groups events by language or author. Larger groups come
+// first; ties keep the order in which the groups first appeared.
+func groupSnippets(events []*nostr.Event, groupBy string) []snippetGroup {
+	var groups []snippetGroup
+	positions := make(map[string]int)
+	for _, ev := range events {
+		name := snippetGroupName(ev, groupBy)
+		position, ok := positions[name]
+		if !ok {
+			position = len(groups)
+			positions[name] = position
+			groups = append(groups, snippetGroup{Name: name})
+		}
+		groups[position].Events = append(groups[position].Events, ev)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].Events) > len(groups[j].Events)
+	})
+	return groups
+}
+
+// snippetGroupName returns the group an event belongs to
+func snippetGroupName(ev *nostr.Event, groupBy string) string {
+	if groupBy == groupByAuthor {
+		if npub, err := nip19.EncodePublicKey(ev.PubKey); err == nil {
+			return npub
+		}
+		return ev.PubKey
+	}
+
+	if languages := snippetLanguages(ev); len(languages) > 0 {
+		return canonicalLanguage(languages[0])
+	}
+	return "unknown"
+}
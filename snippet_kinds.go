@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Event kinds that can carry code
+const (
+	kindCodeSnippet = 1337  // NIP-C0 code snippets
+	kindPatch       = 1617  // NIP-34 git patches
+	kindLongForm    = 30023 // NIP-23 long-form articles
+)
+
+// snippetKinds lists the event kinds searched for code. It can be changed
+// with the -snippet-kinds flag.
+var snippetKinds = []int{kindCodeSnippet, kindPatch, kindLongForm}
+
+// codeFence matches fenced code blocks in markdown, capturing the info string and body
+var codeFence = regexp.MustCompile("(?s)```([^\\n`]*)\\n(.*?)```")
+
+// diffFile matches the file names touched by a git patch
+var diffFile = regexp.MustCompile(`(?m)^diff --git a/(\S+)`)
+
+// extensionLanguages maps file extensions found in patches to language names
+var extensionLanguages = map[string]string{
+	".go":    "go",
+	".js":    "javascript",
+	".mjs":   "javascript",
+	".ts":    "typescript",
+	".tsx":   "typescript",
+	".py":    "python",
+	".rs":    "rust",
+	".swift": "swift",
+	".kt":    "kotlin",
+	".java":  "java",
+	".dart":  "dart",
+	".c":     "c",
+	".h":     "c",
+	".cpp":   "cpp",
+	".rb":    "ruby",
+	".php":   "php",
+	".sh":    "bash",
+	".md":    "markdown",
+}
+
+// languageAliases maps each canonical language name to the other names and
+// spellings snippet authors tag it with
+var languageAliases = map[string][]string{
+	"javascript": {"js", "JavaScript", "node", "nodejs"},
+	"typescript": {"ts", "TypeScript", "tsx"},
+	"go":         {"golang", "Go", "Golang"},
+	"rust":       {"rs", "Rust"},
+	"python":     {"py", "Python", "python3"},
+	"kotlin":     {"kt", "Kotlin"},
+	"swift":      {"Swift"},
+	"java":       {"Java"},
+	"dart":       {"Dart", "flutter"},
+	"c":          {"C"},
+	"cpp":        {"c++", "C++", "cxx"},
+	"csharp":     {"c#", "C#", "cs"},
+	"ruby":       {"rb", "Ruby"},
+	"php":        {"PHP"},
+	"bash":       {"sh", "shell", "zsh", "Bash"},
+	"markdown":   {"md", "Markdown"},
+}
+
+// canonicalLanguages maps every lowercased language name or alias to its canonical name
+var canonicalLanguages = func() map[string]string {
+	canonical := make(map[string]string)
+	for name, aliases := range languageAliases {
+		canonical[name] = name
+		for _, alias := range aliases {
+			canonical[strings.ToLower(alias)] = name
+		}
+	}
+	return canonical
+}()
+
+// canonicalLanguage normalizes a language name, resolving aliases such as
+// ts, golang, or rs. Unknown names are returned lowercased.
+func canonicalLanguage(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if canonical, ok := canonicalLanguages[name]; ok {
+		return canonical
+	}
+	return name
+}
+
+// languageTagValues returns every "l" tag value that may mean the language,
+// since relays match tag values exactly
+func languageTagValues(language string) []string {
+	canonical := canonicalLanguage(language)
+	values := []string{canonical}
+	seen := map[string]bool{canonical: true}
+	for _, alias := range languageAliases[canonical] {
+		for _, value := range []string{alias, strings.ToLower(alias)} {
+			if !seen[value] {
+				seen[value] = true
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// parseSnippetKinds parses a comma-separated list of event kinds
+func parseSnippetKinds(value string) ([]int, error) {
+	var kinds []int
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kind, err := strconv.Atoi(part)
+		if err != nil || kind < 0 {
+			return nil, fmt.Errorf("invalid event kind %q", part)
+		}
+		kinds = append(kinds, kind)
+	}
+	if len(kinds) == 0 {
+		return nil, fmt.Errorf("no event kinds given")
+	}
+	return kinds, nil
+}
+
+// snippetFilters builds relay filters for code-bearing events. Only kind 1337
+// events carry an "l" language tag, so when a language is given the other
+// kinds are fetched separately and matched locally.
+func snippetFilters(language string, authors []string, limit int) []nostr.Filter {
+	base := nostr.Filter{Limit: limit, Authors: authors}
+
+	if language == "" {
+		filter := base
+		filter.Kinds = snippetKinds
+		return []nostr.Filter{filter}
+	}
+
+	var filters []nostr.Filter
+	var otherKinds []int
+	for _, kind := range snippetKinds {
+		if kind == kindCodeSnippet {
+			filter := base
+			filter.Kinds = []int{kindCodeSnippet}
+			filter.Tags = nostr.TagMap{"l": languageTagValues(language)}
+			filters = append(filters, filter)
+		} else {
+			otherKinds = append(otherKinds, kind)
+		}
+	}
+	if len(otherKinds) > 0 {
+		filter := base
+		filter.Kinds = otherKinds
+		filters = append(filters, filter)
+	}
+	return filters
+}
+
+// isCodeBearing reports whether an event actually contains code. Long-form
+// articles only count when they include a fenced code block.
+func isCodeBearing(ev *nostr.Event) bool {
+	if ev.Kind == kindLongForm {
+		return codeFence.MatchString(ev.Content)
+	}
+	return true
+}
+
+// snippetLanguages returns the programming languages an event's code is written in
+func snippetLanguages(ev *nostr.Event) []string {
+	var languages []string
+	switch ev.Kind {
+	case kindLongForm:
+		for _, match := range codeFence.FindAllStringSubmatch(ev.Content, -1) {
+			if info := strings.Fields(match[1]); len(info) > 0 {
+				languages = append(languages, info[0])
+			}
+		}
+	case kindPatch:
+		for _, match := range diffFile.FindAllStringSubmatch(ev.Content, -1) {
+			if language, ok := extensionLanguages[path.Ext(match[1])]; ok {
+				languages = append(languages, language)
+			}
+		}
+	default:
+		for _, tag := range ev.Tags {
+			if len(tag) >= 2 && tag[0] == "l" {
+				languages = append(languages, tag[1])
+			}
+		}
+	}
+	return languages
+}
+
+// snippetMatchesLanguage reports whether an event contains code in the given language
+func snippetMatchesLanguage(ev *nostr.Event, language string) bool {
+	if language == "" {
+		return true
+	}
+	language = canonicalLanguage(language)
+	for _, candidate := range snippetLanguages(ev) {
+		if canonicalLanguage(candidate) == language {
+			return true
+		}
+	}
+	return false
+}
+
+// snippetSource labels where a code result came from
+func snippetSource(ev *nostr.Event) string {
+	switch ev.Kind {
+	case kindCodeSnippet:
+		return "code snippet (kind 1337)"
+	case kindPatch:
+		return "git patch (kind 1617)"
+	case kindLongForm:
+		return "long-form article (kind 30023)"
+	}
+	return fmt.Sprintf("event (kind %d)", ev.Kind)
+}
+
+// snippetCode returns the code to display for an event: the fenced blocks of
+// an article, or the full content for snippets and patches
+func snippetCode(ev *nostr.Event) string {
+	if ev.Kind != kindLongForm {
+		return ev.Content
+	}
+
+	var blocks []string
+	for _, match := range codeFence.FindAllStringSubmatch(ev.Content, -1) {
+		blocks = append(blocks, strings.TrimRight(match[2], "\n"))
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+// patchSubject extracts the commit subject from a git format-patch body
+func patchSubject(content string) string {
+	for _, line := range strings.Split(content, "\n") {
+		if subject, ok := strings.CutPrefix(line, "Subject: "); ok {
+			return strings.TrimSpace(strings.TrimPrefix(subject, "[PATCH]"))
+		}
+	}
+	return ""
+}
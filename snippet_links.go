@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// nipMention finds NIP references such as "NIP-57" or "nip57" in text
+var nipMention = regexp.MustCompile(`(?i)\bnip-?([0-9a-f]{2})\b`)
+
+// defaultLinkedSnippets is how many related snippets are appended to query results
+const defaultLinkedSnippets = 3
+
+// resultReferences collects the event kinds and NIP numbers that the returned
+// chunks are about
+func resultReferences(results []searchResult) (map[int]bool, map[string]bool) {
+	kinds := make(map[int]bool)
+	nips := make(map[string]bool)
+
+	for _, result := range results {
+		meta := extractChunkMeta(result.Record)
+		for _, kind := range meta.Kinds {
+			kinds[kind] = true
+		}
+		if nipFilePattern.MatchString(meta.File + ".md") {
+			nips[strings.ToUpper(meta.File)] = true
+		}
+		for _, match := range nipMention.FindAllStringSubmatch(result.Record.Prompt, -1) {
+			nips[strings.ToUpper(match[1])] = true
+		}
+	}
+	return kinds, nips
+}
+
+// snippetReferences reports whether a code snippet event refers to any of the
+// given kinds or NIPs, through its tags or its content
+func snippetReferences(ev *nostr.Event, kinds map[int]bool, nips map[string]bool) bool {
+	for _, tag := range ev.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "k":
+			if kind, err := strconv.Atoi(tag[1]); err == nil && kinds[kind] {
+				return true
+			}
+		case "t", "l":
+			if match := nipMention.FindStringSubmatch(tag[1]); match != nil && nips[strings.ToUpper(match[1])] {
+				return true
+			}
+		}
+	}
+
+	for _, match := range nipMention.FindAllStringSubmatch(ev.Content, -1) {
+		if nips[strings.ToUpper(match[1])] {
+			return true
+		}
+	}
+	for _, match := range kindMention.FindAllStringSubmatch(ev.Content, -1) {
+		if kind, err := strconv.Atoi(match[1]); err == nil && kinds[kind] {
+			return true
+		}
+	}
+	return false
+}
+
+// findLinkedSnippets returns cached code snippets that relate to the kinds and
+// NIPs covered by the search results
+func findLinkedSnippets(results []searchResult, limit int) []*nostr.Event {
+	kinds, nips := resultReferences(results)
+	if len(kinds) == 0 && len(nips) == 0 {
+		return nil
+	}
+
+	codeSnippetCache.mutex.RLock()
+	defer codeSnippetCache.mutex.RUnlock()
+
+	var linked []*nostr.Event
+	for _, ev := range codeSnippetCache.events {
+		if snippetReferences(ev, kinds, nips) {
+			linked = append(linked, ev)
+			if len(linked) >= limit {
+				break
+			}
+		}
+	}
+	return linked
+}
+
+// formatLinkedSnippets renders related code snippets to append after query results
+func formatLinkedSnippets(events []*nostr.Event) string {
+	if len(events) == 0 {
+		return ""
+	}
+
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("\n\n# Related code snippets (%d)\n\n", len(events)))
+	for i, ev := range events {
+		writeCodeSnippet(&result, i+1, ev, "", "", defaultMaxSnippetLength)
+	}
+	return result.String()
+}
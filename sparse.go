@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Sparse checkouts are done with the git command line tool, since go-git
+// neither keeps a worktree sparse across pulls nor reads git's sparse
+// checkout patterns. go-git still does the cloning and fetching.
+
+// isSparse reports whether a repository checks out only some paths
+func isSparse(repo RepoConfig) bool {
+	return len(repo.SparsePaths) > 0
+}
+
+// runGit runs a git command in a clone and returns its error with git's output
+func runGit(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// sparsePatterns turns SparsePaths into patterns anchored at the top of the
+// repository, so "docs" does not also match "src/docs"
+func sparsePatterns(repo RepoConfig) []string {
+	var patterns []string
+	for _, path := range repo.SparsePaths {
+		if path = strings.Trim(path, "/"); path != "" {
+			patterns = append(patterns, "/"+path)
+		}
+	}
+	return patterns
+}
+
+// sparseCheckout checks out a revision with only the repository's sparse
+// paths in the worktree. With a branch, the branch is reset to the revision
+// and checked out; without one, HEAD is detached at it.
+func sparseCheckout(repo RepoConfig, branch string, hash plumbing.Hash) error {
+	if _, err := exec.LookPath("git"); err != nil {
+		return fmt.Errorf("SparsePaths is set but git is not installed")
+	}
+
+	// git sparse-checkout keeps its settings in the worktree config, which
+	// git ignores in the repositories go-git creates, so they are set here
+	for _, setting := range [][]string{{"core.sparseCheckout", "true"}, {"core.sparseCheckoutCone", "false"}} {
+		if err := runGit(repo.CloneDir, "config", setting[0], setting[1]); err != nil {
+			return fmt.Errorf("error enabling sparse checkout: %v", err)
+		}
+	}
+	args := append([]string{"sparse-checkout", "set", "--no-cone", "--"}, sparsePatterns(repo)...)
+	if err := runGit(repo.CloneDir, args...); err != nil {
+		return fmt.Errorf("error setting sparse paths: %v", err)
+	}
+	if branch != "" {
+		err := runGit(repo.CloneDir, "checkout", "--force", "-B", branch, hash.String())
+		if err != nil {
+			return fmt.Errorf("error checking out branch %s: %v", branch, err)
+		}
+	} else if err := runGit(repo.CloneDir, "checkout", "--force", "--detach", hash.String()); err != nil {
+		return fmt.Errorf("error checking out %s: %v", shortHash(hash), err)
+	}
+
+	if repo.Submodules {
+		if err := runGit(repo.CloneDir, "submodule", "update", "--init", "--recursive"); err != nil {
+			return fmt.Errorf("error updating submodules: %v", err)
+		}
+	}
+	return fetchLFSObjects(repo)
+}
+
+// disableSparseCheckout restores the full worktree of a clone that was
+// sparse, after SparsePaths was removed from its configuration
+func disableSparseCheckout(r *git.Repository, repo RepoConfig) error {
+	cfg, err := r.Config()
+	if err != nil || cfg.Raw.Section("core").Option("sparseCheckout") != "true" {
+		return nil
+	}
+	if err := runGit(repo.CloneDir, "sparse-checkout", "disable"); err != nil {
+		return fmt.Errorf("error disabling sparse checkout: %v", err)
+	}
+	if err := runGit(repo.CloneDir, "config", "core.sparseCheckout", "false"); err != nil {
+		return fmt.Errorf("error disabling sparse checkout: %v", err)
+	}
+	return nil
+}
+
+// pullSparseRepository fetches a sparse clone's branch from url and checks
+// out its tip with only the sparse paths, reporting whether it moved
+func pullSparseRepository(r *git.Repository, repo RepoConfig, url string, progress io.Writer) (string, bool, error) {
+	before, err := r.Head()
+	if err != nil {
+		return "", false, fmt.Errorf("error reading HEAD: %v", err)
+	}
+	branch := before.Name().Short()
+	if repo.Branch != "" {
+		branch = repo.Branch
+	}
+
+	auth, err := repoAuth(repo, url)
+	if err != nil {
+		return "", false, err
+	}
+	err = r.Fetch(&git.FetchOptions{
+		RemoteName: "origin",
+		RemoteURL:  url,
+		Auth:       auth,
+		Progress:   progress,
+		Depth:      repo.Depth,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return "", false, fmt.Errorf("error fetching: %v", err)
+	}
+	remote, err := r.Reference(plumbing.NewRemoteReferenceName("origin", branch), true)
+	if err != nil {
+		return "", false, fmt.Errorf("branch %s not found on the remote: %v", branch, err)
+	}
+
+	// Checking out again also applies SparsePaths changes to an up to date clone
+	if err := sparseCheckout(repo, branch, remote.Hash()); err != nil {
+		return "", false, err
+	}
+	if before.Hash() == remote.Hash() {
+		return fmt.Sprintf("already up to date at %s", shortHash(before.Hash())), false, nil
+	}
+	return fmt.Sprintf("updated %s..%s (%s)", shortHash(before.Hash()), shortHash(remote.Hash()),
+		countCommits(r, before.Hash(), remote.Hash())), true, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// staleAfterDays is how long after a repository was last updated query
+// results from it carry a staleness note (-stale-after-days); 0 disables the
+// note
+var staleAfterDays = 90
+
+// stalenessNote warns when repositories the results came from were last
+// fetched from upstream more than staleAfterDays ago, naming when and the
+// commit each was ingested at. Repositories without a recorded fetch are
+// judged by the date of that commit. It returns "" when the results are
+// fresh enough.
+func stalenessNote(manifest *corpusManifest, results []searchResult) string {
+	if manifest == nil || staleAfterDays <= 0 {
+		return ""
+	}
+	limit := time.Duration(staleAfterDays) * 24 * time.Hour
+	now := time.Now()
+
+	entries := make(map[string]corpusRepo, len(manifest.Repos))
+	for _, repo := range manifest.Repos {
+		entries[repo.Name] = repo
+	}
+
+	seen := make(map[string]bool)
+	var sources []string
+	for _, result := range results {
+		name := chunkRepo(result.Record.Id)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		entry, ok := entries[name]
+		if !ok {
+			continue
+		}
+
+		var source string
+		switch {
+		case !entry.FetchedAt.IsZero():
+			if now.Sub(entry.FetchedAt) < limit {
+				continue
+			}
+			source = fmt.Sprintf("%s, last fetched %s ago", entry.Name, describeAge(now.Sub(entry.FetchedAt)))
+			if entry.Commit != "" {
+				source += fmt.Sprintf(" at commit %.7s", entry.Commit)
+			}
+		case !entry.CommitDate.IsZero():
+			if now.Sub(entry.CommitDate) < limit {
+				continue
+			}
+			source = fmt.Sprintf("%s, at commit %.7s from %s", entry.Name, entry.Commit, entry.CommitDate.UTC().Format("2006-01-02"))
+		default:
+			// Nothing tells how old the repository's content is
+			continue
+		}
+		sources = append(sources, source)
+	}
+	if len(sources) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("\n\nNote: some of these results come from repositories that have not been updated in %d days (%s). The specifications may have changed since; re-run the ingestion with -ingest -clone-repos before relying on time-sensitive details.",
+		staleAfterDays, strings.Join(sources, "; "))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parakeet-nest/parakeet/llm"
+)
+
+func TestStalenessNote(t *testing.T) {
+	old := time.Now().AddDate(0, 0, -staleAfterDays-10)
+	recent := time.Now().AddDate(0, 0, -1)
+	manifest := &corpusManifest{
+		// The index itself was built long ago; only each repository's own
+		// dates count
+		IngestedAt: old,
+		Repos: []corpusRepo{
+			{Name: "nips", Commit: "1a2b3c4d5e", CommitDate: old, FetchedAt: recent},
+			{Name: "nostr-tools", Commit: "5e6f7a8b9c", CommitDate: recent, FetchedAt: old},
+			{Name: "wiki", Commit: "9c8b7a6f5e", CommitDate: old},
+			{Name: "handbook", Commit: "0a1b2c3d4e", CommitDate: recent},
+			{Name: eventsRepo},
+		},
+	}
+	results := func(repos ...string) []searchResult {
+		var list []searchResult
+		for _, repo := range repos {
+			list = append(list, searchResult{Record: llm.VectorRecord{Id: repo + "/README-chunk-1"}})
+		}
+		return list
+	}
+
+	tests := []struct {
+		name    string
+		results []searchResult
+		want    []string
+	}{
+		{name: "recently fetched, old commit", results: results("nips")},
+		{name: "not fetched recently", results: results("nostr-tools"), want: []string{"nostr-tools, last fetched", "at commit 5e6f7a8"}},
+		{name: "no fetch recorded, old commit", results: results("wiki"), want: []string{"wiki, at commit 9c8b7a6 from"}},
+		{name: "no fetch recorded, recent commit", results: results("handbook")},
+		{name: "no dates", results: results(eventsRepo)},
+		{name: "only stale repositories named", results: results("nips", "wiki", "nostr-tools", "wiki"), want: []string{"wiki", "nostr-tools"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			note := stalenessNote(manifest, test.results)
+			if len(test.want) == 0 {
+				if note != "" {
+					t.Errorf("unexpected note: %q", note)
+				}
+				return
+			}
+			for _, want := range test.want {
+				if !strings.Contains(note, want) {
+					t.Errorf("note %q does not contain %q", note, want)
+				}
+			}
+			if strings.Contains(note, "nips") {
+				t.Errorf("note %q names the recently fetched nips repository", note)
+			}
+		})
+	}
+}
+
+func TestRepoFetchTime(t *testing.T) {
+	dir := t.TempDir()
+	if fetched := repoFetchTime(dir); !fetched.IsZero() {
+		t.Fatalf("fetch time without a record: %v", fetched)
+	}
+	if err := os.Mkdir(filepath.Join(dir, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	recordFetch(dir)
+	if fetched := repoFetchTime(dir); time.Since(fetched) > time.Minute {
+		t.Errorf("recorded fetch time %v is not the current time", fetched)
+	}
+}
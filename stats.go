@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// statsPath is the local-only usage statistics database. It is kept apart
+// from the embeddings database, which the vector store holds locked while open.
+var statsPath = filepath.Join(dataDir, "stats.db")
+
+// sourceHitsPath holds the source hit counts a server writes before it starts
+// an ingest, which cannot open the statistics database the server holds open
+var sourceHitsPath = filepath.Join(dataDir, "source-hits.json")
+
+// statsEnabled turns usage statistics on or off (-no-stats)
+var statsEnabled = true
+
+// Buckets of the statistics database
+const (
+	statsDailyBucket   = "queries-per-day"
+	statsSourcesBucket = "source-hits"
+	statsLastHitBucket = "source-last-hit"
+	statsZeroBucket    = "zero-result-queries"
+)
+
+// statsShownDays is how many days of query counts -stats prints
+const statsShownDays = 14
+
+// statsShownEntries is how many sources and zero-result queries -stats prints
+const statsShownEntries = 10
+
+// zeroResultQuery records a query that returned nothing above the threshold
+type zeroResultQuery struct {
+	Query     string    `json:"query"`
+	Time      time.Time `json:"time"`
+	BestScore float64   `json:"best_score"`
+}
+
+// statsFlushInterval is how often a process writes the statistics it has
+// buffered since the last write
+const statsFlushInterval = 30 * time.Second
+
+// pendingStats are statistics recorded in memory and not yet written
+type pendingStats struct {
+	daily   map[string]uint64
+	sources map[string]uint64
+	lastHit map[string]time.Time
+	zero    []zeroResultQuery
+}
+
+// statsBuffer buffers statistics in memory and writes them periodically
+// through one handle, which stays open once the first write opens it, so a
+// query costs neither opening the database nor a sync to disk. A process
+// that exits without flushing loses at most the last interval of statistics.
+var statsBuffer struct {
+	mutex   sync.Mutex
+	pending pendingStats
+	flusher sync.Once
+
+	// flushMutex serializes writes and guards db
+	flushMutex sync.Mutex
+	db         *bbolt.DB
+}
+
+// recordQueryStats counts a query, the sources it hit, and whether it came
+// back empty. Statistics are best effort: failures are logged and ignored.
+func recordQueryStats(query string, candidates, results []searchResult) {
+	if !statsEnabled {
+		return
+	}
+
+	now := time.Now()
+	statsBuffer.mutex.Lock()
+	pending := &statsBuffer.pending
+	if pending.daily == nil {
+		*pending = pendingStats{
+			daily:   make(map[string]uint64),
+			sources: make(map[string]uint64),
+			lastHit: make(map[string]time.Time),
+		}
+	}
+	pending.daily[now.Format(time.DateOnly)]++
+	seen := make(map[string]bool)
+	for _, result := range results {
+		source := chunkSource(result.Record.Id)
+		if seen[source] {
+			continue
+		}
+		seen[source] = true
+		pending.sources[source]++
+		pending.lastHit[source] = now
+	}
+	if len(results) == 0 {
+		entry := zeroResultQuery{Query: query, Time: now}
+		if len(candidates) > 0 {
+			entry.BestScore = candidates[0].Score
+		}
+		pending.zero = append(pending.zero, entry)
+	}
+	statsBuffer.mutex.Unlock()
+
+	statsBuffer.flusher.Do(func() {
+		go func() {
+			for range time.Tick(statsFlushInterval) {
+				flushQueryStats()
+			}
+		}()
+	})
+}
+
+// flushQueryStats writes the buffered statistics to the statistics database
+func flushQueryStats() {
+	statsBuffer.mutex.Lock()
+	pending := statsBuffer.pending
+	statsBuffer.pending = pendingStats{}
+	statsBuffer.mutex.Unlock()
+	if pending.daily == nil {
+		return
+	}
+
+	statsBuffer.flushMutex.Lock()
+	defer statsBuffer.flushMutex.Unlock()
+	if statsBuffer.db == nil {
+		if err := os.MkdirAll(filepath.Dir(statsPath), 0755); err != nil {
+			log.Printf("Dropping usage statistics: %v", err)
+			return
+		}
+		db, err := bbolt.Open(statsPath, 0600, &bbolt.Options{Timeout: dbOpenTimeout})
+		if err != nil {
+			log.Printf("Dropping usage statistics: %v", err)
+			return
+		}
+		statsBuffer.db = db
+	}
+
+	err := statsBuffer.db.Update(func(tx *bbolt.Tx) error {
+		for day, count := range pending.daily {
+			if err := addToCounter(tx, statsDailyBucket, day, count); err != nil {
+				return err
+			}
+		}
+		for source, count := range pending.sources {
+			if err := addToCounter(tx, statsSourcesBucket, source, count); err != nil {
+				return err
+			}
+		}
+		for source, hit := range pending.lastHit {
+			if err := recordLastHit(tx, source, hit); err != nil {
+				return err
+			}
+		}
+		if len(pending.zero) == 0 {
+			return nil
+		}
+		bucket, err := tx.CreateBucketIfNotExists([]byte(statsZeroBucket))
+		if err != nil {
+			return err
+		}
+		for _, entry := range pending.zero {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(entry.Time.Format(time.RFC3339Nano)), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error recording usage statistics: %v", err)
+	}
+}
+
+// writeSourceHits writes the buffered statistics and then the source hit
+// counts to sourceHitsPath, for an ingest started by this process. Nothing is
+// written while this process does not hold the statistics database open.
+func writeSourceHits() error {
+	flushQueryStats()
+
+	statsBuffer.flushMutex.Lock()
+	defer statsBuffer.flushMutex.Unlock()
+	if statsBuffer.db == nil {
+		return nil
+	}
+	var hits map[string]uint64
+	statsBuffer.db.View(func(tx *bbolt.Tx) error {
+		hits = readCounters(tx, statsSourcesBucket)
+		return nil
+	})
+	data, err := json.Marshal(hits)
+	if err != nil {
+		return err
+	}
+	tmpPath := sourceHitsPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, sourceHitsPath)
+}
+
+// openStatsForReading opens the statistics database read-only, explaining
+// the failure when a running server holds it open
+func openStatsForReading() (*bbolt.DB, error) {
+	db, err := bbolt.Open(statsPath, 0600, &bbolt.Options{Timeout: dbOpenTimeout, ReadOnly: true})
+	if errors.Is(err, bbolt.ErrTimeout) {
+		return nil, errors.New("a running server holds it open; stop the server to read it")
+	}
+	return db, err
+}
+
+// addToCounter adds n to a big-endian counter stored under key
+func addToCounter(tx *bbolt.Tx, bucketName, key string, n uint64) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(bucketName))
+	if err != nil {
+		return err
+	}
+	count := uint64(0)
+	if value := bucket.Get([]byte(key)); len(value) == 8 {
+		count = binary.BigEndian.Uint64(value)
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, count+n)
+	return bucket.Put([]byte(key), buf)
+}
+
+// recordLastHit stores when a source last appeared in query results
+func recordLastHit(tx *bbolt.Tx, source string, now time.Time) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(statsLastHitBucket))
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(source), []byte(now.Format(time.RFC3339)))
+}
+
+// readLastHits returns when each source last appeared in query results.
+// Sources hit before this was recorded are missing.
+func readLastHits(tx *bbolt.Tx) map[string]time.Time {
+	hits := make(map[string]time.Time)
+	bucket := tx.Bucket([]byte(statsLastHitBucket))
+	if bucket == nil {
+		return hits
+	}
+	bucket.ForEach(func(k, v []byte) error {
+		if t, err := time.Parse(time.RFC3339, string(v)); err == nil {
+			hits[string(k)] = t
+		}
+		return nil
+	})
+	return hits
+}
+
+// readCounters returns every counter in a bucket
+func readCounters(tx *bbolt.Tx, bucketName string) map[string]uint64 {
+	counters := make(map[string]uint64)
+	bucket := tx.Bucket([]byte(bucketName))
+	if bucket == nil {
+		return counters
+	}
+	bucket.ForEach(func(k, v []byte) error {
+		if len(v) == 8 {
+			counters[string(k)] = binary.BigEndian.Uint64(v)
+		}
+		return nil
+	})
+	return counters
+}
+
+// readZeroResultQueries returns the logged zero-result queries, oldest first
+func readZeroResultQueries(tx *bbolt.Tx) []zeroResultQuery {
+	var queries []zeroResultQuery
+	bucket := tx.Bucket([]byte(statsZeroBucket))
+	if bucket == nil {
+		return queries
+	}
+	bucket.ForEach(func(k, v []byte) error {
+		var entry zeroResultQuery
+		if json.Unmarshal(v, &entry) == nil {
+			queries = append(queries, entry)
+		}
+		return nil
+	})
+	return queries
+}
+
+// printStats prints the local usage statistics
+func printStats() {
+	if _, err := os.Stat(statsPath); os.IsNotExist(err) {
+		fmt.Println("No usage statistics recorded yet.")
+		return
+	}
+
+	db, err := openStatsForReading()
+	if err != nil {
+		log.Fatalf("Error opening statistics database %s: %v", statsPath, err)
+	}
+	defer db.Close()
+
+	db.View(func(tx *bbolt.Tx) error {
+		daily := readCounters(tx, statsDailyBucket)
+		var total uint64
+		for _, count := range daily {
+			total += count
+		}
+		fmt.Printf("Queries: %d in total\n\n", total)
+
+		fmt.Printf("Queries per day (last %d days):\n", statsShownDays)
+		today := time.Now()
+		for i := statsShownDays - 1; i >= 0; i-- {
+			day := today.AddDate(0, 0, -i).Format(time.DateOnly)
+			fmt.Printf("  %s  %d\n", day, daily[day])
+		}
+
+		fmt.Println("\nMost-hit sources:")
+		printTopCounters(readCounters(tx, statsSourcesBucket), statsShownEntries)
+
+		zero := readZeroResultQueries(tx)
+		fmt.Printf("\nZero-result queries: %d", len(zero))
+		if total > 0 {
+			fmt.Printf(" (%.0f%% of all queries)", 100*float64(len(zero))/float64(total))
+		}
+		fmt.Println()
+		start := max(0, len(zero)-statsShownEntries)
+		for i := len(zero) - 1; i >= start; i-- {
+			fmt.Printf("  %s  %q (best score %.2f)\n", zero[i].Time.Format(time.DateTime), zero[i].Query, zero[i].BestScore)
+		}
+		return nil
+	})
+}
+
+// printTopCounters prints the n largest counters, largest first
+func printTopCounters(counters map[string]uint64, n int) {
+	if len(counters) == 0 {
+		fmt.Println("  (none)")
+		return
+	}
+	keys := make([]string, 0, len(counters))
+	for key := range counters {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counters[keys[i]] != counters[keys[j]] {
+			return counters[keys[i]] > counters[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	for i, key := range keys {
+		if i == n {
+			break
+		}
+		fmt.Printf("  %6d  %s\n", counters[key], key)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/parakeet-nest/parakeet/llm"
+	"go.etcd.io/bbolt"
+)
+
+func TestFlushQueryStats(t *testing.T) {
+	inTempDir(t)
+	t.Cleanup(func() {
+		statsBuffer.flushMutex.Lock()
+		defer statsBuffer.flushMutex.Unlock()
+		if statsBuffer.db != nil {
+			statsBuffer.db.Close()
+			statsBuffer.db = nil
+		}
+	})
+
+	hit := []searchResult{{Record: llm.VectorRecord{Id: "nips/01-chunk-1"}, Score: 0.8}}
+	recordQueryStats("relay list", hit, hit)
+	recordQueryStats("relay list again", hit, hit)
+	recordQueryStats("something missing", nil, nil)
+	flushQueryStats()
+	recordQueryStats("relay list", hit, hit)
+	flushQueryStats()
+	// Nothing buffered, nothing written
+	flushQueryStats()
+
+	var sources map[string]uint64
+	var zero []zeroResultQuery
+	var total uint64
+	err := statsBuffer.db.View(func(tx *bbolt.Tx) error {
+		for _, count := range readCounters(tx, statsDailyBucket) {
+			total += count
+		}
+		sources = readCounters(tx, statsSourcesBucket)
+		zero = readZeroResultQueries(tx)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 4 {
+		t.Errorf("got %d queries, want 4", total)
+	}
+	if got := sources[chunkSource("nips/01-chunk-1")]; got != 3 {
+		t.Errorf("got %d hits of the source, want 3", got)
+	}
+	if len(zero) != 1 || zero[0].Query != "something missing" {
+		t.Errorf("got zero-result queries %+v, want the one that found nothing", zero)
+	}
+}
+
+func TestReadSourceHitsWhileServerHoldsStats(t *testing.T) {
+	inTempDir(t)
+	defer func(timeout time.Duration) { dbOpenTimeout = timeout }(dbOpenTimeout)
+	dbOpenTimeout = 50 * time.Millisecond
+	t.Cleanup(func() {
+		statsBuffer.flushMutex.Lock()
+		defer statsBuffer.flushMutex.Unlock()
+		if statsBuffer.db != nil {
+			statsBuffer.db.Close()
+			statsBuffer.db = nil
+		}
+	})
+
+	// The server has written statistics and holds the database open, as
+	// it does when it starts an ingest
+	hit := []searchResult{{Record: llm.VectorRecord{Id: "nips/65-chunk-2"}, Score: 0.8}}
+	recordQueryStats("outbox relays", hit, hit)
+	if err := writeSourceHits(); err != nil {
+		t.Fatalf("writeSourceHits: %v", err)
+	}
+
+	if hits := readSourceHits(); hits[chunkSource("nips/65-chunk-2")] != 1 {
+		t.Errorf("got source hits %v, want the ones the server wrote", hits)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Defaults of the tag_examples tool
+const (
+	defaultTagExamples = 6
+	tagExamplesPerKind = 2   // Keeps one popular kind from filling the examples
+	tagSampleSize      = 100 // Events fetched per relay to find the tag in
+	maxTagSampleKinds  = 8
+	maxExampleContent  = 200
+	maxExampleTagValue = 80
+)
+
+// commonTagKinds are sampled when the registry does not tie a tag to any kind
+var commonTagKinds = []int{1, 6, 7, 1111, 9735, 10002, 30023, 30311}
+
+// tagNamePattern matches a valid tag name argument
+var tagNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// identifierPatterns match the public keys, event IDs, and bech32 entities
+// that are replaced in examples so they do not point at real people
+var identifierPatterns = []struct {
+	pattern     *regexp.Regexp
+	placeholder string
+}{
+	// Coordinates go first, before the bare hex keys inside them
+	{regexp.MustCompile(`\b\d+:[0-9a-f]{64}:`), "<kind>:<pubkey>:"},
+	{regexp.MustCompile(`\b(nostr:)?(npub|nprofile|note|nevent|naddr)1[02-9ac-hj-np-z]{20,}\b`), "<$2>"},
+	{regexp.MustCompile(`\b[0-9a-f]{64}\b`), "<hex id>"},
+	{regexp.MustCompile(`\blnurl1[02-9ac-hj-np-z]{20,}\b`), "<lnurl>"},
+	{regexp.MustCompile(`\blnbc[0-9a-z]{20,}\b`), "<invoice>"},
+}
+
+// anonymize replaces identifiers in text with placeholders and shortens it
+// to at most limit characters
+func anonymize(text string, limit int) string {
+	for _, identifier := range identifierPatterns {
+		text = identifier.pattern.ReplaceAllString(text, identifier.placeholder)
+	}
+	if runes := []rune(text); len(runes) > limit {
+		text = string(runes[:limit]) + "…"
+	}
+	return text
+}
+
+// tagExample is an anonymized event showing a tag in use
+type tagExample struct {
+	Kind    int        `json:"kind"`
+	Tags    [][]string `json:"tags"`
+	Content string     `json:"content,omitempty"`
+}
+
+// newTagExample strips an event down to what shows how a tag is used: its
+// kind, its tags with the example tag first, and the start of its content.
+// The ID, author, signature, and timestamp are left out.
+func newTagExample(ev *nostr.Event, name string) tagExample {
+	example := tagExample{Kind: ev.Kind, Content: anonymize(ev.Content, maxExampleContent)}
+	var others [][]string
+	for _, tag := range ev.Tags {
+		values := make([]string, len(tag))
+		for i, value := range tag {
+			values[i] = anonymize(value, maxExampleTagValue)
+		}
+		if len(tag) > 0 && tag[0] == name {
+			example.Tags = append(example.Tags, values)
+		} else {
+			others = append(others, values)
+		}
+	}
+	example.Tags = append(example.Tags, others...)
+	return example
+}
+
+// hasTag reports whether an event carries a tag with the given name
+func hasTag(ev *nostr.Event, name string) bool {
+	for _, tag := range ev.Tags {
+		if len(tag) > 0 && tag[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// tagSampleKinds picks the kinds to look for a tag in: those defined by the
+// NIPs that standardize the tag, or common kinds when there are none
+func tagSampleKinds(registry *kindRegistry, definitions []registryTag) []int {
+	nips := make(map[string]bool)
+	for _, tag := range definitions {
+		for _, nip := range tag.NIPs {
+			nips[nip] = true
+		}
+	}
+
+	var kinds []int
+	if registry != nil {
+		for _, entry := range registry.Kinds {
+			from, to, ok := kindRange(entry.Kind)
+			if !ok || from != to {
+				continue
+			}
+			for _, nip := range entry.NIPs {
+				// NIP-01 defines the basic kinds, which would crowd out the rest
+				if nips[nip] && nip != "01" && !containsInt(kinds, from) {
+					kinds = append(kinds, from)
+					break
+				}
+			}
+		}
+	}
+	if len(kinds) == 0 {
+		return commonTagKinds
+	}
+	if len(kinds) > maxTagSampleKinds {
+		kinds = kinds[:maxTagSampleKinds]
+	}
+	return kinds
+}
+
+// formatKindList renders kinds as "1, 7, 30023"
+func formatKindList(kinds []int) string {
+	parts := make([]string, len(kinds))
+	for i, kind := range kinds {
+		parts[i] = strconv.Itoa(kind)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// containsInt reports whether a slice contains a number
+func containsInt(slice []int, n int) bool {
+	for _, item := range slice {
+		if item == n {
+			return true
+		}
+	}
+	return false
+}
+
+// sampleTagExamples fetches events carrying a tag from the search relays and
+// keeps up to limit of them, spread across kinds
+func sampleTagExamples(ctx context.Context, name, value string, kinds []int, limit int) []*nostr.Event {
+	var filters []nostr.Filter
+	if value != "" && len(name) == 1 {
+		// Relays index single-letter tags, so the value can be queried directly
+		filters = []nostr.Filter{{Tags: nostr.TagMap{name: {value}}, Limit: tagSampleSize}}
+	} else {
+		// A limit of 0 would ask for every event of the kind
+		perKind := max(1, tagSampleSize/len(kinds))
+		for _, kind := range kinds {
+			filters = append(filters, nostr.Filter{Kinds: []int{kind}, Limit: perKind})
+		}
+	}
+
+	events := fetchFromRelays(ctx, rankRelays(searchRelays), filters, relayDeadline, tagSampleSize, func(ev *nostr.Event) bool {
+		if !hasTag(ev, name) {
+			return false
+		}
+		if value == "" {
+			return true
+		}
+		for _, tag := range ev.Tags {
+			if len(tag) > 1 && tag[0] == name && tag[1] == value {
+				return true
+			}
+		}
+		return false
+	})
+
+	// Newest first, then at most tagExamplesPerKind of each kind
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].CreatedAt > events[j].CreatedAt
+	})
+	perKind := make(map[int]int)
+	var picked []*nostr.Event
+	for _, ev := range events {
+		if perKind[ev.Kind] >= tagExamplesPerKind {
+			continue
+		}
+		perKind[ev.Kind]++
+		picked = append(picked, ev)
+		if len(picked) >= limit {
+			break
+		}
+	}
+	return picked
+}
+
+func tagExamplesHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	name, _ := request.Params.Arguments["tag"].(string)
+	if !tagNamePattern.MatchString(name) {
+		return nil, errors.New("tag must be a tag name such as \"t\" or \"relay\"")
+	}
+	value, _ := request.Params.Arguments["value"].(string)
+	limit := defaultTagExamples
+	if num, ok := request.Params.Arguments["limit"].(float64); ok && num > 0 {
+		limit = int(num)
+	}
+
+	registry := currentIndex().registry
+	var definitions []registryTag
+	if registry != nil {
+		for _, tag := range registry.Tags {
+			if tag.Name == name {
+				definitions = append(definitions, tag)
+			}
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("# The `%s` tag\n\n## Specification\n", name))
+	switch {
+	case registry == nil:
+		b.WriteString("No kind registry in the index; enable the NIPs repository and re-ingest with -ingest.\n")
+	case len(definitions) == 0:
+		b.WriteString("Not listed in the standardized tags table of the NIPs README.\n")
+	default:
+		for _, tag := range definitions {
+			line := fmt.Sprintf("- value: %s", tag.Value)
+			if tag.Other != "" && tag.Other != "--" {
+				line += fmt.Sprintf("; other parameters: %s", tag.Other)
+			}
+			b.WriteString(fmt.Sprintf("%s (%s)\n", line, formatNipRefs(tag.NIPs, tag.Reference)))
+		}
+	}
+
+	b.WriteString("\n## In practice\n")
+	if relaysUnavailable() {
+		b.WriteString(offlineNotice("Sampling tag usage") + "\n")
+		return mcp.NewToolResultText(b.String()), nil
+	}
+
+	kinds := tagSampleKinds(registry, definitions)
+	if list, ok := request.Params.Arguments["kinds"].(string); ok && strings.TrimSpace(list) != "" {
+		parsed, err := parseSnippetKinds(list)
+		if err != nil {
+			return nil, err
+		}
+		kinds = parsed
+	}
+
+	events := sampleTagExamples(ctx, name, value, kinds, limit)
+	if len(events) == 0 {
+		if value != "" {
+			b.WriteString(fmt.Sprintf("No events on the search relays use this tag with the value %q.\n", value))
+		} else {
+			b.WriteString(fmt.Sprintf("No recent events of kinds %s on the search relays use this tag.\n", formatKindList(kinds)))
+		}
+		return mcp.NewToolResultText(b.String()), nil
+	}
+	b.WriteString(fmt.Sprintf("%d recent events from the search relays. IDs, authors, signatures, and timestamps are left out, keys and identifiers are replaced with placeholders, and long values are shortened.\n", len(events)))
+	for _, ev := range events {
+		data, err := json.MarshalIndent(newTagExample(ev, name), "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("error serializing example: %v", err)
+		}
+		b.WriteString(fmt.Sprintf("\nKind %d:\n```json\n%s\n```\n", ev.Kind, data))
+	}
+	return mcp.NewToolResultText(b.String()), nil
+}
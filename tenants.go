@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tenantsFile is the default path of the HTTP tenant configuration
+const tenantsFile = "tenants.json"
+
+// Tenant is one API key holder of a hosted instance
+type Tenant struct {
+	Name        string   // Name shown in logs
+	Key         string   // API key sent as "Authorization: Bearer <key>" or "X-API-Key", or a secret reference such as env:NAME
+	RateLimit   int      `json:",omitempty"` // Tool calls allowed per minute (0 for no limit)
+	Collections []string `json:",omitempty"` // Collections the tenant may search (empty for all)
+	Admin       bool     `json:",omitempty"` // Whether the tenant is offered the admin tools
+
+	limiter *rateLimiter
+}
+
+// tenants holds the configured tenants, keyed by API key
+var tenants map[string]*Tenant
+
+// tenantContextKey stores the calling tenant in a request context
+type tenantContextKey struct{}
+
+// loadTenants reads the tenant configuration. A missing default file leaves
+// the HTTP transport open to anyone who can reach it.
+func loadTenants(customFile string) {
+	cfgFile := tenantsFile
+	if customFile != "" {
+		cfgFile = customFile
+	}
+
+	file, err := os.ReadFile(cfgFile)
+	if os.IsNotExist(err) && cfgFile == tenantsFile {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error reading tenants file: %v\n", err)
+		os.Exit(1)
+	}
+
+	var list []*Tenant
+	if _, problems := decodeConfigList(file, &list); len(problems) > 0 {
+		printConfigProblems("tenants file", cfgFile, problems)
+		os.Exit(1)
+	}
+
+	tenants = make(map[string]*Tenant, len(list))
+	for _, tenant := range list {
+		if tenant.Key == "" {
+			fmt.Printf("Error in tenants file: tenant %q has no key\n", tenant.Name)
+			os.Exit(1)
+		}
+		if isSecretReference(tenant.Key) {
+			key, err := resolveSecret(tenant.Key)
+			if err != nil {
+				fmt.Printf("Error loading the key of tenant %q: %v\n", tenant.Name, err)
+				os.Exit(1)
+			}
+			tenant.Key = key
+		}
+		if _, exists := tenants[tenant.Key]; exists {
+			fmt.Printf("Error in tenants file: tenant %q reuses another tenant's key\n", tenant.Name)
+			os.Exit(1)
+		}
+		if tenant.RateLimit > 0 {
+			tenant.limiter = newRateLimiter(tenant.RateLimit, time.Minute)
+		}
+		tenants[tenant.Key] = tenant
+	}
+}
+
+// requestKey extracts the API key from a request
+func requestKey(r *http.Request) string {
+	if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(key)
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// tenantForRequest returns the tenant a request authenticates as
+func tenantForRequest(r *http.Request) (*Tenant, bool) {
+	tenant, ok := tenants[requestKey(r)]
+	return tenant, ok
+}
+
+// withTenant stores the calling tenant in ctx
+func withTenant(ctx context.Context, tenant *Tenant) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// tenantFromContext returns the tenant making a tool call, or nil over stdio
+// and for an HTTP transport without tenants
+func tenantFromContext(ctx context.Context) *Tenant {
+	tenant, _ := ctx.Value(tenantContextKey{}).(*Tenant)
+	return tenant
+}
+
+// tenantCollections narrows the collections a query searches to those the
+// calling tenant may access. An empty requested list means every collection.
+func tenantCollections(ctx context.Context, requested []string) ([]string, error) {
+	tenant := tenantFromContext(ctx)
+	if tenant == nil || len(tenant.Collections) == 0 {
+		return requested, nil
+	}
+	if len(requested) == 0 {
+		return tenant.Collections, nil
+	}
+
+	var allowed []string
+	for _, collection := range requested {
+		if contains(tenant.Collections, collection) {
+			allowed = append(allowed, collection)
+		}
+	}
+	if len(allowed) == 0 {
+		return nil, fmt.Errorf("collections %s are not available to this API key; available: %s",
+			strings.Join(requested, ", "), strings.Join(tenant.Collections, ", "))
+	}
+	return allowed, nil
+}
+
+// tenantCanRead reports whether the calling tenant may read a chunk
+func tenantCanRead(ctx context.Context, id string) bool {
+	tenant := tenantFromContext(ctx)
+	return tenant == nil || len(tenant.Collections) == 0 || contains(tenant.Collections, chunkCollection(id)) || chunkCollection(id) == collectionScratch
+}
+
+// rateLimiter is a token bucket allowing limit events per period
+type rateLimiter struct {
+	mutex    sync.Mutex
+	tokens   float64
+	capacity float64
+	rate     float64 // tokens per second
+	last     time.Time
+}
+
+// newRateLimiter creates a full token bucket
+func newRateLimiter(limit int, period time.Duration) *rateLimiter {
+	return &rateLimiter{
+		tokens:   float64(limit),
+		capacity: float64(limit),
+		rate:     float64(limit) / period.Seconds(),
+		last:     time.Now(),
+	}
+}
+
+// allow takes a token if one is available and otherwise reports how long
+// until the next one
+func (l *rateLimiter) allow() (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.tokens = math.Min(l.capacity, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
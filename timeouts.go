@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// timeoutsConfigFile is the default path of the timeouts file
+const timeoutsConfigFile = "timeouts.json"
+
+// timeoutSettings maps the names used in the timeouts file to the timeouts
+// and intervals they set. The defaults suit a home connection; slower links
+// and busy relays need longer ones.
+var timeoutSettings = map[string]*time.Duration{
+	"RelayFetch":     &relayDeadline,
+	"RelayHealth":    &relayHealthTimeout,
+	"RelayCount":     &countTimeout,
+	"SnippetFetch":   &snippetFetchTimeout,
+	"SnippetRefresh": &snippetRefreshInterval,
+	"HTTPRequest":    &outboundTimeout,
+	"Embedding":      &embeddingTimeout,
+	"DatabaseOpen":   &dbOpenTimeout,
+	"StoreWatch":     &storeWatchInterval,
+	"ScratchIdle":    &scratchIdleTimeout,
+}
+
+// loadTimeouts reads timeouts from a file of setting names and durations,
+// such as "45s" or "2m". Settings missing from the file keep their defaults.
+// A missing default file is not an error.
+func loadTimeouts(customConfigFile string) {
+	cfgFile := timeoutsConfigFile
+	if customConfigFile != "" {
+		cfgFile = customConfigFile
+	}
+
+	file, err := os.ReadFile(cfgFile)
+	if os.IsNotExist(err) && cfgFile == timeoutsConfigFile {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error reading timeouts file: %v\n", err)
+		os.Exit(1)
+	}
+
+	var settings map[string]string
+	if err := json.Unmarshal(file, &settings); err != nil {
+		fmt.Printf("Error parsing timeouts file: %v\n", err)
+		os.Exit(1)
+	}
+	if err := applyTimeouts(settings); err != nil {
+		fmt.Printf("Error in timeouts file %s: %v\n", cfgFile, err)
+		os.Exit(1)
+	}
+}
+
+// applyTimeouts sets the named timeouts, refusing unknown names and
+// durations that are not positive
+func applyTimeouts(settings map[string]string) error {
+	for name, value := range settings {
+		setting, ok := timeoutSettings[name]
+		if !ok {
+			names := make([]string, 0, len(timeoutSettings))
+			for known := range timeoutSettings {
+				names = append(names, known)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("unknown timeout %q; expected one of %s", name, strings.Join(names, ", "))
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if duration <= 0 {
+			return fmt.Errorf("%s must be positive, got %s", name, value)
+		}
+		*setting = duration
+	}
+
+	// The HTTP clients were built with the default timeouts
+	outboundClient.Timeout = outboundTimeout
+	ollamaClient.Timeout = embeddingTimeout
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Trust tiers say how authoritative the source of a chunk is, so agents can
+// tell normative spec text from opinion
+const (
+	tierSpec    = "spec"    // Official protocol specifications, such as the NIPs
+	tierProject = "project" // Documentation and code maintained in a project's repository
+	tierWiki    = "wiki"    // Community-edited wikis
+	tierArticle = "article" // Personal articles and posts, such as events ingested from relays
+	tierSnippet = "snippet" // Code snippets shared on Nostr
+)
+
+// trustTiers lists the tiers from most to least authoritative
+var trustTiers = []string{tierSpec, tierProject, tierWiki, tierArticle, tierSnippet}
+
+// tierDescriptions explain each tier in result labels and tool descriptions
+var tierDescriptions = map[string]string{
+	tierSpec:    "official specification",
+	tierProject: "project documentation or code",
+	tierWiki:    "community wiki",
+	tierArticle: "personal article",
+	tierSnippet: "code snippet",
+}
+
+// tierRank returns the position of a tier in trustTiers, lower being more
+// authoritative. Unknown tiers rank below all others.
+func tierRank(tier string) int {
+	for i, t := range trustTiers {
+		if t == tier {
+			return i
+		}
+	}
+	return len(trustTiers)
+}
+
+// parseTier validates a tier name given by a user or a repository config
+func parseTier(value string) (string, error) {
+	tier := strings.ToLower(strings.TrimSpace(value))
+	if tierRank(tier) == len(trustTiers) {
+		return "", fmt.Errorf("unknown trust tier %q; expected one of %s", value, strings.Join(trustTiers, ", "))
+	}
+	return tier, nil
+}
+
+// collectionTier returns the default trust tier of a collection's chunks
+func collectionTier(collection string) string {
+	switch collection {
+	case collectionSpecs:
+		return tierSpec
+	case collectionWiki:
+		return tierWiki
+	case collectionArticles:
+		return tierArticle
+	}
+	return tierProject
+}
+
+// repoTier returns the trust tier of a repository's chunks: its Trust
+// setting, or the default tier of its collection
+func repoTier(repoName string) string {
+	for _, repo := range repos {
+		if repo.Name == repoName && repo.Trust != "" {
+			if tier, err := parseTier(repo.Trust); err == nil {
+				return tier
+			}
+		}
+	}
+	return collectionTier(repoCollection(repoName))
+}
+
+// chunkTier returns the trust tier of a stored chunk. Scratch documents have
+// no tier; they are the client's own.
+func chunkTier(id string) string {
+	if chunkCollection(id) == collectionScratch {
+		return collectionScratch
+	}
+	return repoTier(chunkRepo(id))
+}
+
+// tierAllowed reports whether a tier is at least as authoritative as minTier,
+// which allows every tier when empty
+func tierAllowed(tier, minTier string) bool {
+	return minTier == "" || tierRank(tier) <= tierRank(minTier)
+}
+
+// meetsMinTier reports whether a chunk is at least as authoritative as
+// minTier. Scratch documents always pass, as they do the collection filter.
+func meetsMinTier(id, minTier string) bool {
+	if minTier == "" {
+		return true
+	}
+	tier := chunkTier(id)
+	return tier == collectionScratch || tierAllowed(tier, minTier)
+}
+
+// minTierArgument reads the optional min_tier argument of a search tool
+func minTierArgument(request mcp.CallToolRequest) (string, error) {
+	value, _ := request.Params.Arguments["min_tier"].(string)
+	if strings.TrimSpace(value) == "" {
+		return "", nil
+	}
+	return parseTier(value)
+}
+
+// minTierDescription documents the min_tier argument of the search tools
+const minTierDescription = "Only return chunks at least this authoritative: spec (official specifications), project (project documentation and code), wiki (community wikis), article (personal articles), or snippet (everything)"
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"go.etcd.io/bbolt"
+)
+
+// ingestedIDs holds the IDs of the chunks stored by this ingest, so the chunks
+// of files that were removed or shrank can be swept afterwards
+var ingestedIDs = make(map[string]bool)
+
+// keptSources holds the files this ingest did not get to store because their
+// repository failed part way, and keptRepos the repositories whose files could
+// not all be listed. Their chunks from the previous ingest are kept rather than
+// swept, so one error does not drop a repository from the index.
+var (
+	keptSources = make(map[string]bool)
+	keptRepos   = make(map[string]bool)
+)
+
+// reusedEmbeddings counts the chunks whose stored vectors were kept because
+// their text had not changed
+var reusedEmbeddings int
+
+// fileSource returns the identifier of a repository's file used in its chunk
+// IDs: its path in the clone, with the .md extension of NIPs and other
+// markdown files dropped, e.g. "nips/01" or "relay/docs/setup". The same file
+// always gets the same IDs, so a re-ingest replaces its chunks.
+func fileSource(repoName, filePath string) string {
+	name := filepath.Base(filePath)
+	if i := findRepoByName(repoName); i >= 0 {
+		if rel, err := filepath.Rel(repos[i].CloneDir, filePath); err == nil && !filepath.IsAbs(rel) {
+			name = rel
+		}
+	}
+	dir := filepath.Dir(name)
+	return repoName + "/" + filepath.ToSlash(filepath.Join(dir, extractNipIdentifier(filepath.Base(name))))
+}
+
+// upsertChunk stores the embedding of a chunk and its metadata under id,
+// replacing the chunk stored there before. When the stored chunk has the same
+// text its vector is kept instead of being embedded again; when embedding
+// fails the stored chunk is kept as it was.
+func upsertChunk(store *vectorStore, id, text string, metadata map[string]interface{}) error {
+	ingestedIDs[id] = true
+	embedding, err := store.Get(id)
+	if err == nil && embedding.Prompt == text && len(embedding.Embedding) > 0 {
+		reusedEmbeddings++
+		if sameMetadata(embedding.Metadata, metadata) {
+			return nil
+		}
+	} else {
+		if embedding, err = createEmbedding(text, id); err != nil {
+			return fmt.Errorf("error creating embedding for %s: %v", id, err)
+		}
+		prepareEmbedding(&embedding, store.Metric())
+	}
+
+	embedding.Metadata = metadata
+	if _, err := store.Save(embedding); err != nil {
+		return fmt.Errorf("error saving embedding for %s: %v", id, err)
+	}
+	return nil
+}
+
+// sweepStaleChunks deletes the chunks of the store that this ingest did not
+// store: those of removed files and disabled repositories, and the trailing
+// chunks of files that now split into fewer. Chunks of files that were not
+// processed because of an error are kept. It runs through the store's
+// own handle, since the store holds the database locked.
+func sweepStaleChunks(store *vectorStore) (int, error) {
+	removed := 0
+	err := store.db.Update(func(tx *bbolt.Tx) error {
+		var err error
+		removed, err = deleteChunks(tx, func(id string) bool {
+			return !ingestedIDs[id] && !keptSources[chunkSource(id)] && !keptRepos[chunkRepo(id)]
+		})
+		return err
+	})
+	return removed, err
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/parakeet-nest/parakeet/llm"
+	"go.etcd.io/bbolt"
+)
+
+// storeBucket is the bucket holding the vector records, named as parakeet's
+// bbolt store names it so databases it created open unchanged
+const storeBucket = "embeddings-store-bucket"
+
+// vectorStore keeps vector records as JSON in a bbolt file, laid out the way
+// parakeet's BboltVectorStore lays them out. Unlike that store it can be
+// closed, which releases the file lock, so the same process can reopen the
+// database afterwards, and it gives access to its database for bookkeeping
+// that is written along with the vectors.
+type vectorStore struct {
+	db     *bbolt.DB
+	metric string
+}
+
+// Initialize opens the database at path, waiting at most dbOpenTimeout for
+// another process to release it, and creates the record bucket if needed
+func (s *vectorStore) Initialize(path string) error {
+	db, err := openRawDatabase(path, false)
+	if err != nil {
+		return fmt.Errorf("error opening database %s: %v", path, err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(storeBucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("error creating bucket in %s: %v", path, err)
+	}
+	s.db = db
+	return nil
+}
+
+// Close releases the database file. Closing a store that is not open does
+// nothing.
+func (s *vectorStore) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	return err
+}
+
+// Get returns the record with the given ID
+func (s *vectorStore) Get(id string) (llm.VectorRecord, error) {
+	var record llm.VectorRecord
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		value := storeRecords(tx).Get([]byte(id))
+		if value == nil {
+			return fmt.Errorf("record %s not found", id)
+		}
+		return json.Unmarshal(value, &record)
+	})
+	return record, err
+}
+
+// Metric returns the similarity metric of the collection, read from the
+// database by initializeStore
+func (s *vectorStore) Metric() string {
+	if s.metric == "" {
+		return metricCosine
+	}
+	return s.metric
+}
+
+// GetAll returns every record, in ID order, read in a single transaction
+func (s *vectorStore) GetAll() ([]llm.VectorRecord, error) {
+	var records []llm.VectorRecord
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return storeRecords(tx).ForEach(func(key, value []byte) error {
+			var record llm.VectorRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return fmt.Errorf("error decoding record %s: %v", key, err)
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	return records, err
+}
+
+// Save stores a record under its ID, replacing the record stored there
+func (s *vectorStore) Save(record llm.VectorRecord) (llm.VectorRecord, error) {
+	if record.Id == "" {
+		return llm.VectorRecord{}, errors.New("record has no ID")
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return llm.VectorRecord{}, err
+	}
+	err = s.db.Update(func(tx *bbolt.Tx) error {
+		return storeRecords(tx).Put([]byte(record.Id), data)
+	})
+	return record, err
+}
+
+// storeRecords returns the record bucket, which Initialize created
+func storeRecords(tx *bbolt.Tx) *bbolt.Bucket {
+	return tx.Bucket([]byte(storeBucket))
+}
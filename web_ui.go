@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// webUIEnabled serves the web UI at the root of the HTTP server. Set with
+// -web-ui.
+var webUIEnabled bool
+
+// webUIPage is the whole web UI: a single page that talks to the JSON API
+//
+//go:embed web_ui.html
+var webUIPage []byte
+
+// Defaults of the web UI's search
+const (
+	defaultWebResults    = 10
+	defaultWebSimilarity = 0.6
+)
+
+// webResult is a search result as the web UI shows it: the chunk, its
+// citation, and its score
+type webResult struct {
+	chunkInfo
+	Score float64 `json:"score"`
+}
+
+// webRepo is a configured repository as the web UI lists it. Credentials
+// and clone settings are left out.
+type webRepo struct {
+	Name       string `json:"name"`
+	URL        string `json:"url"`
+	Enabled    bool   `json:"enabled"`
+	Role       string `json:"role,omitempty"`
+	Collection string `json:"collection,omitempty"`
+}
+
+// webStatus is the state of the index and of the last ingest. Admin tells
+// the UI whether to offer repository management.
+type webStatus struct {
+	Vectors       int             `json:"vectors"`
+	InMemory      bool            `json:"in_memory"`
+	Loaded        time.Time       `json:"loaded"`
+	IngestRunning bool            `json:"ingest_running"`
+	Ingest        *ingestProgress `json:"ingest,omitempty"`
+	Admin         bool            `json:"admin"`
+}
+
+// webUIHandler serves the web UI's page
+func webUIHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(webUIPage)
+	})
+}
+
+// registerWebAPI adds the endpoints the web UI uses besides the chunk
+// listing:
+//
+//	GET  /api/search?q=&results=&min_score=&collections=&min_tier=&repo=&nip=&path=&section=&mode=&expand=&rerank=&mmr_lambda=
+//	GET  /api/status
+//	GET  /api/repos
+//	POST /api/repos                  {"url", "name", "role", "collection"}
+//	POST /api/repos/{name}/enabled   {"enabled"}
+//	POST /api/reingest?clone=false
+//
+// The POST endpoints change the configuration or the index, so they are
+// only open to admin tenants, or to everyone with -admin when no tenants are
+// configured, and only to JSON requests from the web UI's own origin.
+func registerWebAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/search", func(w http.ResponseWriter, r *http.Request) {
+		results, err := webSearch(r)
+		writeJSON(w, map[string][]webResult{"results": results}, err)
+	})
+	mux.HandleFunc("GET /api/status", func(w http.ResponseWriter, r *http.Request) {
+		index := currentIndex()
+		status := webStatus{
+			Vectors:       index.vectors,
+			InMemory:      index.inMemory,
+			Loaded:        index.loaded,
+			IngestRunning: ingestRunning.Load() || ingestLocked(dbPath),
+			Admin:         webAdmin(r.Context()),
+		}
+		if progress, ok := loadIngestProgress(dbPath); ok {
+			status.Ingest = &progress
+		}
+		writeJSON(w, status, nil)
+	})
+	mux.HandleFunc("GET /api/repos", func(w http.ResponseWriter, r *http.Request) {
+		list := []webRepo{}
+		for _, repo := range repos {
+			list = append(list, webRepo{Name: repo.Name, URL: repo.URL, Enabled: repo.Enabled, Role: repo.Role, Collection: repo.Collection})
+		}
+		writeJSON(w, map[string][]webRepo{"repos": list}, nil)
+	})
+	mux.HandleFunc("POST /api/repos", requireWebAdmin(func(w http.ResponseWriter, r *http.Request) {
+		var repo webRepo
+		if err := json.NewDecoder(r.Body).Decode(&repo); err != nil {
+			http.Error(w, "invalid repository: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		err := appendRepository(strings.TrimSpace(repo.URL), strings.TrimSpace(repo.Name), repo.Role, repo.Collection)
+		writeJSON(w, map[string]string{"message": "Added repository " + repo.Name + ". Reingest to index it."}, err)
+	}))
+	mux.HandleFunc("POST /api/repos/{name}/enabled", requireWebAdmin(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Enabled == nil {
+			http.Error(w, "the body must be {\"enabled\": true} or {\"enabled\": false}", http.StatusBadRequest)
+			return
+		}
+		name := r.PathValue("name")
+		err := updateRepositoryEnabled(name, *body.Enabled)
+		writeJSON(w, map[string]string{"message": "Repository " + name + " is now " + enabledState(*body.Enabled) + ". Reingest to update the index."}, err)
+	}))
+	mux.HandleFunc("POST /api/reingest", requireWebAdmin(func(w http.ResponseWriter, r *http.Request) {
+		clone := true
+		if value := r.URL.Query().Get("clone"); value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				http.Error(w, "invalid clone parameter "+strconv.Quote(value), http.StatusBadRequest)
+				return
+			}
+			clone = parsed
+		}
+		err := startIngestProcess(clone)
+		writeJSON(w, map[string]string{"message": "Re-ingestion started. The new index is served once it is complete."}, err)
+	}))
+}
+
+// webSearch runs a search with the parameters of a web UI request, the same
+// way the query_nostr_data tool does
+func webSearch(r *http.Request) ([]webResult, error) {
+	params := r.URL.Query()
+	query := strings.TrimSpace(params.Get("q"))
+	if query == "" {
+		return nil, errors.New("missing q parameter")
+	}
+
+	opts := searchOptions{
+		Threshold:  defaultWebSimilarity,
+		NumResults: defaultWebResults,
+		MaxPerFile: defaultMaxPerFile,
+	}
+	if value := params.Get("results"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errors.New("invalid results parameter " + strconv.Quote(value))
+		}
+		opts.NumResults = n
+	}
+	if value := params.Get("min_score"); value != "" {
+		score, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, errors.New("invalid min_score parameter " + strconv.Quote(value))
+		}
+		opts.Threshold = score
+	}
+
+	var err error
+	if opts.Collections, err = tenantCollections(r.Context(), parseCollections(params.Get("collections"), query)); err != nil {
+		return nil, err
+	}
+	if value := strings.TrimSpace(params.Get("min_tier")); value != "" {
+		if opts.MinTier, err = parseTier(value); err != nil {
+			return nil, err
+		}
+	}
+	values := make(map[string]string)
+	for _, field := range filterParams {
+		values[field] = params.Get(field)
+	}
+	if opts.Filter, err = parseFilterParams(values); err != nil {
+		return nil, err
+	}
+	mode, err := parseRetrievalMode(params.Get("mode"))
+	if err != nil {
+		return nil, err
+	}
+	opts.MMRLambda = mmrLambda
+	if value := params.Get("mmr_lambda"); value != "" {
+		lambda, err := strconv.ParseFloat(value, 64)
+		if err == nil {
+			lambda, err = parseMMRLambda(lambda)
+		}
+		if err != nil {
+			return nil, errors.New("invalid mmr_lambda parameter " + strconv.Quote(value))
+		}
+		opts.MMRLambda = lambda
+	}
+
+	expand := expansionEnabled
+	if value := params.Get("expand"); value != "" {
+		if expand, err = strconv.ParseBool(value); err != nil {
+			return nil, errors.New("invalid expand parameter " + strconv.Quote(value))
+		}
+	}
+	index, release := acquireIndex()
+	defer release()
+	candidates, err := retrieveWithMode(r.Context(), index.reader, query, mode, expand)
+	if err != nil {
+		return nil, err
+	}
+	rerank := rerankEnabled
+	if value := params.Get("rerank"); value != "" {
+		if rerank, err = strconv.ParseBool(value); err != nil {
+			return nil, errors.New("invalid rerank parameter " + strconv.Quote(value))
+		}
+	}
+	results, _ := rerankResults(query, candidates, opts, rerank)
+	recordQueryStats(query, candidates, results)
+
+	hits := []webResult{}
+	for _, result := range results {
+		hits = append(hits, webResult{chunkInfo: describeChunk(result.Record, true), Score: result.Score})
+	}
+	return hits, nil
+}
+
+// webAdmin reports whether the caller may change the configuration or the
+// index
+func webAdmin(ctx context.Context) bool {
+	if tenant := tenantFromContext(ctx); tenant != nil {
+		return tenant.Admin
+	}
+	return adminMode
+}
+
+// requireWebAdmin rejects the requests of callers that are not admins, and
+// requests that did not come from the web UI itself
+func requireWebAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := checkSameOrigin(r); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if !webAdmin(r.Context()) {
+			http.Error(w, "only admins may change the configuration or the index", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// checkSameOrigin rejects cross-site requests, which another page open in
+// the admin's browser could send to a server on localhost. A form cannot
+// send a JSON content type, and a script on another origin can only do so
+// after a CORS preflight, which the server does not answer; browsers also
+// name the origin of the request, which must be the server's.
+func checkSameOrigin(r *http.Request) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return errors.New("requests that change the configuration or the index must have Content-Type: application/json")
+	}
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return errors.New("cross-site requests may not change the configuration or the index")
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		parsed, err := url.Parse(origin)
+		if err != nil || parsed.Host != r.Host {
+			return fmt.Errorf("requests from %s may not change the configuration or the index", origin)
+		}
+	}
+	return nil
+}
+
+// writeJSON writes value as JSON, or err as a bad request
+func writeJSON(w http.ResponseWriter, value any, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
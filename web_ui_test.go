@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckSameOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		ok      bool
+	}{
+		{"web UI", map[string]string{"Content-Type": "application/json", "Origin": "http://localhost:8080", "Sec-Fetch-Site": "same-origin"}, true},
+		{"command line client", map[string]string{"Content-Type": "application/json; charset=utf-8"}, true},
+		{"form post", map[string]string{"Content-Type": "application/x-www-form-urlencoded", "Origin": "http://localhost:8080"}, false},
+		{"plain text post", map[string]string{"Content-Type": "text/plain"}, false},
+		{"no content type", map[string]string{}, false},
+		{"other site", map[string]string{"Content-Type": "application/json", "Sec-Fetch-Site": "cross-site"}, false},
+		{"other origin", map[string]string{"Content-Type": "application/json", "Origin": "http://evil.example"}, false},
+		{"other port", map[string]string{"Content-Type": "application/json", "Origin": "http://localhost:9999"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "http://localhost:8080/api/reingest", strings.NewReader("{}"))
+			for key, value := range tt.headers {
+				r.Header.Set(key, value)
+			}
+			if err := checkSameOrigin(r); (err == nil) != tt.ok {
+				t.Errorf("checkSameOrigin = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}